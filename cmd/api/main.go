@@ -3,7 +3,8 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,21 +13,26 @@ import (
 
 	"github.com/Lec7ral/fullAPI/configs"
 	"github.com/Lec7ral/fullAPI/docs" // Import generated docs
+	"github.com/Lec7ral/fullAPI/internal/audit"
+	"github.com/Lec7ral/fullAPI/internal/auth"
+	"github.com/Lec7ral/fullAPI/internal/cache"
 	"github.com/Lec7ral/fullAPI/internal/database"
 	"github.com/Lec7ral/fullAPI/internal/handlers"
+	"github.com/Lec7ral/fullAPI/internal/health"
+	"github.com/Lec7ral/fullAPI/internal/logging"
+	"github.com/Lec7ral/fullAPI/internal/metrics"
 	"github.com/Lec7ral/fullAPI/internal/middleware"
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/ratelimit"
 	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/tracing"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
-func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using OS environment variables.")
-	}
-}
-
 // @title           Librarium API
 // @version         1.0
 // @description     This is the API for the Librarium application.
@@ -38,7 +44,30 @@ func init() {
 // @description Type "Bearer" followed by a space and a JWT token.
 func main() {
 	// --- 1. SETUP ---
+	envFileErr := godotenv.Load()
 	cfg := configs.LoadConfig()
+	logger, err := logging.New(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		fatal("Invalid logging configuration", err)
+	}
+	slog.SetDefault(logger)
+	if envFileErr != nil {
+		slog.Info("No .env file found, using OS environment variables")
+	}
+	slog.Info("Configuration loaded", "environment", cfg.Environment)
+	if err := cfg.Validate(); err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:     cfg.Tracing.Exporter,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		ServiceName:  cfg.Tracing.ServiceName,
+		SampleRatio:  float64(cfg.Tracing.SamplePercent) / 100,
+	})
+	if err != nil {
+		fatal("Invalid tracing configuration", err)
+	}
 
 	// --- Dynamic Swagger Configuration ---
 	// The generated docs.SwaggerInfo holds all the static information from the annotations.
@@ -46,69 +75,390 @@ func main() {
 	docs.SwaggerInfo.Host = cfg.PublicHost
 	docs.SwaggerInfo.Schemes = []string{cfg.PublicScheme}
 
-	db, err := database.InitDB(cfg.Database.DSN)
+	db, err := database.InitDB(cfg.Database.Driver, cfg.Database.DSN)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", err)
 	}
 	defer db.Close()
 
-	// ... (Repository and Env setup remains the same)
+	// Repositories append to the audit log as they make changes.
+	audit.SetHashChain(cfg.Audit.HashChain)
+
+	// The repository implementations are chosen by the database driver.
 	bookRepo := repository.NewSQLiteBookRepository(db)
 	userRepo := repository.NewSQLiteUserRepository(db)
 	authorRepo := repository.NewSQLiteAuthorRepository(db)
 	loanRepo := repository.NewSQLiteLoanRepository(db)
+	copyRepo := repository.NewSQLiteCopyRepository(db)
+	holdRepo := repository.NewSQLiteHoldRepository(db)
+	accountRepo := repository.NewSQLiteAccountRepository(db)
+	finePolicyRepo := repository.NewSQLiteFinePolicyRepository(db)
+	loanPolicyRepo := repository.NewSQLiteLoanPolicyRepository(db)
+	refreshTokenRepo := repository.NewSQLiteRefreshTokenRepository(db)
+	roleRepo := repository.NewSQLiteRoleRepository(db)
+	loginAttemptRepo := repository.NewSQLiteLoginAttemptRepository(db)
+	passwordResetRepo := repository.NewSQLitePasswordResetRepository(db)
+	twoFactorRepo := repository.NewSQLiteTwoFactorRepository(db)
+	auditRepo := repository.NewSQLiteAuditRepository(db)
+	if cfg.Database.Driver == configs.DriverPostgres {
+		bookRepo = repository.NewPostgresBookRepository(db)
+		userRepo = repository.NewPostgresUserRepository(db)
+		authorRepo = repository.NewPostgresAuthorRepository(db)
+		loanRepo = repository.NewPostgresLoanRepository(db)
+		copyRepo = repository.NewPostgresCopyRepository(db)
+		holdRepo = repository.NewPostgresHoldRepository(db)
+		accountRepo = repository.NewPostgresAccountRepository(db)
+		finePolicyRepo = repository.NewPostgresFinePolicyRepository(db)
+		loanPolicyRepo = repository.NewPostgresLoanPolicyRepository(db)
+		refreshTokenRepo = repository.NewPostgresRefreshTokenRepository(db)
+		roleRepo = repository.NewPostgresRoleRepository(db)
+		loginAttemptRepo = repository.NewPostgresLoginAttemptRepository(db)
+		passwordResetRepo = repository.NewPostgresPasswordResetRepository(db)
+		twoFactorRepo = repository.NewPostgresTwoFactorRepository(db)
+		auditRepo = repository.NewPostgresAuditRepository(db)
+	}
+
+	keys := auth.NewHMACKeyset(cfg.JWTSecret)
+	if cfg.JWT.KeysDir != "" {
+		keys, err = auth.LoadKeyset(cfg.JWT.KeysDir, cfg.JWT.SigningKID)
+		if err != nil {
+			fatal("Failed to load JWT keys", err)
+		}
+		slog.Info("Signing tokens", "kid", keys.Signing().ID, "alg", keys.Signing().Method.Alg())
+	}
+	tokens := auth.NewTokenManager(keys,
+		time.Duration(cfg.Tokens.AccessTTLMinutes)*time.Minute,
+		time.Duration(cfg.Tokens.RefreshTTLDays)*24*time.Hour)
+	redisClient := newRedisClient(cfg)
+	revokedTokens := newRevocationList(redisClient, tokens.AccessTTL)
+	limiter, lockout := newRateLimiter(cfg, redisClient)
+	passwordPolicy, err := auth.NewPasswordPolicy(cfg.Password.MinLength, cfg.Password.BreachedFile)
+	if err != nil {
+		fatal("Invalid password policy", err)
+	}
+	if cfg.Password.BreachedFile != "" {
+		slog.Info("Loaded breached passwords", "count", passwordPolicy.BreachedCount())
+	}
+
+	// Books and authors are read far more often than they change, so they are cached.
+	// Loans, copies and holds change book stock, so they invalidate the cached books they touch.
+	repoCache, cacheStats := newCache(cfg, redisClient)
+	if repoCache != nil {
+		repoCache = cache.NewTracingCache(repoCache, cacheStats.Backend())
+		cacheTTL := time.Duration(cfg.Cache.TTLSeconds) * time.Second
+		bookRepo = repository.NewCachingBookRepository(bookRepo, repoCache, cacheTTL, cacheStats.Counter("books"))
+		authorRepo = repository.NewCachingAuthorRepository(authorRepo, repoCache, cacheTTL, cacheStats.Counter("authors"))
+		loanRepo = repository.NewCachingLoanRepository(loanRepo, repoCache)
+		copyRepo = repository.NewCachingCopyRepository(copyRepo, repoCache)
+		holdRepo = repository.NewCachingHoldRepository(holdRepo, repoCache)
+	}
+	appMetrics := metrics.New(db, cacheStats)
+
+	// Readiness requires the database, Redis when it was reachable at startup, and a
+	// schema as new as this binary.
+	migrator, err := database.NewMigrator(db, cfg.Database.Driver)
+	if err != nil {
+		fatal("Failed to load migrations", err)
+	}
+	healthChecker := health.NewChecker(2 * time.Second)
+	healthChecker.Add("database", db.PingContext)
+	healthChecker.Add("migrations", migrator.CheckCurrent)
+	if redisClient != nil {
+		healthChecker.Add("redis", func(ctx context.Context) error { return redisClient.Ping(ctx).Err() })
+	}
 	env := &handlers.Env{
 		BookRepo:   bookRepo,
 		UserRepo:   userRepo,
 		AuthorRepo: authorRepo,
 		LoanRepo:   loanRepo,
-		JWTSecret:  cfg.JWTSecret,
+		CopyRepo:   copyRepo,
+		HoldRepo:   holdRepo,
+		RoleRepo:   roleRepo,
+
+		AccountRepo:    accountRepo,
+		FinePolicyRepo: finePolicyRepo,
+		LoanPolicyRepo: loanPolicyRepo,
+
+		RefreshTokenRepo: refreshTokenRepo,
+		Tokens:           tokens,
+		RevokedTokens:    revokedTokens,
+		LoginAttemptRepo: loginAttemptRepo,
+		Lockout:          lockout,
+
+		PasswordPolicy:    passwordPolicy,
+		PasswordResetRepo: passwordResetRepo,
+		PasswordResetTTL:  time.Duration(cfg.Password.ResetTTLHours) * time.Hour,
+
+		TwoFactorRepo:          twoFactorRepo,
+		TwoFactorIssuer:        cfg.TwoFactor.Issuer,
+		TwoFactorRequiredRoles: cfg.TwoFactor.RequiredRoles,
+		AuditRepo:              auditRepo,
+
+		LoanPeriodDays:          cfg.Circulation.LoanPeriodDays,
+		MaxRenewals:             cfg.Circulation.MaxRenewals,
+		HoldPickupDays:          cfg.Circulation.HoldPickupDays,
+		FineBlockThresholdCents: int64(cfg.Circulation.FineBlockThresholdCents),
+
+		CacheStats: cacheStats,
+		Metrics:    appMetrics,
+		Health:     healthChecker,
 	}
 
 	// --- 2. ROUTING ---
 	router := mux.NewRouter()
+	if cfg.RateLimit.TrustProxy {
+		router.Use(middleware.RealIP)
+	}
+	router.Use(middleware.Tracing)
+	router.Use(middleware.RequestID)
+	router.Use(middleware.AuditActor)
 	router.Use(middleware.LoggingMiddleware)
+	router.Use(middleware.Metrics(appMetrics))
+	router.Use(middleware.Timeout(time.Duration(cfg.Database.TimeoutSeconds) * time.Second))
+	// Unmatched requests bypass the router's middleware, so they are given a request ID here.
+	router.NotFoundHandler = middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		web.RespondWithError(w, http.StatusNotFound, "No such endpoint")
+	}))
+	router.MethodNotAllowedHandler = middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		web.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed for this endpoint")
+	}))
 
-	authMw := middleware.AuthMiddleware(userRepo, cfg.JWTSecret)
-	adminMw := middleware.RoleRequiredMiddleware("librarian")
+	authMw := middleware.AuthMiddleware(userRepo, tokens, revokedTokens)
+	// can wraps a route so only users whose role grants permission reach it, once they
+	// have enabled two-factor authentication if their role requires it.
+	requireTwoFactor := middleware.RequireTwoFactor(cfg.TwoFactor.RequiredRoles)
+	can := func(permission string) func(http.Handler) http.Handler {
+		requirePermission := middleware.RequirePermission(roleRepo, permission)
+		return func(next http.Handler) http.Handler {
+			return requireTwoFactor(requirePermission(next))
+		}
+	}
 
 	router.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
+	// Metrics go on the admin server when there is one, out of reach of API clients.
+	var adminSrv *http.Server
+	if cfg.Metrics.Addr == "" {
+		router.Handle("/metrics", appMetrics.Handler()).Methods(http.MethodGet)
+	} else {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", appMetrics.Handler())
+		adminSrv = &http.Server{Addr: cfg.Metrics.Addr, Handler: adminMux}
+	}
 
 	// ... (All route definitions remain the same)
-	router.HandleFunc("/register", env.RegisterUserHandler).Methods(http.MethodPost)
-	router.HandleFunc("/login", env.LoginUserHandler).Methods(http.MethodPost)
+	router.HandleFunc("/healthz", env.HealthzHandler).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", env.ReadyzHandler).Methods(http.MethodGet)
+	// Logins are limited by IP against password spraying, and by username against
+	// guessing one account's password from many addresses.
+	registerLimit := middleware.RateLimit(limiter,
+		middleware.RateRule{Name: "register:ip", Limit: ratelimit.PerMinute(cfg.RateLimit.RegisterPerIP), Key: middleware.ByIP})
+	loginLimit := middleware.RateLimit(limiter,
+		middleware.RateRule{Name: "login:ip", Limit: ratelimit.PerMinute(cfg.RateLimit.LoginPerIP), Key: middleware.ByIP},
+		middleware.RateRule{Name: "login:user", Limit: ratelimit.PerMinute(cfg.RateLimit.LoginPerUsername), Key: middleware.ByUsername})
+	twoFactorLimit := middleware.RateLimit(limiter,
+		middleware.RateRule{Name: "login-2fa:ip", Limit: ratelimit.PerMinute(cfg.RateLimit.LoginPerIP), Key: middleware.ByIP})
+	resetLimit := middleware.RateLimit(limiter,
+		middleware.RateRule{Name: "password-reset:ip", Limit: ratelimit.PerMinute(cfg.RateLimit.LoginPerIP), Key: middleware.ByIP})
+	router.Handle("/register", registerLimit(http.HandlerFunc(env.RegisterUserHandler))).Methods(http.MethodPost)
+	router.Handle("/login", loginLimit(http.HandlerFunc(env.LoginUserHandler))).Methods(http.MethodPost)
+	router.Handle("/login/2fa", twoFactorLimit(http.HandlerFunc(env.LoginTwoFactorHandler))).Methods(http.MethodPost)
+	router.Handle("/password-reset", resetLimit(http.HandlerFunc(env.ResetPasswordHandler))).Methods(http.MethodPost)
+	router.Handle("/users/me/password", authMw(http.HandlerFunc(env.ChangeMyPasswordHandler))).Methods(http.MethodPut)
+	router.Handle("/users/{id}/password-reset", authMw(can(models.PermUsersManage)(http.HandlerFunc(env.CreatePasswordResetHandler)))).Methods(http.MethodPost)
+	router.Handle("/users/me/2fa", authMw(http.HandlerFunc(env.GetMyTwoFactorHandler))).Methods(http.MethodGet)
+	router.Handle("/users/me/2fa", authMw(http.HandlerFunc(env.EnrollTwoFactorHandler))).Methods(http.MethodPost)
+	router.Handle("/users/me/2fa", authMw(http.HandlerFunc(env.DisableMyTwoFactorHandler))).Methods(http.MethodDelete)
+	router.Handle("/users/me/2fa/confirm", authMw(http.HandlerFunc(env.ConfirmTwoFactorHandler))).Methods(http.MethodPost)
+	router.Handle("/users/{id}/2fa", authMw(can(models.PermUsersManage)(http.HandlerFunc(env.ResetTwoFactorHandler)))).Methods(http.MethodDelete)
+	router.Handle("/login-attempts", authMw(can(models.PermSecurityRead)(http.HandlerFunc(env.GetLoginAttemptsHandler)))).Methods(http.MethodGet)
+	router.Handle("/audit", authMw(can(models.PermAuditRead)(http.HandlerFunc(env.GetAuditLogHandler)))).Methods(http.MethodGet)
+	router.Handle("/audit/verify", authMw(can(models.PermAuditRead)(http.HandlerFunc(env.VerifyAuditLogHandler)))).Methods(http.MethodGet)
+	router.HandleFunc("/.well-known/jwks.json", env.GetJWKSHandler).Methods(http.MethodGet)
+	router.HandleFunc("/token/refresh", env.RefreshTokenHandler).Methods(http.MethodPost)
+	router.Handle("/logout", authMw(http.HandlerFunc(env.LogoutHandler))).Methods(http.MethodPost)
+	router.Handle("/logout-all", authMw(http.HandlerFunc(env.LogoutAllHandler))).Methods(http.MethodPost)
 	router.HandleFunc("/authors", env.GetAuthorsHandler).Methods(http.MethodGet)
 	router.HandleFunc("/authors/{id}", env.GetAuthorHandler).Methods(http.MethodGet)
-	router.Handle("/authors", authMw(adminMw(http.HandlerFunc(env.CreateAuthorHandler)))).Methods(http.MethodPost)
+	router.Handle("/authors", authMw(can(models.PermAuthorsWrite)(http.HandlerFunc(env.CreateAuthorHandler)))).Methods(http.MethodPost)
+	router.Handle("/authors/{id}", authMw(can(models.PermAuthorsWrite)(http.HandlerFunc(env.UpdateAuthorHandler)))).Methods(http.MethodPut)
+	router.Handle("/authors/{id}", authMw(can(models.PermAuthorsWrite)(http.HandlerFunc(env.PatchAuthorHandler)))).Methods(http.MethodPatch)
+	router.Handle("/authors/{id}", authMw(can(models.PermAuthorsWrite)(http.HandlerFunc(env.DeleteAuthorHandler)))).Methods(http.MethodDelete)
+	router.HandleFunc("/authors/{id}/books", env.GetAuthorBooksHandler).Methods(http.MethodGet)
+	router.Handle("/authors/{id}/merge", authMw(can(models.PermAuthorsMerge)(http.HandlerFunc(env.MergeAuthorsHandler)))).Methods(http.MethodPost)
 	router.HandleFunc("/books", env.GetBooksHandler).Methods(http.MethodGet)
 	router.HandleFunc("/books/{id}", env.GetBookHandler).Methods(http.MethodGet)
-	router.Handle("/books", authMw(adminMw(http.HandlerFunc(env.CreateBookHandler)))).Methods(http.MethodPost)
-	router.Handle("/books/{id}", authMw(adminMw(http.HandlerFunc(env.UpdateBookHandler)))).Methods(http.MethodPut)
-	router.Handle("/books/{id}", authMw(adminMw(http.HandlerFunc(env.DeleteBookHandler)))).Methods(http.MethodDelete)
+	router.Handle("/books", authMw(can(models.PermBooksWrite)(http.HandlerFunc(env.CreateBookHandler)))).Methods(http.MethodPost)
+	router.Handle("/books/{id}", authMw(can(models.PermBooksWrite)(http.HandlerFunc(env.UpdateBookHandler)))).Methods(http.MethodPut)
+	router.Handle("/books/{id}", authMw(can(models.PermBooksWrite)(http.HandlerFunc(env.DeleteBookHandler)))).Methods(http.MethodDelete)
+	router.Handle("/books/{id}/copies", authMw(can(models.PermCopiesManage)(http.HandlerFunc(env.GetBookCopiesHandler)))).Methods(http.MethodGet)
+	router.Handle("/books/{id}/copies", authMw(can(models.PermCopiesManage)(http.HandlerFunc(env.CreateCopyHandler)))).Methods(http.MethodPost)
+	router.Handle("/copies/{id}", authMw(can(models.PermCopiesManage)(http.HandlerFunc(env.GetCopyHandler)))).Methods(http.MethodGet)
+	router.Handle("/copies/{id}", authMw(can(models.PermCopiesManage)(http.HandlerFunc(env.UpdateCopyHandler)))).Methods(http.MethodPut)
+	router.Handle("/copies/{id}", authMw(can(models.PermCopiesManage)(http.HandlerFunc(env.DeleteCopyHandler)))).Methods(http.MethodDelete)
 	router.Handle("/loans", authMw(http.HandlerFunc(env.CreateLoanHandler))).Methods(http.MethodPost)
 	router.Handle("/loans/{id}", authMw(http.HandlerFunc(env.ReturnLoanHandler))).Methods(http.MethodDelete)
+	router.Handle("/loans/{id}/renew", authMw(http.HandlerFunc(env.RenewLoanHandler))).Methods(http.MethodPost)
 	router.Handle("/users/me/loans", authMw(http.HandlerFunc(env.GetMyLoansHandler))).Methods(http.MethodGet)
-	router.Handle("/loans", authMw(adminMw(http.HandlerFunc(env.GetAllLoansHandler)))).Methods(http.MethodGet)
+	router.Handle("/books/{id}/holds", authMw(http.HandlerFunc(env.CreateHoldHandler))).Methods(http.MethodPost)
+	router.Handle("/users/me/holds", authMw(http.HandlerFunc(env.GetMyHoldsHandler))).Methods(http.MethodGet)
+	router.Handle("/holds/{id}", authMw(http.HandlerFunc(env.DeleteHoldHandler))).Methods(http.MethodDelete)
+	router.Handle("/loans", authMw(can(models.PermLoansReadAll)(http.HandlerFunc(env.GetAllLoansHandler)))).Methods(http.MethodGet)
+	router.Handle("/circulation/checkout", authMw(can(models.PermCirculationDesk)(http.HandlerFunc(env.CheckoutHandler)))).Methods(http.MethodPost)
+	router.Handle("/circulation/checkin", authMw(can(models.PermCirculationDesk)(http.HandlerFunc(env.CheckinHandler)))).Methods(http.MethodPost)
+	router.Handle("/patrons/{id}", authMw(can(models.PermCirculationDesk)(http.HandlerFunc(env.GetPatronHandler)))).Methods(http.MethodGet)
+	router.Handle("/users/{id}/membership", authMw(can(models.PermUsersManage)(http.HandlerFunc(env.UpdateMembershipHandler)))).Methods(http.MethodPut)
+	router.Handle("/loan-policies", authMw(can(models.PermLoanPoliciesManage)(http.HandlerFunc(env.GetLoanPoliciesHandler)))).Methods(http.MethodGet)
+	router.Handle("/loan-policies/{role}/{material_type}", authMw(can(models.PermLoanPoliciesManage)(http.HandlerFunc(env.UpdateLoanPolicyHandler)))).Methods(http.MethodPut)
+	router.Handle("/loan-policies/{role}/{material_type}", authMw(can(models.PermLoanPoliciesManage)(http.HandlerFunc(env.DeleteLoanPolicyHandler)))).Methods(http.MethodDelete)
+	router.Handle("/users/me/account", authMw(http.HandlerFunc(env.GetMyAccountHandler))).Methods(http.MethodGet)
+	router.Handle("/users/{id}/account", authMw(can(models.PermAccountsRead)(http.HandlerFunc(env.GetUserAccountHandler)))).Methods(http.MethodGet)
+	router.Handle("/users/{id}/account/entries", authMw(can(models.PermAccountsWrite)(http.HandlerFunc(env.CreateAccountEntryHandler)))).Methods(http.MethodPost)
+	router.Handle("/fine-policies", authMw(can(models.PermFinePoliciesManage)(http.HandlerFunc(env.GetFinePoliciesHandler)))).Methods(http.MethodGet)
+	router.Handle("/permissions", authMw(can(models.PermRolesManage)(http.HandlerFunc(env.GetPermissionsHandler)))).Methods(http.MethodGet)
+	router.Handle("/roles", authMw(can(models.PermRolesManage)(http.HandlerFunc(env.GetRolesHandler)))).Methods(http.MethodGet)
+	router.Handle("/roles", authMw(can(models.PermRolesManage)(http.HandlerFunc(env.CreateRoleHandler)))).Methods(http.MethodPost)
+	router.Handle("/roles/{name}", authMw(can(models.PermRolesManage)(http.HandlerFunc(env.UpdateRoleHandler)))).Methods(http.MethodPut)
+	router.Handle("/roles/{name}", authMw(can(models.PermRolesManage)(http.HandlerFunc(env.DeleteRoleHandler)))).Methods(http.MethodDelete)
+	router.Handle("/users/{id}/role", authMw(can(models.PermUsersManage)(http.HandlerFunc(env.UpdateUserRoleHandler)))).Methods(http.MethodPut)
+	router.Handle("/cache/stats", authMw(can(models.PermSystemRead)(http.HandlerFunc(env.GetCacheStatsHandler)))).Methods(http.MethodGet)
+	router.Handle("/fine-policies/{material_type}", authMw(can(models.PermFinePoliciesManage)(http.HandlerFunc(env.UpdateFinePolicyHandler)))).Methods(http.MethodPut)
+
+	// Requests and background jobs run on this context. It is cancelled if the server
+	// cannot shut down gracefully, so queries still running are abandoned.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	// Copies set aside for holds that were not picked up in time pass to the next hold.
+	go expireHolds(baseCtx, holdRepo, cfg.Circulation.HoldPickupDays, 15*time.Minute)
 
 	// --- 3. GRACEFUL SHUTDOWN ---
 	srv := &http.Server{
-		Addr:    cfg.ServerPort,
-		Handler: router,
+		Addr:        cfg.ServerPort,
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+		ErrorLog:    slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+	if adminSrv != nil {
+		go func() {
+			slog.Info("Starting admin server", "addr", adminSrv.Addr)
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Could not start admin server", err)
+			}
+		}()
 	}
 	go func() {
-		log.Printf("Starting server on port %s\n", srv.Addr)
+		slog.Info("Starting server", "addr", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Could not start server: %s\n", err)
+			fatal("Could not start server", err)
 		}
 	}()
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	// Report unready first, so load balancers stop sending traffic before the server stops
+	// accepting it. A second signal skips the wait.
+	healthChecker.Drain()
+	if drain := time.Duration(cfg.Shutdown.DrainSeconds) * time.Second; drain > 0 {
+		slog.Info("Draining traffic", "seconds", cfg.Shutdown.DrainSeconds)
+		select {
+		case <-time.After(drain):
+		case <-quit:
+		}
+	}
+	slog.Info("Shutting down server")
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		cancelBase()
+		fatal("Server forced to shutdown", err)
+	}
+	// The admin server stops last, so metrics can be scraped while requests drain.
+	if adminSrv != nil {
+		adminSrv.Shutdown(ctx)
+	}
+	// Spans still buffered are sent before exiting.
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	slog.Info("Server exiting")
+}
+
+// fatal logs an error the server cannot start or stop cleanly with, and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newRedisClient connects to Redis, returning nil when it is unreachable so the caller
+// can fall back to an in-process alternative.
+func newRedisClient(cfg *configs.Config) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		slog.Warn("Redis unavailable, using in-process caching, token revocation and rate limiting", "addr", cfg.Redis.Addr, "error", err)
+		client.Close()
+		return nil
+	}
+	slog.Info("Connected to Redis", "addr", cfg.Redis.Addr)
+	return client
+}
+
+// newRevocationList keeps revoked access tokens in Redis when it is reachable, so every
+// instance refuses them, and falls back to process memory otherwise.
+func newRevocationList(client *redis.Client, tokenTTL time.Duration) auth.RevocationList {
+	if client == nil {
+		return auth.NewMemoryRevocationList(tokenTTL)
+	}
+	return auth.NewRedisRevocationList(client, tokenTTL)
+}
+
+// newRateLimiter keeps rate limits and failed login counts in Redis when it is reachable,
+// so they hold across every instance, and falls back to process memory otherwise.
+func newRateLimiter(cfg *configs.Config, client *redis.Client) (ratelimit.Limiter, ratelimit.Lockout) {
+	policy := ratelimit.LockoutPolicy{
+		Threshold: cfg.RateLimit.LockoutThreshold,
+		Duration:  time.Duration(cfg.RateLimit.LockoutMinutes) * time.Minute,
+	}
+	if client == nil {
+		return ratelimit.NewMemoryLimiter(), ratelimit.NewMemoryLockout(policy)
+	}
+	return ratelimit.NewRedisLimiter(client), ratelimit.NewRedisLockout(client, policy)
+}
+
+// newCache picks the cache the caching repositories share: Redis when it is reachable,
+// the in-process LRU otherwise. It returns nil when caching is disabled.
+func newCache(cfg *configs.Config, client *redis.Client) (cache.Cache, *cache.Stats) {
+	switch {
+	case cfg.Cache.TTLSeconds == 0 || (client == nil && cfg.Cache.LRUSize == 0):
+		return nil, cache.NewStats("none")
+	case client != nil:
+		return cache.NewRedisCache(client), cache.NewStats("redis")
+	default:
+		return cache.NewLRUCache(cfg.Cache.LRUSize), cache.NewStats("memory")
+	}
+}
+
+// expireHolds expires ready holds past their pickup deadline every interval, until ctx is done.
+func expireHolds(ctx context.Context, holdRepo repository.HoldRepository, pickupDays int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		expired, err := holdRepo.ExpireReadyHolds(ctx, time.Now().AddDate(0, 0, pickupDays))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to expire holds", "error", err)
+		} else if len(expired) > 0 {
+			slog.InfoContext(ctx, "Expired holds that were not picked up", "count", len(expired))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
-	log.Println("Server exiting.")
 }
@@ -2,23 +2,116 @@
 package configs
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 )
 
+// Supported database drivers, as registered with database/sql.
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "pgx"
+)
+
+// EnvDevelopment is the default APP_ENV, which allows insecure settings meant for local use.
+const EnvDevelopment = "development"
+
+// defaultJWTSecret is the HS256 secret used when none is configured. It is public, so it is
+// refused outside development.
+const defaultJWTSecret = "default_super_secret_key_for_dev_only"
+
 // Config holds all configuration for the application.
 type Config struct {
+	Environment  string // APP_ENV: "development" locally, anything else (e.g. "production") is strict
 	ServerPort   string
 	PublicHost   string // The public-facing hostname (e.g., my-app.com)
 	PublicScheme string // The public-facing protocol (http or https)
-	Database     struct{ DSN string }
-	Redis        struct {
+	Database     struct {
+		DSN    string
+		Driver string // Derived from the DSN scheme (DriverSQLite or DriverPostgres)
+		// Seconds a request's queries may run before they are cancelled; 0 disables the limit
+		TimeoutSeconds int
+	}
+	Shutdown struct {
+		// Seconds /readyz reports the server as draining before it stops accepting
+		// connections on SIGTERM, so load balancers have time to stop sending it traffic
+		DrainSeconds int
+	}
+	Log struct {
+		Level  string // debug, info, warn or error
+		Format string // json, for log pipelines, or text, for reading in a terminal
+	}
+	Metrics struct {
+		// Address of a separate admin server for /metrics (e.g. ":9090"); when empty,
+		// /metrics is served on the main port
+		Addr string
+	}
+	Tracing struct {
+		Exporter      string // none, stdout (for local debugging) or otlp
+		OTLPEndpoint  string // Collector URL for the otlp exporter, e.g. http://localhost:4318
+		ServiceName   string // Name the service's spans are reported under
+		SamplePercent int    // Percentage of new traces recorded; traces joined from callers follow their decision
+	}
+	Password struct {
+		MinLength int // Characters a password must have at least
+		// File of breached passwords, one per line, that users may not choose; none when empty
+		BreachedFile string
+		// Hours a password reset token issued by a librarian stays valid
+		ResetTTLHours int
+	}
+	TwoFactor struct {
+		Issuer string // Names the service in authenticator apps
+		// Roles whose users must enable two-factor authentication before they may use
+		// any route that requires a permission
+		RequiredRoles []string
+	}
+	Audit struct {
+		// Chain audit log entries by hash, so altering or deleting one can be detected.
+		// Appending is serialized while it is on.
+		HashChain bool
+	}
+	RateLimit struct {
+		// Requests a minute allowed to /login per client IP and per username, and to
+		// /register per client IP; 0 disables a limit
+		LoginPerIP       int
+		LoginPerUsername int
+		RegisterPerIP    int
+		// Failed logins in a row that lock an account, for LockoutMinutes; 0 disables lockout
+		LockoutThreshold int
+		LockoutMinutes   int
+		// Take client IPs from X-Forwarded-For, as set by a reverse proxy such as Passenger's.
+		// Only enable it behind a proxy, or clients can pick the address they are limited by.
+		TrustProxy bool
+	}
+	Redis struct {
 		Addr     string
 		Password string
 		DB       int
 	}
+	Cache struct {
+		// Seconds books and authors stay cached; 0 disables caching. Cached books may
+		// show stock up to this old.
+		TTLSeconds int
+		LRUSize    int // Entries kept by the in-process cache used when Redis is unreachable
+	}
+	Circulation struct {
+		LoanPeriodDays int // Days a loan runs before it is due, and how far each renewal extends it
+		MaxRenewals    int // Times a single loan may be renewed
+		HoldPickupDays int // Days a copy set aside for a hold waits for pickup before passing to the next hold
+		// Patrons owing more than this, in cents, cannot borrow until they pay
+		FineBlockThresholdCents int
+	}
 	JWTSecret string
+	JWT       struct {
+		KeysDir    string // Directory of PEM keys for RS256/EdDSA signing; HS256 with JWTSecret when empty
+		SigningKID string // ID (file name) of the key new tokens are signed with
+	}
+	Tokens struct {
+		AccessTTLMinutes int // Minutes an access token is accepted before it must be refreshed
+		RefreshTTLDays   int // Days a refresh token can be exchanged for a new pair
+	}
 }
 
 // LoadConfig reads configuration from environment variables and returns a Config struct.
@@ -34,6 +127,16 @@ func LoadConfig() *Config {
 		cfg.ServerPort = ":" + cfg.ServerPort
 	}
 
+	// --- Environment ---
+	cfg.Environment = os.Getenv("APP_ENV")
+	if cfg.Environment == "" {
+		if os.Getenv("IN_PASSENGER") == "1" {
+			cfg.Environment = "production"
+		} else {
+			cfg.Environment = EnvDevelopment
+		}
+	}
+
 	// --- Public Host & Scheme (External) ---
 	// Check if we are running in the production environment (Domcloud/Passenger).
 	if os.Getenv("IN_PASSENGER") == "1" {
@@ -56,16 +159,123 @@ func LoadConfig() *Config {
 	if cfg.Database.DSN == "" {
 		cfg.Database.DSN = "./library.db"
 	}
+	cfg.Database.Driver = DatabaseDriver(cfg.Database.DSN)
+	cfg.Database.TimeoutSeconds = envInt("DB_TIMEOUT_SECONDS", 5)
+	// Locally there is no load balancer to wait for.
+	drainSeconds := 5
+	if cfg.Environment == EnvDevelopment {
+		drainSeconds = 0
+	}
+	cfg.Shutdown.DrainSeconds = envInt("SHUTDOWN_DRAIN_SECONDS", drainSeconds)
+	cfg.Log.Level = envString("LOG_LEVEL", "info")
+	cfg.Log.Format = envString("LOG_FORMAT", "json")
+	cfg.Metrics.Addr = os.Getenv("METRICS_ADDR")
+	if cfg.Metrics.Addr != "" && !strings.Contains(cfg.Metrics.Addr, ":") {
+		cfg.Metrics.Addr = ":" + cfg.Metrics.Addr
+	}
+	cfg.Tracing.Exporter = envString("TRACING_EXPORTER", "none")
+	cfg.Tracing.OTLPEndpoint = envString("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	cfg.Tracing.ServiceName = envString("OTEL_SERVICE_NAME", "librarium")
+	cfg.Tracing.SamplePercent = min(envInt("TRACING_SAMPLE_PERCENT", 100), 100)
+	cfg.Password.MinLength = envInt("PASSWORD_MIN_LENGTH", 10)
+	cfg.Password.BreachedFile = os.Getenv("BREACHED_PASSWORDS_FILE")
+	cfg.Password.ResetTTLHours = envInt("PASSWORD_RESET_TTL_HOURS", 24)
+	cfg.TwoFactor.Issuer = envString("TWO_FACTOR_ISSUER", "Librarium")
+	cfg.TwoFactor.RequiredRoles = envList("TWO_FACTOR_REQUIRED_ROLES")
+	cfg.Audit.HashChain = envBool("AUDIT_HASH_CHAIN", false)
+	cfg.RateLimit.LoginPerIP = envInt("RATE_LIMIT_LOGIN_PER_IP", 20)
+	cfg.RateLimit.LoginPerUsername = envInt("RATE_LIMIT_LOGIN_PER_USERNAME", 5)
+	cfg.RateLimit.RegisterPerIP = envInt("RATE_LIMIT_REGISTER_PER_IP", 5)
+	cfg.RateLimit.LockoutThreshold = envInt("LOCKOUT_THRESHOLD", 5)
+	cfg.RateLimit.LockoutMinutes = envInt("LOCKOUT_MINUTES", 15)
+	cfg.RateLimit.TrustProxy = envBool("TRUST_PROXY", os.Getenv("IN_PASSENGER") == "1")
 	cfg.Redis.Addr = os.Getenv("REDIS_ADDR")
 	if cfg.Redis.Addr == "" {
 		cfg.Redis.Addr = "localhost:6379"
 	}
 	cfg.Redis.Password = os.Getenv("REDIS_PASSWORD")
+	cfg.Cache.TTLSeconds = envInt("CACHE_TTL_SECONDS", 60)
+	cfg.Cache.LRUSize = envInt("CACHE_LRU_SIZE", 1000)
+	cfg.Circulation.LoanPeriodDays = envInt("LOAN_PERIOD_DAYS", 14)
+	cfg.Circulation.MaxRenewals = envInt("MAX_RENEWALS", 2)
+	cfg.Circulation.HoldPickupDays = envInt("HOLD_PICKUP_DAYS", 3)
+	cfg.Circulation.FineBlockThresholdCents = envInt("FINE_BLOCK_THRESHOLD_CENTS", 1000)
 	cfg.JWTSecret = os.Getenv("JWT_SECRET_KEY")
 	if cfg.JWTSecret == "" {
-		cfg.JWTSecret = "default_super_secret_key_for_dev_only"
+		cfg.JWTSecret = defaultJWTSecret
 	}
+	cfg.JWT.KeysDir = os.Getenv("JWT_KEYS_DIR")
+	cfg.JWT.SigningKID = os.Getenv("JWT_SIGNING_KID")
+	cfg.Tokens.AccessTTLMinutes = envInt("ACCESS_TOKEN_TTL_MINUTES", 15)
+	cfg.Tokens.RefreshTTLDays = envInt("REFRESH_TOKEN_TTL_DAYS", 30)
 
-	log.Println("Configuration loaded")
 	return &cfg
 }
+
+// Validate reports settings the server must not start with. Outside development, tokens
+// cannot be signed with the default secret, which anyone can read in the source.
+func (cfg *Config) Validate() error {
+	if cfg.Environment != EnvDevelopment && cfg.JWT.KeysDir == "" && cfg.JWTSecret == defaultJWTSecret {
+		return fmt.Errorf("JWT_SECRET_KEY or JWT_KEYS_DIR must be set when APP_ENV is %q", cfg.Environment)
+	}
+	return nil
+}
+
+// envString reads a string from the environment, falling back to def when the variable is unset.
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// envList reads a comma-separated list from the environment, ignoring blank entries.
+// It is empty when the variable is unset.
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envInt reads a non-negative integer from the environment, falling back to def
+// when the variable is unset or invalid.
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		slog.Warn("Invalid setting, using default", "key", key, "value", value, "default", def)
+		return def
+	}
+	return n
+}
+
+// envBool reads a boolean ("true", "false", "1", "0", ...) from the environment, falling
+// back to def when the variable is unset or invalid.
+func envBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", value, "default", def)
+		return def
+	}
+	return b
+}
+
+// DatabaseDriver picks the database driver from the DSN scheme.
+// "postgres://" and "postgresql://" DSNs use PostgreSQL; anything else is treated as a SQLite file path.
+func DatabaseDriver(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return DriverPostgres
+	}
+	return DriverSQLite
+}
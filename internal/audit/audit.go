@@ -0,0 +1,170 @@
+// Package audit builds the entries of the append-only audit log: who changed which
+// entity, how, and from which request. Repositories write them in the same transaction
+// as the change, so a change is never committed without its entry.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// Actor is whoever makes the changes of a request: a logged-in user, an anonymous client
+// such as someone registering, or a command-line tool.
+type Actor struct {
+	UserID    int64 // 0 when nobody is logged in
+	Username  string
+	RequestID string
+	IP        string
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose changes are attributed to actor. The actor is shared,
+// so middleware further down the chain can fill in the user once it is authenticated.
+func WithActor(ctx context.Context, actor *Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor of ctx, or nil if it has none.
+func ActorFrom(ctx context.Context) *Actor {
+	actor, _ := ctx.Value(actorKey{}).(*Actor)
+	return actor
+}
+
+// Change is the value of a field before and after a change. From is null for entities
+// that were created, and To for ones that were deleted.
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Diff returns the JSON object of the fields whose JSON value differs between before and
+// after, each as a Change. Either may be nil, for creations and deletions.
+func Diff(before, after interface{}) (json.RawMessage, error) {
+	from, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]Change)
+	for name, value := range from {
+		if string(to[name]) != string(value) {
+			changes[name] = Change{From: value, To: to[name]}
+		}
+	}
+	for name, value := range to {
+		if _, ok := from[name]; !ok {
+			changes[name] = Change{From: nil, To: value}
+		}
+	}
+	return json.Marshal(changes)
+}
+
+// fields returns the JSON value of each field of v, which must marshal to an object.
+func fields(v interface{}) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	return m, json.Unmarshal(b, &m)
+}
+
+// NewEntry builds the entry recording that the actor of ctx applied action to the entity,
+// changing it from before to after. It is timestamped now, to the microsecond, which is
+// as precise as every supported database stores it.
+func NewEntry(ctx context.Context, action, entityType string, entityID int64, before, after interface{}) (models.AuditEntry, error) {
+	changes, err := Diff(before, after)
+	if err != nil {
+		return models.AuditEntry{}, err
+	}
+	entry := models.AuditEntry{
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    changes,
+	}
+	if actor := ActorFrom(ctx); actor != nil {
+		if actor.UserID != 0 {
+			id := actor.UserID
+			entry.ActorID = &id
+		}
+		entry.Actor = actor.Username
+		entry.RequestID = actor.RequestID
+		entry.IP = actor.IP
+	}
+	return entry, nil
+}
+
+// hashChain reports whether entries are chained by hash.
+var hashChain atomic.Bool
+
+// SetHashChain turns chaining entries by hash on or off. Each chained entry stores the
+// hash of the chained entry before it, so editing or deleting one breaks the chain from
+// there on. Appending is serialized while it is on.
+func SetHashChain(enabled bool) {
+	hashChain.Store(enabled)
+}
+
+// HashChain reports whether entries are chained by hash.
+func HashChain() bool {
+	return hashChain.Load()
+}
+
+// Hash returns the hash of entry chained after the entry with hash prevHash ("" for the
+// first). It covers every field but the ID, which the database assigns.
+func Hash(prevHash string, entry models.AuditEntry) string {
+	var actorID string
+	if entry.ActorID != nil {
+		actorID = strconv.FormatInt(*entry.ActorID, 10)
+	}
+	h := sha256.New()
+	for _, field := range []string{
+		prevHash,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		actorID,
+		entry.Actor,
+		entry.Action,
+		entry.EntityType,
+		strconv.FormatInt(entry.EntityID, 10),
+		string(entry.Changes),
+		entry.RequestID,
+		entry.IP,
+	} {
+		// Fields are length-prefixed, so moving text from one to the next changes the hash.
+		h.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verifier checks a hash chain one entry at a time, in the order they were appended.
+type Verifier struct {
+	prevHash string
+}
+
+// Check reports whether entry links to the chained entry before it and its hash matches
+// its contents. Entries written while the chain was off are not chained and always pass.
+func (v *Verifier) Check(entry models.AuditEntry) bool {
+	if entry.Hash == "" {
+		return true
+	}
+	if entry.PrevHash != v.prevHash || Hash(v.prevHash, entry) != entry.Hash {
+		return false
+	}
+	v.prevHash = entry.Hash
+	return true
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// TestDiff tests that only changed fields are recorded, with null standing in for the
+// missing side of creations and deletions.
+func TestDiff(t *testing.T) {
+	type book struct {
+		Title string `json:"title"`
+		ISBN  string `json:"isbn"`
+	}
+	tests := []struct {
+		name          string
+		before, after interface{}
+		want          string
+	}{
+		{"update", book{"Dune", "1"}, book{"Dune Messiah", "1"}, `{"title":{"from":"Dune","to":"Dune Messiah"}}`},
+		{"unchanged", book{"Dune", "1"}, book{"Dune", "1"}, `{}`},
+		{"create", nil, book{"Dune", "1"}, `{"isbn":{"from":null,"to":"1"},"title":{"from":null,"to":"Dune"}}`},
+		{"delete", book{"Dune", "1"}, nil, `{"isbn":{"from":"1","to":null},"title":{"from":"Dune","to":null}}`},
+		{"no fields", nil, nil, `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff(tt.before, tt.after)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Diff() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNewEntry tests that an entry is attributed to the actor of the context, and that
+// anonymous actors have no actor ID.
+func TestNewEntry(t *testing.T) {
+	ctx := WithActor(context.Background(), &Actor{UserID: 3, Username: "lib", RequestID: "req-1", IP: "10.0.0.1"})
+	entry, err := NewEntry(ctx, models.AuditDelete, models.AuditBook, 7, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if entry.ActorID == nil || *entry.ActorID != 3 || entry.Actor != "lib" || entry.RequestID != "req-1" || entry.IP != "10.0.0.1" {
+		t.Errorf("expected the entry to be attributed to the actor, but got %+v", entry)
+	}
+
+	entry, err = NewEntry(WithActor(context.Background(), &Actor{IP: "10.0.0.2"}), models.AuditCreate, models.AuditUser, 8, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if entry.ActorID != nil || entry.IP != "10.0.0.2" {
+		t.Errorf("expected an anonymous entry with an IP, but got %+v", entry)
+	}
+}
+
+// TestVerifier tests that a chain checks out, skipping unchained entries, and that altering
+// an entry or dropping one from the chain is detected.
+func TestVerifier(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	chain := func() []models.AuditEntry {
+		entries := []models.AuditEntry{
+			{ID: 1, CreatedAt: created, Action: models.AuditCreate, EntityType: models.AuditBook, EntityID: 1, Changes: []byte(`{}`)},
+			{ID: 2, CreatedAt: created, Action: models.AuditUpdate, EntityType: models.AuditBook, EntityID: 1, Changes: []byte(`{}`)},
+			{ID: 3, CreatedAt: created, Action: models.AuditDelete, EntityType: models.AuditBook, EntityID: 1, Changes: []byte(`{}`)},
+		}
+		var prev string
+		for i := range entries {
+			if i == 1 {
+				continue // written while the chain was off
+			}
+			entries[i].PrevHash = prev
+			entries[i].Hash = Hash(prev, entries[i])
+			prev = entries[i].Hash
+		}
+		return entries
+	}
+	check := func(entries []models.AuditEntry) int64 {
+		var v Verifier
+		for _, entry := range entries {
+			if !v.Check(entry) {
+				return entry.ID
+			}
+		}
+		return 0
+	}
+
+	if broken := check(chain()); broken != 0 {
+		t.Errorf("expected an intact chain, but it broke at %d", broken)
+	}
+	altered := chain()
+	altered[0].Actor = "someone else"
+	if broken := check(altered); broken != 1 {
+		t.Errorf("expected an altered entry to break the chain at 1, but got %d", broken)
+	}
+	if broken := check(append(chain()[:0:0], chain()[1:]...)); broken != 3 {
+		t.Errorf("expected a deleted entry to break the chain at 3, but got %d", broken)
+	}
+}
@@ -0,0 +1,193 @@
+// Package auth issues and verifies the tokens clients authenticate with.
+// This file contains the keys access tokens are signed and verified with.
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// minRSABits is the smallest RSA modulus accepted for signing or verification.
+const minRSABits = 2048
+
+// Key is one key of a Keyset. Keys with a private half can sign; the others only verify
+// tokens signed before they were rotated out.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	signKey interface{}
+	public  interface{}
+}
+
+// CanSign reports whether the key has the private half needed to sign tokens.
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// Keyset holds the key new tokens are signed with and every key tokens are still verified with,
+// looked up by the `kid` header of the token.
+type Keyset struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewHMACKeyset creates a keyset with a single HS256 secret. Its tokens carry no `kid`,
+// and there is no public key to publish.
+func NewHMACKeyset(secret string) *Keyset {
+	key := &Key{Method: jwt.SigningMethodHS256, signKey: []byte(secret), public: []byte(secret)}
+	return &Keyset{signing: key, keys: map[string]*Key{"": key}}
+}
+
+// LoadKeyset loads every PEM file in dir as a key, using the file name without its
+// extension as the key's ID. Private keys (PKCS#1 or PKCS#8) can sign; public keys (PKIX)
+// only verify, which is how a key is kept after rotation until its last token expires.
+// RSA keys sign with RS256 and Ed25519 keys with EdDSA. New tokens are signed with the key
+// named signingKID, which may be left empty when dir holds a single private key.
+func LoadKeyset(dir, signingKID string) (*Keyset, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	set := &Keyset{keys: make(map[string]*Key)}
+	var private []*Key
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", path, err)
+		}
+		set.keys[key.ID] = key
+		if key.CanSign() {
+			private = append(private, key)
+		}
+	}
+	if len(set.keys) == 0 {
+		return nil, fmt.Errorf("no *.pem keys found in %s", dir)
+	}
+
+	switch {
+	case signingKID != "":
+		set.signing = set.keys[signingKID]
+		if set.signing == nil {
+			return nil, fmt.Errorf("signing key %q not found in %s", signingKID, dir)
+		}
+		if !set.signing.CanSign() {
+			return nil, fmt.Errorf("signing key %q is a public key", signingKID)
+		}
+	case len(private) == 1:
+		set.signing = private[0]
+	default:
+		return nil, fmt.Errorf("found %d private keys in %s, choose the signing key by its ID", len(private), dir)
+	}
+	return set, nil
+}
+
+// loadKey parses the key in a single PEM file.
+func loadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	key := &Key{ID: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.signKey = signer
+		parsed = signer.Public()
+	}
+	switch public := parsed.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key has %d bits, at least %d are required", public.N.BitLen(), minRSABits)
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", parsed)
+	}
+	key.public = parsed
+	return key, nil
+}
+
+// Signing returns the key new tokens are signed with.
+func (s *Keyset) Signing() *Key {
+	return s.signing
+}
+
+// Lookup returns the key with the given ID, or nil if there is none.
+func (s *Keyset) Lookup(kid string) *Key {
+	return s.keys[kid]
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	N       string `json:"n,omitempty"`   // RSA modulus
+	E       string `json:"e,omitempty"`   // RSA public exponent
+	Curve   string `json:"crv,omitempty"` // OKP curve
+	X       string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS is a JSON Web Key Set, as published at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyset, sorted by ID. HMAC secrets are never published.
+func (s *Keyset) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range s.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
@@ -0,0 +1,171 @@
+// Package auth contains tests for loading signing keys and publishing them.
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// writeKey writes a PEM block to dir/name.pem.
+func writeKey(t *testing.T, dir, name, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), data, 0o600); err != nil {
+		t.Fatalf("failed to write key: %s", err)
+	}
+}
+
+// newRSAKey generates an RSA key and writes it to dir as a PKCS#1 private key.
+func newRSAKey(t *testing.T, dir, name string) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %s", err)
+	}
+	writeKey(t, dir, name, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	return key
+}
+
+// newEd25519Key generates an Ed25519 key and writes it to dir as a PKCS#8 private key.
+func newEd25519Key(t *testing.T, dir, name string) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %s", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal Ed25519 key: %s", err)
+	}
+	writeKey(t, dir, name, "PRIVATE KEY", der)
+	return key
+}
+
+// TestLoadKeyset_SignsWithKid tests that RSA and Ed25519 keys sign tokens carrying their kid.
+func TestLoadKeyset_SignsWithKid(t *testing.T) {
+	user := &models.User{Username: "alice", Role: "member"}
+	tests := []struct {
+		name    string
+		newKey  func(*testing.T, string, string)
+		wantAlg string
+	}{
+		{"RS256", func(t *testing.T, dir, name string) { newRSAKey(t, dir, name) }, "RS256"},
+		{"EdDSA", func(t *testing.T, dir, name string) { newEd25519Key(t, dir, name) }, "EdDSA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.newKey(t, dir, "2026-01")
+
+			keys, err := LoadKeyset(dir, "")
+			if err != nil {
+				t.Fatalf("unexpected error loading keys: %s", err)
+			}
+			manager := NewTokenManager(keys, 15*time.Minute, 24*time.Hour)
+			tokenString, err := manager.IssueAccessToken(user, "session")
+			if err != nil {
+				t.Fatalf("unexpected error issuing token: %s", err)
+			}
+
+			if keys.Signing().ID != "2026-01" || keys.Signing().Method.Alg() != tt.wantAlg {
+				t.Errorf("expected to sign with 2026-01 using %s, but got %s using %s", tt.wantAlg, keys.Signing().ID, keys.Signing().Method.Alg())
+			}
+			claims, err := manager.ParseAccessToken(tokenString)
+			if err != nil {
+				t.Fatalf("unexpected error parsing token: %s", err)
+			}
+			if claims.Subject != "alice" {
+				t.Errorf("expected alice's token, but got %+v", claims)
+			}
+		})
+	}
+}
+
+// TestLoadKeyset_Rotation tests that tokens signed with a rotated-out key still verify while
+// its public key is kept, and that tokens from an unknown key are rejected.
+func TestLoadKeyset_Rotation(t *testing.T) {
+	user := &models.User{Username: "alice", Role: "member"}
+	oldDir, dir := t.TempDir(), t.TempDir()
+	oldKey := newRSAKey(t, oldDir, "old")
+	newEd25519Key(t, dir, "new")
+	publicDER, err := x509.MarshalPKIXPublicKey(&oldKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	writeKey(t, dir, "old", "PUBLIC KEY", publicDER)
+
+	oldKeys, err := LoadKeyset(oldDir, "old")
+	if err != nil {
+		t.Fatalf("unexpected error loading old keys: %s", err)
+	}
+	oldToken, err := NewTokenManager(oldKeys, 15*time.Minute, 24*time.Hour).IssueAccessToken(user, "session")
+	if err != nil {
+		t.Fatalf("unexpected error issuing token: %s", err)
+	}
+
+	keys, err := LoadKeyset(dir, "new")
+	if err != nil {
+		t.Fatalf("unexpected error loading keys: %s", err)
+	}
+	if _, err := NewTokenManager(keys, 15*time.Minute, 24*time.Hour).ParseAccessToken(oldToken); err != nil {
+		t.Errorf("expected the token signed with the old key to verify, but got %s", err)
+	}
+
+	newToken, err := NewTokenManager(keys, 15*time.Minute, 24*time.Hour).IssueAccessToken(user, "session")
+	if err != nil {
+		t.Fatalf("unexpected error issuing token: %s", err)
+	}
+	if _, err := NewTokenManager(oldKeys, 15*time.Minute, 24*time.Hour).ParseAccessToken(newToken); err == nil {
+		t.Errorf("expected the token from a key the old keyset lacks to be rejected")
+	}
+
+	jwks := keys.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != "new" || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[1].KeyID != "old" || jwks.Keys[1].KeyType != "RSA" {
+		t.Errorf("expected the new OKP key and the old RSA key to be published, but got %+v", jwks.Keys)
+	}
+}
+
+// TestLoadKeyset_Invalid tests the keysets that cannot sign tokens.
+func TestLoadKeyset_Invalid(t *testing.T) {
+	empty := t.TempDir()
+	if _, err := LoadKeyset(empty, ""); err == nil {
+		t.Errorf("expected an empty directory to be rejected")
+	}
+
+	twoKeys := t.TempDir()
+	newRSAKey(t, twoKeys, "a")
+	newEd25519Key(t, twoKeys, "b")
+	if _, err := LoadKeyset(twoKeys, ""); err == nil {
+		t.Errorf("expected two private keys without a signing kid to be rejected")
+	}
+	if _, err := LoadKeyset(twoKeys, "c"); err == nil {
+		t.Errorf("expected an unknown signing kid to be rejected")
+	}
+
+	weak := t.TempDir()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %s", err)
+	}
+	writeKey(t, weak, "weak", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	if _, err := LoadKeyset(weak, ""); err == nil {
+		t.Errorf("expected a 1024-bit RSA key to be rejected")
+	}
+}
+
+// TestHMACKeyset_PublishesNothing tests that a shared secret is never published.
+func TestHMACKeyset_PublishesNothing(t *testing.T) {
+	if keys := NewHMACKeyset("secret").JWKS().Keys; len(keys) != 0 {
+		t.Errorf("expected no published keys, but got %+v", keys)
+	}
+}
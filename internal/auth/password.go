@@ -0,0 +1,76 @@
+// Package auth issues and verifies the tokens clients authenticate with.
+// This file contains the password policy.
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxPasswordBytes is the longest password bcrypt accepts.
+const maxPasswordBytes = 72
+
+// Reasons a password is refused.
+var (
+	ErrPasswordTooShort         = errors.New("password is too short")
+	ErrPasswordTooLong          = errors.New("password is too long")
+	ErrPasswordBreached         = errors.New("password appears in a list of breached passwords")
+	ErrPasswordContainsUsername = errors.New("password contains the username")
+)
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	MinLength int // In characters
+	breached  map[string]struct{}
+}
+
+// NewPasswordPolicy creates a policy requiring minLength characters, refusing the
+// passwords listed one per line in breachedFile. An empty breachedFile skips that check.
+func NewPasswordPolicy(minLength int, breachedFile string) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{MinLength: minLength, breached: make(map[string]struct{})}
+	if breachedFile == "" {
+		return policy, nil
+	}
+
+	f, err := os.Open(breachedFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading breached passwords: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			policy.breached[password] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("loading breached passwords: %w", err)
+	}
+	return policy, nil
+}
+
+// BreachedCount returns how many breached passwords the policy refuses.
+func (p *PasswordPolicy) BreachedCount() int {
+	return len(p.breached)
+}
+
+// Check returns the first rule password breaks for the user named username, or nil.
+func (p *PasswordPolicy) Check(username, password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrPasswordTooShort, p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: it must be at most %d bytes long", ErrPasswordTooLong, maxPasswordBytes)
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return ErrPasswordContainsUsername
+	}
+	if _, ok := p.breached[password]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
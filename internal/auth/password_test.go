@@ -0,0 +1,50 @@
+// Package auth contains tests for the password policy.
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPasswordPolicy_Check tests each rule of the password policy.
+func TestPasswordPolicy_Check(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(file, []byte("password123\n\ncorrecthorse\n"), 0o600); err != nil {
+		t.Fatalf("unexpected error writing the breached list: %s", err)
+	}
+	policy, err := NewPasswordPolicy(10, file)
+	if err != nil {
+		t.Fatalf("unexpected error loading the policy: %s", err)
+	}
+	if policy.BreachedCount() != 2 {
+		t.Errorf("expected 2 breached passwords, but got %d", policy.BreachedCount())
+	}
+
+	tests := []struct {
+		password string
+		expected error
+	}{
+		{"short", ErrPasswordTooShort},
+		{"ñandúñandú", nil}, // 10 characters, though more bytes
+		{strings.Repeat("x", 73), ErrPasswordTooLong},
+		{"my-Alice-password", ErrPasswordContainsUsername},
+		{"correcthorse", ErrPasswordBreached},
+		{"battery staple on a horse", nil},
+	}
+	for _, tt := range tests {
+		if err := policy.Check("alice", tt.password); !errors.Is(err, tt.expected) {
+			t.Errorf("Check(%q): expected %v, but got %v", tt.password, tt.expected, err)
+		}
+	}
+}
+
+// TestNewPasswordPolicy_MissingFile tests that a breached list that cannot be read is an
+// error rather than a policy that silently skips the check.
+func TestNewPasswordPolicy_MissingFile(t *testing.T) {
+	if _, err := NewPasswordPolicy(10, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("expected a missing breached list to be an error")
+	}
+}
@@ -0,0 +1,133 @@
+// Package auth issues and verifies the tokens clients authenticate with.
+// This file contains the revocation list of access tokens.
+package auth
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RevocationList records access tokens that must be refused before they expire.
+// Tokens are revoked one at a time by their jti, or all at once for a subject.
+type RevocationList interface {
+	// RevokeToken revokes the token with the given jti until it expires.
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// RevokeSubject revokes every token issued to subject before at.
+	RevokeSubject(ctx context.Context, subject string, at time.Time) error
+	// IsRevoked reports whether the token with the given claims has been revoked.
+	IsRevoked(ctx context.Context, claims *Claims) (bool, error)
+}
+
+// issuedBefore reports whether a token was issued before cutoff, to the microsecond.
+// Tokens issued before IssuedAtMicros was added only have the whole seconds of iat, so
+// those issued in the same second as cutoff are kept.
+func issuedBefore(claims *Claims, cutoff time.Time) bool {
+	if claims.IssuedAtMicros != 0 {
+		return time.UnixMicro(claims.IssuedAtMicros).Before(cutoff)
+	}
+	return claims.IssuedAt.Time.Before(cutoff.Truncate(time.Second))
+}
+
+// memoryRevocationList keeps the revocation list in process memory. It is lost on restart
+// and not shared between instances, so it suits a single instance without Redis.
+type memoryRevocationList struct {
+	mu       sync.Mutex
+	tokenTTL time.Duration
+	tokens   map[string]time.Time // jti -> when the token expires
+	subjects map[string]time.Time // subject -> tokens issued before this are revoked
+}
+
+// NewMemoryRevocationList creates an in-memory revocation list for access tokens that live at most tokenTTL.
+func NewMemoryRevocationList(tokenTTL time.Duration) RevocationList {
+	return &memoryRevocationList{
+		tokenTTL: tokenTTL,
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]time.Time),
+	}
+}
+
+func (l *memoryRevocationList) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(time.Now())
+	l.tokens[jti] = expiresAt
+	return nil
+}
+
+func (l *memoryRevocationList) RevokeSubject(ctx context.Context, subject string, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(time.Now())
+	l.subjects[subject] = at
+	return nil
+}
+
+func (l *memoryRevocationList) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.tokens[claims.ID]; ok {
+		return true, nil
+	}
+	cutoff, ok := l.subjects[claims.Subject]
+	return ok && issuedBefore(claims, cutoff), nil
+}
+
+// prune drops entries for tokens that have expired by now. The caller must hold l.mu.
+func (l *memoryRevocationList) prune(now time.Time) {
+	for jti, expiresAt := range l.tokens {
+		if now.After(expiresAt) {
+			delete(l.tokens, jti)
+		}
+	}
+	for subject, at := range l.subjects {
+		if now.After(at.Add(l.tokenTTL)) {
+			delete(l.subjects, subject)
+		}
+	}
+}
+
+// redisRevocationList keeps the revocation list in Redis, shared by every instance.
+// Entries expire on their own once the tokens they revoke would have.
+type redisRevocationList struct {
+	client   *redis.Client
+	tokenTTL time.Duration
+}
+
+// NewRedisRevocationList creates a Redis-backed revocation list for access tokens that live at most tokenTTL.
+func NewRedisRevocationList(client *redis.Client, tokenTTL time.Duration) RevocationList {
+	return &redisRevocationList{client: client, tokenTTL: tokenTTL}
+}
+
+func (l *redisRevocationList) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return l.client.Set(ctx, "revoked:jti:"+jti, 1, ttl).Err()
+}
+
+func (l *redisRevocationList) RevokeSubject(ctx context.Context, subject string, at time.Time) error {
+	return l.client.Set(ctx, "revoked:sub:"+subject, at.UnixNano(), l.tokenTTL).Err()
+}
+
+func (l *redisRevocationList) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	values, err := l.client.MGet(ctx, "revoked:jti:"+claims.ID, "revoked:sub:"+claims.Subject).Result()
+	if err != nil {
+		return false, err
+	}
+	if values[0] != nil {
+		return true, nil
+	}
+	if cutoff, ok := values[1].(string); ok {
+		nanos, err := strconv.ParseInt(cutoff, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return issuedBefore(claims, time.Unix(0, nanos)), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,178 @@
+// Package auth issues and verifies the tokens clients authenticate with.
+// This file contains access tokens (short-lived JWTs), the challenge tokens of two-step
+// logins and refresh tokens (opaque, single-use).
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Claims are the claims of an access token. The subject is the username, the ID (jti)
+// identifies the token for revocation, and SessionID ties it to the refresh tokens of the
+// login it came from.
+type Claims struct {
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	// IssuedAtMicros is when the token was issued, in microseconds since the Unix epoch.
+	// The iat claim has whole seconds, which cannot tell a token issued just before a
+	// revocation cutoff from one issued just after it.
+	IssuedAtMicros int64 `json:"iat_us,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// ChallengeTTL is how long a user has to enter their second factor after their password.
+const ChallengeTTL = 5 * time.Minute
+
+// challengeAudience is the audience of challenge tokens, which keeps them from being
+// accepted as access tokens.
+const challengeAudience = "two-factor"
+
+// TokenManager signs and verifies access tokens and sets how long both kinds of token live.
+type TokenManager struct {
+	keys       *Keyset
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// NewTokenManager creates a TokenManager that signs access tokens with the signing key of keys.
+func NewTokenManager(keys *Keyset, accessTTL, refreshTTL time.Duration) *TokenManager {
+	return &TokenManager{keys: keys, AccessTTL: accessTTL, RefreshTTL: refreshTTL}
+}
+
+// JWKS returns the public keys access tokens can be verified with.
+func (m *TokenManager) JWKS() JWKS {
+	return m.keys.JWKS()
+}
+
+// IssueAccessToken signs a new access token for the user in the given session.
+func (m *TokenManager) IssueAccessToken(user *models.User, sessionID string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := &Claims{
+		Role:           user.Role,
+		SessionID:      sessionID,
+		IssuedAtMicros: now.UnixMicro(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   user.Username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.AccessTTL)),
+		},
+	}
+	key := m.keys.Signing()
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.signKey)
+}
+
+// ParseAccessToken verifies an access token's signature and expiry and returns its claims.
+// The key is chosen by the token's `kid` header and must match the token's algorithm.
+// Tokens without a jti predate revocation support and are rejected, as are challenge
+// tokens, which carry an audience.
+func (m *TokenManager) ParseAccessToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, m.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.ID == "" || claims.IssuedAt == nil || len(claims.Audience) > 0 {
+		return nil, errors.New("invalid access token")
+	}
+	return claims, nil
+}
+
+// IssueChallengeToken signs a challenge token for a user who gave the right password but
+// still has to enter their second factor. It only proves the password was checked, and
+// expires after ChallengeTTL.
+func (m *TokenManager) IssueChallengeToken(user *models.User) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := &jwt.RegisteredClaims{
+		ID:        jti,
+		Subject:   user.Username,
+		Audience:  jwt.ClaimStrings{challengeAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ChallengeTTL)),
+	}
+	key := m.keys.Signing()
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.signKey)
+}
+
+// ParseChallengeToken verifies a challenge token and returns its claims. Access tokens
+// are rejected.
+func (m *TokenManager) ParseChallengeToken(tokenString string) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, m.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || !claims.VerifyAudience(challengeAudience, true) {
+		return nil, errors.New("invalid challenge token")
+	}
+	return claims, nil
+}
+
+// keyFunc returns the key a token is verified with, chosen by its `kid` header.
+func (m *TokenManager) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := m.keys.Lookup(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// NewRefreshToken generates a refresh token and the hash it is stored under.
+func NewRefreshToken() (token, hash string, err error) {
+	token, err = randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the hash a refresh token is stored and looked up under.
+// Refresh tokens are random, so a fast hash is enough; it only keeps the stored
+// values from being usable if the database leaks.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSessionID generates the ID shared by the tokens of one login.
+func NewSessionID() (string, error) {
+	return randomToken(16)
+}
+
+// randomToken returns n random bytes encoded as URL-safe base64.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
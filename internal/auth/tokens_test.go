@@ -0,0 +1,139 @@
+// Package auth contains tests for token issuing and revocation.
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TestAccessToken_RoundTrip tests that an issued access token parses back into its claims.
+func TestAccessToken_RoundTrip(t *testing.T) {
+	manager := NewTokenManager(NewHMACKeyset("secret"), 15*time.Minute, 24*time.Hour)
+	user := &models.User{ID: 1, Username: "alice", Role: "librarian"}
+
+	tokenString, err := manager.IssueAccessToken(user, "session")
+	if err != nil {
+		t.Fatalf("unexpected error issuing token: %s", err)
+	}
+	claims, err := manager.ParseAccessToken(tokenString)
+	if err != nil {
+		t.Fatalf("unexpected error parsing token: %s", err)
+	}
+
+	if claims.Subject != "alice" || claims.Role != "librarian" || claims.SessionID != "session" {
+		t.Errorf("expected alice's librarian token in 'session', but got %+v", claims)
+	}
+	if claims.ID == "" {
+		t.Errorf("expected the token to have a jti")
+	}
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != 15*time.Minute {
+		t.Errorf("expected the token to live 15m, but it lives %s", ttl)
+	}
+	if issued := time.UnixMicro(claims.IssuedAtMicros); issued.Truncate(time.Second) != claims.IssuedAt.Time {
+		t.Errorf("expected the sub-second issue time to fall in the iat second, but got %s and %s", issued, claims.IssuedAt.Time)
+	}
+}
+
+// TestParseAccessToken_Rejects tests that tokens signed with another key or algorithm, or without a jti, are rejected.
+func TestParseAccessToken_Rejects(t *testing.T) {
+	manager := NewTokenManager(NewHMACKeyset("secret"), 15*time.Minute, 24*time.Hour)
+	now := time.Now()
+	valid := jwt.RegisteredClaims{
+		ID:        "jti",
+		Subject:   "alice",
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+	}
+	withoutID := valid
+	withoutID.ID = ""
+
+	otherKey, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{RegisteredClaims: valid}).SignedString([]byte("other"))
+	otherAlg, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, &Claims{RegisteredClaims: valid}).SignedString([]byte("secret"))
+	noneAlg, _ := jwt.NewWithClaims(jwt.SigningMethodNone, &Claims{RegisteredClaims: valid}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	noID, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{RegisteredClaims: withoutID}).SignedString([]byte("secret"))
+
+	tests := map[string]string{"other key": otherKey, "HS512": otherAlg, "none": noneAlg, "no jti": noID}
+	for name, tokenString := range tests {
+		if _, err := manager.ParseAccessToken(tokenString); err == nil {
+			t.Errorf("%s: expected the token to be rejected", name)
+		}
+	}
+}
+
+// TestChallengeToken tests that a challenge token parses back, and that challenge and
+// access tokens are not accepted in place of each other.
+func TestChallengeToken(t *testing.T) {
+	manager := NewTokenManager(NewHMACKeyset("secret"), 15*time.Minute, 24*time.Hour)
+	user := &models.User{ID: 1, Username: "alice", Role: "librarian"}
+
+	challenge, err := manager.IssueChallengeToken(user)
+	if err != nil {
+		t.Fatalf("unexpected error issuing challenge token: %s", err)
+	}
+	claims, err := manager.ParseChallengeToken(challenge)
+	if err != nil {
+		t.Fatalf("unexpected error parsing challenge token: %s", err)
+	}
+	if claims.Subject != "alice" {
+		t.Errorf("expected a challenge for alice, but got %+v", claims)
+	}
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != ChallengeTTL {
+		t.Errorf("expected the challenge to live %s, but it lives %s", ChallengeTTL, ttl)
+	}
+
+	if _, err := manager.ParseAccessToken(challenge); err == nil {
+		t.Errorf("expected a challenge token to be rejected as an access token")
+	}
+	access, _ := manager.IssueAccessToken(user, "session")
+	if _, err := manager.ParseChallengeToken(access); err == nil {
+		t.Errorf("expected an access token to be rejected as a challenge token")
+	}
+}
+
+// TestMemoryRevocationList tests revoking a single token and every earlier token of a
+// subject, telling apart tokens issued in the same second as the cutoff.
+func TestMemoryRevocationList(t *testing.T) {
+	ctx := context.Background()
+	list := NewMemoryRevocationList(15 * time.Minute)
+	// The cutoff falls mid-second, so tokens just before and after it share its iat.
+	now := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+	claims := func(jti, subject string, issuedAt time.Time) *Claims {
+		return &Claims{
+			IssuedAtMicros:   issuedAt.UnixMicro(),
+			RegisteredClaims: jwt.RegisteredClaims{ID: jti, Subject: subject, IssuedAt: jwt.NewNumericDate(issuedAt)},
+		}
+	}
+
+	if err := list.RevokeToken(ctx, "a", now.Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error revoking token: %s", err)
+	}
+	if err := list.RevokeSubject(ctx, "bob", now); err != nil {
+		t.Fatalf("unexpected error revoking subject: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		claims  *Claims
+		revoked bool
+	}{
+		{"revoked jti", claims("a", "alice", now), true},
+		{"other jti", claims("b", "alice", now), false},
+		{"subject's earlier token", claims("c", "bob", now.Add(-time.Minute)), true},
+		{"subject's later token", claims("d", "bob", now.Add(time.Minute)), false},
+		{"subject's token earlier in the cutoff's second", claims("e", "bob", now.Add(-100*time.Millisecond)), true},
+		{"subject's token later in the cutoff's second", claims("f", "bob", now.Add(100*time.Millisecond)), false},
+	}
+	for _, tt := range tests {
+		revoked, err := list.IsRevoked(ctx, tt.claims)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+		if revoked != tt.revoked {
+			t.Errorf("%s: expected revoked to be %t, but got %t", tt.name, tt.revoked, revoked)
+		}
+	}
+}
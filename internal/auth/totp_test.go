@@ -0,0 +1,85 @@
+// Package auth contains tests for one-time passwords and recovery codes.
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestTOTPCode tests the code generator against the SHA-1 test vectors of RFC 6238,
+// truncated to six digits.
+func TestTOTPCode(t *testing.T) {
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if code != tt.expected {
+			t.Errorf("at %d: expected code %s, but got %s", tt.unix, tt.expected, code)
+		}
+	}
+}
+
+// TestVerifyTOTP tests that codes are accepted within one period of skew, and that a code
+// is not accepted twice.
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+	previous, _ := TOTPCode(rfc6238Secret, step-1)
+	stale, _ := TOTPCode(rfc6238Secret, step-2)
+
+	if got, ok := VerifyTOTP(rfc6238Secret, "050471", now, 0); !ok || got != step {
+		t.Errorf("expected the current code to be accepted at step %d, but got %d, %t", step, got, ok)
+	}
+	if _, ok := VerifyTOTP(rfc6238Secret, previous, now, 0); !ok {
+		t.Errorf("expected the previous period's code to be accepted")
+	}
+	if _, ok := VerifyTOTP(rfc6238Secret, stale, now, 0); ok {
+		t.Errorf("expected a code two periods old to be refused")
+	}
+	if _, ok := VerifyTOTP(rfc6238Secret, "050471", now, step); ok {
+		t.Errorf("expected a used code to be refused")
+	}
+	if _, ok := VerifyTOTP(rfc6238Secret, "50471", now, 0); ok {
+		t.Errorf("expected a code of the wrong length to be refused")
+	}
+}
+
+// TestRecoveryCodes tests that recovery codes are distinct and match their hashes however
+// they are typed.
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("expected %d codes and hashes, but got %d and %d", RecoveryCodeCount, len(codes), len(hashes))
+	}
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("expected distinct codes, but %s repeats", code)
+		}
+		seen[code] = true
+		if HashRecoveryCode(code) != hashes[i] {
+			t.Errorf("expected %s to match its hash", code)
+		}
+	}
+
+	if HashRecoveryCode("ABCDE FGHIJ") != HashRecoveryCode("abcde-fghij") {
+		t.Errorf("expected case, spaces and dashes to be ignored")
+	}
+}
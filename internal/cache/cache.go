@@ -0,0 +1,94 @@
+// Package cache provides the key-value caches the caching repositories store results in:
+// Redis, shared by every instance, and an in-process LRU for deployments without Redis.
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache stores byte values under string keys for a limited time.
+type Cache interface {
+	// Get returns the value stored under key, and whether there was one.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key until ttl has passed.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the given keys. Missing keys are ignored.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Counter counts the lookups of one cached repository. It is safe for concurrent use.
+type Counter struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	errors atomic.Uint64
+}
+
+// Hit records a lookup answered from the cache.
+func (c *Counter) Hit() { c.hits.Add(1) }
+
+// Miss records a lookup that had to go to the database.
+func (c *Counter) Miss() { c.misses.Add(1) }
+
+// Error records a lookup the cache failed to answer. It also counts as a miss.
+func (c *Counter) Error() {
+	c.errors.Add(1)
+	c.misses.Add(1)
+}
+
+// Counts is a snapshot of a Counter.
+type Counts struct {
+	Name     string  `json:"name"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	Errors   uint64  `json:"errors"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// Stats holds the counters of every cached repository, by name.
+type Stats struct {
+	mu       sync.Mutex
+	backend  string
+	counters map[string]*Counter
+}
+
+// NewStats creates an empty set of counters for caches stored in backend (e.g. "redis").
+func NewStats(backend string) *Stats {
+	return &Stats{backend: backend, counters: make(map[string]*Counter)}
+}
+
+// Backend returns the name of the cache backend the counters describe.
+func (s *Stats) Backend() string {
+	return s.backend
+}
+
+// Counter returns the counter with the given name, creating it on first use.
+func (s *Stats) Counter(name string) *Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counter, ok := s.counters[name]
+	if !ok {
+		counter = &Counter{}
+		s.counters[name] = counter
+	}
+	return counter
+}
+
+// Snapshot returns the current counts of every counter, sorted by name.
+func (s *Stats) Snapshot() []Counts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make([]Counts, 0, len(s.counters))
+	for name, counter := range s.counters {
+		counts := Counts{Name: name, Hits: counter.hits.Load(), Misses: counter.misses.Load(), Errors: counter.errors.Load()}
+		if total := counts.Hits + counts.Misses; total > 0 {
+			counts.HitRatio = float64(counts.Hits) / float64(total)
+		}
+		snapshot = append(snapshot, counts)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Name < snapshot[j].Name })
+	return snapshot
+}
@@ -0,0 +1,69 @@
+// Package cache contains tests for the caches and their counters.
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestLRUCache_EvictsLeastRecentlyUsed tests that a full cache evicts the entry used longest ago.
+func TestLRUCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(2)
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	c.Get(ctx, "a") // "b" is now the least recently used
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, found, _ := c.Get(ctx, "b"); found {
+		t.Errorf("expected 'b' to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, found, _ := c.Get(ctx, key); !found {
+			t.Errorf("expected '%s' to be kept", key)
+		}
+	}
+}
+
+// TestLRUCache_Expiry tests that entries are not served once their TTL has passed.
+func TestLRUCache_Expiry(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(10).(*lruCache)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	if value, found, _ := c.Get(ctx, "a"); !found || string(value) != "1" {
+		t.Fatalf("expected '1' to be cached, but got %q (found %t)", value, found)
+	}
+
+	now = now.Add(time.Minute)
+	if _, found, _ := c.Get(ctx, "a"); found {
+		t.Errorf("expected 'a' to have expired")
+	}
+	if c.order.Len() != 0 {
+		t.Errorf("expected the expired entry to be dropped, but %d remain", c.order.Len())
+	}
+}
+
+// TestStats_Snapshot tests that counters are reported by name with their hit ratio.
+func TestStats_Snapshot(t *testing.T) {
+	stats := NewStats("memory")
+	books := stats.Counter("books")
+	books.Hit()
+	books.Hit()
+	books.Hit()
+	books.Error()
+	stats.Counter("authors").Miss()
+
+	snapshot := stats.Snapshot()
+
+	if len(snapshot) != 2 || snapshot[0].Name != "authors" || snapshot[1].Name != "books" {
+		t.Fatalf("expected authors and books, but got %+v", snapshot)
+	}
+	if got := snapshot[1]; got.Hits != 3 || got.Misses != 1 || got.Errors != 1 || got.HitRatio != 0.75 {
+		t.Errorf("expected 3 hits, 1 miss from 1 error and a 0.75 hit ratio, but got %+v", got)
+	}
+}
@@ -0,0 +1,64 @@
+// Package cache provides the key-value caches the caching repositories store results in.
+// This file contains the decorator that traces cache calls.
+package cache
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Lec7ral/fullAPI/internal/cache")
+
+// tracingCache records a span for every call to the cache it wraps, so the time spent
+// in the cache shows up in request traces next to the SQL statements.
+type tracingCache struct {
+	next    Cache
+	backend string
+}
+
+// NewTracingCache wraps next, stored in backend (e.g. "redis"), in spans.
+func NewTracingCache(next Cache, backend string) Cache {
+	return &tracingCache{next: next, backend: backend}
+}
+
+func (c *tracingCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	ctx, span := c.start(ctx, "cache.get", attribute.String("cache.key", key))
+	value, found, err := c.next.Get(ctx, key)
+	span.SetAttributes(attribute.Bool("cache.hit", found))
+	end(span, err)
+	return value, found, err
+}
+
+func (c *tracingCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ctx, span := c.start(ctx, "cache.set", attribute.String("cache.key", key))
+	err := c.next.Set(ctx, key, value, ttl)
+	end(span, err)
+	return err
+}
+
+func (c *tracingCache) Delete(ctx context.Context, keys ...string) error {
+	ctx, span := c.start(ctx, "cache.delete", attribute.StringSlice("cache.keys", keys))
+	err := c.next.Delete(ctx, keys...)
+	end(span, err)
+	return err
+}
+
+func (c *tracingCache) start(ctx context.Context, name string, attr attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("cache.backend", c.backend), attr))
+}
+
+// end marks span as failed if err is set, and ends it.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
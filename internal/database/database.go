@@ -3,100 +3,98 @@ package database
 
 import (
 	"database/sql"
-	"log"
+	"errors"
+	"log/slog"
+	"strings"
 
-	// Import the sqlite3 driver with a blank identifier to register it.
+	"github.com/Lec7ral/fullAPI/configs"
+
+	// Import the database drivers with a blank identifier to register them.
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// InitDB connects to the database specified by the DSN and ensures the required tables exist.
-// It returns the database connection pool (*sql.DB) or an error.
-func InitDB(dsn string) (*sql.DB, error) {
-	// Open a connection to the SQLite database.
-	db, err := sql.Open("sqlite3", dsn)
-	if err != nil {
-		return nil, err
-	}
+// ErrFTS5Unavailable is returned when the SQLite driver was compiled without the FTS5 extension,
+// which the full-text search migration requires.
+var ErrFTS5Unavailable = errors.New("SQLite was built without FTS5 support; rebuild with -tags sqlite_fts5")
 
-	// Prepare the SQL statement to create the 'authors' table if it doesn't exist.
-	authorsTableStmt, err := db.Prepare(`
-		CREATE TABLE IF NOT EXISTS authors (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			bio TEXT
-		)
-	`)
+// Open connects to the database specified by the driver and DSN without touching the schema.
+// SQLite connections enforce foreign keys, as PostgreSQL always does, unless the DSN says
+// otherwise.
+func Open(driver, dsn string) (*sql.DB, error) {
+	if driver == configs.DriverSQLite {
+		dsn = withForeignKeys(dsn)
+	}
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
-	_, err = authorsTableStmt.Exec()
-	if err != nil {
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
+	return db, nil
+}
 
-	// Prepare the SQL statement to create the 'users' table if it doesn't exist.
-	usersTableStmt, err := db.Prepare(`
-		CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT UNIQUE NOT NULL,
-			password_hash TEXT NOT NULL,
-			role TEXT NOT NULL DEFAULT 'member'
-		)
-	`)
-	if err != nil {
-		return nil, err
+// withForeignKeys adds the sqlite3 driver's _foreign_keys parameter to a SQLite DSN that
+// does not set it. The driver then turns enforcement on for every connection it opens,
+// which SQLite leaves off by default.
+func withForeignKeys(dsn string) string {
+	if strings.Contains(dsn, "_foreign_keys=") || strings.Contains(dsn, "_fk=") {
+		return dsn
 	}
-	_, err = usersTableStmt.Exec()
-	if err != nil {
-		return nil, err
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_foreign_keys=on"
 	}
+	return dsn + "?_foreign_keys=on"
+}
 
-	// --- Book Table Migration ---
-	// This simple migration drops the old table to recreate it with the new schema.
-	// In a real production environment, a more sophisticated migration tool would be used.
-	_, err = db.Exec("DROP TABLE IF EXISTS books")
+// InitDB connects to the database specified by the driver and DSN and applies any pending migrations.
+// It returns the database connection pool (*sql.DB) or an error.
+func InitDB(driver, dsn string) (*sql.DB, error) {
+	db, err := Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	// Prepare the SQL statement to create the 'books' table with the new 'stock' column.
-	booksTableStmt, err := db.Prepare(`
-		CREATE TABLE books (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			title TEXT NOT NULL,
-			published_date TEXT NOT NULL,
-			isbn TEXT UNIQUE NOT NULL,
-			stock INTEGER NOT NULL DEFAULT 0,
-			author_id INTEGER,
-			FOREIGN KEY(author_id) REFERENCES authors(id)
-		)
-	`)
-	if err != nil {
-		return nil, err
+	if driver == configs.DriverSQLite {
+		enabled, err := FTS5Enabled(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if !enabled {
+			db.Close()
+			return nil, ErrFTS5Unavailable
+		}
 	}
-	_, err = booksTableStmt.Exec()
+
+	migrator, err := NewMigrator(db, driver)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	loansTableStmt, err := db.Prepare(`
-CREATE TABLE IF NOT EXISTS loans (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    book_id INTEGER NOT NULL,
-    user_id INTEGER NOT NULL,
-    loan_date TEXT NOT NULL,
-    return_date TEXT,
-    FOREIGN KEY (book_id) REFERENCES books(id),
-    FOREIGN KEY (user_id) REFERENCES users(id)
-)`)
+	pending, err := migrator.Pending()
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	_, err = loansTableStmt.Exec()
-	if err != nil {
+	for _, m := range pending {
+		slog.Info("Applying migration", "version", m.Version, "name", m.Name)
+	}
+	if err := migrator.Up(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	log.Println("Database tables (re)created successfully.")
+	slog.Info("Database schema is up to date", "version", migrator.LatestVersion())
 	return db, nil
 }
+
+// FTS5Enabled reports whether the SQLite library behind db was compiled with FTS5.
+func FTS5Enabled(db *sql.DB) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	return enabled, err
+}
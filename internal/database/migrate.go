@@ -0,0 +1,323 @@
+// Package database handles database initialization and schema creation.
+// This file contains the versioned schema migration subsystem.
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/configs"
+)
+
+// migrationFiles holds the SQL migration files compiled into the binary,
+// with one directory per SQL dialect.
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// migrationFilePattern matches names like "0001_initial_schema.up.sql".
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// ErrUnknownVersion is returned when a target version has no matching migration.
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// ErrSchemaOutdated is returned when the database has migrations left to apply.
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// Migration is a single numbered schema change with its up and down SQL.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies and rolls back migrations, recording progress in the schema_migrations table.
+type Migrator struct {
+	DB         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// NewMigrator creates a Migrator for the embedded migration files of the given driver's dialect.
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, migrationDir(driver))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, driver: driver, migrations: migrations}, nil
+}
+
+// migrationDir returns the embedded directory holding the migrations for a driver.
+func migrationDir(driver string) string {
+	if driver == configs.DriverPostgres {
+		return "migrations/postgres"
+	}
+	return "migrations/sqlite"
+}
+
+// bind rewrites "?" placeholders to "$n" when the driver is PostgreSQL.
+func (m *Migrator) bind(query string) string {
+	if m.driver != configs.DriverPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// loadMigrations reads and pairs the up/down files in dir, sorted by version.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %04d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s is missing its up or down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrations returns all known migrations in ascending version order.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// LatestVersion returns the highest known migration version, or 0 if there are none.
+func (m *Migrator) LatestVersion() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// ensureVersionTable creates the schema_migrations table if it doesn't exist.
+func (m *Migrator) ensureVersionTable() error {
+	_, err := m.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		)
+	`)
+	return err
+}
+
+// CurrentVersion returns the highest applied migration version, or 0 for an empty database.
+func (m *Migrator) CurrentVersion() (int, error) {
+	if err := m.ensureVersionTable(); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := m.DB.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// CheckCurrent returns ErrSchemaOutdated if the database is behind the latest known
+// migration. Unlike CurrentVersion, it never writes, so it is safe to call from probes.
+// A database ahead of this binary, as during a rolling deploy, counts as current.
+func (m *Migrator) CheckCurrent(ctx context.Context) error {
+	var version sql.NullInt64
+	if err := m.DB.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+	if int(version.Int64) < m.LatestVersion() {
+		return fmt.Errorf("%w: at version %d, want %d", ErrSchemaOutdated, version.Int64, m.LatestVersion())
+	}
+	return nil
+}
+
+// Pending returns the migrations that have not been applied yet.
+func (m *Migrator) Pending() ([]Migration, error) {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if migration.Version > current {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up() error {
+	return m.To(m.LatestVersion())
+}
+
+// Down rolls back the given number of applied migrations.
+func (m *Migrator) Down(steps int) error {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	}
+	target := 0
+	applied := 0
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if m.migrations[i].Version > current {
+			continue
+		}
+		if applied == steps {
+			target = m.migrations[i].Version
+			break
+		}
+		applied++
+	}
+	return m.To(target)
+}
+
+// To migrates the schema up or down until it reaches the given version.
+// Version 0 rolls back every migration.
+func (m *Migrator) To(version int) error {
+	if version != 0 && !m.hasVersion(version) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	}
+
+	if version >= current {
+		for _, migration := range m.migrations {
+			if migration.Version > current && migration.Version <= version {
+				if err := m.apply(migration, true); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version <= current && migration.Version > version {
+			if err := m.apply(migration, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) hasVersion(version int) bool {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// baselineColumns lists the columns of the baseline schema, migration 1, that SQLite
+// databases created before versioned migrations may lack. CREATE TABLE IF NOT EXISTS
+// adopts their tables as they are, so the columns are added afterwards. Earlier releases
+// only ran on SQLite, so PostgreSQL databases always start from the baseline.
+var baselineColumns = []struct {
+	table, column, definition string
+}{
+	{"users", "role", "role TEXT NOT NULL DEFAULT 'member'"},
+}
+
+// adoptBaseline adds the baseline columns missing from tables that migration 1 adopted.
+func adoptBaseline(tx *sql.Tx) error {
+	for _, c := range baselineColumns {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := tx.Exec("ALTER TABLE " + c.table + " ADD COLUMN " + c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply runs a single migration and updates schema_migrations in one transaction.
+func (m *Migrator) apply(migration Migration, up bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if _, err := tx.Exec(migration.Up); err != nil {
+			return fmt.Errorf("migration %04d_%s up: %w", migration.Version, migration.Name, err)
+		}
+		if migration.Version == 1 && m.driver != configs.DriverPostgres {
+			if err := adoptBaseline(tx); err != nil {
+				return fmt.Errorf("migration %04d_%s up: adopting existing tables: %w", migration.Version, migration.Name, err)
+			}
+		}
+		_, err = tx.Exec(m.bind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			migration.Version, migration.Name, time.Now().UTC().Format(time.RFC3339))
+	} else {
+		if _, err := tx.Exec(migration.Down); err != nil {
+			return fmt.Errorf("migration %04d_%s down: %w", migration.Version, migration.Name, err)
+		}
+		_, err = tx.Exec(m.bind("DELETE FROM schema_migrations WHERE version = ?"), migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,558 @@
+// Package database contains tests for the migration subsystem.
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/configs"
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// newTestDB opens an in-memory SQLite database restricted to one connection,
+// so every query sees the same in-memory schema. The migrations need FTS5, so the
+// test is skipped unless it runs with -tags sqlite_fts5.
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := Open(configs.DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening an in-memory database", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	enabled, err := FTS5Enabled(db)
+	if err != nil {
+		t.Fatalf("unexpected error checking for FTS5: %s", err)
+	}
+	if !enabled {
+		t.Skip("SQLite was built without FTS5; run the tests with -tags sqlite_fts5")
+	}
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	if err != nil {
+		t.Fatalf("unexpected error checking table %s: %s", name, err)
+	}
+	return count > 0
+}
+
+// TestMigrator_UpAndDown tests applying every migration and rolling them all back.
+func TestMigrator_UpAndDown(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+	version, err := migrator.CurrentVersion()
+	if err != nil {
+		t.Fatalf("unexpected error reading version: %s", err)
+	}
+	if version != migrator.LatestVersion() {
+		t.Errorf("expected version %d, but got %d", migrator.LatestVersion(), version)
+	}
+	for _, table := range []string{"authors", "users", "books", "loans", "copies"} {
+		if !tableExists(t, db, table) {
+			t.Errorf("expected table '%s' to exist after migrating up", table)
+		}
+	}
+
+	pending, err := migrator.Pending()
+	if err != nil {
+		t.Fatalf("unexpected error listing pending migrations: %s", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending migrations, but got %d", len(pending))
+	}
+
+	if err := migrator.To(0); err != nil {
+		t.Fatalf("unexpected error migrating down: %s", err)
+	}
+	if tableExists(t, db, "books") {
+		t.Errorf("expected table 'books' to be dropped after migrating down")
+	}
+}
+
+// TestMigrator_AdoptsPreSeriesSchema tests that a database created before versioned
+// migrations, with the schema of the shipped library.db, reaches the latest version, that
+// its users get the columns the baseline adds, and that its loans of books the old startup
+// dropped are kept under a placeholder book.
+func TestMigrator_AdoptsPreSeriesSchema(t *testing.T) {
+	db := newTestDB(t)
+	for _, stmt := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, username TEXT UNIQUE NOT NULL, password_hash TEXT NOT NULL)",
+		"CREATE TABLE authors (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, bio TEXT)",
+		`CREATE TABLE loans (id INTEGER PRIMARY KEY AUTOINCREMENT, book_id INTEGER NOT NULL, user_id INTEGER NOT NULL,
+			loan_date TEXT NOT NULL, return_date TEXT, FOREIGN KEY (book_id) REFERENCES books(id), FOREIGN KEY (user_id) REFERENCES users(id))`,
+		`CREATE TABLE books (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL, published_date TEXT NOT NULL,
+			isbn TEXT UNIQUE NOT NULL, stock INTEGER NOT NULL DEFAULT 0, author_id INTEGER, FOREIGN KEY(author_id) REFERENCES authors(id))`,
+		"INSERT INTO users (username, password_hash) VALUES ('yoan', 'x')",
+		"INSERT INTO authors (name, bio) VALUES ('Frank Herbert', '')",
+		// Foreign keys were never enforced, so the loan outlived its book.
+		"PRAGMA foreign_keys = OFF",
+		"INSERT INTO loans (id, book_id, user_id, loan_date) VALUES (1, 3, 1, '2024-03-01 10:30:00')",
+		"PRAGMA foreign_keys = ON",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("unexpected error creating the pre-series schema: %s", err)
+		}
+	}
+
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+	version, err := migrator.CurrentVersion()
+	if err != nil {
+		t.Fatalf("unexpected error reading version: %s", err)
+	}
+	if version != migrator.LatestVersion() {
+		t.Errorf("expected version %d, but got %d", migrator.LatestVersion(), version)
+	}
+
+	var role string
+	if err := db.QueryRow("SELECT role FROM users WHERE username = 'yoan'").Scan(&role); err != nil {
+		t.Fatalf("unexpected error scanning the role: %s", err)
+	}
+	if role != "member" {
+		t.Errorf("expected the existing user to get role 'member', but got '%s'", role)
+	}
+
+	var title, status string
+	err = db.QueryRow(`SELECT b.title, c.status FROM loans l JOIN copies c ON c.id = l.copy_id
+		JOIN books b ON b.id = c.book_id WHERE l.id = 1 AND l.return_date IS NULL`).Scan(&title, &status)
+	if err != nil {
+		t.Fatalf("expected the loan of the missing book to survive as an active loan: %s", err)
+	}
+	if title != "Missing book #3" || status != "on_loan" {
+		t.Errorf("expected an on-loan copy of placeholder 'Missing book #3', but got a %s copy of '%s'", status, title)
+	}
+}
+
+// TestMigrator_UpIsIdempotent tests that re-running Up keeps existing data.
+func TestMigrator_UpIsIdempotent(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+	if _, err := db.Exec("INSERT INTO authors (name, bio) VALUES ('Frank Herbert', '')"); err != nil {
+		t.Fatalf("unexpected error inserting author: %s", err)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error re-running migrations: %s", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM authors").Scan(&count); err != nil {
+		t.Fatalf("unexpected error counting authors: %s", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 author to survive a second migration run, but got %d", count)
+	}
+}
+
+// TestMigrator_DownSteps tests rolling back a single migration.
+func TestMigrator_DownSteps(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+
+	migrations := migrator.Migrations()
+	expected := 0
+	if len(migrations) > 1 {
+		expected = migrations[len(migrations)-2].Version
+	}
+
+	if err := migrator.Down(1); err != nil {
+		t.Fatalf("unexpected error migrating down: %s", err)
+	}
+	version, err := migrator.CurrentVersion()
+	if err != nil {
+		t.Fatalf("unexpected error reading version: %s", err)
+	}
+	if version != expected {
+		t.Errorf("expected version %d after rolling back one step, but got %d", expected, version)
+	}
+}
+
+// TestMigrator_CheckCurrent tests that a database is current once every migration is
+// applied, and outdated after one is rolled back.
+func TestMigrator_CheckCurrent(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+	if err := migrator.CheckCurrent(context.Background()); err != nil {
+		t.Errorf("expected the schema to be current, but got %v", err)
+	}
+
+	if err := migrator.Down(1); err != nil {
+		t.Fatalf("unexpected error migrating down: %s", err)
+	}
+	if err := migrator.CheckCurrent(context.Background()); !errors.Is(err, ErrSchemaOutdated) {
+		t.Errorf("expected error to be ErrSchemaOutdated, but got %v", err)
+	}
+}
+
+// TestMigrator_UnknownVersion tests that migrating to a missing version fails.
+func TestMigrator_UnknownVersion(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+
+	err = migrator.To(9999)
+
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("expected error to be ErrUnknownVersion, but got %v", err)
+	}
+}
+
+// TestLoadMigrations_DialectsInSync tests that every dialect ships the same migration versions.
+func TestLoadMigrations_DialectsInSync(t *testing.T) {
+	sqlite, err := loadMigrations(migrationFiles, migrationDir(configs.DriverSQLite))
+	if err != nil {
+		t.Fatalf("unexpected error loading sqlite migrations: %s", err)
+	}
+	postgres, err := loadMigrations(migrationFiles, migrationDir(configs.DriverPostgres))
+	if err != nil {
+		t.Fatalf("unexpected error loading postgres migrations: %s", err)
+	}
+
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("expected the same number of migrations, but got %d (sqlite) and %d (postgres)", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("migration mismatch: sqlite %04d_%s, postgres %04d_%s",
+				sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+// TestMigrator_BindPostgres tests placeholder rewriting for PostgreSQL.
+func TestMigrator_BindPostgres(t *testing.T) {
+	m := &Migrator{driver: configs.DriverPostgres}
+
+	got := m.bind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)")
+
+	expected := "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)"
+	if got != expected {
+		t.Errorf("expected '%s', but got '%s'", expected, got)
+	}
+}
+
+// TestMigrator_BookSearchIndex tests that the FTS5 triggers keep the search index in sync.
+func TestMigrator_BookSearchIndex(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+
+	mustExec := func(query string, args ...interface{}) {
+		if _, err := db.Exec(query, args...); err != nil {
+			t.Fatalf("unexpected error executing '%s': %s", query, err)
+		}
+	}
+	matches := func(query string) int {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM books_fts WHERE books_fts MATCH ?", query).Scan(&count); err != nil {
+			t.Fatalf("unexpected error searching for '%s': %s", query, err)
+		}
+		return count
+	}
+
+	mustExec("INSERT INTO authors (id, name, bio) VALUES (1, 'Frank Herbert', 'American science fiction author')")
+	mustExec("INSERT INTO books (title, published_date, isbn, author_id) VALUES ('Dune Messiah', '1969-01-01', '9780593098233', 1)")
+
+	if got := matches("dune herbert"); got != 1 {
+		t.Errorf("expected 1 match for 'dune herbert', but got %d", got)
+	}
+	// The porter stemmer matches "messiahs" to "Messiah".
+	if got := matches("messiahs"); got != 1 {
+		t.Errorf("expected 1 stemmed match for 'messiahs', but got %d", got)
+	}
+
+	mustExec("UPDATE authors SET name = 'F. P. Herbert Jr' WHERE id = 1")
+	if got := matches("jr"); got != 1 {
+		t.Errorf("expected the author rename to be indexed, but got %d matches", got)
+	}
+
+	mustExec("DELETE FROM books")
+	if got := matches("dune"); got != 0 {
+		t.Errorf("expected no matches after deleting the book, but got %d", got)
+	}
+}
+
+// TestMigrator_CopiesFromStock tests that the copies migration turns stock counters and
+// active loans into copies, points loans at them, and that rolling back restores stock.
+func TestMigrator_CopiesFromStock(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.To(2); err != nil {
+		t.Fatalf("unexpected error migrating to version 2: %s", err)
+	}
+
+	mustExec := func(query string, args ...interface{}) {
+		if _, err := db.Exec(query, args...); err != nil {
+			t.Fatalf("unexpected error executing '%s': %s", query, err)
+		}
+	}
+	mustCount := func(query string, args ...interface{}) int {
+		var count int
+		if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+			t.Fatalf("unexpected error executing '%s': %s", query, err)
+		}
+		return count
+	}
+
+	mustExec("INSERT INTO users (id, username, password_hash) VALUES (1, 'reader', 'x')")
+	mustExec("INSERT INTO books (id, title, published_date, isbn, stock) VALUES (1, 'Dune', '1965-08-01', '9780441013593', 2)")
+	mustExec("INSERT INTO books (id, title, published_date, isbn, stock) VALUES (2, 'Emma', '1815-12-23', '9780141439587', 0)")
+	// Book 1 has one copy out on loan; book 2 was lent out once and returned, and has no stock left.
+	mustExec("INSERT INTO loans (id, book_id, user_id, loan_date) VALUES (1, 1, 1, ?)", time.Now())
+	mustExec("INSERT INTO loans (id, book_id, user_id, loan_date, return_date) VALUES (2, 2, 1, ?, ?)", time.Now(), time.Now())
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+
+	if got := mustCount("SELECT COUNT(*) FROM copies WHERE book_id = 1 AND status = 'available'"); got != 2 {
+		t.Errorf("expected 2 available copies of book 1, but got %d", got)
+	}
+	if got := mustCount("SELECT COUNT(*) FROM copies c JOIN loans l ON l.copy_id = c.id WHERE l.id = 1 AND c.book_id = 1 AND c.status = 'on_loan'"); got != 1 {
+		t.Errorf("expected the active loan to hold an on-loan copy of book 1, but got %d", got)
+	}
+	if got := mustCount("SELECT COUNT(*) FROM copies c JOIN loans l ON l.copy_id = c.id WHERE l.id = 2 AND c.book_id = 2 AND c.status = 'withdrawn'"); got != 1 {
+		t.Errorf("expected the returned loan to keep a withdrawn copy of book 2, but got %d", got)
+	}
+
+	// The rebuilt loans table declares its dates as DATETIME, so they scan as times.
+	var loanDate time.Time
+	var returnDate sql.NullTime
+	if err := db.QueryRow("SELECT loan_date, return_date FROM loans WHERE id = 2").Scan(&loanDate, &returnDate); err != nil {
+		t.Fatalf("unexpected error scanning loan dates: %s", err)
+	}
+	if !returnDate.Valid {
+		t.Errorf("expected loan 2 to keep its return date")
+	}
+
+	if err := migrator.To(2); err != nil {
+		t.Fatalf("unexpected error migrating down: %s", err)
+	}
+	if got := mustCount("SELECT stock FROM books WHERE id = 1"); got != 2 {
+		t.Errorf("expected book 1 to have its stock of 2 restored, but got %d", got)
+	}
+	if got := mustCount("SELECT COUNT(*) FROM loans WHERE book_id = 1 AND return_date IS NULL"); got != 1 {
+		t.Errorf("expected the active loan to point at book 1 again, but got %d", got)
+	}
+}
+
+// TestMigrator_CopiesForLoansOfMissingBooks tests that loans of books that no longer exist,
+// which databases from before versioned migrations have, get a placeholder book to keep
+// their copies under rather than failing the migration on its foreign keys.
+func TestMigrator_CopiesForLoansOfMissingBooks(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.To(2); err != nil {
+		t.Fatalf("unexpected error migrating to version 2: %s", err)
+	}
+
+	// The loans predate enforcement, so seed them with it off like those databases were.
+	for _, stmt := range []string{
+		"INSERT INTO users (id, username, password_hash) VALUES (1, 'reader', 'x')",
+		"PRAGMA foreign_keys = OFF",
+		"INSERT INTO loans (id, book_id, user_id, loan_date) VALUES (1, 7, 1, '2024-03-01 10:30:00')",
+		"INSERT INTO loans (id, book_id, user_id, loan_date, return_date) VALUES (2, 8, 1, '2024-03-01 10:30:00', '2024-03-08 10:30:00')",
+		"PRAGMA foreign_keys = ON",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("unexpected error seeding loans of missing books: %s", err)
+		}
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+
+	for _, tt := range []struct {
+		loanID int64
+		bookID int64
+		status string
+	}{
+		{1, 7, "on_loan"},
+		{2, 8, "withdrawn"},
+	} {
+		var title, status string
+		err := db.QueryRow(`SELECT b.title, c.status FROM loans l JOIN copies c ON c.id = l.copy_id
+			JOIN books b ON b.id = c.book_id WHERE l.id = ? AND b.id = ?`, tt.loanID, tt.bookID).Scan(&title, &status)
+		if err != nil {
+			t.Fatalf("expected loan %d to keep a copy of a placeholder book %d: %s", tt.loanID, tt.bookID, err)
+		}
+		if want := fmt.Sprintf("Missing book #%d", tt.bookID); title != want {
+			t.Errorf("expected placeholder title '%s', but got '%s'", want, title)
+		}
+		if status != tt.status {
+			t.Errorf("expected loan %d's copy to be %s, but got %s", tt.loanID, tt.status, status)
+		}
+	}
+}
+
+// TestMigrator_LoanDueDates tests that existing loans are given a due date one default
+// loan period after their loan date.
+func TestMigrator_LoanDueDates(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.To(3); err != nil {
+		t.Fatalf("unexpected error migrating to version 3: %s", err)
+	}
+
+	// Foreign keys are enforced, so the loan needs a patron and a copy to reference.
+	for _, stmt := range []string{
+		"INSERT INTO users (id, username, password_hash) VALUES (1, 'pat', 'x')",
+		"INSERT INTO books (id, title, published_date, isbn) VALUES (1, 'Dune', '1965-08-01', '9780441013593')",
+		"INSERT INTO copies (id, book_id, barcode, acquisition_date) VALUES (1, 1, 'LIB-000001-001', '2024-01-01')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("unexpected error seeding the loan's references: %s", err)
+		}
+	}
+	loanDate := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	if _, err := db.Exec("INSERT INTO loans (id, copy_id, user_id, loan_date) VALUES (1, 1, 1, ?)", loanDate); err != nil {
+		t.Fatalf("unexpected error inserting loan: %s", err)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+
+	var dueDate time.Time
+	var renewals int
+	if err := db.QueryRow("SELECT due_date, renewal_count FROM loans WHERE id = 1").Scan(&dueDate, &renewals); err != nil {
+		t.Fatalf("unexpected error scanning the due date: %s", err)
+	}
+	if expected := loanDate.AddDate(0, 0, 14); !dueDate.Equal(expected) {
+		t.Errorf("expected due date %s, but got %s", expected, dueDate)
+	}
+	if renewals != 0 {
+		t.Errorf("expected no renewals, but got %d", renewals)
+	}
+}
+
+// TestMigrator_Fines tests that existing books become the "book" material type and
+// every seeded material type has a fine policy.
+func TestMigrator_Fines(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.To(4); err != nil {
+		t.Fatalf("unexpected error migrating to version 4: %s", err)
+	}
+
+	if _, err := db.Exec("INSERT INTO authors (id, name) VALUES (1, 'Frank Herbert')"); err != nil {
+		t.Fatalf("unexpected error inserting author: %s", err)
+	}
+	if _, err := db.Exec("INSERT INTO books (id, title, published_date, isbn, author_id) VALUES (1, 'Dune', '1965-08-01', '9780441013593', 1)"); err != nil {
+		t.Fatalf("unexpected error inserting book: %s", err)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+
+	var materialType string
+	if err := db.QueryRow("SELECT material_type FROM books WHERE id = 1").Scan(&materialType); err != nil {
+		t.Fatalf("unexpected error scanning the material type: %s", err)
+	}
+	if materialType != "book" {
+		t.Errorf("expected material type 'book', but got '%s'", materialType)
+	}
+
+	var policies int
+	if err := db.QueryRow("SELECT COUNT(*) FROM fine_policies WHERE material_type IN ('book', 'magazine', 'dvd')").Scan(&policies); err != nil {
+		t.Fatalf("unexpected error counting fine policies: %s", err)
+	}
+	if policies != 3 {
+		t.Errorf("expected 3 seeded fine policies, but got %d", policies)
+	}
+}
+
+// TestMigrator_Roles tests that librarians are granted every permission, and that roles
+// users were given before roles existed are kept.
+func TestMigrator_Roles(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := NewMigrator(db, configs.DriverSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error loading migrations: %s", err)
+	}
+	if err := migrator.To(7); err != nil {
+		t.Fatalf("unexpected error migrating to version 7: %s", err)
+	}
+
+	if _, err := db.Exec("INSERT INTO users (username, password_hash, role) VALUES ('alice', 'hash', 'member'), ('bob', 'hash', 'archivist')"); err != nil {
+		t.Fatalf("unexpected error inserting users: %s", err)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
+	}
+
+	var roles int
+	if err := db.QueryRow("SELECT COUNT(*) FROM roles WHERE name IN ('member', 'librarian', 'archivist')").Scan(&roles); err != nil {
+		t.Fatalf("unexpected error counting roles: %s", err)
+	}
+	if roles != 3 {
+		t.Errorf("expected the seeded roles and 'archivist', but got %d roles", roles)
+	}
+
+	var permissions int
+	if err := db.QueryRow("SELECT COUNT(*) FROM role_permissions WHERE role = 'librarian'").Scan(&permissions); err != nil {
+		t.Fatalf("unexpected error counting permissions: %s", err)
+	}
+	if permissions != len(models.AllPermissions) {
+		t.Errorf("expected librarian to have all %d permissions, but got %d", len(models.AllPermissions), permissions)
+	}
+}
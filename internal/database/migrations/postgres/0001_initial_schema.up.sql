@@ -0,0 +1,30 @@
+-- Initial schema: authors, users, books and loans.
+CREATE TABLE IF NOT EXISTS authors (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    bio TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    username TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'member'
+);
+
+CREATE TABLE IF NOT EXISTS books (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    title TEXT NOT NULL,
+    published_date TEXT NOT NULL,
+    isbn TEXT UNIQUE NOT NULL,
+    stock INTEGER NOT NULL DEFAULT 0,
+    author_id BIGINT REFERENCES authors(id)
+);
+
+CREATE TABLE IF NOT EXISTS loans (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    book_id BIGINT NOT NULL REFERENCES books(id),
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    loan_date TIMESTAMPTZ NOT NULL,
+    return_date TIMESTAMPTZ
+);
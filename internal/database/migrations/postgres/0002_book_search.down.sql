@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS books_search_vector_idx;
+DROP TRIGGER IF EXISTS authors_search_vector_update ON authors;
+DROP FUNCTION IF EXISTS authors_search_vector_update();
+DROP TRIGGER IF EXISTS books_search_vector_update ON books;
+DROP FUNCTION IF EXISTS books_search_vector_update();
+ALTER TABLE books DROP COLUMN IF EXISTS search_vector;
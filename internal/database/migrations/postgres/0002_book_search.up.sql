@@ -0,0 +1,38 @@
+-- Full-text search over book titles, ISBNs and author names and bios.
+-- Each book carries a weighted tsvector that triggers keep in sync with the book and its author.
+ALTER TABLE books ADD COLUMN search_vector tsvector;
+
+CREATE FUNCTION books_search_vector_update() RETURNS trigger AS $$
+DECLARE
+    author_name TEXT;
+    author_bio TEXT;
+BEGIN
+    SELECT name, bio INTO author_name, author_bio FROM authors WHERE id = NEW.author_id;
+    NEW.search_vector :=
+        setweight(to_tsvector('english', COALESCE(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('simple', COALESCE(NEW.isbn, '')), 'A') ||
+        setweight(to_tsvector('english', COALESCE(author_name, '')), 'B') ||
+        setweight(to_tsvector('english', COALESCE(author_bio, '')), 'D');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_search_vector_update
+    BEFORE INSERT OR UPDATE OF title, isbn, author_id ON books
+    FOR EACH ROW EXECUTE FUNCTION books_search_vector_update();
+
+-- Re-index an author's books when their name or bio changes.
+CREATE FUNCTION authors_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    UPDATE books SET author_id = author_id WHERE author_id = NEW.id;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER authors_search_vector_update
+    AFTER UPDATE OF name, bio ON authors
+    FOR EACH ROW EXECUTE FUNCTION authors_search_vector_update();
+
+UPDATE books SET title = title;
+
+CREATE INDEX books_search_vector_idx ON books USING GIN (search_vector);
@@ -0,0 +1,15 @@
+ALTER TABLE books ADD COLUMN stock INTEGER NOT NULL DEFAULT 0;
+
+UPDATE books SET stock = (
+    SELECT COUNT(*) FROM copies c WHERE c.book_id = books.id AND c.status = 'available'
+);
+
+DROP INDEX IF EXISTS idx_loans_user_id;
+DROP INDEX IF EXISTS idx_loans_copy_id;
+
+ALTER TABLE loans ADD COLUMN book_id BIGINT REFERENCES books(id);
+UPDATE loans l SET book_id = c.book_id FROM copies c WHERE c.id = l.copy_id;
+ALTER TABLE loans ALTER COLUMN book_id SET NOT NULL;
+ALTER TABLE loans DROP COLUMN copy_id;
+
+DROP TABLE copies;
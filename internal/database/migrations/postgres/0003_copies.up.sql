@@ -0,0 +1,52 @@
+-- Physical copies: each item of a book has its own barcode, condition and status,
+-- loans reference the copy they lend out, and availability is derived from copy status.
+CREATE TABLE copies (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    book_id BIGINT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    barcode TEXT UNIQUE NOT NULL,
+    condition TEXT NOT NULL DEFAULT 'good',
+    status TEXT NOT NULL DEFAULT 'available',
+    acquisition_date TEXT NOT NULL
+);
+
+CREATE INDEX idx_copies_book_status ON copies (book_id, status);
+
+-- Convert each book's stock counter into that many available copies.
+INSERT INTO copies (book_id, barcode, condition, status, acquisition_date)
+SELECT b.id, format('LIB-%s-%s', lpad(b.id::text, 6, '0'), lpad(n::text, 3, '0')),
+    'good', 'available', to_char(CURRENT_DATE, 'YYYY-MM-DD')
+FROM books b
+CROSS JOIN LATERAL generate_series(1, b.stock) AS n
+ORDER BY b.id, n;
+
+-- Stock was decremented for active loans, so the copies they hold do not exist yet.
+INSERT INTO copies (book_id, barcode, condition, status, acquisition_date)
+SELECT l.book_id, format('LIB-%s-L%s', lpad(l.book_id::text, 6, '0'), lpad(l.id::text, 6, '0')),
+    'good', 'on_loan', to_char(CURRENT_DATE, 'YYYY-MM-DD')
+FROM loans l
+WHERE l.return_date IS NULL
+ORDER BY l.id;
+
+-- Returned loans of books that no longer have any copy keep their history through a withdrawn copy.
+INSERT INTO copies (book_id, barcode, condition, status, acquisition_date)
+SELECT DISTINCT l.book_id, format('LIB-%s-W', lpad(l.book_id::text, 6, '0')),
+    'good', 'withdrawn', to_char(CURRENT_DATE, 'YYYY-MM-DD')
+FROM loans l
+WHERE l.return_date IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM copies c WHERE c.book_id = l.book_id);
+
+ALTER TABLE loans ADD COLUMN copy_id BIGINT REFERENCES copies(id);
+
+UPDATE loans l SET copy_id = CASE WHEN l.return_date IS NULL
+    THEN (SELECT c.id FROM copies c
+          WHERE c.barcode = format('LIB-%s-L%s', lpad(l.book_id::text, 6, '0'), lpad(l.id::text, 6, '0')))
+    ELSE (SELECT MIN(c.id) FROM copies c WHERE c.book_id = l.book_id)
+END;
+
+ALTER TABLE loans ALTER COLUMN copy_id SET NOT NULL;
+ALTER TABLE loans DROP COLUMN book_id;
+
+CREATE INDEX idx_loans_copy_id ON loans (copy_id);
+CREATE INDEX idx_loans_user_id ON loans (user_id);
+
+ALTER TABLE books DROP COLUMN stock;
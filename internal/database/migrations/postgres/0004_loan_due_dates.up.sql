@@ -0,0 +1,10 @@
+-- Loans get a due date and a renewal counter. Existing loans are given the default
+-- 14-day loan period, counted from their loan date.
+ALTER TABLE loans ADD COLUMN due_date TIMESTAMPTZ;
+ALTER TABLE loans ADD COLUMN renewal_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE loans SET due_date = loan_date + INTERVAL '14 days';
+
+ALTER TABLE loans ALTER COLUMN due_date SET NOT NULL;
+
+CREATE INDEX idx_loans_due_date ON loans (due_date) WHERE return_date IS NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS account_entries;
+DROP TABLE IF EXISTS fine_policies;
+ALTER TABLE books DROP COLUMN material_type;
@@ -0,0 +1,18 @@
+-- Holds: patrons queue for books with no available copy, first come, first served.
+-- A returned copy is set aside ("on_hold") for the oldest waiting hold, which is then
+-- "ready" until its pickup expiry.
+CREATE TABLE holds (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    book_id BIGINT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    copy_id BIGINT REFERENCES copies(id) ON DELETE SET NULL,
+    status TEXT NOT NULL DEFAULT 'waiting',
+    created_at TIMESTAMPTZ NOT NULL,
+    ready_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_holds_book_queue ON holds (book_id, status);
+CREATE INDEX idx_holds_user_id ON holds (user_id);
+-- A patron may only hold a book once at a time.
+CREATE UNIQUE INDEX idx_holds_active_per_user ON holds (book_id, user_id) WHERE status IN ('waiting', 'ready');
@@ -0,0 +1,15 @@
+-- Refresh tokens: long-lived, single-use tokens that are exchanged for a new access token
+-- and a new refresh token. Only a SHA-256 hash of each token is stored. Every token issued
+-- from one login shares a session_id, so a session can be revoked as a whole.
+CREATE TABLE refresh_tokens (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens (session_id);
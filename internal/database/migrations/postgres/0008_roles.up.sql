@@ -0,0 +1,32 @@
+-- Roles: named bundles of permissions. users.role names a role; the permission names
+-- themselves are defined by the application.
+CREATE TABLE roles (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('member', 'Borrows books and manages their own loans, holds and account'),
+    ('librarian', 'Runs the library');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('librarian', 'authors:write'),
+    ('librarian', 'books:write'),
+    ('librarian', 'copies:manage'),
+    ('librarian', 'loans:read_all'),
+    ('librarian', 'loans:manage'),
+    ('librarian', 'holds:manage'),
+    ('librarian', 'accounts:read'),
+    ('librarian', 'accounts:write'),
+    ('librarian', 'fine_policies:manage'),
+    ('librarian', 'roles:manage'),
+    ('librarian', 'users:manage');
+
+-- Any other role users were given before roles were validated is kept, without permissions.
+INSERT INTO roles (name) SELECT DISTINCT role FROM users WHERE role NOT IN (SELECT name FROM roles);
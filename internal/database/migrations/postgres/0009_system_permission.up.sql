@@ -0,0 +1,3 @@
+-- The system:read permission lets librarians view operational data such as cache statistics.
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'system:read' FROM roles WHERE name = 'librarian';
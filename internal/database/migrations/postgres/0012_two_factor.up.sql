@@ -0,0 +1,21 @@
+-- Two-factor authentication. A user's TOTP secret is pending until they confirm it with
+-- a code, and enabled from then on. last_step is the time step of the last code accepted,
+-- so no code can be used twice.
+CREATE TABLE user_totp (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    enabled_at TIMESTAMPTZ,
+    last_step BIGINT NOT NULL DEFAULT 0
+);
+
+-- Single-use codes that stand in for a TOTP code when the authenticator is lost. Only a
+-- SHA-256 hash of each code is stored.
+CREATE TABLE recovery_codes (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
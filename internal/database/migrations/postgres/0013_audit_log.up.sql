@@ -0,0 +1,37 @@
+-- Audit log: one row per change to a book, author, user or loan, written in the same
+-- transaction as the change. changes holds the JSON diff of the entity; it is TEXT rather
+-- than JSONB so it is stored byte for byte as hashed. prev_hash and hash chain each row
+-- to the one before it while the hash chain is on. Actors are not foreign keys, so the
+-- log outlives the users it names.
+CREATE TABLE audit_log (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    actor_id BIGINT,
+    actor TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    changes TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT,
+    hash TEXT
+);
+
+CREATE INDEX idx_audit_log_entity ON audit_log (entity_type, entity_id);
+CREATE INDEX idx_audit_log_actor_id ON audit_log (actor_id);
+CREATE INDEX idx_audit_log_created_at ON audit_log (created_at);
+
+-- The log is append-only: rows can be inserted but never changed or removed.
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- The audit:read permission lets librarians review the audit log.
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'audit:read' FROM roles WHERE name = 'librarian';
@@ -0,0 +1,4 @@
+-- The circulation:desk permission lets librarians check copies out to and in from any patron,
+-- and look patrons up, at the circulation desk.
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'circulation:desk' FROM roles WHERE name = 'librarian';
@@ -0,0 +1,18 @@
+-- Circulation rules: loan policies cap how many items patrons of each role may borrow at
+-- once, either of one material type or, with material type '*', of all of them together.
+-- Roles without a policy are not capped. Memberships without an expiry never expire.
+ALTER TABLE users ADD COLUMN membership_expires_at TIMESTAMPTZ;
+
+CREATE TABLE loan_policies (
+    role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    material_type TEXT NOT NULL,
+    max_loans INTEGER NOT NULL,
+    PRIMARY KEY (role, material_type)
+);
+
+INSERT INTO loan_policies (role, material_type, max_loans) VALUES
+    ('member', '*', 5),
+    ('member', 'dvd', 2);
+
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'loan_policies:manage' FROM roles WHERE name = 'librarian';
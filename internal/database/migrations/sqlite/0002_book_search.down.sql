@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS authors_fts_after_delete;
+DROP TRIGGER IF EXISTS authors_fts_after_update;
+DROP TRIGGER IF EXISTS books_fts_after_delete;
+DROP TRIGGER IF EXISTS books_fts_after_update;
+DROP TRIGGER IF EXISTS books_fts_after_insert;
+DROP TABLE IF EXISTS books_fts;
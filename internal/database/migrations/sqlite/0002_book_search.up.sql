@@ -0,0 +1,49 @@
+-- Full-text search index over book titles, ISBNs and author names and bios.
+-- The rowid of each entry is the id of the book it indexes. Requires SQLite built with FTS5
+-- (go-sqlite3's "sqlite_fts5" build tag).
+CREATE VIRTUAL TABLE books_fts USING fts5(
+    title,
+    isbn,
+    author_name,
+    author_bio,
+    tokenize = 'porter unicode61'
+);
+
+INSERT INTO books_fts (rowid, title, isbn, author_name, author_bio)
+SELECT b.id, b.title, b.isbn, COALESCE(a.name, ''), COALESCE(a.bio, '')
+FROM books b
+LEFT JOIN authors a ON b.author_id = a.id;
+
+-- Keep the index in sync with the books table.
+CREATE TRIGGER books_fts_after_insert AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts (rowid, title, isbn, author_name, author_bio)
+    VALUES (
+        NEW.id, NEW.title, NEW.isbn,
+        COALESCE((SELECT name FROM authors WHERE id = NEW.author_id), ''),
+        COALESCE((SELECT bio FROM authors WHERE id = NEW.author_id), '')
+    );
+END;
+
+CREATE TRIGGER books_fts_after_update AFTER UPDATE OF title, isbn, author_id ON books BEGIN
+    UPDATE books_fts SET
+        title = NEW.title,
+        isbn = NEW.isbn,
+        author_name = COALESCE((SELECT name FROM authors WHERE id = NEW.author_id), ''),
+        author_bio = COALESCE((SELECT bio FROM authors WHERE id = NEW.author_id), '')
+    WHERE rowid = NEW.id;
+END;
+
+CREATE TRIGGER books_fts_after_delete AFTER DELETE ON books BEGIN
+    DELETE FROM books_fts WHERE rowid = OLD.id;
+END;
+
+-- Keep the denormalized author columns in sync with the authors table.
+CREATE TRIGGER authors_fts_after_update AFTER UPDATE OF name, bio ON authors BEGIN
+    UPDATE books_fts SET author_name = NEW.name, author_bio = COALESCE(NEW.bio, '')
+    WHERE rowid IN (SELECT id FROM books WHERE author_id = NEW.id);
+END;
+
+CREATE TRIGGER authors_fts_after_delete AFTER DELETE ON authors BEGIN
+    UPDATE books_fts SET author_name = '', author_bio = ''
+    WHERE rowid IN (SELECT id FROM books WHERE author_id = OLD.id);
+END;
@@ -0,0 +1,25 @@
+ALTER TABLE books ADD COLUMN stock INTEGER NOT NULL DEFAULT 0;
+
+UPDATE books SET stock = (
+    SELECT COUNT(*) FROM copies c WHERE c.book_id = books.id AND c.status = 'available'
+);
+
+CREATE TABLE loans_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    loan_date TEXT NOT NULL,
+    return_date TEXT,
+    FOREIGN KEY (book_id) REFERENCES books(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+INSERT INTO loans_old (id, book_id, user_id, loan_date, return_date)
+SELECT l.id, c.book_id, l.user_id, l.loan_date, l.return_date
+FROM loans l
+JOIN copies c ON l.copy_id = c.id;
+
+DROP TABLE loans;
+ALTER TABLE loans_old RENAME TO loans;
+
+DROP TABLE copies;
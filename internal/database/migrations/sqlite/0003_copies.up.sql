@@ -0,0 +1,75 @@
+-- Physical copies: each item of a book has its own barcode, condition and status,
+-- loans reference the copy they lend out, and availability is derived from copy status.
+CREATE TABLE copies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    barcode TEXT UNIQUE NOT NULL,
+    condition TEXT NOT NULL DEFAULT 'good',
+    status TEXT NOT NULL DEFAULT 'available',
+    acquisition_date TEXT NOT NULL,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_copies_book_status ON copies (book_id, status);
+
+-- Convert each book's stock counter into that many available copies.
+WITH RECURSIVE seq(n) AS (
+    SELECT 1
+    UNION ALL
+    SELECT n + 1 FROM seq WHERE n < (SELECT MAX(stock) FROM books)
+)
+INSERT INTO copies (book_id, barcode, condition, status, acquisition_date)
+SELECT b.id, printf('LIB-%06d-%03d', b.id, seq.n), 'good', 'available', date('now')
+FROM books b
+JOIN seq ON seq.n <= b.stock
+ORDER BY b.id, seq.n;
+
+-- Databases from before versioned migrations dropped books on every start, leaving loans
+-- of books that no longer exist. Each missing book gets a placeholder, so those loans keep
+-- a copy to point at and can still be returned.
+INSERT INTO books (id, title, published_date, isbn, stock, author_id)
+SELECT DISTINCT l.book_id, printf('Missing book #%d', l.book_id), '1970-01-01', printf('MISSING-%06d', l.book_id), 0, NULL
+FROM loans l
+WHERE NOT EXISTS (SELECT 1 FROM books b WHERE b.id = l.book_id);
+
+-- Stock was decremented for active loans, so the copies they hold do not exist yet.
+INSERT INTO copies (book_id, barcode, condition, status, acquisition_date)
+SELECT l.book_id, printf('LIB-%06d-L%06d', l.book_id, l.id), 'good', 'on_loan', date('now')
+FROM loans l
+WHERE l.return_date IS NULL
+ORDER BY l.id;
+
+-- Returned loans of books that no longer have any copy keep their history through a withdrawn copy.
+INSERT INTO copies (book_id, barcode, condition, status, acquisition_date)
+SELECT DISTINCT l.book_id, printf('LIB-%06d-W', l.book_id), 'good', 'withdrawn', date('now')
+FROM loans l
+WHERE l.return_date IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM copies c WHERE c.book_id = l.book_id);
+
+-- Rebuild loans around copy_id. The dates become DATETIME so the driver scans them as times.
+CREATE TABLE loans_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    copy_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    loan_date DATETIME NOT NULL,
+    return_date DATETIME,
+    FOREIGN KEY (copy_id) REFERENCES copies(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+INSERT INTO loans_new (id, copy_id, user_id, loan_date, return_date)
+SELECT l.id,
+    CASE WHEN l.return_date IS NULL
+        THEN (SELECT c.id FROM copies c WHERE c.barcode = printf('LIB-%06d-L%06d', l.book_id, l.id))
+        ELSE (SELECT MIN(c.id) FROM copies c WHERE c.book_id = l.book_id)
+    END,
+    l.user_id, l.loan_date, l.return_date
+FROM loans l;
+
+DROP TABLE loans;
+ALTER TABLE loans_new RENAME TO loans;
+
+CREATE INDEX idx_loans_copy_id ON loans (copy_id);
+CREATE INDEX idx_loans_user_id ON loans (user_id);
+
+ALTER TABLE books DROP COLUMN stock;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_loans_due_date;
+ALTER TABLE loans DROP COLUMN renewal_count;
+ALTER TABLE loans DROP COLUMN due_date;
@@ -0,0 +1,8 @@
+-- Loans get a due date and a renewal counter. Existing loans are given the default
+-- 14-day loan period, counted from their loan date.
+ALTER TABLE loans ADD COLUMN due_date DATETIME;
+ALTER TABLE loans ADD COLUMN renewal_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE loans SET due_date = datetime(loan_date, '+14 days');
+
+CREATE INDEX idx_loans_due_date ON loans (due_date) WHERE return_date IS NULL;
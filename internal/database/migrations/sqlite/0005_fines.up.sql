@@ -0,0 +1,31 @@
+-- Fines: books carry a material type, each material type has a fine policy, and every
+-- patron has a ledger of charges, payments and waivers. Amounts are in cents.
+ALTER TABLE books ADD COLUMN material_type TEXT NOT NULL DEFAULT 'book';
+
+CREATE TABLE fine_policies (
+    material_type TEXT PRIMARY KEY,
+    daily_rate_cents INTEGER NOT NULL,
+    max_fine_cents INTEGER NOT NULL,
+    grace_days INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO fine_policies (material_type, daily_rate_cents, max_fine_cents, grace_days) VALUES
+    ('book', 25, 1000, 1),
+    ('magazine', 10, 300, 1),
+    ('dvd', 100, 2000, 0);
+
+CREATE TABLE account_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    loan_id INTEGER,
+    kind TEXT NOT NULL,
+    amount_cents INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    recorded_by INTEGER,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (loan_id) REFERENCES loans(id),
+    FOREIGN KEY (recorded_by) REFERENCES users(id)
+);
+
+CREATE INDEX idx_account_entries_user_id ON account_entries (user_id);
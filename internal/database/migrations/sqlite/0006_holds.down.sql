@@ -0,0 +1,3 @@
+-- Copies set aside for holds go back on the shelf.
+UPDATE copies SET status = 'available' WHERE status = 'on_hold';
+DROP TABLE IF EXISTS holds;
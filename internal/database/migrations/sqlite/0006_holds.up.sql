@@ -0,0 +1,21 @@
+-- Holds: patrons queue for books with no available copy, first come, first served.
+-- A returned copy is set aside ("on_hold") for the oldest waiting hold, which is then
+-- "ready" until its pickup expiry.
+CREATE TABLE holds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    copy_id INTEGER,
+    status TEXT NOT NULL DEFAULT 'waiting',
+    created_at DATETIME NOT NULL,
+    ready_at DATETIME,
+    expires_at DATETIME,
+    FOREIGN KEY (book_id) REFERENCES books(id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (copy_id) REFERENCES copies(id)
+);
+
+CREATE INDEX idx_holds_book_queue ON holds (book_id, status);
+CREATE INDEX idx_holds_user_id ON holds (user_id);
+-- A patron may only hold a book once at a time.
+CREATE UNIQUE INDEX idx_holds_active_per_user ON holds (book_id, user_id) WHERE status IN ('waiting', 'ready');
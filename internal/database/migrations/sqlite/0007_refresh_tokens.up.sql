@@ -0,0 +1,16 @@
+-- Refresh tokens: long-lived, single-use tokens that are exchanged for a new access token
+-- and a new refresh token. Only a SHA-256 hash of each token is stored. Every token issued
+-- from one login shares a session_id, so a session can be revoked as a whole.
+CREATE TABLE refresh_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    session_id TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens (session_id);
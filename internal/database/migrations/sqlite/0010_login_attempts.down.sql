@@ -0,0 +1,2 @@
+DELETE FROM role_permissions WHERE permission = 'security:read';
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,18 @@
+-- Failed login attempts, kept so librarians can spot password spraying and brute-force
+-- attacks. Usernames are stored as typed, since attacks often target accounts that do
+-- not exist.
+CREATE TABLE login_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_login_attempts_created_at ON login_attempts (created_at);
+CREATE INDEX idx_login_attempts_username ON login_attempts (username);
+CREATE INDEX idx_login_attempts_ip ON login_attempts (ip);
+
+-- The security:read permission lets librarians review failed login attempts.
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'security:read' FROM roles WHERE name = 'librarian';
@@ -0,0 +1,23 @@
+-- Two-factor authentication. A user's TOTP secret is pending until they confirm it with
+-- a code, and enabled from then on. last_step is the time step of the last code accepted,
+-- so no code can be used twice.
+CREATE TABLE user_totp (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    enabled_at DATETIME,
+    last_step INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Single-use codes that stand in for a TOTP code when the authenticator is lost. Only a
+-- SHA-256 hash of each code is stored.
+CREATE TABLE recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
@@ -0,0 +1,4 @@
+DELETE FROM role_permissions WHERE permission = 'audit:read';
+DROP TRIGGER IF EXISTS audit_log_no_delete;
+DROP TRIGGER IF EXISTS audit_log_no_update;
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,36 @@
+-- Audit log: one row per change to a book, author, user or loan, written in the same
+-- transaction as the change. changes holds the JSON diff of the entity. prev_hash and
+-- hash chain each row to the one before it while the hash chain is on.
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME NOT NULL,
+    actor_id INTEGER,
+    actor TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    changes TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT,
+    hash TEXT
+);
+
+CREATE INDEX idx_audit_log_entity ON audit_log (entity_type, entity_id);
+CREATE INDEX idx_audit_log_actor_id ON audit_log (actor_id);
+CREATE INDEX idx_audit_log_created_at ON audit_log (created_at);
+
+-- The log is append-only: rows can be inserted but never changed or removed.
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+-- The audit:read permission lets librarians review the audit log.
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'audit:read' FROM roles WHERE name = 'librarian';
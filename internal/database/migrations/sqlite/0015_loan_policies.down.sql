@@ -0,0 +1,3 @@
+DELETE FROM role_permissions WHERE permission = 'loan_policies:manage';
+DROP TABLE IF EXISTS loan_policies;
+ALTER TABLE users DROP COLUMN membership_expires_at;
@@ -0,0 +1,19 @@
+-- Circulation rules: loan policies cap how many items patrons of each role may borrow at
+-- once, either of one material type or, with material type '*', of all of them together.
+-- Roles without a policy are not capped. Memberships without an expiry never expire.
+ALTER TABLE users ADD COLUMN membership_expires_at DATETIME;
+
+CREATE TABLE loan_policies (
+    role TEXT NOT NULL,
+    material_type TEXT NOT NULL,
+    max_loans INTEGER NOT NULL,
+    PRIMARY KEY (role, material_type),
+    FOREIGN KEY (role) REFERENCES roles(name)
+);
+
+INSERT INTO loan_policies (role, material_type, max_loans) VALUES
+    ('member', '*', 5),
+    ('member', 'dvd', 2);
+
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'loan_policies:manage' FROM roles WHERE name = 'librarian';
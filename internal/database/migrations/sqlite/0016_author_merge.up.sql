@@ -0,0 +1,4 @@
+-- The authors:merge permission lets librarians merge duplicate authors, moving their books
+-- to the author kept.
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'authors:merge' FROM roles WHERE name = 'librarian';
@@ -0,0 +1,211 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handlers for patron accounts (fines, payments and waivers) and fine policies.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/gorilla/mux"
+)
+
+// respondWithAccount writes a patron's account, or the error looking it up.
+func (e *Env) respondWithAccount(w http.ResponseWriter, r *http.Request, userID int64) {
+	account, err := e.AccountRepo.GetAccount(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "User not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting account", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve account")
+		}
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, account)
+}
+
+// @Summary      Get my account
+// @Description  Retrieves the authenticated user's balance and ledger of fines, payments and waivers, newest first. Amounts are in cents.
+// @Tags         Accounts
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  models.Account
+// @Failure      401  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/me/account [get]
+func (e *Env) GetMyAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	e.respondWithAccount(w, r, user.ID)
+}
+
+// @Summary      Get a patron's account (Admin)
+// @Description  Retrieves a patron's balance and ledger of fines, payments and waivers, newest first. Amounts are in cents. Requires the accounts:read permission.
+// @Tags         Accounts
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  models.Account
+// @Failure      400  {object}  web.Problem
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/{id}/account [get]
+func (e *Env) GetUserAccountHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	e.respondWithAccount(w, r, userID)
+}
+
+// @Summary      Record an account entry (Admin)
+// @Description  Records a charge, payment or waiver on a patron's account. Requires the accounts:write permission.
+// @Description  Payments and waivers cannot exceed the balance. 'loan_id' is optional and must be one of the patron's loans.
+// @Tags         Accounts
+// @Accept       json
+// @Produce      json
+// @Param        id     path      int                  true  "User ID"
+// @Param        entry  body      models.AccountEntry  true  "Entry to record. Note: 'id', 'user_id', 'recorded_by' and 'created_at' fields are ignored."
+// @Success      201    {object}  models.Account
+// @Failure      400    {object}  web.Problem
+// @Failure      401    {object}  web.Problem
+// @Failure      403    {object}  web.Problem
+// @Failure      404    {object}  web.Problem
+// @Failure      409    {object}  web.Problem
+// @Failure      500    {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/{id}/account/entries [post]
+func (e *Env) CreateAccountEntryHandler(w http.ResponseWriter, r *http.Request) {
+	librarian, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var entry models.AccountEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	entry.UserID = userID
+	entry.RecordedBy = &librarian.ID
+
+	if err := validate.Struct(entry); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	if _, err := e.AccountRepo.AddEntry(r.Context(), entry); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "User not found")
+		} else if errors.Is(err, repository.ErrExceedsBalance) {
+			respondWithRepoError(w, r, err, "Payments and waivers cannot exceed the outstanding balance")
+		} else if errors.Is(err, repository.ErrLoanNotOwned) {
+			web.RespondWithValidationErrors(w, map[string]string{"loan_id": "This loan does not belong to the user."})
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error recording account entry", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to record entry")
+		}
+		return
+	}
+
+	account, err := e.AccountRepo.GetAccount(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error fetching updated account", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusCreated, account)
+}
+
+// @Summary      List fine policies (Admin)
+// @Description  Retrieves the daily rate, cap and grace period for each material type. Amounts are in cents. Requires the fine_policies:manage permission.
+// @Tags         Accounts
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   models.FinePolicy
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /fine-policies [get]
+func (e *Env) GetFinePoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	policies, err := e.FinePolicyRepo.GetAll(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error listing fine policies", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve fine policies")
+		return
+	}
+
+	if policies == nil {
+		policies = []models.FinePolicy{}
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, policies)
+}
+
+// @Summary      Set a fine policy (Admin)
+// @Description  Creates or replaces the fine policy for a material type. Fines apply to loans returned after the policy is set. Requires the fine_policies:manage permission.
+// @Tags         Accounts
+// @Accept       json
+// @Produce      json
+// @Param        material_type  path      string             true  "Material type (e.g. book, magazine, dvd)"
+// @Param        policy         body      models.FinePolicy  true  "Policy to set. Note: the 'material_type' field is ignored."
+// @Success      200            {object}  models.FinePolicy
+// @Failure      400            {object}  web.Problem
+// @Failure      401            {object}  web.Problem
+// @Failure      403            {object}  web.Problem
+// @Failure      500            {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /fine-policies/{material_type} [put]
+func (e *Env) UpdateFinePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var policy models.FinePolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	policy.MaterialType = vars["material_type"]
+
+	if err := validate.Struct(policy); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	if err := e.FinePolicyRepo.Upsert(r.Context(), policy); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error saving fine policy", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to save fine policy")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, policy)
+}
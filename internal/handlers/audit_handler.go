@@ -0,0 +1,137 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handlers for reviewing the audit log.
+package handlers
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+)
+
+// PaginatedAuditResponse is the structure for paginated audit log responses.
+type PaginatedAuditResponse struct {
+	Metadata map[string]interface{} `json:"metadata"`
+	Data     []models.AuditEntry    `json:"data"`
+}
+
+// @Summary      List audit log entries
+// @Description  Get a paginated list of the changes made to books, authors, users and loans, newest first. Each entry names who made the change, from which request and IP, and the fields it changed. Requires the audit:read permission.
+// @Description  Actions are create, update, delete, role_change, password_change, checkout, return and renew; entity types are book, author, user and loan.
+// @Tags         Audit
+// @Produce      json
+// @Param        actor_id     query     int     false  "Filter by the ID of the user who made the change"
+// @Param        action       query     string  false  "Filter by action"
+// @Param        entity_type  query     string  false  "Filter by entity type"
+// @Param        entity_id    query     int     false  "Filter by entity ID"
+// @Param        request_id   query     string  false  "Filter by request ID"
+// @Param        since        query     string  false  "Only entries at or after this time (RFC 3339)"
+// @Param        until        query     string  false  "Only entries before this time (RFC 3339)"
+// @Param        page         query     int     false  "Page number for pagination"
+// @Param        limit        query     int     false  "Number of items per page"
+// @Success      200          {object}  PaginatedAuditResponse
+// @Failure      400          {object}  web.Problem
+// @Failure      401          {object}  web.Problem
+// @Failure      403          {object}  web.Problem
+// @Failure      500          {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /audit [get]
+func (e *Env) GetAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+
+	var filter repository.AuditFilter
+	if actorIDStr := query.Get("actor_id"); actorIDStr != "" {
+		actorID, err := strconv.ParseInt(actorIDStr, 10, 64)
+		if err != nil {
+			web.RespondWithError(w, http.StatusBadRequest, "Invalid 'actor_id', expected an integer")
+			return
+		}
+		filter.ActorID = &actorID
+	}
+	if action := query.Get("action"); action != "" {
+		filter.Action = &action
+	}
+	if entityType := query.Get("entity_type"); entityType != "" {
+		filter.EntityType = &entityType
+	}
+	if entityIDStr := query.Get("entity_id"); entityIDStr != "" {
+		entityID, err := strconv.ParseInt(entityIDStr, 10, 64)
+		if err != nil {
+			web.RespondWithError(w, http.StatusBadRequest, "Invalid 'entity_id', expected an integer")
+			return
+		}
+		filter.EntityID = &entityID
+	}
+	if requestID := query.Get("request_id"); requestID != "" {
+		filter.RequestID = &requestID
+	}
+	if sinceStr := query.Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			web.RespondWithError(w, http.StatusBadRequest, "Invalid 'since', expected an RFC 3339 time")
+			return
+		}
+		filter.Since = &since
+	}
+	if untilStr := query.Get("until"); untilStr != "" {
+		until, err := time.Parse(time.RFC3339, untilStr)
+		if err != nil {
+			web.RespondWithError(w, http.StatusBadRequest, "Invalid 'until', expected an RFC 3339 time")
+			return
+		}
+		filter.Until = &until
+	}
+
+	entries, totalRecords, err := e.AuditRepo.Search(r.Context(), filter, limit, (page-1)*limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error searching the audit log", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, PaginatedAuditResponse{
+		Metadata: map[string]interface{}{
+			"current_page":  page,
+			"page_size":     limit,
+			"total_records": totalRecords,
+			"total_pages":   int(math.Ceil(float64(totalRecords) / float64(limit))),
+		},
+		Data: entries,
+	})
+}
+
+// @Summary      Verify the audit log
+// @Description  Check the hash chain of the whole audit log, oldest entry first. Entries written while the hash chain was off are counted but not chained. If an entry was altered, or the one chained before it was altered or deleted, intact is false and broken_at is its ID. Requires the audit:read permission.
+// @Tags         Audit
+// @Produce      json
+// @Success      200  {object}  models.AuditVerification
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /audit/verify [get]
+func (e *Env) VerifyAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := e.AuditRepo.Verify(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error verifying the audit log", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	web.RespondWithJSON(w, http.StatusOK, result)
+}
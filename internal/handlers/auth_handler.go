@@ -1,18 +1,20 @@
 // Package handlers contains the HTTP handlers for the application.
-// This file focuses on user registration and login.
+// This file focuses on user registration, login and the session tokens.
 package handlers
 
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/Lec7ral/fullAPI/internal/auth"
 	"github.com/Lec7ral/fullAPI/internal/models"
 	"github.com/Lec7ral/fullAPI/internal/repository"
 	"github.com/Lec7ral/fullAPI/internal/web"
-	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -22,108 +24,354 @@ type Credentials struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// TokenResponse is returned by login and refresh. The access token authenticates requests
+// until it expires; the refresh token is exchanged once for a new pair.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds until the access token expires
+}
+
+// RefreshRequest is the body of a token refresh.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
 // @Summary      Register a new user
 // @Description  Creates a new user account with the 'member' role.
+// @Description  The password must meet the password policy: a minimum length, not containing the username, and not in the list of breached passwords.
+// @Description  Registrations are rate limited by client IP, and refused with 429 and a Retry-After header beyond the limit.
 // @Tags         Authentication
 // @Accept       json
 // @Produce      json
 // @Param        credentials  body      Credentials  true  "User Credentials"
 // @Success      201          {string}  string "Created"
-// @Failure      400          {object}  map[string]string
-// @Failure      409          {object}  map[string]string
-// @Failure      500          {object}  map[string]string
+// @Failure      400          {object}  web.Problem
+// @Failure      409          {object}  web.Problem
+// @Failure      429          {object}  web.Problem
+// @Failure      500          {object}  web.Problem
 // @Router       /register [post]
 func (e *Env) RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	if err := validate.Struct(creds); err != nil {
 		errors := validationErrors(err)
-		web.RespondWithJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errors})
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+	if err := e.PasswordPolicy.Check(creds.Username, creds.Password); err != nil {
+		web.RespondWithValidationErrors(w, passwordPolicyErrors("password", err))
 		return
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("Error hashing password: %v", err)
+		slog.ErrorContext(r.Context(), "Error hashing password", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
 	user := models.User{Username: creds.Username}
-	err = e.UserRepo.Create(user, string(hashedPassword))
+	err = e.UserRepo.Create(r.Context(), user, string(hashedPassword))
 	if err != nil {
 		if errors.Is(err, repository.ErrUsernameExists) {
-			web.RespondWithError(w, http.StatusConflict, "Username already exists")
+			respondWithRepoError(w, r, err, "Username already exists")
 		} else {
-			log.Printf("Handler error creating user: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error creating user", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		}
 		return
 	}
 
+	e.Metrics.Registrations.Inc()
 	web.RespondWithJSON(w, http.StatusCreated, nil)
 }
 
 // @Summary      Login a user
-// @Description  Authenticates a user and returns a JWT token.
+// @Description  Authenticates a user and returns a short-lived access token and a refresh token.
+// @Description  The refresh token is single-use: exchange it at /token/refresh for a new pair before the access token expires.
+// @Description  Users with two-factor authentication enabled get 202 and a challenge token instead, which they exchange at /login/2fa along with a code.
+// @Description  Attempts are rate limited by client IP and by username, and an account is locked for a while after repeated failures. Both are refused with 429 and a Retry-After header.
 // @Tags         Authentication
 // @Accept       json
 // @Produce      json
 // @Param        credentials  body      Credentials      true  "User Credentials"
-// @Success      200          {object}  map[string]string
-// @Failure      400          {object}  map[string]string
-// @Failure      401          {object}  map[string]string
-// @Failure      500          {object}  map[string]string
+// @Success      200          {object}  TokenResponse
+// @Success      202          {object}  TwoFactorChallengeResponse
+// @Failure      400          {object}  web.Problem
+// @Failure      401          {object}  web.Problem
+// @Failure      429          {object}  web.Problem
+// @Failure      500          {object}  web.Problem
 // @Router       /login [post]
 func (e *Env) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
-	user, err := e.UserRepo.GetByUsername(creds.Username)
+	// Locked accounts are refused before their password is checked, so guessing costs
+	// the attacker a request but not the server a bcrypt comparison.
+	lockedFor, err := e.Lockout.LockedFor(r.Context(), creds.Username)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Lockout unavailable, not checking account", "error", err)
+	}
+	if lockedFor > 0 {
+		e.recordFailedLogin(r, creds.Username, models.LoginLockedOut)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+		web.RespondWithCode(w, http.StatusTooManyRequests, CodeAccountLocked, "Too many failed logins, account temporarily locked")
+		return
+	}
+
+	user, err := e.UserRepo.GetByUsername(r.Context(), creds.Username)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			web.RespondWithError(w, http.StatusUnauthorized, "Invalid username or password")
+			e.failLogin(r, creds.Username, models.LoginUnknownUser)
+			web.RespondWithCode(w, http.StatusUnauthorized, CodeInvalidCredentials, "Invalid username or password")
 		} else {
-			log.Printf("Handler error getting user: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error getting user", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		}
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)); err != nil {
-		web.RespondWithError(w, http.StatusUnauthorized, "Invalid username or password")
+		e.failLogin(r, creds.Username, models.LoginWrongPassword)
+		web.RespondWithCode(w, http.StatusUnauthorized, CodeInvalidCredentials, "Invalid username or password")
+		return
+	}
+	// With two-factor authentication the failures are only reset once the code is right
+	// too, so knowing the password does not buy unlimited guesses at the code.
+	if user.TwoFactorEnabled {
+		e.respondWithChallenge(w, r, user)
+		return
+	}
+	if err := e.Lockout.Reset(r.Context(), creds.Username); err != nil {
+		slog.WarnContext(r.Context(), "Error resetting failed logins", "error", err)
+	}
+
+	e.startSession(w, r, user)
+}
+
+// respondWithChallenge responds to a right password with a challenge token, which the
+// user exchanges at /login/2fa along with their code.
+func (e *Env) respondWithChallenge(w http.ResponseWriter, r *http.Request, user *models.User) {
+	challenge, err := e.Tokens.IssueChallengeToken(user)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating challenge token", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	web.RespondWithJSON(w, http.StatusAccepted, TwoFactorChallengeResponse{
+		ChallengeToken: challenge,
+		ExpiresIn:      int(auth.ChallengeTTL.Seconds()),
+	})
+}
+
+// failLogin records a failed login and counts it towards locking the account.
+func (e *Env) failLogin(r *http.Request, username, reason string) {
+	e.recordFailedLogin(r, username, reason)
+	lockedFor, err := e.Lockout.Fail(r.Context(), username)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Error counting failed login", "error", err)
+	}
+	if lockedFor > 0 {
+		slog.WarnContext(r.Context(), "Account locked after repeated failed logins", "username", username, "ip", web.ClientIP(r), "duration", lockedFor.String())
+	}
+}
+
+// recordFailedLogin stores a failed login for review. The login fails either way, so
+// storage errors are only logged.
+func (e *Env) recordFailedLogin(r *http.Request, username, reason string) {
+	e.Metrics.FailedLogins.Inc()
+	attempt := models.LoginAttempt{Username: username, IP: web.ClientIP(r), Reason: reason}
+	if err := e.LoginAttemptRepo.Record(r.Context(), attempt); err != nil {
+		slog.ErrorContext(r.Context(), "Error recording failed login", "error", err)
+	}
+}
+
+// @Summary      Refresh the access token
+// @Description  Exchanges a refresh token for a new access token and a new refresh token. The presented refresh token cannot be used again;
+// @Description  presenting one that was already exchanged signs out its whole session, since it means the token was stolen or replayed.
+// @Tags         Authentication
+// @Accept       json
+// @Produce      json
+// @Param        refresh  body      RefreshRequest  true  "Refresh Token"
+// @Success      200      {object}  TokenResponse
+// @Failure      400      {object}  web.Problem
+// @Failure      401      {object}  web.Problem
+// @Failure      500      {object}  web.Problem
+// @Router       /token/refresh [post]
+func (e *Env) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating refresh token", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	next, err := e.RefreshTokenRepo.Rotate(r.Context(), auth.HashRefreshToken(req.RefreshToken), refreshHash, time.Now().Add(e.Tokens.RefreshTTL))
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound), errors.Is(err, repository.ErrTokenRevoked):
+			web.RespondWithCode(w, http.StatusUnauthorized, web.CodeInvalidToken, "Invalid refresh token")
+		case errors.Is(err, repository.ErrTokenExpired):
+			respondWithRepoError(w, r, err, "Refresh token has expired")
+		default:
+			slog.ErrorContext(r.Context(), "Handler error rotating refresh token", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
 		return
 	}
 
-	// Create custom claims to include the user's role.
-	type AppClaims struct {
-		Role string `json:"role"`
-		jwt.RegisteredClaims
+	// The user is read again so a changed role is reflected in the new access token.
+	user, err := e.UserRepo.GetByID(r.Context(), next.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			web.RespondWithCode(w, http.StatusUnauthorized, web.CodeInvalidToken, "Invalid refresh token")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting user", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	e.respondWithTokens(w, r, user, next.SessionID, refreshToken)
+}
+
+// @Summary      Log out
+// @Description  Revokes the access token used for this request and every refresh token of its session.
+// @Tags         Authentication
+// @Success      204
+// @Failure      401  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /logout [post]
+func (e *Env) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(web.ClaimsContextKey).(*auth.Claims)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve token from context")
+		return
+	}
+
+	if err := e.RefreshTokenRepo.RevokeSession(r.Context(), claims.SessionID); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking session", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := e.RevokedTokens.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking access token", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
 	}
 
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &AppClaims{
-		Role: user.Role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   user.Username,
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Log out everywhere
+// @Description  Revokes every access and refresh token of the current user, signing out all of their sessions.
+// @Tags         Authentication
+// @Success      204
+// @Failure      401  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /logout-all [post]
+func (e *Env) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(e.JWTSecret))
+	if err := e.RefreshTokenRepo.RevokeAllForUser(r.Context(), user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking sessions", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := e.revokeAccessTokens(r, user.Username); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking access tokens", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Get the token verification keys
+// @Description  Publishes the public keys access tokens are signed with as a JSON Web Key Set, so other services can verify them.
+// @Description  Tokens name their key in the `kid` header. During a key rotation, the previous key stays listed until its last token expires.
+// @Description  The set is empty when tokens are signed with a shared HS256 secret.
+// @Tags         Authentication
+// @Produce      json
+// @Success      200  {object}  auth.JWKS
+// @Router       /.well-known/jwks.json [get]
+func (e *Env) GetJWKSHandler(w http.ResponseWriter, r *http.Request) {
+	// Verifiers may cache the set briefly, refetching it when they meet an unknown kid.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	web.RespondWithJSON(w, http.StatusOK, e.Tokens.JWKS())
+}
+
+// startSession starts a new login session for the user and responds with its tokens.
+func (e *Env) startSession(w http.ResponseWriter, r *http.Request, user *models.User) {
+	// Each login starts a session; the refresh tokens it is rotated through share its ID.
+	sessionID, err := auth.NewSessionID()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating session ID", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating refresh token", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	stored := models.RefreshToken{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		TokenHash: refreshHash,
+		ExpiresAt: time.Now().Add(e.Tokens.RefreshTTL),
+	}
+	if err := e.RefreshTokenRepo.Create(r.Context(), stored); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error storing refresh token", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	e.respondWithTokens(w, r, user, sessionID, refreshToken)
+}
+
+// respondWithTokens issues an access token for the user in the given session and responds
+// with it and the session's new refresh token.
+func (e *Env) respondWithTokens(w http.ResponseWriter, r *http.Request, user *models.User, sessionID, refreshToken string) {
+	tokenString, err := e.Tokens.IssueAccessToken(user, sessionID)
 	if err != nil {
-		log.Printf("Error generating token: %v", err)
+		slog.ErrorContext(r.Context(), "Error generating token", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	web.RespondWithJSON(w, http.StatusOK, map[string]string{"token": tokenString})
+	web.RespondWithJSON(w, http.StatusOK, TokenResponse{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(e.Tokens.AccessTTL.Seconds()),
+	})
 }
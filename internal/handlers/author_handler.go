@@ -5,7 +5,8 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -16,41 +17,41 @@ import (
 )
 
 // @Summary      Create a new author
-// @Description  Adds a new author to the collection. Requires librarian role.
+// @Description  Adds a new author to the collection. Requires the authors:write permission.
 // @Tags         Authors
 // @Accept       json
 // @Produce      json
 // @Param        author  body      models.Author  true  "Author object to be created"
 // @Success      201     {object}  models.Author
-// @Failure      400     {object}  map[string]string
-// @Failure      401     {object}  map[string]string
-// @Failure      403     {object}  map[string]string
-// @Failure      500     {object}  map[string]string
+// @Failure      400     {object}  web.Problem
+// @Failure      401     {object}  web.Problem
+// @Failure      403     {object}  web.Problem
+// @Failure      500     {object}  web.Problem
 // @Security     BearerAuth
 // @Router       /authors [post]
 func (e *Env) CreateAuthorHandler(w http.ResponseWriter, r *http.Request) {
 	var newAuthor models.Author
 	if err := json.NewDecoder(r.Body).Decode(&newAuthor); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	if err := validate.Struct(newAuthor); err != nil {
 		errors := validationErrors(err)
-		web.RespondWithJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errors})
+		web.RespondWithValidationErrors(w, errors)
 		return
 	}
 
-	id, err := e.AuthorRepo.Create(newAuthor)
+	id, err := e.AuthorRepo.Create(r.Context(), newAuthor)
 	if err != nil {
-		log.Printf("Handler error creating author: %v", err)
+		slog.ErrorContext(r.Context(), "Handler error creating author", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Failed to create author")
 		return
 	}
 
-	createdAuthor, err := e.AuthorRepo.GetByID(id)
+	createdAuthor, err := e.AuthorRepo.GetByID(r.Context(), id)
 	if err != nil {
-		log.Printf("Handler error fetching created author: %v", err)
+		slog.ErrorContext(r.Context(), "Handler error fetching created author", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
@@ -58,18 +59,61 @@ func (e *Env) CreateAuthorHandler(w http.ResponseWriter, r *http.Request) {
 	web.RespondWithJSON(w, http.StatusCreated, createdAuthor)
 }
 
+// PaginatedAuthorsResponse is the structure for paginated author list responses.
+type PaginatedAuthorsResponse struct {
+	Metadata map[string]interface{} `json:"metadata"`
+	Data     []models.Author        `json:"data"`
+}
+
+// AuthorPatch holds the fields of an author to change; omitted fields are kept.
+type AuthorPatch struct {
+	Name *string `json:"name" validate:"omitempty,min=2,max=100"`
+	Bio  *string `json:"bio"`
+}
+
+// MergeAuthorsRequest lists the duplicate authors to merge into another.
+type MergeAuthorsRequest struct {
+	AuthorIDs []int64 `json:"author_ids" validate:"required,min=1,unique,dive,gt=0"`
+}
+
+// MergeAuthorsResponse is the author kept by a merge and the books moved to them.
+type MergeAuthorsResponse struct {
+	Author       *models.Author `json:"author"`
+	MovedBookIDs []int64        `json:"moved_book_ids"`
+}
+
 // @Summary      List authors
-// @Description  Get a list of all authors.
+// @Description  Get a paginated, filtered, and sorted list of authors.
 // @Tags         Authors
 // @Accept       json
 // @Produce      json
-// @Success      200  {array}   models.Author
-// @Failure      500  {object}  map[string]string
+// @Param        name     query     string  false  "Filter by author name (case-insensitive, partial match)"
+// @Param        sort     query     string  false  "Field to sort by. Allowed values: id, name"
+// @Param        order    query     string  false  "Sort order. Allowed values: asc, desc"
+// @Param        page     query     int     false  "Page number for pagination"
+// @Param        limit    query     int     false  "Number of items per page"
+// @Success      200      {object}  PaginatedAuthorsResponse
+// @Failure      500      {object}  web.Problem
 // @Router       /authors [get]
 func (e *Env) GetAuthorsHandler(w http.ResponseWriter, r *http.Request) {
-	authors, err := e.AuthorRepo.GetAll()
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	var filter repository.AuthorFilter
+	if name := r.URL.Query().Get("name"); name != "" {
+		filter.Name = &name
+	}
+	sort := r.URL.Query().Get("sort")
+	order := r.URL.Query().Get("order")
+
+	authors, totalRecords, err := e.AuthorRepo.Search(r.Context(), filter, limit, (page-1)*limit, sort, order)
 	if err != nil {
-		log.Printf("Handler error getting all authors: %v", err)
+		slog.ErrorContext(r.Context(), "Handler error searching authors", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
@@ -78,7 +122,15 @@ func (e *Env) GetAuthorsHandler(w http.ResponseWriter, r *http.Request) {
 		authors = []models.Author{}
 	}
 
-	web.RespondWithJSON(w, http.StatusOK, authors)
+	web.RespondWithJSON(w, http.StatusOK, PaginatedAuthorsResponse{
+		Metadata: map[string]interface{}{
+			"current_page":  page,
+			"page_size":     limit,
+			"total_records": totalRecords,
+			"total_pages":   int(math.Ceil(float64(totalRecords) / float64(limit))),
+		},
+		Data: authors,
+	})
 }
 
 // @Summary      Get an author by ID
@@ -88,19 +140,19 @@ func (e *Env) GetAuthorsHandler(w http.ResponseWriter, r *http.Request) {
 // @Produce      json
 // @Param        id   path      int  true  "Author ID"
 // @Success      200  {object}  models.Author
-// @Failure      404  {object}  map[string]string
-// @Failure      500  {object}  map[string]string
+// @Failure      404  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
 // @Router       /authors/{id} [get]
 func (e *Env) GetAuthorHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := strconv.ParseInt(vars["id"], 10, 64)
 
-	author, err := e.AuthorRepo.GetByID(id)
+	author, err := e.AuthorRepo.GetByID(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			web.RespondWithError(w, http.StatusNotFound, "Author not found")
+			respondWithRepoError(w, r, err, "Author not found")
 		} else {
-			log.Printf("Handler error getting author by ID: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error getting author by ID", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		}
 		return
@@ -108,3 +160,293 @@ func (e *Env) GetAuthorHandler(w http.ResponseWriter, r *http.Request) {
 
 	web.RespondWithJSON(w, http.StatusOK, author)
 }
+
+// @Summary      List an author's books
+// @Description  Get a paginated, filtered, and sorted list of the books of an author.
+// @Tags         Authors
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int     true   "Author ID"
+// @Param        title    query     string  false  "Filter by book title (case-insensitive, partial match)"
+// @Param        sort     query     string  false  "Field to sort by. Allowed values: title, published_date, stock"
+// @Param        order    query     string  false  "Sort order. Allowed values: asc, desc"
+// @Param        page     query     int     false  "Page number for pagination"
+// @Param        limit    query     int     false  "Number of items per page"
+// @Success      200      {object}  PaginatedBooksResponse
+// @Failure      400      {object}  web.Problem
+// @Failure      404      {object}  web.Problem
+// @Failure      500      {object}  web.Problem
+// @Router       /authors/{id}/books [get]
+func (e *Env) GetAuthorBooksHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		return
+	}
+	if _, err := e.AuthorRepo.GetByID(r.Context(), id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Author not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting author by ID", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	filter := repository.BookFilter{AuthorID: &id}
+	if title := r.URL.Query().Get("title"); title != "" {
+		filter.Title = &title
+	}
+	sort := r.URL.Query().Get("sort")
+	order := r.URL.Query().Get("order")
+
+	books, totalRecords, err := e.BookRepo.Search(r.Context(), filter, limit, (page-1)*limit, sort, order)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error searching books of author", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if books == nil {
+		books = []models.Book{}
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, PaginatedBooksResponse{
+		Metadata: map[string]interface{}{
+			"current_page":  page,
+			"page_size":     limit,
+			"total_records": totalRecords,
+			"total_pages":   int(math.Ceil(float64(totalRecords) / float64(limit))),
+		},
+		Data: books,
+	})
+}
+
+// @Summary      Update an author
+// @Description  Replaces the name and bio of an author. Requires the authors:write permission.
+// @Tags         Authors
+// @Accept       json
+// @Produce      json
+// @Param        id      path      int            true  "Author ID"
+// @Param        author  body      models.Author  true  "Author details. Note: the 'id' field is ignored."
+// @Success      200     {object}  models.Author
+// @Failure      400     {object}  web.Problem
+// @Failure      401     {object}  web.Problem
+// @Failure      403     {object}  web.Problem
+// @Failure      404     {object}  web.Problem
+// @Failure      500     {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /authors/{id} [put]
+func (e *Env) UpdateAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		return
+	}
+
+	var author models.Author
+	if err := json.NewDecoder(r.Body).Decode(&author); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := validate.Struct(author); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	e.saveAuthor(w, r, id, author)
+}
+
+// @Summary      Partially update an author
+// @Description  Changes the fields of an author given in the body, keeping the others. Requires the authors:write permission.
+// @Tags         Authors
+// @Accept       json
+// @Produce      json
+// @Param        id     path      int          true  "Author ID"
+// @Param        patch  body      AuthorPatch  true  "Fields to change"
+// @Success      200    {object}  models.Author
+// @Failure      400    {object}  web.Problem
+// @Failure      401    {object}  web.Problem
+// @Failure      403    {object}  web.Problem
+// @Failure      404    {object}  web.Problem
+// @Failure      500    {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /authors/{id} [patch]
+func (e *Env) PatchAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		return
+	}
+
+	var patch AuthorPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := validate.Struct(patch); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	author, err := e.AuthorRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Author not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting author by ID", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+	if patch.Name != nil {
+		author.Name = *patch.Name
+	}
+	if patch.Bio != nil {
+		author.Bio = *patch.Bio
+	}
+
+	e.saveAuthor(w, r, id, *author)
+}
+
+// saveAuthor stores the validated details of the author and responds with the result.
+func (e *Env) saveAuthor(w http.ResponseWriter, r *http.Request, id int64, author models.Author) {
+	if err := e.AuthorRepo.Update(r.Context(), id, author); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Author not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error updating author", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to update author")
+		}
+		return
+	}
+
+	updatedAuthor, err := e.AuthorRepo.GetByID(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error fetching updated author", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, updatedAuthor)
+}
+
+// @Summary      Delete an author
+// @Description  Deletes an author. An author with books is only deleted with 'cascade=true', which deletes their books along with the books' copies and holds; otherwise the request fails with 409 author_has_books. The cascade fails with 409 book_has_loans if any of the books' copies has ever been lent out. Requires the authors:write permission.
+// @Tags         Authors
+// @Accept       json
+// @Produce      json
+// @Param        id       path   int   true   "Author ID"
+// @Param        cascade  query  bool  false  "Also delete the author's books"
+// @Success      204      "No Content"
+// @Failure      400      {object}  web.Problem
+// @Failure      401      {object}  web.Problem
+// @Failure      403      {object}  web.Problem
+// @Failure      404      {object}  web.Problem
+// @Failure      409      {object}  web.Problem
+// @Failure      500      {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /authors/{id} [delete]
+func (e *Env) DeleteAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		return
+	}
+	cascade, _ := strconv.ParseBool(r.URL.Query().Get("cascade"))
+
+	if _, err := e.AuthorRepo.Delete(r.Context(), id, cascade); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Author not found")
+		} else if errors.Is(err, repository.ErrAuthorHasBooks) {
+			respondWithRepoError(w, r, err, "Author has books; merge them into another author, or delete them with cascade=true")
+		} else if errors.Is(err, repository.ErrBookHasLoans) {
+			respondWithRepoError(w, r, err, "Author's books have loan history and cannot be deleted; merge the author into another instead")
+		} else if errors.Is(err, repository.ErrForeignKey) {
+			respondWithRepoError(w, r, err, "Author's books have loan history and cannot be deleted")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error deleting author", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to delete author")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Merge duplicate authors (Admin)
+// @Description  Moves the books of the listed authors to this author and deletes the listed authors, for authors entered more than once. Every moved book and deleted author is recorded in the audit log. Requires the authors:merge permission.
+// @Tags         Authors
+// @Accept       json
+// @Produce      json
+// @Param        id     path      int                  true  "ID of the author to keep"
+// @Param        merge  body      MergeAuthorsRequest  true  "Duplicate authors to merge into this one"
+// @Success      200    {object}  MergeAuthorsResponse
+// @Failure      400    {object}  web.Problem
+// @Failure      401    {object}  web.Problem
+// @Failure      403    {object}  web.Problem
+// @Failure      404    {object}  web.Problem
+// @Failure      500    {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /authors/{id}/merge [post]
+func (e *Env) MergeAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		return
+	}
+
+	var req MergeAuthorsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+	for _, authorID := range req.AuthorIDs {
+		if authorID == id {
+			web.RespondWithValidationErrors(w, map[string]string{"author_ids": "An author cannot be merged into itself."})
+			return
+		}
+	}
+
+	moved, err := e.AuthorRepo.Merge(r.Context(), id, req.AuthorIDs)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Author not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error merging authors", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to merge authors")
+		}
+		return
+	}
+
+	author, err := e.AuthorRepo.GetByID(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error fetching merged author", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if moved == nil {
+		moved = []int64{}
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, MergeAuthorsResponse{Author: author, MovedBookIDs: moved})
+}
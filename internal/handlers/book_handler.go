@@ -5,12 +5,19 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Lec7ral/fullAPI/internal/auth"
+	"github.com/Lec7ral/fullAPI/internal/cache"
+	"github.com/Lec7ral/fullAPI/internal/health"
+	"github.com/Lec7ral/fullAPI/internal/metrics"
 	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/ratelimit"
 	"github.com/Lec7ral/fullAPI/internal/repository"
 	"github.com/Lec7ral/fullAPI/internal/web"
 	"github.com/gorilla/mux"
@@ -22,7 +29,51 @@ type Env struct {
 	UserRepo   repository.UserRepository
 	AuthorRepo repository.AuthorRepository
 	LoanRepo   repository.LoanRepository
-	JWTSecret  string
+	CopyRepo   repository.CopyRepository
+	HoldRepo   repository.HoldRepository
+	// RoleRepo holds the roles users are assigned and the permissions each one grants.
+	RoleRepo repository.RoleRepository
+	// AccountRepo holds each patron's ledger of fines, payments and waivers.
+	AccountRepo    repository.AccountRepository
+	FinePolicyRepo repository.FinePolicyRepository
+	// LoanPolicyRepo holds how many items patrons of each role may borrow at once.
+	LoanPolicyRepo repository.LoanPolicyRepository
+	// RefreshTokenRepo stores the hashed refresh tokens of every login session.
+	RefreshTokenRepo repository.RefreshTokenRepository
+	// Tokens issues and verifies access tokens; RevokedTokens lists the ones logged out early.
+	Tokens        *auth.TokenManager
+	RevokedTokens auth.RevocationList
+	// LoginAttemptRepo records failed logins; Lockout locks accounts after too many in a row.
+	LoginAttemptRepo repository.LoginAttemptRepository
+	Lockout          ratelimit.Lockout
+	// PasswordPolicy decides which passwords users may choose.
+	PasswordPolicy *auth.PasswordPolicy
+	// PasswordResetRepo stores the hashed reset tokens librarians issue, which are valid
+	// for PasswordResetTTL.
+	PasswordResetRepo repository.PasswordResetRepository
+	PasswordResetTTL  time.Duration
+	// TwoFactorRepo stores each user's TOTP authenticator and hashed recovery codes.
+	// TwoFactorIssuer names the service in authenticator apps, and users whose role is in
+	// TwoFactorRequiredRoles must enable two-factor authentication.
+	TwoFactorRepo          repository.TwoFactorRepository
+	TwoFactorIssuer        string
+	TwoFactorRequiredRoles []string
+	// AuditRepo reads the audit log the repositories append to.
+	AuditRepo repository.AuditRepository
+	// LoanPeriodDays is how long a loan runs, and how far each renewal extends it.
+	LoanPeriodDays int
+	// MaxRenewals is how many times a single loan may be renewed.
+	MaxRenewals int
+	// HoldPickupDays is how long a copy set aside for a hold waits to be picked up.
+	HoldPickupDays int
+	// FineBlockThresholdCents is the balance above which a patron cannot borrow.
+	FineBlockThresholdCents int64
+	// CacheStats counts the hits and misses of the cached repositories.
+	CacheStats *cache.Stats
+	// Metrics counts circulation and account events for /metrics.
+	Metrics *metrics.Metrics
+	// Health checks the dependencies reported by /readyz.
+	Health *health.Checker
 }
 
 // PaginatedBooksResponse is the structure for paginated book list responses.
@@ -33,9 +84,11 @@ type PaginatedBooksResponse struct {
 
 // @Summary      List books
 // @Description  Get a paginated, filtered, and sorted list of books.
+// @Description  With `q`, books are full-text searched by title, ISBN and author name and bio, ranked by relevance, and each result includes a highlighted `snippet`.
 // @Tags         Books
 // @Accept       json
 // @Produce      json
+// @Param        q        query     string  false  "Free-form full-text search (e.g. 'dune herbert'). Results are ranked by relevance unless 'sort' is given"
 // @Param        title    query     string  false  "Filter by book title (case-insensitive, partial match)"
 // @Param        author   query     string  false  "Filter by author name (case-insensitive, partial match)"
 // @Param        sort     query     string  false  "Field to sort by. Allowed values: title, author, published_date, stock"
@@ -43,7 +96,7 @@ type PaginatedBooksResponse struct {
 // @Param        page     query     int     false  "Page number for pagination"
 // @Param        limit    query     int     false  "Number of items per page"
 // @Success      200      {object}  PaginatedBooksResponse
-// @Failure      500      {object}  map[string]string
+// @Failure      500      {object}  web.Problem
 // @Router       /books [get]
 func (e *Env) GetBooksHandler(w http.ResponseWriter, r *http.Request) {
 	limitStr := r.URL.Query().Get("limit")
@@ -64,12 +117,15 @@ func (e *Env) GetBooksHandler(w http.ResponseWriter, r *http.Request) {
 	if author := r.URL.Query().Get("author"); author != "" {
 		filter.Author = &author
 	}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		filter.Query = &q
+	}
 	sort := r.URL.Query().Get("sort")
 	order := r.URL.Query().Get("order")
 
-	books, totalRecords, err := e.BookRepo.Search(filter, limit, offset, sort, order)
+	books, totalRecords, err := e.BookRepo.Search(r.Context(), filter, limit, offset, sort, order)
 	if err != nil {
-		log.Printf("Handler error searching books: %v", err)
+		slog.ErrorContext(r.Context(), "Handler error searching books", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
@@ -94,52 +150,60 @@ func (e *Env) GetBooksHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary      Create a new book
-// @Description  Adds a new book to the collection. Requires librarian role.
+// @Description  Adds a new book to the collection. Requires the books:write permission.
+// @Description  'stock' available copies are created along with the book, with generated barcodes.
 // @Tags         Books
 // @Accept       json
 // @Produce      json
 // @Param        book  body      models.Book  true  "Book object to be created. Note: 'id' and 'author' fields are ignored."
 // @Success      201   {object}  models.Book
-// @Failure      400   {object}  map[string]string
-// @Failure      401   {object}  map[string]string
-// @Failure      403   {object}  map[string]string
-// @Failure      500   {object}  map[string]string
+// @Failure      400   {object}  web.Problem
+// @Failure      401   {object}  web.Problem
+// @Failure      403   {object}  web.Problem
+// @Failure      409   {object}  web.Problem
+// @Failure      500   {object}  web.Problem
 // @Security     BearerAuth
 // @Router       /books [post]
 func (e *Env) CreateBookHandler(w http.ResponseWriter, r *http.Request) {
 	var newBook models.Book
 	if err := json.NewDecoder(r.Body).Decode(&newBook); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	if err := validate.Struct(newBook); err != nil {
 		errors := validationErrors(err)
-		web.RespondWithJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errors})
+		web.RespondWithValidationErrors(w, errors)
 		return
 	}
 
-	_, err := e.AuthorRepo.GetByID(newBook.AuthorID)
+	_, err := e.AuthorRepo.GetByID(r.Context(), newBook.AuthorID)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			web.RespondWithError(w, http.StatusBadRequest, "Author with the specified ID does not exist")
+			web.RespondWithValidationErrors(w, map[string]string{"author_id": "Author with the specified ID does not exist."})
 		} else {
-			log.Printf("Handler error checking author existence: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error checking author existence", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		}
 		return
 	}
 
-	id, err := e.BookRepo.Create(newBook)
+	id, err := e.BookRepo.Create(r.Context(), newBook)
 	if err != nil {
-		log.Printf("Handler error creating book: %v", err)
-		web.RespondWithError(w, http.StatusInternalServerError, "Failed to create book")
+		if errors.Is(err, repository.ErrConflict) {
+			respondWithRepoError(w, r, err, "A book with this ISBN already exists")
+		} else if errors.Is(err, repository.ErrForeignKey) {
+			respondWithRepoError(w, r, err, "Author with the specified ID does not exist")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error creating book", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to create book")
+		}
 		return
 	}
 
-	createdBook, err := e.BookRepo.GetByID(id)
+	createdBook, err := e.BookRepo.GetByID(r.Context(), id)
 	if err != nil {
-		log.Printf("Handler error fetching created book: %v", err)
+		slog.ErrorContext(r.Context(), "Handler error fetching created book", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
@@ -154,19 +218,19 @@ func (e *Env) CreateBookHandler(w http.ResponseWriter, r *http.Request) {
 // @Produce      json
 // @Param        id   path      int  true  "Book ID"
 // @Success      200  {object}  models.Book
-// @Failure      404  {object}  map[string]string
-// @Failure      500  {object}  map[string]string
+// @Failure      404  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
 // @Router       /books/{id} [get]
 func (e *Env) GetBookHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := strconv.ParseInt(vars["id"], 10, 64)
 
-	book, err := e.BookRepo.GetByID(id)
+	book, err := e.BookRepo.GetByID(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			web.RespondWithError(w, http.StatusNotFound, "Book not found")
+			respondWithRepoError(w, r, err, "Book not found")
 		} else {
-			log.Printf("Handler error getting book by ID: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error getting book by ID", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		}
 		return
@@ -176,18 +240,20 @@ func (e *Env) GetBookHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary      Update a book
-// @Description  Updates the details of an existing book. Requires librarian role.
+// @Description  Updates the details of an existing book. Requires the books:write permission.
+// @Description  'stock' is ignored: availability is derived from the book's copies, managed through /books/{id}/copies.
 // @Tags         Books
 // @Accept       json
 // @Produce      json
 // @Param        id    path      int          true  "Book ID"
 // @Param        book  body      models.Book  true  "Book object with updated details"
 // @Success      200   {object}  models.Book
-// @Failure      400   {object}  map[string]string
-// @Failure      401   {object}  map[string]string
-// @Failure      403   {object}  map[string]string
-// @Failure      404   {object}  map[string]string
-// @Failure      500   {object}  map[string]string
+// @Failure      400   {object}  web.Problem
+// @Failure      401   {object}  web.Problem
+// @Failure      403   {object}  web.Problem
+// @Failure      404   {object}  web.Problem
+// @Failure      409   {object}  web.Problem
+// @Failure      500   {object}  web.Problem
 // @Security     BearerAuth
 // @Router       /books/{id} [put]
 func (e *Env) UpdateBookHandler(w http.ResponseWriter, r *http.Request) {
@@ -196,41 +262,45 @@ func (e *Env) UpdateBookHandler(w http.ResponseWriter, r *http.Request) {
 
 	var updatedBook models.Book
 	if err := json.NewDecoder(r.Body).Decode(&updatedBook); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	if err := validate.Struct(updatedBook); err != nil {
 		errors := validationErrors(err)
-		web.RespondWithJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errors})
+		web.RespondWithValidationErrors(w, errors)
 		return
 	}
 
-	_, err := e.AuthorRepo.GetByID(updatedBook.AuthorID)
+	_, err := e.AuthorRepo.GetByID(r.Context(), updatedBook.AuthorID)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			web.RespondWithError(w, http.StatusBadRequest, "Author with the specified ID does not exist")
+			web.RespondWithValidationErrors(w, map[string]string{"author_id": "Author with the specified ID does not exist."})
 		} else {
-			log.Printf("Handler error checking author existence: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error checking author existence", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		}
 		return
 	}
 
-	err = e.BookRepo.Update(id, updatedBook)
+	err = e.BookRepo.Update(r.Context(), id, updatedBook)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			web.RespondWithError(w, http.StatusNotFound, "Book not found")
+			respondWithRepoError(w, r, err, "Book not found")
+		} else if errors.Is(err, repository.ErrConflict) {
+			respondWithRepoError(w, r, err, "A book with this ISBN already exists")
+		} else if errors.Is(err, repository.ErrForeignKey) {
+			respondWithRepoError(w, r, err, "Author with the specified ID does not exist")
 		} else {
-			log.Printf("Handler error updating book: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error updating book", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Failed to update book")
 		}
 		return
 	}
 
-	finalBook, err := e.BookRepo.GetByID(id)
+	finalBook, err := e.BookRepo.GetByID(r.Context(), id)
 	if err != nil {
-		log.Printf("Handler error fetching updated book: %v", err)
+		slog.ErrorContext(r.Context(), "Handler error fetching updated book", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
@@ -239,28 +309,33 @@ func (e *Env) UpdateBookHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary      Delete a book
-// @Description  Deletes a book from the collection. Requires librarian role.
+// @Description  Deletes a book and its copies from the collection. A book whose copies have ever been lent out cannot be deleted (409 book_has_loans); withdraw its copies instead. Requires the books:write permission.
 // @Tags         Books
 // @Accept       json
 // @Produce      json
 // @Param        id   path      int  true  "Book ID"
 // @Success      204  {string}  string "No Content"
-// @Failure      401  {object}  map[string]string
-// @Failure      403  {object}  map[string]string
-// @Failure      404  {object}  map[string]string
-// @Failure      500  {object}  map[string]string
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      409  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
 // @Security     BearerAuth
 // @Router       /books/{id} [delete]
 func (e *Env) DeleteBookHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := strconv.ParseInt(vars["id"], 10, 64)
 
-	err := e.BookRepo.Delete(id)
+	err := e.BookRepo.Delete(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			web.RespondWithError(w, http.StatusNotFound, "Book not found")
+			respondWithRepoError(w, r, err, "Book not found")
+		} else if errors.Is(err, repository.ErrBookHasLoans) {
+			respondWithRepoError(w, r, err, "Book has loan history; withdraw its copies instead")
+		} else if errors.Is(err, repository.ErrForeignKey) {
+			respondWithRepoError(w, r, err, "Book is still referenced and cannot be deleted")
 		} else {
-			log.Printf("Handler error deleting book: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error deleting book", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Failed to delete book")
 		}
 		return
@@ -0,0 +1,33 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handler for cache statistics.
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Lec7ral/fullAPI/internal/cache"
+	"github.com/Lec7ral/fullAPI/internal/web"
+)
+
+// CacheStatsResponse reports which cache backend is in use and how each cached repository is doing.
+type CacheStatsResponse struct {
+	Backend string         `json:"backend"`
+	Caches  []cache.Counts `json:"caches"`
+}
+
+// @Summary      Get cache statistics
+// @Description  Reports the cache backend ("redis", "memory" or "none") and the hits, misses and errors of each cached repository since startup.
+// @Description  Errors are lookups the cache failed to answer, which were served from the database instead. Requires the system:read permission.
+// @Tags         System
+// @Produce      json
+// @Success      200  {object}  CacheStatsResponse
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /cache/stats [get]
+func (e *Env) GetCacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	web.RespondWithJSON(w, http.StatusOK, CacheStatsResponse{
+		Backend: e.CacheStats.Backend(),
+		Caches:  e.CacheStats.Snapshot(),
+	})
+}
@@ -0,0 +1,293 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handlers librarians use at the circulation desk.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/gorilla/mux"
+)
+
+// CheckoutRequest names the patron whose card was scanned and the copy being lent,
+// either by its barcode or, when it has none to hand, by its book.
+type CheckoutRequest struct {
+	UserID  int64  `json:"user_id" validate:"required"`
+	Barcode string `json:"barcode" validate:"required_without=BookID"`
+	BookID  int64  `json:"book_id" validate:"required_without=Barcode"`
+}
+
+// CheckinRequest names the copy being returned, by its barcode or by its book. When
+// several copies of the book are on loan, the patron returning it tells them apart.
+type CheckinRequest struct {
+	Barcode string `json:"barcode" validate:"required_without=BookID"`
+	BookID  int64  `json:"book_id" validate:"required_without=Barcode"`
+	UserID  int64  `json:"user_id"`
+}
+
+// PatronSummary is what the circulation desk sees after scanning a patron's card.
+type PatronSummary struct {
+	User         *models.User  `json:"user"`
+	Loans        []models.Loan `json:"loans"`
+	Holds        []models.Hold `json:"holds"`
+	BalanceCents int64         `json:"balance_cents"`
+	// Blocks are the circulation rules that keep the patron from borrowing any book.
+	Blocks []models.PolicyViolation `json:"blocks"`
+}
+
+// @Summary      Check out a copy to a patron
+// @Description  Lends a copy to the given patron, as when their card and the copy are scanned at the circulation desk. Requires the circulation:desk permission.
+// @Description  Give the copy's 'barcode', or a 'book_id' to lend its first available copy. A copy set aside for a hold can only be lent to the patron who placed it, which fulfils the hold.
+// @Description  Loans that break a circulation rule are refused with 403 and the list of 'violations'.
+// @Tags         Circulation
+// @Accept       json
+// @Produce      json
+// @Param        checkout  body      CheckoutRequest  true  "Patron and copy"
+// @Success      201       {object}  models.Loan
+// @Failure      400       {object}  web.Problem
+// @Failure      401       {object}  web.Problem
+// @Failure      403       {object}  web.Problem
+// @Failure      404       {object}  web.Problem
+// @Failure      409       {object}  web.Problem
+// @Failure      500       {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /circulation/checkout [post]
+func (e *Env) CheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	var req CheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	if _, err := e.UserRepo.GetByID(r.Context(), req.UserID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Patron not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting patron", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to process checkout")
+		}
+		return
+	}
+
+	dueDate := time.Now().AddDate(0, 0, e.LoanPeriodDays)
+	var loanID int64
+	var err error
+	if req.Barcode != "" {
+		loanID, err = e.LoanRepo.CheckoutCopy(r.Context(), req.Barcode, req.UserID, dueDate, e.FineBlockThresholdCents)
+	} else {
+		loanID, err = e.LoanRepo.CreateLoan(r.Context(), req.BookID, req.UserID, dueDate, e.FineBlockThresholdCents)
+	}
+	if err != nil {
+		var policyErr *repository.PolicyError
+		if errors.As(err, &policyErr) {
+			respondWithRepoError(w, r, err, "This loan is not allowed by the circulation rules.")
+		} else if errors.Is(err, repository.ErrStockExhausted) {
+			respondWithRepoError(w, r, err, "No copy of this book is available")
+		} else if errors.Is(err, repository.ErrNotFound) && req.Barcode != "" {
+			respondWithRepoError(w, r, err, "Copy not found")
+		} else if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Book not found")
+		} else if errors.Is(err, repository.ErrCopyOnLoan) {
+			respondWithRepoError(w, r, err, "This copy is already on loan and must be checked in first")
+		} else if errors.Is(err, repository.ErrCopyOnHold) {
+			respondWithRepoError(w, r, err, "This copy is set aside for another patron's hold")
+		} else if errors.Is(err, repository.ErrCopyNotLendable) {
+			respondWithRepoError(w, r, err, "This copy is not available for loan")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error checking out copy", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to process checkout")
+		}
+		return
+	}
+	e.Metrics.LoansCreated.Inc()
+
+	loan, err := e.LoanRepo.GetLoanByID(r.Context(), loanID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error fetching created loan", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	loan.MarkOverdue(time.Now())
+
+	web.RespondWithJSON(w, http.StatusCreated, loan)
+}
+
+// @Summary      Check in a copy
+// @Description  Returns the active loan of a copy handed in at the circulation desk, charging any overdue fine. Requires the circulation:desk permission.
+// @Description  Give the copy's 'barcode', or a 'book_id' when only one copy of the book is on loan, or to the given 'user_id'.
+// @Description  The response's 'copy_status' is 'on_hold' when the copy has been set aside for the next hold on its book.
+// @Tags         Circulation
+// @Accept       json
+// @Produce      json
+// @Param        checkin  body      CheckinRequest  true  "Copy being returned"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  web.Problem
+// @Failure      401      {object}  web.Problem
+// @Failure      403      {object}  web.Problem
+// @Failure      404      {object}  web.Problem
+// @Failure      409      {object}  web.Problem
+// @Failure      500      {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /circulation/checkin [post]
+func (e *Env) CheckinHandler(w http.ResponseWriter, r *http.Request) {
+	var req CheckinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	status := "active"
+	filter := repository.LoanFilter{Status: &status}
+	if req.Barcode != "" {
+		filter.Barcode = &req.Barcode
+	}
+	if req.BookID != 0 {
+		filter.BookID = &req.BookID
+	}
+	if req.UserID != 0 {
+		filter.UserID = &req.UserID
+	}
+	loans, err := e.LoanRepo.SearchLoans(r.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error searching loans", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to process check-in")
+		return
+	}
+	if len(loans) == 0 {
+		web.RespondWithError(w, http.StatusNotFound, "No active loan found for this copy")
+		return
+	}
+	if len(loans) > 1 {
+		web.RespondWithError(w, http.StatusConflict, fmt.Sprintf("%d copies of this book are on loan; scan the copy's barcode or give the patron", len(loans)))
+		return
+	}
+	loan := loans[0]
+
+	fine, err := e.LoanRepo.ReturnLoan(r.Context(), loan.ID, e.holdPickupDeadline())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "No active loan found for this copy")
+		} else if errors.Is(err, repository.ErrAlreadyReturned) {
+			respondWithRepoError(w, r, err, "Book has already been returned")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error returning loan", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to process check-in")
+		}
+		return
+	}
+	e.Metrics.LoansReturned.Inc()
+
+	response := map[string]interface{}{
+		"message":    "Book checked in successfully.",
+		"loan_id":    loan.ID,
+		"user_id":    loan.UserID,
+		"fine_cents": fine,
+	}
+	// The return is done; the copy's new status only tells the desk where to shelve it.
+	if bookCopy, err := e.CopyRepo.GetByID(r.Context(), loan.CopyID); err == nil {
+		response["copy_status"] = bookCopy.Status
+	} else {
+		slog.ErrorContext(r.Context(), "Handler error getting returned copy", "error", err)
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// @Summary      Look up a patron
+// @Description  Retrieves a patron's active loans, flagging the overdue ones, their waiting and ready holds, their balance in cents, and the circulation rules blocking them from borrowing. Requires the circulation:desk permission.
+// @Tags         Circulation
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  PatronSummary
+// @Failure      400  {object}  web.Problem
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /patrons/{id} [get]
+func (e *Env) GetPatronHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := e.UserRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Patron not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting patron", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve patron")
+		}
+		return
+	}
+	summary := PatronSummary{User: user, Loans: []models.Loan{}, Holds: []models.Hold{}, Blocks: []models.PolicyViolation{}}
+
+	loans, err := e.LoanRepo.GetActiveLoansByUserID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error getting patron loans", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve patron")
+		return
+	}
+	now := time.Now()
+	for i := range loans {
+		loans[i].MarkOverdue(now)
+	}
+	if loans != nil {
+		summary.Loans = loans
+	}
+
+	holds, err := e.HoldRepo.GetActiveHoldsByUserID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error getting patron holds", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve patron")
+		return
+	}
+	if holds != nil {
+		summary.Holds = holds
+	}
+
+	account, err := e.AccountRepo.GetAccount(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error getting patron account", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve patron")
+		return
+	}
+	summary.BalanceCents = account.BalanceCents
+
+	blocks, err := e.LoanRepo.CheckEligibility(r.Context(), userID, e.FineBlockThresholdCents)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error checking patron eligibility", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve patron")
+		return
+	}
+	if blocks != nil {
+		summary.Blocks = blocks
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, summary)
+}
@@ -0,0 +1,254 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handlers for managing the physical copies of books.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/gorilla/mux"
+)
+
+// respondWithCopyError maps the errors shared by the copy write handlers to responses.
+func respondWithCopyError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		respondWithRepoError(w, r, err, "Copy not found")
+	case errors.Is(err, repository.ErrBarcodeExists):
+		respondWithRepoError(w, r, err, "A copy with this barcode already exists")
+	case errors.Is(err, repository.ErrCopyOnLoan):
+		respondWithRepoError(w, r, err, "A copy's on-loan status only changes when it is checked out or returned")
+	case errors.Is(err, repository.ErrCopyOnHold):
+		respondWithRepoError(w, r, err, "A copy's on-hold status only changes when it is set aside for a hold or picked up")
+	case errors.Is(err, repository.ErrCopyHasLoans):
+		respondWithRepoError(w, r, err, "Copy has loan history; set its status to 'withdrawn' instead")
+	default:
+		slog.ErrorContext(r.Context(), "Handler error "+action+" copy", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to "+action+" copy")
+	}
+}
+
+// @Summary      List the copies of a book
+// @Description  Retrieves every physical copy of a book with its barcode, condition and status. Requires the copies:manage permission.
+// @Tags         Copies
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Book ID"
+// @Success      200  {array}   models.Copy
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /books/{id}/copies [get]
+func (e *Env) GetBookCopiesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookID, _ := strconv.ParseInt(vars["id"], 10, 64)
+
+	if _, err := e.BookRepo.GetByID(r.Context(), bookID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Book not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error checking book existence", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	copies, err := e.CopyRepo.ListByBook(r.Context(), bookID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error listing copies", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, copies)
+}
+
+// @Summary      Add a copy of a book
+// @Description  Adds a physical copy to a book. Requires the copies:manage permission.
+// @Description  'condition' defaults to 'good', 'status' to 'available' and 'acquisition_date' to today. Copies cannot be created on loan or on hold.
+// @Description  A new available copy is set aside for the first waiting hold on the book, if any, and so comes back 'on_hold'.
+// @Tags         Copies
+// @Accept       json
+// @Produce      json
+// @Param        id    path      int          true  "Book ID"
+// @Param        copy  body      models.Copy  true  "Copy to add. Note: 'id' and 'book_id' fields are ignored."
+// @Success      201   {object}  models.Copy
+// @Failure      400   {object}  web.Problem
+// @Failure      401   {object}  web.Problem
+// @Failure      403   {object}  web.Problem
+// @Failure      404   {object}  web.Problem
+// @Failure      409   {object}  web.Problem
+// @Failure      500   {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /books/{id}/copies [post]
+func (e *Env) CreateCopyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookID, _ := strconv.ParseInt(vars["id"], 10, 64)
+
+	var newCopy models.Copy
+	if err := json.NewDecoder(r.Body).Decode(&newCopy); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	newCopy.BookID = bookID
+	if newCopy.Condition == "" {
+		newCopy.Condition = "good"
+	}
+	if newCopy.Status == "" {
+		newCopy.Status = models.CopyStatusAvailable
+	}
+	if newCopy.AcquisitionDate == "" {
+		newCopy.AcquisitionDate = time.Now().Format("2006-01-02")
+	}
+
+	if err := validate.Struct(newCopy); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+	if newCopy.Status == models.CopyStatusOnLoan || newCopy.Status == models.CopyStatusOnHold {
+		web.RespondWithValidationErrors(w, map[string]string{"status": "A new copy cannot be on loan or on hold."})
+		return
+	}
+
+	if _, err := e.BookRepo.GetByID(r.Context(), bookID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Book not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error checking book existence", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	id, err := e.CopyRepo.Create(r.Context(), newCopy, e.holdPickupDeadline())
+	if err != nil {
+		respondWithCopyError(w, r, err, "create")
+		return
+	}
+
+	createdCopy, err := e.CopyRepo.GetByID(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error fetching created copy", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusCreated, createdCopy)
+}
+
+// @Summary      Get a copy by ID
+// @Description  Retrieves a single physical copy. Requires the copies:manage permission.
+// @Tags         Copies
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Copy ID"
+// @Success      200  {object}  models.Copy
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /copies/{id} [get]
+func (e *Env) GetCopyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 10, 64)
+
+	bookCopy, err := e.CopyRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Copy not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting copy by ID", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, bookCopy)
+}
+
+// @Summary      Update a copy
+// @Description  Updates a copy's barcode, condition, status and acquisition date. Requires the copies:manage permission.
+// @Description  A copy moves into or out of 'on_loan' only when it is checked out or returned, and into or out of 'on_hold' only through holds.
+// @Description  A copy put back to 'available' is set aside for the first waiting hold on its book, if any, and so comes back 'on_hold'.
+// @Tags         Copies
+// @Accept       json
+// @Produce      json
+// @Param        id    path      int          true  "Copy ID"
+// @Param        copy  body      models.Copy  true  "Copy with updated details. Note: 'id' and 'book_id' fields are ignored."
+// @Success      200   {object}  models.Copy
+// @Failure      400   {object}  web.Problem
+// @Failure      401   {object}  web.Problem
+// @Failure      403   {object}  web.Problem
+// @Failure      404   {object}  web.Problem
+// @Failure      409   {object}  web.Problem
+// @Failure      500   {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /copies/{id} [put]
+func (e *Env) UpdateCopyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 10, 64)
+
+	var updatedCopy models.Copy
+	if err := json.NewDecoder(r.Body).Decode(&updatedCopy); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := validate.Struct(updatedCopy); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	if err := e.CopyRepo.Update(r.Context(), id, updatedCopy, e.holdPickupDeadline()); err != nil {
+		respondWithCopyError(w, r, err, "update")
+		return
+	}
+
+	finalCopy, err := e.CopyRepo.GetByID(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error fetching updated copy", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, finalCopy)
+}
+
+// @Summary      Delete a copy
+// @Description  Deletes a copy that has never been lent out. Requires the copies:manage permission.
+// @Description  Copies with loan history are kept; set their status to 'withdrawn' instead.
+// @Tags         Copies
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Copy ID"
+// @Success      204  {string}  string "No Content"
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      409  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /copies/{id} [delete]
+func (e *Env) DeleteCopyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 10, 64)
+
+	if err := e.CopyRepo.Delete(r.Context(), id); err != nil {
+		respondWithCopyError(w, r, err, "delete")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
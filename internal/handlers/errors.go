@@ -0,0 +1,105 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file maps the errors of the repository layer to problem responses.
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+)
+
+// Stable codes of the problems the repository errors are reported as, and of failed sign-ins.
+const (
+	CodeNotFound           = "not_found"
+	CodeStockExhausted     = "stock_exhausted"
+	CodeAlreadyReturned    = "already_returned"
+	CodeLoanPolicy         = "loan_policy_violation"
+	CodeLoanNotOwned       = "loan_not_owned"
+	CodeRenewalLimit       = "renewal_limit_reached"
+	CodeLoanOverdue        = "loan_overdue"
+	CodeHoldsWaiting       = "holds_waiting"
+	CodeCopyOnLoan         = "copy_on_loan"
+	CodeCopyOnHold         = "copy_on_hold"
+	CodeCopyHasLoans       = "copy_has_loans"
+	CodeBookHasLoans       = "book_has_loans"
+	CodeCopyNotLendable    = "copy_not_lendable"
+	CodeCopyAvailable      = "copy_available"
+	CodeHoldExists         = "hold_exists"
+	CodeHoldNotActive      = "hold_not_active"
+	CodeExceedsBalance     = "exceeds_balance"
+	CodeUsernameExists     = "username_exists"
+	CodeBarcodeExists      = "barcode_exists"
+	CodeRoleExists         = "role_exists"
+	CodeRoleInUse          = "role_in_use"
+	CodeAuthorHasBooks     = "author_has_books"
+	CodeTokenExpired       = "token_expired"
+	CodeTokenUsed          = "token_used"
+	CodeTwoFactorEnabled   = "two_factor_enabled"
+	CodeConflict           = "conflict"
+	CodeForeignKey         = "foreign_key_violation"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeAccountLocked      = "account_locked"
+)
+
+// repositoryErrors lists the status and code each repository error is reported with. The
+// first entry the error matches wins, so specific errors come before the generic ones they
+// may wrap.
+var repositoryErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{repository.ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{repository.ErrStockExhausted, http.StatusConflict, CodeStockExhausted},
+	{repository.ErrAlreadyReturned, http.StatusConflict, CodeAlreadyReturned},
+	{repository.ErrLoanNotOwned, http.StatusBadRequest, CodeLoanNotOwned},
+	{repository.ErrRenewalLimit, http.StatusConflict, CodeRenewalLimit},
+	{repository.ErrLoanOverdue, http.StatusConflict, CodeLoanOverdue},
+	{repository.ErrHoldsWaiting, http.StatusConflict, CodeHoldsWaiting},
+	{repository.ErrCopyOnLoan, http.StatusConflict, CodeCopyOnLoan},
+	{repository.ErrCopyOnHold, http.StatusConflict, CodeCopyOnHold},
+	{repository.ErrCopyHasLoans, http.StatusConflict, CodeCopyHasLoans},
+	{repository.ErrBookHasLoans, http.StatusConflict, CodeBookHasLoans},
+	{repository.ErrCopyNotLendable, http.StatusConflict, CodeCopyNotLendable},
+	{repository.ErrCopyAvailable, http.StatusConflict, CodeCopyAvailable},
+	{repository.ErrHoldExists, http.StatusConflict, CodeHoldExists},
+	{repository.ErrHoldNotActive, http.StatusConflict, CodeHoldNotActive},
+	{repository.ErrExceedsBalance, http.StatusConflict, CodeExceedsBalance},
+	{repository.ErrUsernameExists, http.StatusConflict, CodeUsernameExists},
+	{repository.ErrBarcodeExists, http.StatusConflict, CodeBarcodeExists},
+	{repository.ErrRoleExists, http.StatusConflict, CodeRoleExists},
+	{repository.ErrRoleInUse, http.StatusConflict, CodeRoleInUse},
+	{repository.ErrAuthorHasBooks, http.StatusConflict, CodeAuthorHasBooks},
+	{repository.ErrTokenRevoked, http.StatusUnauthorized, web.CodeTokenRevoked},
+	{repository.ErrTokenExpired, http.StatusUnauthorized, CodeTokenExpired},
+	{repository.ErrTokenUsed, http.StatusUnauthorized, CodeTokenUsed},
+	{repository.ErrTwoFactorEnabled, http.StatusConflict, CodeTwoFactorEnabled},
+	{repository.ErrConflict, http.StatusConflict, CodeConflict},
+	{repository.ErrForeignKey, http.StatusConflict, CodeForeignKey},
+}
+
+// respondWithRepoError reports a repository error as the problem it maps to, with detail
+// as the problem's detail. A *repository.PolicyError also lists the broken rules under
+// "violations". Errors that map to no problem are logged and reported as a 500.
+func respondWithRepoError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	var policyErr *repository.PolicyError
+	if errors.As(err, &policyErr) {
+		p := web.NewProblem(http.StatusForbidden, CodeLoanPolicy, detail)
+		p.Extensions = map[string]interface{}{"violations": policyErr.Violations}
+		web.RespondWithProblem(w, p)
+		return
+	}
+
+	for _, m := range repositoryErrors {
+		if errors.Is(err, m.err) {
+			web.RespondWithCode(w, m.status, m.code, detail)
+			return
+		}
+	}
+
+	slog.ErrorContext(r.Context(), "Handler error with no problem mapping", "error", err)
+	web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+}
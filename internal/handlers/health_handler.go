@@ -0,0 +1,37 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the liveness and readiness probes.
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Lec7ral/fullAPI/internal/web"
+)
+
+// @Summary      Liveness probe
+// @Description  Reports that the process is up and serving requests. It does not check dependencies, so a database outage does not get the process restarted.
+// @Tags         System
+// @Produce      json
+// @Success      200  {object}  map[string]string
+// @Router       /healthz [get]
+func (e *Env) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	web.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// @Summary      Readiness probe
+// @Description  Checks the database connection, Redis (when it is in use) and that every migration has been applied, reporting the status and latency of each.
+// @Description  Returns 503 when any check fails, and with status "draining" as soon as the server starts shutting down, so load balancers stop sending it traffic.
+// @Tags         System
+// @Produce      json
+// @Success      200  {object}  health.Report
+// @Failure      503  {object}  health.Report
+// @Router       /readyz [get]
+func (e *Env) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := e.Health.Check(r.Context())
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	web.RespondWithJSON(w, status, report)
+}
@@ -0,0 +1,171 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handlers for holds on books with no available copies.
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/gorilla/mux"
+)
+
+// holdPickupDeadline is when a copy set aside for a hold now stops waiting for pickup.
+func (e *Env) holdPickupDeadline() time.Time {
+	return time.Now().AddDate(0, 0, e.HoldPickupDays)
+}
+
+// @Summary      Place a hold on a book
+// @Description  Joins the queue for a book with no available copies. Holds are served first come, first served:
+// @Description  when a copy is returned it is set aside for the first hold, which becomes 'ready' until its pickup expiry.
+// @Description  Borrowing the book with `POST /loans` while the hold is ready checks out the copy set aside.
+// @Tags         Holds
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Book ID"
+// @Success      201  {object}  models.Hold
+// @Failure      400  {object}  web.Problem
+// @Failure      401  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      409  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /books/{id}/holds [post]
+func (e *Env) CreateHoldHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	vars := mux.Vars(r)
+	bookID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	id, err := e.HoldRepo.Create(r.Context(), bookID, user.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Book not found")
+		} else if errors.Is(err, repository.ErrCopyAvailable) {
+			respondWithRepoError(w, r, err, "This book has an available copy and can be borrowed now")
+		} else if errors.Is(err, repository.ErrHoldExists) {
+			respondWithRepoError(w, r, err, "You already have a hold on this book")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error creating hold", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to place hold")
+		}
+		return
+	}
+
+	hold, err := e.HoldRepo.GetByID(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error fetching created hold", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusCreated, hold)
+}
+
+// @Summary      List my holds
+// @Description  Retrieves the authenticated user's waiting and ready holds, oldest first.
+// @Description  Waiting holds include their 'position' in the book's queue; ready holds include the copy set aside and its pickup expiry.
+// @Tags         Holds
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   models.Hold
+// @Failure      401  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/me/holds [get]
+func (e *Env) GetMyHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	holds, err := e.HoldRepo.GetActiveHoldsByUserID(r.Context(), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error getting user holds", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve holds")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, holds)
+}
+
+// @Summary      Cancel a hold
+// @Description  Cancels a waiting or ready hold. A copy set aside for a ready hold passes to the next hold in the queue.
+// @Description  Members can cancel their own holds; the holds:manage permission allows cancelling any hold.
+// @Tags         Holds
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Hold ID"
+// @Success      204  "No Content"
+// @Failure      400  {object}  web.Problem
+// @Failure      401  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      409  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /holds/{id} [delete]
+func (e *Env) DeleteHoldHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid hold ID")
+		return
+	}
+
+	hold, err := e.HoldRepo.GetByID(r.Context(), id)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "Handler error getting hold by ID", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to cancel hold")
+		return
+	}
+	if hold != nil {
+		allowed, err := e.ownsOrCan(r.Context(), user, hold.UserID, models.PermHoldsManage)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Handler error checking permission", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to cancel hold")
+			return
+		}
+		if !allowed {
+			hold = nil
+		}
+	}
+	// Other members' holds are reported as missing rather than forbidden.
+	if hold == nil {
+		web.RespondWithError(w, http.StatusNotFound, "Hold not found")
+		return
+	}
+
+	if err := e.HoldRepo.Cancel(r.Context(), id, e.holdPickupDeadline()); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Hold not found")
+		} else if errors.Is(err, repository.ErrHoldNotActive) {
+			respondWithRepoError(w, r, err, "Hold has already been fulfilled, cancelled or expired")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error cancelling hold", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to cancel hold")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -5,9 +5,11 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/Lec7ral/fullAPI/internal/models"
 	"github.com/Lec7ral/fullAPI/internal/repository"
@@ -15,7 +17,7 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// ... (LoanRequest struct and CreateLoanHandler remain the same)
+// LoanRequest is the body of a request to borrow a book.
 type LoanRequest struct {
 	BookID int64 `json:"book_id" validate:"required"`
 }
@@ -30,34 +32,59 @@ func (e *Env) CreateLoanHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req LoanRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	if err := validate.Struct(req); err != nil {
 		errors := validationErrors(err)
-		web.RespondWithJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errors})
+		web.RespondWithValidationErrors(w, errors)
 		return
 	}
 
-	err := e.LoanRepo.CreateLoan(req.BookID, userID)
+	dueDate := time.Now().AddDate(0, 0, e.LoanPeriodDays)
+	loanID, err := e.LoanRepo.CreateLoan(r.Context(), req.BookID, userID, dueDate, e.FineBlockThresholdCents)
 	if err != nil {
-		if err.Error() == "no stock available" {
-			web.RespondWithError(w, http.StatusConflict, "No stock available for this book.")
+		var policyErr *repository.PolicyError
+		if errors.As(err, &policyErr) {
+			respondWithRepoError(w, r, err, "This loan is not allowed by the circulation rules.")
+		} else if errors.Is(err, repository.ErrStockExhausted) {
+			respondWithRepoError(w, r, err, "No stock available for this book. Place a hold to join the queue.")
 		} else if errors.Is(err, repository.ErrNotFound) {
-			web.RespondWithError(w, http.StatusNotFound, "Book not found.")
+			respondWithRepoError(w, r, err, "Book not found.")
 		} else {
-			log.Printf("Handler error creating loan: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error creating loan", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Failed to process loan.")
 		}
 		return
 	}
 
-	web.RespondWithJSON(w, http.StatusCreated, map[string]string{"message": "Book loaned successfully."})
+	e.Metrics.LoansCreated.Inc()
+	web.RespondWithJSON(w, http.StatusCreated, map[string]interface{}{"message": "Book loaned successfully.", "loan_id": loanID, "due_date": dueDate})
 }
 
-// ... (ReturnLoanHandler and GetMyLoansHandler remain the same)
+// @Summary      Return a loan
+// @Description  Returns an active loan, charging any overdue fine. The copy is set aside for the first hold on its book, if any.
+// @Description  Members can return their own loans; the loans:manage permission allows returning any loan.
+// @Tags         Loans
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Loan ID"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  web.Problem
+// @Failure      401  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      409  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /loans/{id} [delete]
 func (e *Env) ReturnLoanHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
 	vars := mux.Vars(r)
 	loanID, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
@@ -65,20 +92,44 @@ func (e *Env) ReturnLoanHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = e.LoanRepo.ReturnLoan(loanID)
+	loan, err := e.LoanRepo.GetLoanByID(r.Context(), loanID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "Handler error getting loan by ID", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to process return")
+		return
+	}
+	if loan != nil {
+		allowed, err := e.ownsOrCan(r.Context(), user, loan.UserID, models.PermLoansManage)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Handler error checking permission", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to process return")
+			return
+		}
+		if !allowed {
+			loan = nil
+		}
+	}
+	// Other members' loans are reported as missing rather than forbidden.
+	if loan == nil {
+		web.RespondWithError(w, http.StatusNotFound, "Loan not found")
+		return
+	}
+
+	fine, err := e.LoanRepo.ReturnLoan(r.Context(), loanID, e.holdPickupDeadline())
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			web.RespondWithError(w, http.StatusNotFound, "Loan not found")
-		} else if err.Error() == "book already returned" {
-			web.RespondWithError(w, http.StatusConflict, "Book has already been returned")
+			respondWithRepoError(w, r, err, "Loan not found")
+		} else if errors.Is(err, repository.ErrAlreadyReturned) {
+			respondWithRepoError(w, r, err, "Book has already been returned")
 		} else {
-			log.Printf("Handler error returning loan: %v", err)
+			slog.ErrorContext(r.Context(), "Handler error returning loan", "error", err)
 			web.RespondWithError(w, http.StatusInternalServerError, "Failed to process return")
 		}
 		return
 	}
 
-	web.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Book returned successfully."})
+	e.Metrics.LoansReturned.Inc()
+	web.RespondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "Book returned successfully.", "fine_cents": fine})
 }
 
 func (e *Env) GetMyLoansHandler(w http.ResponseWriter, r *http.Request) {
@@ -88,9 +139,9 @@ func (e *Env) GetMyLoansHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	loans, err := e.LoanRepo.GetActiveLoansByUserID(user.ID)
+	loans, err := e.LoanRepo.GetActiveLoansByUserID(r.Context(), user.ID)
 	if err != nil {
-		log.Printf("Handler error getting user loans: %v", err)
+		slog.ErrorContext(r.Context(), "Handler error getting user loans", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve loans")
 		return
 	}
@@ -98,20 +149,107 @@ func (e *Env) GetMyLoansHandler(w http.ResponseWriter, r *http.Request) {
 	if loans == nil {
 		loans = []models.Loan{}
 	}
+	now := time.Now()
+	for i := range loans {
+		loans[i].MarkOverdue(now)
+	}
 
 	web.RespondWithJSON(w, http.StatusOK, loans)
 }
 
+// @Summary      Renew a loan
+// @Description  Extends an active loan's due date by one loan period from today, up to the maximum number of renewals.
+// @Description  Overdue loans cannot be renewed (409 loan_overdue) and must be returned, so the late fine is charged, and neither can loans of a book other patrons are waiting for (409 holds_waiting).
+// @Description  Members can renew their own loans; the loans:manage permission allows renewing any loan.
+// @Tags         Loans
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Loan ID"
+// @Success      200  {object}  models.Loan
+// @Failure      400  {object}  web.Problem
+// @Failure      401  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      409  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /loans/{id}/renew [post]
+func (e *Env) RenewLoanHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	vars := mux.Vars(r)
+	loanID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid loan ID")
+		return
+	}
+
+	loan, err := e.LoanRepo.GetLoanByID(r.Context(), loanID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "Handler error getting loan by ID", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to process renewal")
+		return
+	}
+	if loan != nil {
+		allowed, err := e.ownsOrCan(r.Context(), user, loan.UserID, models.PermLoansManage)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Handler error checking permission", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to process renewal")
+			return
+		}
+		if !allowed {
+			loan = nil
+		}
+	}
+	// Other members' loans are reported as missing rather than forbidden.
+	if loan == nil {
+		web.RespondWithError(w, http.StatusNotFound, "Loan not found")
+		return
+	}
+
+	err = e.LoanRepo.RenewLoan(r.Context(), loanID, time.Now().AddDate(0, 0, e.LoanPeriodDays), e.MaxRenewals)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Loan not found")
+		} else if errors.Is(err, repository.ErrRenewalLimit) {
+			respondWithRepoError(w, r, err, fmt.Sprintf("Loan has already been renewed the maximum of %d times", e.MaxRenewals))
+		} else if errors.Is(err, repository.ErrAlreadyReturned) {
+			respondWithRepoError(w, r, err, "Book has already been returned")
+		} else if errors.Is(err, repository.ErrLoanOverdue) {
+			respondWithRepoError(w, r, err, "Loan is overdue; return the book instead")
+		} else if errors.Is(err, repository.ErrHoldsWaiting) {
+			respondWithRepoError(w, r, err, "Other patrons are waiting for this book")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error renewing loan", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to process renewal")
+		}
+		return
+	}
+
+	renewedLoan, err := e.LoanRepo.GetLoanByID(r.Context(), loanID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error fetching renewed loan", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	renewedLoan.MarkOverdue(time.Now())
+
+	web.RespondWithJSON(w, http.StatusOK, renewedLoan)
+}
+
 // @Summary      List all loans (Admin)
-// @Description  Get a list of all loans in the system. Can be filtered by status.
+// @Description  Get a list of all loans in the system. Can be filtered by status. Requires the loans:read_all permission.
 // @Tags         Loans
 // @Accept       json
 // @Produce      json
-// @Param        status   query     string  false  "Filter by loan status. Allowed values: active, returned"
+// @Param        status   query     string  false  "Filter by loan status. Allowed values: active, returned, overdue"
 // @Success      200      {array}   models.Loan
-// @Failure      401      {object}  map[string]string
-// @Failure      403      {object}  map[string]string
-// @Failure      500      {object}  map[string]string
+// @Failure      401      {object}  web.Problem
+// @Failure      403      {object}  web.Problem
+// @Failure      500      {object}  web.Problem
 // @Security     BearerAuth
 // @Router       /loans [get]
 func (e *Env) GetAllLoansHandler(w http.ResponseWriter, r *http.Request) {
@@ -122,9 +260,9 @@ func (e *Env) GetAllLoansHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delegate the query to the repository.
-	loans, err := e.LoanRepo.SearchLoans(filter)
+	loans, err := e.LoanRepo.SearchLoans(r.Context(), filter)
 	if err != nil {
-		log.Printf("Handler error searching loans: %v", err)
+		slog.ErrorContext(r.Context(), "Handler error searching loans", "error", err)
 		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve loans")
 		return
 	}
@@ -132,6 +270,10 @@ func (e *Env) GetAllLoansHandler(w http.ResponseWriter, r *http.Request) {
 	if loans == nil {
 		loans = []models.Loan{}
 	}
+	now := time.Now()
+	for i := range loans {
+		loans[i].MarkOverdue(now)
+	}
 
 	web.RespondWithJSON(w, http.StatusOK, loans)
 }
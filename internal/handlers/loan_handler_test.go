@@ -0,0 +1,95 @@
+// Package handlers contains tests for the HTTP handlers.
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/metrics"
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stubLoanRepository is a LoanRepository holding a single loan, which records whether it
+// was returned.
+type stubLoanRepository struct {
+	repository.LoanRepository
+	loan     models.Loan
+	returned bool
+}
+
+func (r *stubLoanRepository) GetLoanByID(ctx context.Context, loanID int64) (*models.Loan, error) {
+	if loanID != r.loan.ID {
+		return nil, repository.ErrNotFound
+	}
+	loan := r.loan
+	return &loan, nil
+}
+
+func (r *stubLoanRepository) ReturnLoan(ctx context.Context, loanID int64, holdExpiresAt time.Time) (int64, error) {
+	r.returned = true
+	return 0, nil
+}
+
+// stubRoleRepository is a RoleRepository whose roles grant the permissions listed for them.
+type stubRoleRepository struct {
+	repository.RoleRepository
+	permissions map[string][]string
+}
+
+func (r *stubRoleRepository) HasPermission(ctx context.Context, role, permission string) (bool, error) {
+	for _, p := range r.permissions[role] {
+		if p == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TestReturnLoanHandler tests that a loan can be returned by its borrower or by a user
+// allowed to manage loans, and that anyone else is told it does not exist.
+func TestReturnLoanHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       models.User
+		wantStatus int
+	}{
+		{"borrower", models.User{ID: 7, Role: models.DefaultRole}, http.StatusOK},
+		{"other member", models.User{ID: 8, Role: models.DefaultRole}, http.StatusNotFound},
+		{"loan manager", models.User{ID: 9, Role: "librarian"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loans := &stubLoanRepository{loan: models.Loan{ID: 1, UserID: 7}}
+			env := &Env{
+				LoanRepo: loans,
+				RoleRepo: &stubRoleRepository{permissions: map[string][]string{
+					"librarian": {models.PermLoansManage},
+				}},
+				Metrics: &metrics.Metrics{LoansReturned: prometheus.NewCounter(prometheus.CounterOpts{Name: "loans_returned_total"})},
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/loans/1", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			user := tt.user
+			req = req.WithContext(context.WithValue(req.Context(), web.UserContextKey, &user))
+			rr := httptest.NewRecorder()
+
+			env.ReturnLoanHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if wantReturned := tt.wantStatus == http.StatusOK; loans.returned != wantReturned {
+				t.Errorf("expected returned=%v, got %v", wantReturned, loans.returned)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handlers for the circulation rules: loan policies and memberships.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/gorilla/mux"
+)
+
+// MembershipRequest sets when a patron's membership expires. A null expires_at makes it
+// never expire.
+type MembershipRequest struct {
+	ExpiresAt *string `json:"expires_at" validate:"omitempty,datetime=2006-01-02"`
+}
+
+// @Summary      List loan policies (Admin)
+// @Description  Retrieves how many items patrons of each role may have on loan at once, per material type. Material type '*' caps every material type together. Requires the loan_policies:manage permission.
+// @Tags         Circulation
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   models.LoanPolicy
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /loan-policies [get]
+func (e *Env) GetLoanPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	policies, err := e.LoanPolicyRepo.GetAll(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error listing loan policies", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve loan policies")
+		return
+	}
+
+	if policies == nil {
+		policies = []models.LoanPolicy{}
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, policies)
+}
+
+// @Summary      Set a loan policy (Admin)
+// @Description  Creates or replaces the cap on how many items of a material type patrons of a role may have on loan at once. Use material type '*' to cap every material type together. Requires the loan_policies:manage permission.
+// @Tags         Circulation
+// @Accept       json
+// @Produce      json
+// @Param        role           path      string             true  "Role (e.g. member)"
+// @Param        material_type  path      string             true  "Material type (e.g. book, magazine, dvd), or '*' for all"
+// @Param        policy         body      models.LoanPolicy  true  "Policy to set. Note: the 'role' and 'material_type' fields are ignored."
+// @Success      200            {object}  models.LoanPolicy
+// @Failure      400            {object}  web.Problem
+// @Failure      401            {object}  web.Problem
+// @Failure      403            {object}  web.Problem
+// @Failure      500            {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /loan-policies/{role}/{material_type} [put]
+func (e *Env) UpdateLoanPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var policy models.LoanPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	policy.Role = vars["role"]
+	policy.MaterialType = vars["material_type"]
+
+	if err := validate.Struct(policy); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	if err := e.LoanPolicyRepo.Upsert(r.Context(), policy); err != nil {
+		if errors.Is(err, repository.ErrRoleNotFound) {
+			web.RespondWithValidationErrors(w, map[string]string{"role": "This role does not exist."})
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error saving loan policy", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to save loan policy")
+		}
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, policy)
+}
+
+// @Summary      Delete a loan policy (Admin)
+// @Description  Removes the cap on how many items of a material type patrons of a role may have on loan at once. Requires the loan_policies:manage permission.
+// @Tags         Circulation
+// @Accept       json
+// @Produce      json
+// @Param        role           path  string  true  "Role (e.g. member)"
+// @Param        material_type  path  string  true  "Material type (e.g. book, magazine, dvd), or '*' for all"
+// @Success      204            "No Content"
+// @Failure      401            {object}  web.Problem
+// @Failure      403            {object}  web.Problem
+// @Failure      404            {object}  web.Problem
+// @Failure      500            {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /loan-policies/{role}/{material_type} [delete]
+func (e *Env) DeleteLoanPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := e.LoanPolicyRepo.Delete(r.Context(), vars["role"], vars["material_type"]); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Loan policy not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error deleting loan policy", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to delete loan policy")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Set a patron's membership expiry
+// @Description  Sets the date a patron's membership expires, from the start of which (UTC) they cannot borrow, or makes it never expire with a null 'expires_at'. Requires the users:manage permission.
+// @Tags         Circulation
+// @Accept       json
+// @Produce      json
+// @Param        id          path      int                true  "User ID"
+// @Param        membership  body      MembershipRequest  true  "Expiry date"
+// @Success      200         {object}  models.User
+// @Failure      400         {object}  web.Problem
+// @Failure      401         {object}  web.Problem
+// @Failure      403         {object}  web.Problem
+// @Failure      404         {object}  web.Problem
+// @Failure      500         {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/{id}/membership [put]
+func (e *Env) UpdateMembershipHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req MembershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		// The format was checked by the validator.
+		date, _ := time.Parse("2006-01-02", *req.ExpiresAt)
+		expiresAt = &date
+	}
+
+	if err := e.UserRepo.UpdateMembership(r.Context(), id, expiresAt); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "User not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error updating membership", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to update membership")
+		}
+		return
+	}
+
+	user, err := e.UserRepo.GetByID(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error fetching updated user", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, user)
+}
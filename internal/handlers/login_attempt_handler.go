@@ -0,0 +1,85 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handler for reviewing failed login attempts.
+package handlers
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+)
+
+// PaginatedLoginAttemptsResponse is the structure for paginated failed login responses.
+type PaginatedLoginAttemptsResponse struct {
+	Metadata map[string]interface{} `json:"metadata"`
+	Data     []models.LoginAttempt  `json:"data"`
+}
+
+// @Summary      List failed login attempts
+// @Description  Get a paginated list of failed logins, newest first, to spot password spraying and brute-force attacks. Requires the security:read permission.
+// @Description  Reasons are unknown_user, wrong_password, wrong_code (a wrong two-factor code after the right password) and locked_out (refused because the account was locked after repeated failures).
+// @Tags         Authentication
+// @Produce      json
+// @Param        username  query     string  false  "Filter by the username tried (exact match)"
+// @Param        ip        query     string  false  "Filter by client IP"
+// @Param        since     query     string  false  "Only attempts at or after this time (RFC 3339)"
+// @Param        page      query     int     false  "Page number for pagination"
+// @Param        limit     query     int     false  "Number of items per page"
+// @Success      200       {object}  PaginatedLoginAttemptsResponse
+// @Failure      400       {object}  web.Problem
+// @Failure      401       {object}  web.Problem
+// @Failure      403       {object}  web.Problem
+// @Failure      500       {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /login-attempts [get]
+func (e *Env) GetLoginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+
+	var filter repository.LoginAttemptFilter
+	if username := r.URL.Query().Get("username"); username != "" {
+		filter.Username = &username
+	}
+	if ip := r.URL.Query().Get("ip"); ip != "" {
+		filter.IP = &ip
+	}
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			web.RespondWithError(w, http.StatusBadRequest, "Invalid 'since', expected an RFC 3339 time")
+			return
+		}
+		filter.Since = &since
+	}
+
+	attempts, totalRecords, err := e.LoginAttemptRepo.Search(r.Context(), filter, limit, (page-1)*limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error searching login attempts", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if attempts == nil {
+		attempts = []models.LoginAttempt{}
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, PaginatedLoginAttemptsResponse{
+		Metadata: map[string]interface{}{
+			"current_page":  page,
+			"page_size":     limit,
+			"total_records": totalRecords,
+			"total_pages":   int(math.Ceil(float64(totalRecords) / float64(limit))),
+		},
+		Data: attempts,
+	})
+}
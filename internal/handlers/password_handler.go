@@ -0,0 +1,257 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handlers for changing and resetting passwords.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/auth"
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChangePasswordRequest is the body of a password change.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// PasswordResetResponse carries a newly issued reset token. It is only ever shown once.
+type PasswordResetResponse struct {
+	ResetToken string    `json:"reset_token"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ResetPasswordRequest is the body of a password reset.
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+// @Summary      Change my password
+// @Description  Sets a new password for the current user, who must confirm their current one. The new password must meet the password policy.
+// @Description  Every session of the user is signed out, including the current one; the response carries the tokens of a new session.
+// @Tags         Authentication
+// @Accept       json
+// @Produce      json
+// @Param        passwords  body      ChangePasswordRequest  true  "Current and new password"
+// @Success      200        {object}  TokenResponse
+// @Failure      400        {object}  web.Problem
+// @Failure      401        {object}  web.Problem
+// @Failure      403        {object}  web.Problem
+// @Failure      500        {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/me/password [put]
+func (e *Env) ChangeMyPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	// A stolen access token must not be enough to take the account over, so a wrong
+	// current password counts as a failed login.
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		e.failLogin(r, user.Username, models.LoginWrongPassword)
+		web.RespondWithCode(w, http.StatusForbidden, CodeInvalidCredentials, "Current password is incorrect")
+		return
+	}
+	if err := e.PasswordPolicy.Check(user.Username, req.NewPassword); err != nil {
+		web.RespondWithValidationErrors(w, passwordPolicyErrors("new_password", err))
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error hashing password", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := e.UserRepo.UpdatePassword(r.Context(), user.ID, string(hashedPassword)); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error updating password", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := e.revokeAccessTokens(r, user.Username); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking access tokens", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	e.startSession(w, r, user)
+}
+
+// @Summary      Issue a password reset token
+// @Description  Issues a single-use token the user can set a new password with at POST /password-reset, for users who forgot theirs. Requires the users:manage permission.
+// @Description  The token is only shown in this response and expires after a configurable number of hours. Issuing a new token invalidates the user's earlier ones.
+// @Tags         Authentication
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Success      201  {object}  PasswordResetResponse
+// @Failure      400  {object}  web.Problem
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/{id}/password-reset [post]
+func (e *Env) CreatePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	librarian, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := e.UserRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "User not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting user", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	resetToken, resetHash, err := auth.NewRefreshToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating reset token", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	stored := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: resetHash,
+		CreatedBy: librarian.ID,
+		ExpiresAt: time.Now().Add(e.PasswordResetTTL),
+	}
+	if err := e.PasswordResetRepo.Create(r.Context(), stored); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error storing reset token", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Password reset token issued", "user_id", user.ID)
+	web.RespondWithJSON(w, http.StatusCreated, PasswordResetResponse{ResetToken: resetToken, ExpiresAt: stored.ExpiresAt})
+}
+
+// @Summary      Reset a password
+// @Description  Sets a new password with a reset token issued by a librarian. The token can only be used once, and the new password must meet the password policy.
+// @Description  Every session of the user is signed out; log in again with the new password.
+// @Tags         Authentication
+// @Accept       json
+// @Param        reset  body  ResetPasswordRequest  true  "Reset token and new password"
+// @Success      204
+// @Failure      400    {object}  web.Problem
+// @Failure      401    {object}  web.Problem
+// @Failure      429    {object}  web.Problem
+// @Failure      500    {object}  web.Problem
+// @Router       /password-reset [post]
+func (e *Env) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	token, err := e.PasswordResetRepo.Get(r.Context(), auth.HashRefreshToken(req.Token))
+	if err != nil {
+		e.respondWithResetTokenError(w, r, err)
+		return
+	}
+	user, err := e.UserRepo.GetByID(r.Context(), token.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error getting user", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	// The token is only used up once the new password is accepted.
+	if err := e.PasswordPolicy.Check(user.Username, req.NewPassword); err != nil {
+		web.RespondWithValidationErrors(w, passwordPolicyErrors("new_password", err))
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error hashing password", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := e.PasswordResetRepo.Consume(r.Context(), token, string(hashedPassword)); err != nil {
+		e.respondWithResetTokenError(w, r, err)
+		return
+	}
+	if err := e.revokeAccessTokens(r, user.Username); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking access tokens", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithResetTokenError responds to a reset token that cannot be used.
+func (e *Env) respondWithResetTokenError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound), errors.Is(err, repository.ErrTokenUsed):
+		web.RespondWithCode(w, http.StatusUnauthorized, web.CodeInvalidToken, "Invalid reset token")
+	case errors.Is(err, repository.ErrTokenExpired):
+		respondWithRepoError(w, r, err, "Reset token has expired")
+	default:
+		slog.ErrorContext(r.Context(), "Handler error resetting password", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// revokeAccessTokens refuses every access token issued to the user so far, once their
+// credentials have changed. Callers revoke the user's refresh tokens separately.
+func (e *Env) revokeAccessTokens(r *http.Request, username string) error {
+	return e.RevokedTokens.RevokeSubject(r.Context(), username, time.Now())
+}
+
+// passwordPolicyErrors describes a password policy violation for field, in the shape
+// of validationErrors.
+func passwordPolicyErrors(field string, err error) map[string]string {
+	var message string
+	switch {
+	case errors.Is(err, auth.ErrPasswordTooShort), errors.Is(err, auth.ErrPasswordTooLong):
+		message = fmt.Sprintf("This %s.", err)
+	case errors.Is(err, auth.ErrPasswordContainsUsername):
+		message = "This password must not contain the username."
+	case errors.Is(err, auth.ErrPasswordBreached):
+		message = "This password has appeared in a data breach; choose another."
+	default:
+		message = "This password is invalid."
+	}
+	return map[string]string{field: message}
+}
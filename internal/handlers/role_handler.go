@@ -0,0 +1,265 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handlers for roles, permissions and role assignment.
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	// Role permissions must name a permission in the catalog.
+	validate.RegisterValidation("permission", func(fl validator.FieldLevel) bool {
+		return models.IsPermission(fl.Field().String())
+	})
+}
+
+// RoleAssignment is the body of a role assignment.
+type RoleAssignment struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// ownsOrCan reports whether user owns a resource belonging to ownerID, or their role
+// grants permission to act on other patrons' resources.
+func (e *Env) ownsOrCan(ctx context.Context, user *models.User, ownerID int64, permission string) (bool, error) {
+	if user.ID == ownerID {
+		return true, nil
+	}
+	return e.RoleRepo.HasPermission(ctx, user.Role, permission)
+}
+
+// @Summary      List permissions
+// @Description  Lists every permission a role can grant.
+// @Tags         Roles
+// @Produce      json
+// @Success      200  {array}   models.Permission
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /permissions [get]
+func (e *Env) GetPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	web.RespondWithJSON(w, http.StatusOK, models.AllPermissions)
+}
+
+// @Summary      List roles
+// @Description  Lists every role with the permissions it grants.
+// @Tags         Roles
+// @Produce      json
+// @Success      200  {array}   models.Role
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /roles [get]
+func (e *Env) GetRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := e.RoleRepo.GetAll(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error getting roles", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve roles")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, roles)
+}
+
+// @Summary      Create a role
+// @Description  Creates a role granting the given permissions. See GET /permissions for the permission names.
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Param        role  body      models.Role  true  "Role"
+// @Success      201   {object}  models.Role
+// @Failure      400   {object}  web.Problem
+// @Failure      401   {object}  web.Problem
+// @Failure      403   {object}  web.Problem
+// @Failure      409   {object}  web.Problem
+// @Failure      500   {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /roles [post]
+func (e *Env) CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var role models.Role
+	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+
+	if err := validate.Struct(role); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	if err := e.RoleRepo.Create(r.Context(), role); err != nil {
+		if errors.Is(err, repository.ErrRoleExists) {
+			respondWithRepoError(w, r, err, "Role already exists")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error creating role", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to create role")
+		}
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusCreated, role)
+}
+
+// @Summary      Update a role
+// @Description  Replaces the description and permissions of a role. Users with the role are affected immediately.
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Param        name  path      string       true  "Role name"
+// @Param        role  body      models.Role  true  "Role"
+// @Success      200   {object}  models.Role
+// @Failure      400   {object}  web.Problem
+// @Failure      401   {object}  web.Problem
+// @Failure      403   {object}  web.Problem
+// @Failure      404   {object}  web.Problem
+// @Failure      500   {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /roles/{name} [put]
+func (e *Env) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var role models.Role
+	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	role.Name = vars["name"]
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+
+	if err := validate.Struct(role); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	if err := e.RoleRepo.Update(r.Context(), role); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Role not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error updating role", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to update role")
+		}
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, role)
+}
+
+// @Summary      Delete a role
+// @Description  Deletes a role that no user is assigned. The default role new users receive cannot be deleted.
+// @Tags         Roles
+// @Param        name  path  string  true  "Role name"
+// @Success      204   "No Content"
+// @Failure      401   {object}  web.Problem
+// @Failure      403   {object}  web.Problem
+// @Failure      404   {object}  web.Problem
+// @Failure      409   {object}  web.Problem
+// @Failure      500   {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /roles/{name} [delete]
+func (e *Env) DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if name == models.DefaultRole {
+		web.RespondWithError(w, http.StatusConflict, "The default role cannot be deleted")
+		return
+	}
+
+	if err := e.RoleRepo.Delete(r.Context(), name); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Role not found")
+		} else if errors.Is(err, repository.ErrRoleInUse) {
+			respondWithRepoError(w, r, err, "Role is assigned to users; assign them another role first")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error deleting role", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to delete role")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Assign a role to a user
+// @Description  Assigns an existing role to a user and signs them out of every session, so their next login carries the new role.
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Param        id          path      int             true  "User ID"
+// @Param        assignment  body      RoleAssignment  true  "Role"
+// @Success      200         {object}  models.User
+// @Failure      400         {object}  web.Problem
+// @Failure      401         {object}  web.Problem
+// @Failure      403         {object}  web.Problem
+// @Failure      404         {object}  web.Problem
+// @Failure      500         {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/{id}/role [put]
+func (e *Env) UpdateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var assignment RoleAssignment
+	if err := json.NewDecoder(r.Body).Decode(&assignment); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := validate.Struct(assignment); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	user, err := e.UserRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "User not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting user", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to assign role")
+		}
+		return
+	}
+
+	if err := e.UserRepo.UpdateUserRole(r.Context(), user.Username, assignment.Role); err != nil {
+		if errors.Is(err, repository.ErrRoleNotFound) {
+			web.RespondWithValidationErrors(w, map[string]string{"role": "This role does not exist."})
+		} else if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "User not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error updating user role", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Failed to assign role")
+		}
+		return
+	}
+	user.Role = assignment.Role
+
+	if err := e.RefreshTokenRepo.RevokeAllForUser(r.Context(), user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking sessions", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Failed to assign role")
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, user)
+}
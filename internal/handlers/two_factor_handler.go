@@ -0,0 +1,476 @@
+// Package handlers contains the HTTP handlers for the application.
+// This file contains the handlers for two-factor authentication.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/auth"
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TwoFactorChallengeResponse is returned by login for users with two-factor authentication
+// enabled. The challenge token is exchanged at /login/2fa, along with a code, for the
+// session's tokens.
+type TwoFactorChallengeResponse struct {
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int    `json:"expires_in"` // Seconds until the challenge token expires
+}
+
+// TwoFactorLoginRequest is the body of the second step of a login.
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	// Code is the current code of the user's authenticator, or one of their recovery codes.
+	Code string `json:"code" validate:"required"`
+}
+
+// TwoFactorStatusResponse describes the current user's two-factor authentication.
+type TwoFactorStatusResponse struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	// Required is set when the user's role must have two-factor authentication enabled.
+	Required bool `json:"required"`
+}
+
+// TwoFactorEnrollmentResponse carries the secret of a newly enrolled authenticator.
+type TwoFactorEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	// OTPAuthURI holds the secret and its settings; authenticator apps enroll from it,
+	// usually scanned as a QR code.
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// TwoFactorCodeRequest carries a code from the user's authenticator.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// RecoveryCodesResponse carries newly issued recovery codes. They are only ever shown once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// DisableTwoFactorRequest is the body of turning off two-factor authentication.
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" validate:"required"`
+	// Code is the current code of the user's authenticator, or one of their recovery codes.
+	// It may be left out only while the authenticator has not been confirmed.
+	Code string `json:"code"`
+}
+
+// @Summary      Complete a two-step login
+// @Description  Exchanges the challenge token returned by /login, and a code from the user's authenticator or one of their recovery codes, for an access token and a refresh token.
+// @Description  Each code and recovery code works once. Wrong codes count as failed logins towards locking the account.
+// @Tags         Authentication
+// @Accept       json
+// @Produce      json
+// @Param        login  body      TwoFactorLoginRequest  true  "Challenge token and code"
+// @Success      200    {object}  TokenResponse
+// @Failure      400    {object}  web.Problem
+// @Failure      401    {object}  web.Problem
+// @Failure      429    {object}  web.Problem
+// @Failure      500    {object}  web.Problem
+// @Router       /login/2fa [post]
+func (e *Env) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	claims, err := e.Tokens.ParseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		web.RespondWithCode(w, http.StatusUnauthorized, web.CodeInvalidToken, "Invalid or expired challenge token")
+		return
+	}
+	username := claims.Subject
+
+	lockedFor, err := e.Lockout.LockedFor(r.Context(), username)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Lockout unavailable, not checking account", "error", err)
+	}
+	if lockedFor > 0 {
+		e.recordFailedLogin(r, username, models.LoginLockedOut)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+		web.RespondWithCode(w, http.StatusTooManyRequests, CodeAccountLocked, "Too many failed logins, account temporarily locked")
+		return
+	}
+
+	user, err := e.UserRepo.GetByUsername(r.Context(), username)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			web.RespondWithCode(w, http.StatusUnauthorized, web.CodeInvalidToken, "Invalid or expired challenge token")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting user", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	verified, err := e.verifySecondFactor(r, user, req.Code)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Handler error verifying second factor", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if !verified {
+		e.failLogin(r, username, models.LoginWrongCode)
+		web.RespondWithCode(w, http.StatusUnauthorized, CodeInvalidCredentials, "Invalid code")
+		return
+	}
+	if err := e.Lockout.Reset(r.Context(), username); err != nil {
+		slog.WarnContext(r.Context(), "Error resetting failed logins", "error", err)
+	}
+
+	e.startSession(w, r, user)
+}
+
+// @Summary      Get my two-factor authentication status
+// @Description  Reports whether the current user has two-factor authentication enabled, how many recovery codes they have left, and whether their role requires it.
+// @Tags         Two-Factor Authentication
+// @Produce      json
+// @Success      200  {object}  TwoFactorStatusResponse
+// @Failure      401  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/me/2fa [get]
+func (e *Env) GetMyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	status := TwoFactorStatusResponse{Required: slices.Contains(e.TwoFactorRequiredRoles, user.Role)}
+	tf, err := e.TwoFactorRepo.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "Handler error getting two-factor authentication", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if tf != nil && tf.Enabled() {
+		status.Enabled = true
+		status.EnabledAt = tf.EnabledAt
+		status.RecoveryCodesLeft = tf.RecoveryCodesLeft
+	}
+
+	web.RespondWithJSON(w, http.StatusOK, status)
+}
+
+// @Summary      Enroll an authenticator
+// @Description  Generates a TOTP secret for the current user and returns it with an otpauth URI to add to an authenticator app.
+// @Description  Two-factor authentication is only enabled once a code from the app is confirmed at /users/me/2fa/confirm. Enrolling again before that replaces the secret.
+// @Tags         Two-Factor Authentication
+// @Produce      json
+// @Success      201  {object}  TwoFactorEnrollmentResponse
+// @Failure      401  {object}  web.Problem
+// @Failure      409  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/me/2fa [post]
+func (e *Env) EnrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating TOTP secret", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := e.TwoFactorRepo.Enroll(r.Context(), user.ID, secret); err != nil {
+		if errors.Is(err, repository.ErrTwoFactorEnabled) {
+			respondWithRepoError(w, r, err, "Two-factor authentication is already enabled")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error enrolling authenticator", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	web.RespondWithJSON(w, http.StatusCreated, TwoFactorEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(e.TwoFactorIssuer, user.Username, secret),
+	})
+}
+
+// @Summary      Confirm an authenticator
+// @Description  Enables two-factor authentication with a code from the authenticator enrolled at POST /users/me/2fa, and returns single-use recovery codes to log in with if it is lost.
+// @Description  Every session of the user is signed out, including the current one; from now on logging in takes a code.
+// @Tags         Two-Factor Authentication
+// @Accept       json
+// @Produce      json
+// @Param        code  body      TwoFactorCodeRequest  true  "Code from the authenticator"
+// @Success      200   {object}  RecoveryCodesResponse
+// @Failure      400   {object}  web.Problem
+// @Failure      401   {object}  web.Problem
+// @Failure      404   {object}  web.Problem
+// @Failure      409   {object}  web.Problem
+// @Failure      500   {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/me/2fa/confirm [post]
+func (e *Env) ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	tf, err := e.TwoFactorRepo.Get(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "No authenticator enrolled")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting two-factor authentication", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+	if tf.Enabled() {
+		web.RespondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	step, ok := auth.VerifyTOTP(tf.Secret, req.Code, time.Now(), tf.LastStep)
+	if !ok {
+		web.RespondWithValidationErrors(w, map[string]string{"code": "This code is invalid or has expired."})
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating recovery codes", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := e.TwoFactorRepo.Enable(r.Context(), user.ID, step, hashes); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			web.RespondWithCode(w, http.StatusConflict, CodeTwoFactorEnabled, "Two-factor authentication is already enabled")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error enabling two-factor authentication", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	// Sessions started with the password alone end, so every session left was started
+	// with both factors.
+	if err := e.RefreshTokenRepo.RevokeAllForUser(r.Context(), user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking sessions", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := e.revokeAccessTokens(r, user.Username); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking access tokens", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Two-factor authentication enabled")
+	web.RespondWithJSON(w, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// @Summary      Disable my two-factor authentication
+// @Description  Removes the current user's authenticator and recovery codes. The user must confirm their password and, once two-factor authentication is enabled, a code from their authenticator or a recovery code, so a stolen password alone cannot turn it off.
+// @Description  Users whose role requires two-factor authentication cannot disable it.
+// @Tags         Two-Factor Authentication
+// @Accept       json
+// @Param        password  body  DisableTwoFactorRequest  true  "Current password and code"
+// @Success      204
+// @Failure      400       {object}  web.Problem
+// @Failure      401       {object}  web.Problem
+// @Failure      403       {object}  web.Problem
+// @Failure      404       {object}  web.Problem
+// @Failure      500       {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/me/2fa [delete]
+func (e *Env) DisableMyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+	if !ok {
+		web.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user from context")
+		return
+	}
+
+	var req DisableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.RespondWithCode(w, http.StatusBadRequest, web.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		errors := validationErrors(err)
+		web.RespondWithValidationErrors(w, errors)
+		return
+	}
+
+	if slices.Contains(e.TwoFactorRequiredRoles, user.Role) {
+		web.RespondWithCode(w, http.StatusForbidden, web.CodeTwoFactorRequired, "Two-factor authentication is required for your role")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		e.failLogin(r, user.Username, models.LoginWrongPassword)
+		web.RespondWithCode(w, http.StatusForbidden, CodeInvalidCredentials, "Password is incorrect")
+		return
+	}
+
+	tf, err := e.TwoFactorRepo.Get(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Two-factor authentication is not set up")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting two-factor authentication", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+	if tf.Enabled() {
+		if req.Code == "" {
+			web.RespondWithValidationErrors(w, map[string]string{"code": "This field is required."})
+			return
+		}
+		verified, err := e.verifySecondFactor(r, user, req.Code)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Handler error verifying second factor", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		if !verified {
+			e.failLogin(r, user.Username, models.LoginWrongCode)
+			web.RespondWithCode(w, http.StatusForbidden, CodeInvalidCredentials, "Invalid code")
+			return
+		}
+	}
+
+	if err := e.TwoFactorRepo.Disable(r.Context(), user.ID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Two-factor authentication is not set up")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error disabling two-factor authentication", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Two-factor authentication disabled")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Reset a user's two-factor authentication
+// @Description  Removes a user's authenticator and recovery codes, for users who lost both. Requires the users:manage permission.
+// @Description  Every session of the user ends, since it may have been taken over while the second factor protected the account. If the user's role requires two-factor authentication, they must enroll a new authenticator before using any protected route.
+// @Tags         Two-Factor Authentication
+// @Param        id   path  int  true  "User ID"
+// @Success      204
+// @Failure      400  {object}  web.Problem
+// @Failure      401  {object}  web.Problem
+// @Failure      403  {object}  web.Problem
+// @Failure      404  {object}  web.Problem
+// @Failure      500  {object}  web.Problem
+// @Security     BearerAuth
+// @Router       /users/{id}/2fa [delete]
+func (e *Env) ResetTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		web.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := e.UserRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "User not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error getting user", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	if err := e.TwoFactorRepo.Disable(r.Context(), id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithRepoError(w, r, err, "Two-factor authentication is not set up for this user")
+		} else {
+			slog.ErrorContext(r.Context(), "Handler error resetting two-factor authentication", "error", err)
+			web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return
+	}
+
+	// Sessions started while the second factor protected the account end with it, as
+	// they do when a password is reset.
+	if err := e.RefreshTokenRepo.RevokeAllForUser(r.Context(), user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking sessions", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := e.revokeAccessTokens(r, user.Username); err != nil {
+		slog.ErrorContext(r.Context(), "Handler error revoking access tokens", "error", err)
+		web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Two-factor authentication reset", "user_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifySecondFactor checks a code from the user's authenticator, or else one of their
+// recovery codes, using it up either way.
+func (e *Env) verifySecondFactor(r *http.Request, user *models.User, code string) (bool, error) {
+	tf, err := e.TwoFactorRepo.Get(r.Context(), user.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !tf.Enabled() {
+		return false, nil
+	}
+
+	if step, ok := auth.VerifyTOTP(tf.Secret, code, time.Now(), tf.LastStep); ok {
+		err := e.TwoFactorRepo.UseStep(r.Context(), user.ID, step)
+		if errors.Is(err, repository.ErrTokenUsed) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	err = e.TwoFactorRepo.UseRecoveryCode(r.Context(), user.ID, auth.HashRecoveryCode(code))
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	slog.InfoContext(r.Context(), "Recovery code used", "username", user.Username, "recovery_codes_left", tf.RecoveryCodesLeft-1)
+	return true, nil
+}
@@ -4,13 +4,29 @@ package handlers
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
+	"unicode"
 
 	"github.com/go-playground/validator/v10"
 )
 
 // validate is a singleton instance of the validator.
-var validate = validator.New()
+var validate = newValidator()
+
+// newValidator returns a validator that reports fields by their JSON names, as clients
+// send them, so validation errors can be matched to the fields of a form.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
 
 // validationErrors processes a validator.ValidationErrors error and returns a map
 // of human-readable error messages for each failed field.
@@ -27,17 +43,48 @@ func validationErrors(err error) map[string]string {
 		switch err.Tag() {
 		case "required":
 			errors[field] = "This field is required."
+		case "required_without":
+			errors[field] = fmt.Sprintf("This field is required when %s is not given.", snakeCase(err.Param()))
 		case "min":
-			errors[field] = fmt.Sprintf("This field must be at least %s characters long.", err.Param())
+			if err.Kind() == reflect.Slice {
+				errors[field] = fmt.Sprintf("This field must have at least %s items.", err.Param())
+			} else {
+				errors[field] = fmt.Sprintf("This field must be at least %s characters long.", err.Param())
+			}
+		case "unique":
+			errors[field] = "This field must not repeat a value."
 		case "max":
 			errors[field] = fmt.Sprintf("This field must be at most %s characters long.", err.Param())
 		case "isbn":
 			errors[field] = "This field must be a valid ISBN."
 		case "datetime":
 			errors[field] = fmt.Sprintf("This field must be in the format %s.", err.Param())
+		case "gt":
+			errors[field] = fmt.Sprintf("This field must be greater than %s.", err.Param())
+		case "gte":
+			errors[field] = fmt.Sprintf("This field must be at least %s.", err.Param())
+		case "oneof":
+			errors[field] = fmt.Sprintf("This field must be one of: %s.", strings.ReplaceAll(err.Param(), " ", ", "))
+		case "permission":
+			errors[field] = fmt.Sprintf("Unknown permission '%v'.", err.Value())
 		default:
 			errors[field] = "This field is invalid."
 		}
 	}
 	return errors
 }
+
+// snakeCase converts the Go name of a field, such as BookID, to the snake_case of its JSON
+// name, book_id, for messages that name another field.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,108 @@
+// Package health reports whether the service can take traffic: each dependency it needs
+// is checked, and the service stops taking traffic as soon as it starts shutting down.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Overall and per-dependency statuses.
+const (
+	StatusReady    = "ready"
+	StatusUnready  = "unready"
+	StatusDraining = "draining" // Shutting down; dependencies are not checked
+	StatusUp       = "up"
+	StatusDown     = "down"
+)
+
+// Check reports whether a dependency is usable, returning an error when it is not.
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of one dependency check.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of a readiness check.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Ready reports whether the service should be sent traffic.
+func (r Report) Ready() bool {
+	return r.Status == StatusReady
+}
+
+// Checker runs the dependency checks. It is safe for concurrent use.
+type Checker struct {
+	timeout  time.Duration
+	names    []string
+	checks   []Check
+	draining atomic.Bool
+}
+
+// NewChecker creates a Checker whose checks each get timeout to answer.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers the check of the named dependency. Checks must be added before the
+// Checker is used.
+func (c *Checker) Add(name string, check Check) {
+	c.names = append(c.names, name)
+	c.checks = append(c.checks, check)
+}
+
+// Drain marks the service as shutting down, so every later Check reports it unready.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Check runs every dependency check concurrently. The service is ready only when all of
+// them pass and it is not draining.
+func (c *Checker) Check(ctx context.Context) Report {
+	if c.draining.Load() {
+		return Report{Status: StatusDraining}
+	}
+
+	results := make([]CheckResult, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Checks: make(map[string]CheckResult, len(results))}
+	for i, result := range results {
+		report.Checks[c.names[i]] = result
+		if result.Status != StatusUp {
+			report.Status = StatusUnready
+		}
+	}
+	return report
+}
+
+// run runs a single check within the Checker's timeout.
+func (c *Checker) run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := CheckResult{Status: StatusUp, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+// Package health contains tests for the readiness checks.
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestChecker_Check tests that one failing dependency makes the service unready, and
+// that every dependency is reported with its status.
+func TestChecker_Check(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Add("database", func(ctx context.Context) error { return nil })
+	checker.Add("redis", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	report := checker.Check(context.Background())
+
+	if report.Ready() {
+		t.Errorf("expected the service to be unready")
+	}
+	if report.Checks["database"].Status != StatusUp {
+		t.Errorf("expected the database to be up, but got %+v", report.Checks["database"])
+	}
+	if redis := report.Checks["redis"]; redis.Status != StatusDown || redis.Error != "connection refused" {
+		t.Errorf("expected redis to be down with its error, but got %+v", redis)
+	}
+}
+
+// TestChecker_Timeout tests that a dependency that does not answer in time is down.
+func TestChecker_Timeout(t *testing.T) {
+	checker := NewChecker(10 * time.Millisecond)
+	checker.Add("database", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := checker.Check(context.Background())
+
+	if report.Ready() || report.Checks["database"].Status != StatusDown {
+		t.Errorf("expected a hung check to make the service unready, but got %+v", report)
+	}
+}
+
+// TestChecker_Drain tests that a draining service is unready even with every dependency up.
+func TestChecker_Drain(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Add("database", func(ctx context.Context) error { return nil })
+	if !checker.Check(context.Background()).Ready() {
+		t.Fatalf("expected the service to be ready before draining")
+	}
+
+	checker.Drain()
+
+	if report := checker.Check(context.Background()); report.Status != StatusDraining {
+		t.Errorf("expected status %q, but got %q", StatusDraining, report.Status)
+	}
+}
@@ -0,0 +1,90 @@
+// Package logging sets up the structured logger and carries the details of the request
+// being served, so every line logged while serving it can be traced back to it.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Supported log formats.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// RequestInfo identifies the request being served. It is stored in the request context
+// as a pointer, so details learned further down the chain, such as the authenticated
+// user, show up in every later line, including the access log line.
+type RequestInfo struct {
+	ID    string
+	Route string // Route template, e.g. /books/{id}, rather than the requested path
+	User  string // Username, once the request has been authenticated
+}
+
+type contextKey struct{}
+
+// WithRequestInfo returns a copy of ctx carrying info.
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// RequestInfoFrom returns the request info stored in ctx, or nil outside a request.
+func RequestInfoFrom(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(contextKey{}).(*RequestInfo)
+	return info
+}
+
+// New creates a logger writing to w at the given level (debug, info, warn or error) in
+// the given format. Lines logged with a request context carry request_id, route and user,
+// and trace_id and span_id when the request is traced.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	case FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, want %q or %q", format, FormatJSON, FormatText)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// contextHandler adds the details of the request and span in the record's context to
+// every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info := RequestInfoFrom(ctx); info != nil {
+		record.AddAttrs(slog.String("request_id", info.ID), slog.String("route", info.Route))
+		if info.User != "" {
+			record.AddAttrs(slog.String("user", info.User))
+		}
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
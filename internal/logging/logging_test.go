@@ -0,0 +1,63 @@
+// Package logging contains tests for the structured logger.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+// TestNew_RequestInfo tests that lines logged with a request context carry its details,
+// including a user set after the context was created.
+func TestNew_RequestInfo(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", FormatJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	info := &RequestInfo{ID: "abc123", Route: "/books/{id}"}
+	ctx := WithRequestInfo(context.Background(), info)
+	info.User = "alice"
+	logger.ErrorContext(ctx, "Handler error getting book", "error", "boom")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, but got %q", buf.String())
+	}
+	want := map[string]string{"msg": "Handler error getting book", "level": "ERROR", "request_id": "abc123", "route": "/books/{id}", "user": "alice", "error": "boom"}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("expected %s to be %q, but got %v", key, value, line[key])
+		}
+	}
+}
+
+// TestNew_Level tests that lines below the configured level are dropped.
+func TestNew_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "WARN", FormatText)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	logger.Info("dropped")
+	if buf.Len() != 0 {
+		t.Errorf("expected info lines to be dropped, but got %q", buf.String())
+	}
+	if !logger.Enabled(context.Background(), slog.LevelWarn) {
+		t.Errorf("expected warnings to be logged")
+	}
+}
+
+// TestNew_Invalid tests that unknown levels and formats are rejected.
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "loud", FormatJSON); err == nil {
+		t.Errorf("expected an unknown level to be rejected")
+	}
+	if _, err := New(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Errorf("expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,123 @@
+// Package metrics collects the Prometheus metrics served at /metrics: HTTP traffic,
+// database connection pool stats, cache effectiveness and circulation events.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/Lec7ral/fullAPI/internal/cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric the application defines.
+const namespace = "librarium"
+
+// Metrics holds the application's metrics and the registry they are exposed from.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	// HTTPRequests counts served requests by method, route template and status code.
+	HTTPRequests *prometheus.CounterVec
+	// HTTPDuration observes request latency in seconds, labelled like HTTPRequests.
+	HTTPDuration *prometheus.HistogramVec
+
+	LoansCreated  prometheus.Counter
+	LoansReturned prometheus.Counter
+	FailedLogins  prometheus.Counter
+	Registrations prometheus.Counter
+}
+
+// New creates the application's metrics, along with collectors for the Go runtime, the
+// process, the connection pool of db and the caches counted in cacheStats.
+func New(db *sql.DB, cacheStats *cache.Stats) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests served, by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		HTTPDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests, by method, route template and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		LoansCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "loans_created_total",
+			Help:      "Copies checked out.",
+		}),
+		LoansReturned: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "loans_returned_total",
+			Help:      "Copies checked back in.",
+		}),
+		FailedLogins: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "failed_logins_total",
+			Help:      "Logins refused for an unknown username or a wrong password.",
+		}),
+		Registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Users registered.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.HTTPRequests, m.HTTPDuration,
+		m.LoansCreated, m.LoansReturned, m.FailedLogins, m.Registrations,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "main"),
+		newCacheCollector(cacheStats),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// cacheCollector exposes the counters of the cached repositories, which are kept in
+// cache.Stats so GET /cache/stats can report them too.
+type cacheCollector struct {
+	stats    *cache.Stats
+	hits     *prometheus.Desc
+	misses   *prometheus.Desc
+	errors   *prometheus.Desc
+	hitRatio *prometheus.Desc
+}
+
+func newCacheCollector(stats *cache.Stats) *cacheCollector {
+	labels := []string{"cache", "backend"}
+	return &cacheCollector{
+		stats:    stats,
+		hits:     prometheus.NewDesc(namespace+"_cache_hits_total", "Lookups answered by the cache.", labels, nil),
+		misses:   prometheus.NewDesc(namespace+"_cache_misses_total", "Lookups served from the database, including cache errors.", labels, nil),
+		errors:   prometheus.NewDesc(namespace+"_cache_errors_total", "Lookups the cache failed to answer.", labels, nil),
+		hitRatio: prometheus.NewDesc(namespace+"_cache_hit_ratio", "Share of lookups answered by the cache since startup.", labels, nil),
+	}
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.errors
+	ch <- c.hitRatio
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	backend := c.stats.Backend()
+	for _, counts := range c.stats.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(counts.Hits), counts.Name, backend)
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(counts.Misses), counts.Name, backend)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(counts.Errors), counts.Name, backend)
+		ch <- prometheus.MustNewConstMetric(c.hitRatio, prometheus.GaugeValue, counts.HitRatio, counts.Name, backend)
+	}
+}
@@ -0,0 +1,66 @@
+// Package metrics contains tests for the Prometheus metrics.
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Lec7ral/fullAPI/internal/cache"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestCacheCollector tests that the counters of each cached repository are exposed with
+// their hit ratio.
+func TestCacheCollector(t *testing.T) {
+	stats := cache.NewStats("memory")
+	books := stats.Counter("books")
+	books.Hit()
+	books.Hit()
+	books.Hit()
+	books.Miss()
+
+	expected := `
+# HELP librarium_cache_hit_ratio Share of lookups answered by the cache since startup.
+# TYPE librarium_cache_hit_ratio gauge
+librarium_cache_hit_ratio{backend="memory",cache="books"} 0.75
+# HELP librarium_cache_hits_total Lookups answered by the cache.
+# TYPE librarium_cache_hits_total counter
+librarium_cache_hits_total{backend="memory",cache="books"} 3
+`
+	err := testutil.CollectAndCompare(newCacheCollector(stats), strings.NewReader(expected),
+		"librarium_cache_hit_ratio", "librarium_cache_hits_total")
+	if err != nil {
+		t.Errorf("unexpected cache metrics: %s", err)
+	}
+}
+
+// TestHandler tests that the endpoint serves the application's metrics alongside the
+// connection pool stats.
+func TestHandler(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := New(db, cache.NewStats("none"))
+	m.LoansCreated.Inc()
+	m.HTTPRequests.WithLabelValues("GET", "/books/{id}", "200").Inc()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		"librarium_loans_created_total 1",
+		`librarium_http_requests_total{method="GET",route="/books/{id}",status="200"} 1`,
+		`go_sql_open_connections{db_name="main"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected the metrics to contain %q", want)
+		}
+	}
+}
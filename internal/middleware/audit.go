@@ -0,0 +1,24 @@
+// Package middleware provides HTTP middleware functions for the application.
+// This file contains the audit actor middleware.
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Lec7ral/fullAPI/internal/audit"
+	"github.com/Lec7ral/fullAPI/internal/logging"
+	"github.com/Lec7ral/fullAPI/internal/web"
+)
+
+// AuditActor attributes the changes a request makes to its client IP and request ID, so
+// the audit log can trace them. It must come after RequestID; AuthMiddleware adds the user
+// on protected routes.
+func AuditActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := &audit.Actor{IP: web.ClientIP(r)}
+		if info := logging.RequestInfoFrom(r.Context()); info != nil {
+			actor.RequestID = info.ID
+		}
+		next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), actor)))
+	})
+}
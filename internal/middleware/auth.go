@@ -3,18 +3,21 @@ package middleware
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"github.com/Lec7ral/fullAPI/internal/audit"
+	"github.com/Lec7ral/fullAPI/internal/auth"
+	"github.com/Lec7ral/fullAPI/internal/logging"
 	"github.com/Lec7ral/fullAPI/internal/repository"
 	"github.com/Lec7ral/fullAPI/internal/web"
-	"github.com/golang-jwt/jwt/v4"
 )
 
-// AuthMiddleware is a constructor that takes dependencies (UserRepo, JWTSecret)
-// and returns a middleware handler. This is the standard way to inject dependencies
-// into middleware without causing circular imports.
-func AuthMiddleware(userRepo repository.UserRepository, jwtSecret string) func(http.Handler) http.Handler {
+// AuthMiddleware is a constructor that takes dependencies (UserRepo, token manager and
+// revocation list) and returns a middleware handler. This is the standard way to inject
+// dependencies into middleware without causing circular imports.
+func AuthMiddleware(userRepo repository.UserRepository, tokens *auth.TokenManager, revoked auth.RevocationList) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -24,27 +27,45 @@ func AuthMiddleware(userRepo repository.UserRepository, jwtSecret string) func(h
 			}
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			claims := &jwt.RegisteredClaims{}
-
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(jwtSecret), nil
-			})
+			claims, err := tokens.ParseAccessToken(tokenString)
+			if err != nil {
+				web.RespondWithCode(w, http.StatusUnauthorized, web.CodeInvalidToken, "Invalid or expired token")
+				return
+			}
 
-			if err != nil || !token.Valid {
-				web.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+			// Tokens that were logged out stay valid JWTs until they expire.
+			isRevoked, err := revoked.IsRevoked(r.Context(), claims)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error checking token revocation", "error", err)
+				web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			if isRevoked {
+				web.RespondWithCode(w, http.StatusUnauthorized, web.CodeTokenRevoked, "Token has been revoked")
 				return
 			}
 
 			// Use the username from the token to fetch the full user object.
 			username := claims.Subject
-			user, err := userRepo.GetByUsername(username)
+			user, err := userRepo.GetByUsername(r.Context(), username)
 			if err != nil {
 				web.RespondWithError(w, http.StatusUnauthorized, "User not found")
 				return
 			}
 
-			// Store the user object in the context using the exported key.
+			// Later log lines for this request name the user.
+			if info := logging.RequestInfoFrom(r.Context()); info != nil {
+				info.User = user.Username
+			}
+			// So do the audit log entries for the changes it makes.
+			if actor := audit.ActorFrom(r.Context()); actor != nil {
+				actor.UserID = user.ID
+				actor.Username = user.Username
+			}
+
+			// Store the user object and the token's claims in the context using the exported keys.
 			ctx := context.WithValue(r.Context(), web.UserContextKey, user)
+			ctx = context.WithValue(ctx, web.ClaimsContextKey, claims)
 
 			// Call the next handler with the enriched context.
 			next.ServeHTTP(w, r.WithContext(ctx))
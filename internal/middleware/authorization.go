@@ -1,12 +1,19 @@
 package middleware
 
 import (
+	"log/slog"
+	"net/http"
+	"slices"
+
 	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/Lec7ral/fullAPI/internal/repository"
 	"github.com/Lec7ral/fullAPI/internal/web"
-	"net/http"
 )
 
-func RoleRequiredMiddleware(rquieredRole string) func(http.Handler) http.Handler {
+// RequirePermission only lets the request through if the role of the authenticated user
+// grants permission. It must run after AuthMiddleware. Roles are looked up on every request,
+// so changes to a role apply immediately.
+func RequirePermission(roles repository.RoleRepository, permission string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user, ok := r.Context().Value(web.UserContextKey).(*models.User)
@@ -14,7 +21,13 @@ func RoleRequiredMiddleware(rquieredRole string) func(http.Handler) http.Handler
 				web.RespondWithError(w, http.StatusUnauthorized, "User Not found in context")
 				return
 			}
-			if user.Role != rquieredRole {
+			granted, err := roles.HasPermission(r.Context(), user.Role, permission)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error checking permission", "permission", permission, "error", err)
+				web.RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			if !granted {
 				web.RespondWithError(w, http.StatusForbidden, "You don't have permission to access this resource")
 				return
 			}
@@ -22,3 +35,24 @@ func RoleRequiredMiddleware(rquieredRole string) func(http.Handler) http.Handler
 		})
 	}
 }
+
+// RequireTwoFactor refuses users whose role is one of roles until they enable two-factor
+// authentication. It must run after AuthMiddleware. Enabling it signs out every session of
+// the user, and logins need a code from then on, so every session such a user still has
+// was started with their second factor.
+func RequireTwoFactor(roles []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := r.Context().Value(web.UserContextKey).(*models.User)
+			if !ok {
+				web.RespondWithError(w, http.StatusUnauthorized, "User Not found in context")
+				return
+			}
+			if !user.TwoFactorEnabled && slices.Contains(roles, user.Role) {
+				web.RespondWithCode(w, http.StatusForbidden, web.CodeTwoFactorRequired, "Two-factor authentication must be enabled for your role; set it up at /users/me/2fa")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
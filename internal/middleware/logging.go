@@ -3,16 +3,17 @@
 package middleware
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 )
 
-// responseWriter is a custom wrapper around http.ResponseWriter to capture the status code.
-// This is necessary because the status code is not directly accessible from the ResponseWriter interface.
+// responseWriter is a custom wrapper around http.ResponseWriter to capture the status code
+// and the size of the body. Neither is accessible from the ResponseWriter interface.
 type responseWriter struct {
 	http.ResponseWriter
 	status int
+	bytes  int
 }
 
 // WriteHeader overrides the original WriteHeader method to capture the status code.
@@ -21,29 +22,46 @@ func (rw *responseWriter) WriteHeader(statusCode int) {
 	rw.ResponseWriter.WriteHeader(statusCode)
 }
 
-// LoggingMiddleware logs the details of each incoming HTTP request.
-// It logs the method, URI, protocol, status code, and duration of the request.
+// Write overrides the original Write method to count the bytes written.
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// wrapResponseWriter returns w as a *responseWriter, wrapping it unless an outer
+// middleware already has, so every middleware in the chain sees the same status and size.
+func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
+	if rw, ok := w.(*responseWriter); ok {
+		return rw
+	}
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+// LoggingMiddleware logs a line for each request once it has been served, with its method,
+// path, status code, response size and latency. It must run after RequestID, which adds
+// the request ID, route and user.
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Record the start time of the request processing.
 		start := time.Now()
 
 		// Wrap the original response writer with our custom one.
-		rw := &responseWriter{w, http.StatusOK}
+		rw := wrapResponseWriter(w)
 
 		// Call the next handler in the chain.
 		next.ServeHTTP(rw, r)
 
-		// Calculate the duration of the request.
-		duration := time.Since(start)
-
-		// Log the formatted request details.
-		log.Printf("[%s] %s %s (%d %s) - %s",
-			r.Method,
-			r.RequestURI,
-			r.Proto,
-			rw.status,
-			http.StatusText(rw.status),
-			duration)
+		level := slog.LevelInfo
+		if rw.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "Request served",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rw.status),
+			slog.Int("bytes", rw.bytes),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		)
 	})
 }
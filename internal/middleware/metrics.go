@@ -0,0 +1,36 @@
+// Package middleware provides HTTP middleware functions for the application.
+// This file contains the metrics middleware.
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/metrics"
+	"github.com/gorilla/mux"
+)
+
+// Metrics counts each request and observes its latency, labelled with the method, the
+// route template (so /books/1 and /books/2 share a series) and the status code.
+// It shares the response writer wrapper with LoggingMiddleware when both are used.
+func Metrics(m *metrics.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := wrapResponseWriter(w)
+
+			next.ServeHTTP(rw, r)
+
+			route := "unmatched"
+			if current := mux.CurrentRoute(r); current != nil {
+				if template, err := current.GetPathTemplate(); err == nil {
+					route = template
+				}
+			}
+			status := strconv.Itoa(rw.status)
+			m.HTTPRequests.WithLabelValues(r.Method, route, status).Inc()
+			m.HTTPDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Package middleware provides HTTP middleware functions for the application.
+// This file contains the rate limiting middleware.
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Lec7ral/fullAPI/internal/ratelimit"
+	"github.com/Lec7ral/fullAPI/internal/web"
+)
+
+// maxPeekedBody is the most of a request body ByUsername reads to find the username.
+const maxPeekedBody = 64 << 10
+
+// KeyFunc picks the key a request is counted under, or "" to not count it.
+type KeyFunc func(r *http.Request) string
+
+// ByIP counts requests by client IP.
+func ByIP(r *http.Request) string {
+	return web.ClientIP(r)
+}
+
+// ByUsername counts requests by the "username" field of their JSON body, so attempts
+// against one account are limited however many addresses they come from. The body is
+// restored for the handler.
+func ByUsername(r *http.Request) string {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekedBody))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return ""
+	}
+	var fields struct {
+		Username string `json:"username"`
+	}
+	if json.Unmarshal(body, &fields) != nil {
+		return ""
+	}
+	return fields.Username
+}
+
+// RateRule limits the requests to a route that share a key.
+type RateRule struct {
+	Name  string // Scopes the buckets, so rules on different routes do not share them
+	Limit ratelimit.Limit
+	Key   KeyFunc
+}
+
+// RateLimit refuses requests with 429 Too Many Requests once any rule's bucket for the
+// request is empty, telling the client in Retry-After when to try again. Rules with a
+// disabled limit are skipped. If the limiter fails, requests are let through rather than
+// locking everyone out.
+func RateLimit(limiter ratelimit.Limiter, rules ...RateRule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, rule := range rules {
+				if !rule.Limit.Enabled() {
+					continue
+				}
+				key := rule.Key(r)
+				if key == "" {
+					continue
+				}
+				result, err := limiter.Allow(r.Context(), rule.Name+":"+key, rule.Limit)
+				if err != nil {
+					slog.WarnContext(r.Context(), "Rate limiter unavailable, allowing request", "rule", rule.Name, "error", err)
+					continue
+				}
+				if !result.Allowed {
+					slog.InfoContext(r.Context(), "Request rate limited", "rule", rule.Name, "key", key)
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+					web.RespondWithError(w, http.StatusTooManyRequests, "Too many requests, try again later")
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RealIP sets the request's RemoteAddr to the client address reported by a reverse proxy,
+// so rate limits and logs see the client rather than the proxy. It takes the last address
+// in X-Forwarded-For, which the proxy appended, or X-Real-IP. Only use it behind a proxy
+// that sets these headers: otherwise clients can pick any address they like.
+func RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := r.Header.Get("X-Real-IP")
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			ip = strings.TrimSpace(hops[len(hops)-1])
+		}
+		if net.ParseIP(ip) != nil {
+			r.RemoteAddr = net.JoinHostPort(ip, "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
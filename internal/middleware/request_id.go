@@ -0,0 +1,59 @@
+// Package middleware provides HTTP middleware functions for the application.
+// This file contains the request ID middleware.
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/Lec7ral/fullAPI/internal/logging"
+	"github.com/Lec7ral/fullAPI/internal/web"
+	"github.com/gorilla/mux"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = web.RequestIDHeader
+
+// RequestID identifies each request by the X-Request-ID header it arrives with, so an ID
+// assigned by a proxy or client is kept, or by a new random ID. The ID is echoed in the
+// response and stored in the request context with the matched route template, for every
+// line logged while serving the request.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		info := &logging.RequestInfo{ID: id}
+		if route := mux.CurrentRoute(r); route != nil {
+			info.Route, _ = route.GetPathTemplate()
+		}
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestInfo(r.Context(), info)))
+	})
+}
+
+// validRequestID reports whether an incoming request ID is safe to log and echo: up to
+// 128 letters, digits, dashes, underscores and dots.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,25 @@
+// Package middleware provides HTTP middleware functions for the application.
+// This file contains the request timeout middleware.
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Timeout gives every request a deadline d from when it arrives. Repositories run their
+// queries on the request context, so queries still running at the deadline are cancelled,
+// as are the queries of a request whose client disconnects. A zero d disables the deadline.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Package models defines the data structures used throughout the application.
+package models
+
+import "time"
+
+// Account entry kinds. Charges raise a patron's balance; payments and waivers lower it.
+const (
+	EntryCharge  = "charge"
+	EntryPayment = "payment"
+	EntryWaiver  = "waiver"
+)
+
+// AccountEntry is a single line in a patron's ledger. Amounts are in cents and always positive;
+// the kind decides whether the entry adds to or settles the balance.
+// It includes struct tags for JSON marshaling and validation.
+type AccountEntry struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	// LoanID links fines and their payments or waivers to the loan they are for.
+	LoanID      *int64 `json:"loan_id,omitempty"`
+	Kind        string `json:"kind" validate:"required,oneof=charge payment waiver"`
+	AmountCents int64  `json:"amount_cents" validate:"required,gt=0"`
+	Note        string `json:"note" validate:"max=255"`
+	// RecordedBy is the librarian who recorded the entry. It is nil for fines assessed on return.
+	RecordedBy *int64    `json:"recorded_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Account is a patron's ledger together with the balance it adds up to.
+type Account struct {
+	UserID       int64          `json:"user_id"`
+	BalanceCents int64          `json:"balance_cents"`
+	Entries      []AccountEntry `json:"entries"`
+}
+
+// FinePolicy sets how overdue loans of a material type are fined.
+// It includes struct tags for JSON marshaling and validation.
+type FinePolicy struct {
+	MaterialType   string `json:"material_type" validate:"required,max=32"`
+	DailyRateCents int64  `json:"daily_rate_cents" validate:"gte=0"`
+	MaxFineCents   int64  `json:"max_fine_cents" validate:"gte=0"`
+	// GraceDays is how many days late a loan may be returned without a fine.
+	// Past the grace period, every day overdue is charged.
+	GraceDays int `json:"grace_days" validate:"gte=0"`
+}
+
+// Assess returns the fine in cents for a loan returned daysOverdue days late.
+func (p FinePolicy) Assess(daysOverdue int) int64 {
+	if daysOverdue <= p.GraceDays {
+		return 0
+	}
+	fine := int64(daysOverdue) * p.DailyRateCents
+	if fine > p.MaxFineCents {
+		fine = p.MaxFineCents
+	}
+	return fine
+}
@@ -0,0 +1,56 @@
+// Package models defines the data structures used throughout the application.
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditCreate         = "create"
+	AuditUpdate         = "update"
+	AuditDelete         = "delete"
+	AuditRoleChange     = "role_change"
+	AuditPasswordChange = "password_change"
+	AuditCheckout       = "checkout"
+	AuditReturn         = "return"
+	AuditRenew          = "renew"
+)
+
+// Entity types recorded in the audit log.
+const (
+	AuditBook   = "book"
+	AuditAuthor = "author"
+	AuditUser   = "user"
+	AuditLoan   = "loan"
+)
+
+// AuditEntry records one change to an entity. Entries are never updated or deleted.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// ActorID and Actor identify the user who made the change; both are empty for
+	// anonymous requests, and Actor names the tool for command-line changes.
+	ActorID    *int64 `json:"actor_id,omitempty"`
+	Actor      string `json:"actor"`
+	Action     string `json:"action"`
+	EntityType string `json:"entity_type"`
+	EntityID   int64  `json:"entity_id"`
+	// Changes maps each changed field to its value before and after, as {"from", "to"}.
+	Changes   json.RawMessage `json:"changes" swaggertype:"object"`
+	RequestID string          `json:"request_id,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	// PrevHash and Hash chain the entry to the one before it, when the hash chain is on.
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// AuditVerification is the result of checking the audit log's hash chain.
+type AuditVerification struct {
+	Intact  bool `json:"intact"`
+	Checked int  `json:"checked"` // Entries read, chained or not
+	// BrokenAt is the ID of the first entry that was altered, or whose predecessor was
+	// altered or deleted.
+	BrokenAt int64 `json:"broken_at,omitempty"`
+}
@@ -12,8 +12,11 @@ type Book struct {
 	PublishedDate string `json:"published_date" validate:"required,datetime=2006-01-02"`
 	// ISBN is the International Standard Book Number.
 	ISBN string `json:"isbn" validate:"required,isbn"`
-	// Stock is the number of available copies of the book.
+	// Stock is the number of copies currently available for loan, derived from copy status.
+	// On creation it sets how many copies to add; updates ignore it.
 	Stock int `json:"stock" validate:"gte=0"` // gte=0 means "greater than or equal to 0"
+	// MaterialType selects the fine policy for overdue loans (e.g. "book", "dvd"). It defaults to "book".
+	MaterialType string `json:"material_type" validate:"omitempty,max=32"`
 
 	// AuthorID is used for data input when creating/updating a book.
 	// It links the book to an author in the authors table.
@@ -22,4 +25,8 @@ type Book struct {
 	// Author is a pointer to the Author model for nesting author information in responses.
 	// The `omitempty` tag prevents it from being included in the JSON if it's nil.
 	Author *Author `json:"author,omitempty"`
+
+	// Snippet is an excerpt of the matching text with hits wrapped in <mark> tags.
+	// It is only set on full-text search results.
+	Snippet string `json:"snippet,omitempty"`
 }
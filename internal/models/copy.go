@@ -0,0 +1,31 @@
+// Package models defines the data structures used throughout the application.
+package models
+
+// Copy statuses. A copy is on loan only while an active loan references it, and on hold
+// only while it is set aside for a ready hold.
+const (
+	CopyStatusAvailable = "available"
+	CopyStatusOnLoan    = "on_loan"
+	CopyStatusOnHold    = "on_hold"
+	CopyStatusLost      = "lost"
+	CopyStatusInRepair  = "in_repair"
+	CopyStatusWithdrawn = "withdrawn"
+)
+
+// Copy represents a single physical item of a book, identified by its barcode.
+// It includes struct tags for JSON marshaling and validation.
+type Copy struct {
+	// ID is the unique identifier for the copy.
+	ID int64 `json:"id"`
+	// BookID is the book this copy is an item of.
+	BookID int64 `json:"book_id"`
+	// Barcode is the label scanned at the circulation desk. It is unique across all copies.
+	Barcode string `json:"barcode" validate:"required,min=3,max=64"`
+	// Condition describes the physical state of the copy.
+	Condition string `json:"condition" validate:"required,oneof=new good fair poor damaged"`
+	// Status is the circulation status of the copy. It becomes "on_loan" only through loans,
+	// and "on_hold" only through holds.
+	Status string `json:"status" validate:"required,oneof=available on_loan on_hold lost in_repair withdrawn"`
+	// AcquisitionDate is the date the library acquired the copy, in YYYY-MM-DD format.
+	AcquisitionDate string `json:"acquisition_date" validate:"required,datetime=2006-01-02"`
+}
@@ -0,0 +1,31 @@
+// Package models defines the data structures used throughout the application.
+package models
+
+import "time"
+
+// Hold statuses. A hold waits in its book's queue until a returned copy is set aside
+// for it; it is then ready for pickup until it is fulfilled by a loan or expires.
+const (
+	HoldStatusWaiting   = "waiting"
+	HoldStatusReady     = "ready"
+	HoldStatusFulfilled = "fulfilled"
+	HoldStatusCancelled = "cancelled"
+	HoldStatusExpired   = "expired"
+)
+
+// Hold represents a patron's place in the queue for a book with no available copies.
+type Hold struct {
+	ID     int64  `json:"id"`
+	BookID int64  `json:"book_id"`
+	UserID int64  `json:"user_id"`
+	Status string `json:"status"`
+	// Position is the hold's place in its book's queue, starting at 1. It is only set while waiting.
+	Position int `json:"position,omitempty"`
+	// CopyID is the copy set aside for a ready hold.
+	CopyID    *int64     `json:"copy_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty"`
+	// ExpiresAt is the pickup deadline of a ready hold, after which the copy passes to the next hold.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Book      *Book      `json:"book,omitempty"`
+}
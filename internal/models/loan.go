@@ -1,18 +1,48 @@
 // Package models defines the data structures used throughout the application.
 package models
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // Loan represents the structure of a loan in the library, including its nested book and user.
 // It includes struct tags for JSON marshaling and validation.
 type Loan struct {
-	ID       int64     `json:"id"`
+	ID int64 `json:"id"`
+	// CopyID is the physical copy on loan; BookID is the book that copy belongs to.
+	CopyID   int64     `json:"copy_id"`
 	BookID   int64     `json:"book_id"`
 	UserID   int64     `json:"user_id"`
 	LoanDate time.Time `json:"loan_date"`
+	// DueDate is when the copy must be returned. Each renewal moves it forward by a loan period.
+	DueDate      time.Time `json:"due_date"`
+	RenewalCount int       `json:"renewal_count"`
 	// ReturnDate is a pointer to time.Time to allow for null values from the database.
 	// The omitempty tag ensures it's not included in the JSON response if it's null.
 	ReturnDate *time.Time `json:"return_date,omitempty"`
-	Book       *Book      `json:"book,omitempty"`
-	User       *User      `json:"user,omitempty"`
+	// IsOverdue and DaysOverdue are computed by MarkOverdue rather than stored.
+	IsOverdue   bool  `json:"is_overdue"`
+	DaysOverdue int   `json:"days_overdue"`
+	Book        *Book `json:"book,omitempty"`
+	User        *User `json:"user,omitempty"`
+}
+
+// MarkOverdue sets IsOverdue and DaysOverdue as of now. Only loans that have not
+// been returned can be overdue, and any part of a day past the due date counts as a day.
+func (l *Loan) MarkOverdue(now time.Time) {
+	l.IsOverdue = l.ReturnDate == nil && now.After(l.DueDate)
+	l.DaysOverdue = 0
+	if l.IsOverdue {
+		l.DaysOverdue = DaysOverdue(l.DueDate, now)
+	}
+}
+
+// DaysOverdue returns how many days past dueDate the time at is, counting any part of a day
+// as a whole day. It is 0 when at is on or before the due date.
+func DaysOverdue(dueDate, at time.Time) int {
+	if !at.After(dueDate) {
+		return 0
+	}
+	return int(math.Ceil(at.Sub(dueDate).Hours() / 24))
 }
@@ -0,0 +1,90 @@
+// Package models defines the data structures used throughout the application.
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// AnyMaterialType is the material type of a loan policy that caps the loans of every
+// material type together.
+const AnyMaterialType = "*"
+
+// LoanPolicy caps how many items of a material type patrons of a role may have on loan
+// at once. Roles without a policy for a material type are not capped.
+type LoanPolicy struct {
+	Role string `json:"role"`
+	// MaterialType is a material type such as "dvd", or AnyMaterialType.
+	MaterialType string `json:"material_type" validate:"required,max=32"`
+	MaxLoans     int    `json:"max_loans" validate:"gte=0"`
+}
+
+// Circulation rules a loan can break, as reported in PolicyViolation.Rule.
+const (
+	RuleMembershipExpired = "membership_expired"
+	RuleFineBalance       = "fine_balance"
+	RuleDuplicateLoan     = "duplicate_loan"
+	RuleMaxLoans          = "max_loans"
+)
+
+// PolicyViolation is a circulation rule that keeps a patron from borrowing.
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// BorrowedItem is a book a patron has on loan, along with its material type.
+type BorrowedItem struct {
+	BookID       int64
+	MaterialType string
+}
+
+// Borrower is what the circulation rules consider about a patron.
+type Borrower struct {
+	Role                string
+	MembershipExpiresAt *time.Time
+	BalanceCents        int64
+	ActiveLoans         []BorrowedItem
+}
+
+// CheckLoan returns the circulation rules the borrower would break by borrowing a copy of
+// the book, whose material type is materialType, at now: an expired membership, a balance
+// above maxBalanceCents, a copy of the same book already on loan, and the caps of the
+// policies of their role. A bookID of 0 checks only the rules that apply to every book.
+func (b Borrower) CheckLoan(policies []LoanPolicy, maxBalanceCents int64, bookID int64, materialType string, now time.Time) []PolicyViolation {
+	var violations []PolicyViolation
+	if b.MembershipExpiresAt != nil && !now.Before(*b.MembershipExpiresAt) {
+		violations = append(violations, PolicyViolation{RuleMembershipExpired,
+			fmt.Sprintf("Membership expired on %s", b.MembershipExpiresAt.Format("2006-01-02"))})
+	}
+	if b.BalanceCents > maxBalanceCents {
+		violations = append(violations, PolicyViolation{RuleFineBalance,
+			fmt.Sprintf("Balance of %d cents exceeds the borrowing limit of %d cents", b.BalanceCents, maxBalanceCents)})
+	}
+	for _, item := range b.ActiveLoans {
+		if bookID != 0 && item.BookID == bookID {
+			violations = append(violations, PolicyViolation{RuleDuplicateLoan, "A copy of this book is already on loan to the patron"})
+			break
+		}
+	}
+	for _, policy := range policies {
+		if policy.Role != b.Role || (policy.MaterialType != AnyMaterialType && policy.MaterialType != materialType) {
+			continue
+		}
+		var onLoan int
+		for _, item := range b.ActiveLoans {
+			if policy.MaterialType == AnyMaterialType || item.MaterialType == policy.MaterialType {
+				onLoan++
+			}
+		}
+		if onLoan >= policy.MaxLoans {
+			what := "items"
+			if policy.MaterialType != AnyMaterialType {
+				what = policy.MaterialType + " items"
+			}
+			violations = append(violations, PolicyViolation{RuleMaxLoans,
+				fmt.Sprintf("Already borrowing %d of at most %d %s", onLoan, policy.MaxLoans, what)})
+		}
+	}
+	return violations
+}
@@ -0,0 +1,22 @@
+// Package models defines the data structures used throughout the application.
+package models
+
+import "time"
+
+// Reasons a login attempt failed.
+const (
+	LoginUnknownUser   = "unknown_user"
+	LoginWrongPassword = "wrong_password"
+	LoginWrongCode     = "wrong_code" // The password was right but the second factor was not
+	LoginLockedOut     = "locked_out" // The account was locked after too many failures
+)
+
+// LoginAttempt records a failed login, so librarians can spot password spraying and
+// brute-force attacks.
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,72 @@
+// Package models defines the data structures used throughout the application.
+package models
+
+// DefaultRole is the role new users are registered with. It cannot be deleted.
+const DefaultRole = "member"
+
+// Permissions name the actions a role can grant. Every signed-in user may use the
+// self-service routes (their own loans, holds and account); permissions cover the rest.
+const (
+	PermAuthorsWrite       = "authors:write"
+	PermAuthorsMerge       = "authors:merge"
+	PermBooksWrite         = "books:write"
+	PermCopiesManage       = "copies:manage"
+	PermLoansReadAll       = "loans:read_all"
+	PermLoansManage        = "loans:manage"
+	PermCirculationDesk    = "circulation:desk"
+	PermHoldsManage        = "holds:manage"
+	PermAccountsRead       = "accounts:read"
+	PermAccountsWrite      = "accounts:write"
+	PermFinePoliciesManage = "fine_policies:manage"
+	PermLoanPoliciesManage = "loan_policies:manage"
+	PermRolesManage        = "roles:manage"
+	PermUsersManage        = "users:manage"
+	PermSystemRead         = "system:read"
+	PermSecurityRead       = "security:read"
+	PermAuditRead          = "audit:read"
+)
+
+// Permission describes a permission in the catalog returned by GET /permissions.
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// AllPermissions is the catalog of every permission a role can grant.
+var AllPermissions = []Permission{
+	{PermAuthorsWrite, "Create, update and delete authors"},
+	{PermAuthorsMerge, "Merge duplicate authors, moving their books to the author kept"},
+	{PermBooksWrite, "Create, update and delete books"},
+	{PermCopiesManage, "List, add, update and remove the copies of a book"},
+	{PermLoansReadAll, "List the loans of every patron"},
+	{PermLoansManage, "Renew and return the loans of other patrons"},
+	{PermCirculationDesk, "Check copies out to and in from any patron, and look patrons up, at the circulation desk"},
+	{PermHoldsManage, "Cancel the holds of other patrons"},
+	{PermAccountsRead, "View the account of any patron"},
+	{PermAccountsWrite, "Record charges, payments and waivers on patron accounts"},
+	{PermFinePoliciesManage, "View and change fine policies"},
+	{PermLoanPoliciesManage, "View and change how many items each role may borrow"},
+	{PermRolesManage, "Create, update and delete roles"},
+	{PermUsersManage, "Assign roles to users and set when their memberships expire"},
+	{PermSystemRead, "View cache statistics"},
+	{PermSecurityRead, "View failed login attempts"},
+	{PermAuditRead, "Review the audit log of changes and verify its hash chain"},
+}
+
+// IsPermission reports whether name is a permission in the catalog.
+func IsPermission(name string) bool {
+	for _, p := range AllPermissions {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Role is a named bundle of permissions that users are assigned.
+// It includes struct tags for JSON marshaling and validation.
+type Role struct {
+	Name        string   `json:"name" validate:"required,max=32"`
+	Description string   `json:"description" validate:"max=255"`
+	Permissions []string `json:"permissions" validate:"dive,permission"`
+}
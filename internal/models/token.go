@@ -0,0 +1,30 @@
+// Package models defines the data structures used throughout the application.
+package models
+
+import "time"
+
+// RefreshToken is a single-use token a client exchanges for a new access token.
+// Only its hash is stored; the token itself is given to the client once.
+type RefreshToken struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	// SessionID is shared by every refresh token rotated from the same login.
+	SessionID string    `json:"session_id"`
+	TokenHash string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// RevokedAt is set once the token has been rotated or its session logged out.
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// PasswordResetToken lets a user choose a new password without knowing the current one.
+// Librarians issue them; each can be used once, before it expires.
+type PasswordResetToken struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	TokenHash string     `json:"-"`
+	CreatedBy int64      `json:"created_by"` // The librarian who issued it
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
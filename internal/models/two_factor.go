@@ -0,0 +1,21 @@
+// Package models defines the data structures used throughout the application.
+package models
+
+import "time"
+
+// TwoFactor is a user's TOTP authenticator. It is pending from enrollment until the user
+// confirms it with a code, and enabled from then on.
+type TwoFactor struct {
+	UserID    int64      `json:"user_id"`
+	Secret    string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	EnabledAt *time.Time `json:"enabled_at,omitempty"`
+	// LastStep is the TOTP time step of the last code accepted; codes up to it are refused.
+	LastStep          int64 `json:"-"`
+	RecoveryCodesLeft int   `json:"recovery_codes_left"`
+}
+
+// Enabled reports whether the authenticator has been confirmed.
+func (t *TwoFactor) Enabled() bool {
+	return t.EnabledAt != nil
+}
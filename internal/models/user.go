@@ -1,6 +1,8 @@
 // Package models defines the data structures used throughout the application.
 package models
 
+import "time"
+
 // User represents a user account in the system.
 // It includes struct tags for JSON marshaling and validation.
 type User struct {
@@ -9,6 +11,11 @@ type User struct {
 	// Username is the unique name for the user account.
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Role     string `json:"role"`
+	// MembershipExpiresAt is when the user's membership lapses, from which time they cannot
+	// borrow. It is nil for memberships that never expire.
+	MembershipExpiresAt *time.Time `json:"membership_expires_at,omitempty"`
+	// TwoFactorEnabled is set once the user has confirmed a TOTP authenticator.
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
 	// PasswordHash is the hashed version of the user's password.
 	// The json:"-" tag ensures this field is never exposed in API responses.
 	PasswordHash string `json:"-"`
@@ -0,0 +1,150 @@
+// Package ratelimit throttles requests and locks accounts after repeated failed logins.
+// This file contains the in-process backends.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pruneEvery is how many calls the memory backends serve between sweeps of stale entries.
+const pruneEvery = 1000
+
+// bucket is the state of one token bucket.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration // Time to refill completely, after which the bucket can be dropped
+}
+
+// memoryLimiter keeps token buckets in process memory. They are lost on restart and not
+// shared between instances, so it suits a single instance without Redis.
+type memoryLimiter struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	buckets map[string]*bucket
+	calls   int
+}
+
+// NewMemoryLimiter creates an in-process limiter.
+func NewMemoryLimiter() Limiter {
+	return &memoryLimiter{now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+func (l *memoryLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.calls++; l.calls%pruneEvery == 0 {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.period = limit.Period
+	refilled := float64(now.Sub(b.updated)) / float64(limit.interval())
+	b.tokens = min(float64(limit.Burst), b.tokens+refilled)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return Result{Allowed: true}, nil
+	}
+	wait := time.Duration((1 - b.tokens) * float64(limit.interval()))
+	return Result{RetryAfter: wait}, nil
+}
+
+// prune drops buckets that have refilled completely by now, which are no different from
+// missing ones. The caller must hold l.mu.
+func (l *memoryLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= b.period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// failures is the lockout state of one account.
+type failures struct {
+	count       int
+	first       time.Time // When the first failure still counted happened
+	lockedUntil time.Time
+}
+
+// memoryLockout counts failed logins in process memory.
+type memoryLockout struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	policy   LockoutPolicy
+	accounts map[string]*failures
+	calls    int
+}
+
+// NewMemoryLockout creates an in-process lockout enforcing policy.
+func NewMemoryLockout(policy LockoutPolicy) Lockout {
+	return &memoryLockout{now: time.Now, policy: policy, accounts: make(map[string]*failures)}
+}
+
+func (l *memoryLockout) LockedFor(ctx context.Context, account string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f, ok := l.accounts[account]; ok {
+		if remaining := f.lockedUntil.Sub(l.now()); remaining > 0 {
+			return remaining, nil
+		}
+	}
+	return 0, nil
+}
+
+func (l *memoryLockout) Fail(ctx context.Context, account string) (time.Duration, error) {
+	if l.policy.Threshold <= 0 {
+		return 0, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.calls++; l.calls%pruneEvery == 0 {
+		l.prune(now)
+	}
+
+	f, ok := l.accounts[account]
+	if !ok || now.Sub(f.first) >= l.policy.Duration {
+		f = &failures{first: now}
+		l.accounts[account] = f
+	}
+	f.count++
+	if f.count < l.policy.Threshold {
+		return 0, nil
+	}
+	// The failures that caused the lock are forgotten, so the account gets a full set of
+	// attempts once it is unlocked.
+	f.count = 0
+	f.first = now
+	f.lockedUntil = now.Add(l.policy.Duration)
+	return l.policy.Duration, nil
+}
+
+func (l *memoryLockout) Reset(ctx context.Context, account string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.accounts, account)
+	return nil
+}
+
+// prune drops accounts that are unlocked and whose failures have expired. The caller must hold l.mu.
+func (l *memoryLockout) prune(now time.Time) {
+	for account, f := range l.accounts {
+		if now.After(f.lockedUntil) && now.Sub(f.first) >= l.policy.Duration {
+			delete(l.accounts, account)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package ratelimit contains tests for the in-process limiter and lockout.
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestMemoryLimiter_TokenBucket tests that a burst is allowed at once, that the next
+// request is told when to retry, and that tokens come back at the configured rate.
+func TestMemoryLimiter_TokenBucket(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLimiter().(*memoryLimiter)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	limit := PerMinute(3)
+
+	for i := 0; i < 3; i++ {
+		if result, _ := l.Allow(ctx, "ip:10.0.0.1", limit); !result.Allowed {
+			t.Fatalf("expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	result, _ := l.Allow(ctx, "ip:10.0.0.1", limit)
+	if result.Allowed || result.RetryAfter != 20*time.Second {
+		t.Errorf("expected the fourth request to be refused for 20s, but got %+v", result)
+	}
+	if result, _ := l.Allow(ctx, "ip:10.0.0.2", limit); !result.Allowed {
+		t.Errorf("expected another key to have its own bucket")
+	}
+
+	now = now.Add(20 * time.Second)
+	if result, _ := l.Allow(ctx, "ip:10.0.0.1", limit); !result.Allowed {
+		t.Errorf("expected a token to be refilled after 20s")
+	}
+	if result, _ := l.Allow(ctx, "ip:10.0.0.1", limit); result.Allowed {
+		t.Errorf("expected only one token to be refilled")
+	}
+}
+
+// TestMemoryLimiter_Disabled tests that a zero limit allows every request.
+func TestMemoryLimiter_Disabled(t *testing.T) {
+	l := NewMemoryLimiter()
+	for i := 0; i < 100; i++ {
+		if result, _ := l.Allow(context.Background(), "ip:10.0.0.1", PerMinute(0)); !result.Allowed {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+	}
+}
+
+// TestMemoryLockout tests that an account is locked after the threshold of failures,
+// unlocks once the lock expires, and that a successful login clears its failures.
+func TestMemoryLockout(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLockout(LockoutPolicy{Threshold: 3, Duration: 15 * time.Minute}).(*memoryLockout)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	l.Fail(ctx, "alice")
+	l.Fail(ctx, "alice")
+	l.Reset(ctx, "alice")
+	l.Fail(ctx, "alice")
+	l.Fail(ctx, "alice")
+	if locked, _ := l.LockedFor(ctx, "alice"); locked != 0 {
+		t.Fatalf("expected failures before a successful login to be forgotten, but locked for %s", locked)
+	}
+
+	if locked, _ := l.Fail(ctx, "alice"); locked != 15*time.Minute {
+		t.Fatalf("expected the third failure in a row to lock the account for 15m, but got %s", locked)
+	}
+	now = now.Add(5 * time.Minute)
+	if locked, _ := l.LockedFor(ctx, "alice"); locked != 10*time.Minute {
+		t.Errorf("expected the account to stay locked for 10m, but got %s", locked)
+	}
+	if locked, _ := l.LockedFor(ctx, "bob"); locked != 0 {
+		t.Errorf("expected other accounts not to be locked")
+	}
+
+	now = now.Add(10 * time.Minute)
+	if locked, _ := l.LockedFor(ctx, "alice"); locked != 0 {
+		t.Errorf("expected the lock to have expired, but got %s", locked)
+	}
+}
@@ -0,0 +1,62 @@
+// Package ratelimit throttles requests with token buckets and locks accounts after repeated
+// failed logins. State is kept in process memory for a single instance, or in Redis, where
+// every instance shares it.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit describes a token bucket: Burst requests may be made at once, after which the
+// bucket refills at Burst requests per Period.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// PerMinute returns a limit of n requests a minute, all of which may be made at once.
+func PerMinute(n int) Limit {
+	return Limit{Burst: n, Period: time.Minute}
+}
+
+// Enabled reports whether the limit restricts anything. A zero Limit allows every request.
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Period > 0
+}
+
+// interval returns how long the bucket takes to refill one token.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Burst)
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+	// RetryAfter is how long until the bucket has a token again, when the request was refused.
+	RetryAfter time.Duration
+}
+
+// Limiter keeps a token bucket per key.
+type Limiter interface {
+	// Allow takes a token from the bucket under key, which holds at most limit.Burst tokens.
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// LockoutPolicy locks an account for Duration once Threshold logins have failed without
+// one succeeding in between. Failures older than Duration are forgotten.
+type LockoutPolicy struct {
+	Threshold int // 0 never locks
+	Duration  time.Duration
+}
+
+// Lockout counts failed logins per account.
+type Lockout interface {
+	// LockedFor returns how long the account stays locked, or 0 when it is not locked.
+	LockedFor(ctx context.Context, account string) (time.Duration, error)
+	// Fail records a failed login, returning how long it locked the account for, or 0
+	// when the account is not locked yet.
+	Fail(ctx context.Context, account string) (time.Duration, error)
+	// Reset forgets the failures of an account, after it logs in successfully.
+	Reset(ctx context.Context, account string) error
+}
@@ -0,0 +1,110 @@
+// Package ratelimit throttles requests and locks accounts after repeated failed logins.
+// This file contains the Redis backends.
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript takes a token from the bucket in KEYS[1] atomically, so instances
+// sharing it cannot both take the last one. ARGV holds the burst, the period in
+// milliseconds and the current time in milliseconds. It returns whether a token was taken
+// and, if not, how many milliseconds until one is available.
+var tokenBucketScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * burst / period)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * period / burst)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], period)
+return {allowed, wait}
+`)
+
+// redisLimiter keeps token buckets in Redis, shared by every instance. A bucket expires
+// once it would have refilled completely.
+type redisLimiter struct {
+	client *redis.Client
+}
+
+// NewRedisLimiter creates a limiter backed by the given Redis client.
+func NewRedisLimiter(client *redis.Client) Limiter {
+	return &redisLimiter{client: client}
+}
+
+func (l *redisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	values, err := tokenBucketScript.Run(ctx, l.client, []string{"ratelimit:" + key},
+		limit.Burst, limit.Period.Milliseconds(), time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Allowed: values[0] == 1, RetryAfter: time.Duration(values[1]) * time.Millisecond}, nil
+}
+
+// redisLockout counts failed logins in Redis. The failure counter expires Duration after
+// the first failure it counts, and the lock Duration after it was set.
+type redisLockout struct {
+	client *redis.Client
+	policy LockoutPolicy
+}
+
+// NewRedisLockout creates a lockout enforcing policy, backed by the given Redis client.
+func NewRedisLockout(client *redis.Client, policy LockoutPolicy) Lockout {
+	return &redisLockout{client: client, policy: policy}
+}
+
+func (l *redisLockout) LockedFor(ctx context.Context, account string) (time.Duration, error) {
+	ttl, err := l.client.PTTL(ctx, "lockout:locked:"+account).Result()
+	if err != nil || ttl < 0 {
+		// A negative TTL means the key does not exist.
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (l *redisLockout) Fail(ctx context.Context, account string) (time.Duration, error) {
+	if l.policy.Threshold <= 0 {
+		return 0, nil
+	}
+	key := "lockout:failures:" + account
+	count, err := l.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if err := l.client.PExpire(ctx, key, l.policy.Duration).Err(); err != nil {
+			return 0, err
+		}
+	}
+	if count < int64(l.policy.Threshold) {
+		return 0, nil
+	}
+	_, err = l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "lockout:locked:"+account, 1, l.policy.Duration)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return l.policy.Duration, nil
+}
+
+func (l *redisLockout) Reset(ctx context.Context, account string) error {
+	return l.client.Del(ctx, "lockout:failures:"+account, "lockout:locked:"+account).Err()
+}
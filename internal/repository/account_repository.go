@@ -0,0 +1,121 @@
+// Package repository provides a data abstraction layer.
+// This file contains the implementation for patron account (fines ledger) operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// AccountRepository defines the interface for patron account operations.
+type AccountRepository interface {
+	AddEntry(ctx context.Context, entry models.AccountEntry) (int64, error)
+	GetAccount(ctx context.Context, userID int64) (*models.Account, error)
+}
+
+// accountBalanceSQL sums a patron's ledger into a balance in cents. Callers append
+// the user_id placeholder for their dialect.
+const accountBalanceSQL = "SELECT COALESCE(SUM(CASE WHEN kind = 'charge' THEN amount_cents ELSE -amount_cents END), 0) FROM account_entries WHERE user_id = "
+
+// sqliteAccountRepository is the concrete implementation for SQLite.
+type sqliteAccountRepository struct {
+	DB *sql.DB
+}
+
+// NewSQLiteAccountRepository creates a new repository instance.
+func NewSQLiteAccountRepository(db *sql.DB) AccountRepository {
+	return &sqliteAccountRepository{DB: db}
+}
+
+// AddEntry records a charge, payment or waiver. Payments and waivers cannot exceed
+// the balance, and an entry for a loan must be for one of the patron's own loans.
+func (r *sqliteAccountRepository) AddEntry(ctx context.Context, entry models.AccountEntry) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var balance int64
+	err = tx.QueryRowContext(ctx, "SELECT ("+accountBalanceSQL+"u.id) FROM users u WHERE u.id = ?", entry.UserID).Scan(&balance)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	if entry.Kind != models.EntryCharge && entry.AmountCents > balance {
+		return 0, ErrExceedsBalance
+	}
+
+	if entry.LoanID != nil {
+		var owned bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM loans WHERE id = ? AND user_id = ?)", *entry.LoanID, entry.UserID).Scan(&owned)
+		if err != nil {
+			return 0, err
+		}
+		if !owned {
+			return 0, ErrLoanNotOwned
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO account_entries (user_id, loan_id, kind, amount_cents, note, recorded_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		entry.UserID, entry.LoanID, entry.Kind, entry.AmountCents, entry.Note, entry.RecordedBy, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// GetAccount returns a patron's ledger, newest entry first, and its balance.
+func (r *sqliteAccountRepository) GetAccount(ctx context.Context, userID int64) (*models.Account, error) {
+	var exists bool
+	if err := r.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)", userID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, user_id, loan_id, kind, amount_cents, note, recorded_by, created_at FROM account_entries WHERE user_id = ? ORDER BY created_at DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAccount(userID, rows)
+}
+
+// scanAccount builds an Account from account_entries rows, totalling the balance as it goes.
+func scanAccount(userID int64, rows *sql.Rows) (*models.Account, error) {
+	account := models.Account{UserID: userID, Entries: []models.AccountEntry{}}
+	for rows.Next() {
+		var entry models.AccountEntry
+		var loanID, recordedBy sql.NullInt64
+		if err := rows.Scan(&entry.ID, &entry.UserID, &loanID, &entry.Kind, &entry.AmountCents, &entry.Note, &recordedBy, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if loanID.Valid {
+			entry.LoanID = &loanID.Int64
+		}
+		if recordedBy.Valid {
+			entry.RecordedBy = &recordedBy.Int64
+		}
+		if entry.Kind == models.EntryCharge {
+			account.BalanceCents += entry.AmountCents
+		} else {
+			account.BalanceCents -= entry.AmountCents
+		}
+		account.Entries = append(account.Entries, entry)
+	}
+	return &account, rows.Err()
+}
@@ -0,0 +1,173 @@
+// Package repository provides a data abstraction layer.
+// This file contains the implementation for audit log operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/audit"
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// AuditFilter holds the criteria for searching the audit log.
+type AuditFilter struct {
+	ActorID    *int64
+	Action     *string
+	EntityType *string
+	EntityID   *int64
+	RequestID  *string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+// AuditRepository defines the interface for reading the audit log. Entries are written
+// by the repositories that make the changes, within their transactions.
+type AuditRepository interface {
+	Search(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEntry, int, error)
+	Verify(ctx context.Context) (*models.AuditVerification, error)
+}
+
+// sqliteAuditRepository is the concrete implementation for SQLite.
+type sqliteAuditRepository struct {
+	DB *sql.DB
+}
+
+// NewSQLiteAuditRepository creates a new repository instance.
+func NewSQLiteAuditRepository(db *sql.DB) AuditRepository {
+	return &sqliteAuditRepository{DB: db}
+}
+
+// auditColumns are the columns scanAuditEntry reads, in order.
+const auditColumns = "id, created_at, actor_id, actor, action, entity_type, entity_id, changes, request_id, ip, prev_hash, hash"
+
+// Search returns a page of the audit entries matching filter, newest first, along with
+// the total number of matches.
+func (r *sqliteAuditRepository) Search(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEntry, int, error) {
+	whereClause := " WHERE 1=1"
+	var args []interface{}
+	if filter.ActorID != nil {
+		whereClause += " AND actor_id = ?"
+		args = append(args, *filter.ActorID)
+	}
+	if filter.Action != nil {
+		whereClause += " AND action = ?"
+		args = append(args, *filter.Action)
+	}
+	if filter.EntityType != nil {
+		whereClause += " AND entity_type = ?"
+		args = append(args, *filter.EntityType)
+	}
+	if filter.EntityID != nil {
+		whereClause += " AND entity_id = ?"
+		args = append(args, *filter.EntityID)
+	}
+	if filter.RequestID != nil {
+		whereClause += " AND request_id = ?"
+		args = append(args, *filter.RequestID)
+	}
+	if filter.Since != nil {
+		whereClause += " AND julianday(created_at) >= julianday(?)"
+		args = append(args, *filter.Since)
+	}
+	if filter.Until != nil {
+		whereClause += " AND julianday(created_at) < julianday(?)"
+		args = append(args, *filter.Until)
+	}
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log"+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_log"+whereClause+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var entries []models.AuditEntry
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// Verify checks the hash chain of the whole log, oldest entry first.
+func (r *sqliteAuditRepository) Verify(ctx context.Context) (*models.AuditVerification, error) {
+	return verifyAuditLog(ctx, r.DB)
+}
+
+// verifyAuditLog checks the hash chain of the whole log, oldest entry first. The query
+// is the same in every dialect.
+func verifyAuditLog(ctx context.Context, db *sql.DB) (*models.AuditVerification, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_log ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &models.AuditVerification{Intact: true}
+	var verifier audit.Verifier
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Checked++
+		if !verifier.Check(entry) {
+			result.Intact = false
+			result.BrokenAt = entry.ID
+			break
+		}
+	}
+	return result, rows.Err()
+}
+
+// scanAuditEntry reads the auditColumns of one audit_log row.
+func scanAuditEntry(rows *sql.Rows) (models.AuditEntry, error) {
+	var entry models.AuditEntry
+	var actorID sql.NullInt64
+	var changes string
+	var prevHash, hash sql.NullString
+	err := rows.Scan(&entry.ID, &entry.CreatedAt, &actorID, &entry.Actor, &entry.Action, &entry.EntityType, &entry.EntityID,
+		&changes, &entry.RequestID, &entry.IP, &prevHash, &hash)
+	if err != nil {
+		return entry, err
+	}
+	if actorID.Valid {
+		entry.ActorID = &actorID.Int64
+	}
+	entry.Changes = []byte(changes)
+	entry.PrevHash = prevHash.String
+	entry.Hash = hash.String
+	return entry, nil
+}
+
+// recordAuditSQLite appends the entry for a change made within tx, attributed to the
+// actor of ctx. It must come after the change's own writes: the transaction then holds
+// SQLite's write lock, so no other entry can be chained in between.
+func recordAuditSQLite(ctx context.Context, tx *sql.Tx, action, entityType string, entityID int64, before, after interface{}) error {
+	entry, err := audit.NewEntry(ctx, action, entityType, entityID, before, after)
+	if err != nil {
+		return err
+	}
+	var prevHash, hash sql.NullString
+	if audit.HashChain() {
+		err := tx.QueryRowContext(ctx, "SELECT hash FROM audit_log WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		hash = sql.NullString{String: audit.Hash(prevHash.String, entry), Valid: true}
+		prevHash.Valid = true
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO audit_log (created_at, actor_id, actor, action, entity_type, entity_id, changes, request_id, ip, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.CreatedAt, entry.ActorID, entry.Actor, entry.Action, entry.EntityType, entry.EntityID, string(entry.Changes), entry.RequestID, entry.IP, prevHash, hash)
+	return err
+}
@@ -0,0 +1,199 @@
+// Package repository contains tests for the repository layer.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Lec7ral/fullAPI/internal/audit"
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// auditInserts maps each backend to the statement it appends audit entries with.
+var auditInserts = map[string]string{
+	"sqlite":   "INSERT INTO audit_log (created_at, actor_id, actor, action, entity_type, entity_id, changes, request_id, ip, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	"postgres": "INSERT INTO audit_log (created_at, actor_id, actor, action, entity_type, entity_id, changes, request_id, ip, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
+}
+
+// expectAudit expects backend to append an unchained audit entry for the change, with the
+// given changes, or any changes if changes is sqlmock.AnyArg().
+func expectAudit(mock sqlmock.Sqlmock, backend, action, entityType string, entityID int64, changes interface{}) {
+	mock.ExpectExec(regexp.QuoteMeta(auditInserts[backend])).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), action, entityType, entityID, changes,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullString{}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// auditBackends lists the AuditRepository implementations every test runs against,
+// along with the statements each one issues.
+var auditBackends = []struct {
+	name     string
+	newRepo  func(*sql.DB) AuditRepository
+	record   func(context.Context, *sql.Tx, string, string, int64, interface{}, interface{}) error
+	count    string
+	search   string
+	lastHash string
+}{
+	{
+		name:     "sqlite",
+		newRepo:  NewSQLiteAuditRepository,
+		record:   recordAuditSQLite,
+		count:    "SELECT COUNT(*) FROM audit_log WHERE 1=1 AND entity_type = ? AND entity_id = ?",
+		search:   "SELECT " + auditColumns + " FROM audit_log WHERE 1=1 AND entity_type = ? AND entity_id = ? ORDER BY id DESC LIMIT ? OFFSET ?",
+		lastHash: "SELECT hash FROM audit_log WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1",
+	},
+	{
+		name:     "postgres",
+		newRepo:  NewPostgresAuditRepository,
+		record:   recordAuditPostgres,
+		count:    "SELECT COUNT(*) FROM audit_log WHERE 1=1 AND entity_type = $1 AND entity_id = $2",
+		search:   "SELECT " + auditColumns + " FROM audit_log WHERE 1=1 AND entity_type = $1 AND entity_id = $2 ORDER BY id DESC LIMIT $3 OFFSET $4",
+		lastHash: "SELECT hash FROM audit_log WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1",
+	},
+}
+
+// auditRows returns rows holding entries, as read with auditColumns.
+func auditRows(entries ...models.AuditEntry) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "created_at", "actor_id", "actor", "action", "entity_type", "entity_id", "changes", "request_id", "ip", "prev_hash", "hash"})
+	for _, e := range entries {
+		var actorID, prevHash, hash interface{}
+		if e.ActorID != nil {
+			actorID = *e.ActorID
+		}
+		if e.Hash != "" {
+			prevHash, hash = e.PrevHash, e.Hash
+		}
+		rows.AddRow(e.ID, e.CreatedAt, actorID, e.Actor, e.Action, e.EntityType, e.EntityID, string(e.Changes), e.RequestID, e.IP, prevHash, hash)
+	}
+	return rows
+}
+
+// TestSearchAudit_Filters tests that filters narrow both the count and the page of entries,
+// and that entries by anonymous actors have no actor ID.
+func TestSearchAudit_Filters(t *testing.T) {
+	for _, backend := range auditBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			actorID := int64(1)
+			entityType, entityID := models.AuditUser, int64(4)
+
+			mock.ExpectQuery(regexp.QuoteMeta(backend.count)).
+				WithArgs(entityType, entityID).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.search)).
+				WithArgs(entityType, entityID, 50, 0).
+				WillReturnRows(auditRows(
+					models.AuditEntry{ID: 9, CreatedAt: time.Now(), ActorID: &actorID, Actor: "lib", Action: models.AuditRoleChange,
+						EntityType: entityType, EntityID: entityID, Changes: []byte(`{"role":{"from":"member","to":"librarian"}}`)},
+					models.AuditEntry{ID: 2, CreatedAt: time.Now(), Action: models.AuditCreate,
+						EntityType: entityType, EntityID: entityID, Changes: []byte(`{}`)},
+				))
+
+			entries, total, err := repo.Search(context.Background(), AuditFilter{EntityType: &entityType, EntityID: &entityID}, 50, 0)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if total != 2 || len(entries) != 2 {
+				t.Fatalf("expected 2 of 2 entries, but got %d of %d", len(entries), total)
+			}
+			if entries[0].ActorID == nil || *entries[0].ActorID != actorID || entries[1].ActorID != nil {
+				t.Errorf("expected only the first entry to have an actor ID, but got %v and %v", entries[0].ActorID, entries[1].ActorID)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestRecordAudit_HashChain tests that, with the hash chain on, an entry is chained after
+// the last chained entry, and that PostgreSQL serializes appends with an advisory lock.
+func TestRecordAudit_HashChain(t *testing.T) {
+	audit.SetHashChain(true)
+	defer audit.SetHashChain(false)
+
+	for _, backend := range auditBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			mock.ExpectBegin()
+			if backend.name == "postgres" {
+				mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock(hashtext('audit_log'))")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(backend.lastHash)).
+				WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("prev"))
+			mock.ExpectExec(regexp.QuoteMeta(auditInserts[backend.name])).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), models.AuditDelete, models.AuditBook, 7, sqlmock.AnyArg(),
+					sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{String: "prev", Valid: true}, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := backend.record(context.Background(), tx, models.AuditDelete, models.AuditBook, 7, &bookAudit{Title: "Dune"}, nil); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestVerifyAudit_Tampered tests that verification stops at the first entry whose contents
+// no longer match its hash.
+func TestVerifyAudit_Tampered(t *testing.T) {
+	for _, backend := range auditBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+			first := models.AuditEntry{ID: 1, CreatedAt: created, Action: models.AuditCreate, EntityType: models.AuditBook, EntityID: 1, Changes: []byte(`{}`)}
+			first.Hash = audit.Hash("", first)
+			second := models.AuditEntry{ID: 2, CreatedAt: created, Action: models.AuditDelete, EntityType: models.AuditBook, EntityID: 1, Changes: []byte(`{}`), PrevHash: first.Hash}
+			second.Hash = audit.Hash(first.Hash, second)
+			second.EntityID = 2
+
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT " + auditColumns + " FROM audit_log ORDER BY id")).
+				WillReturnRows(auditRows(first, second))
+
+			result, err := repo.Verify(context.Background())
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if result.Intact || result.BrokenAt != 2 || result.Checked != 2 {
+				t.Errorf("expected the chain to break at entry 2, but got %+v", result)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -3,17 +3,34 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/Lec7ral/fullAPI/internal/models"
 )
 
+// AuthorFilter holds the criteria for searching authors.
+type AuthorFilter struct {
+	// Name matches authors whose name contains it, ignoring case.
+	Name *string
+}
+
 // AuthorRepository defines the interface for author data operations.
 type AuthorRepository interface {
-	Create(author models.Author) (int64, error)
-	GetAll() ([]models.Author, error)
-	GetByID(id int64) (*models.Author, error)
+	Create(ctx context.Context, author models.Author) (int64, error)
+	Update(ctx context.Context, id int64, author models.Author) error
+	// Delete removes the author. If books reference the author it returns ErrAuthorHasBooks,
+	// unless cascade is set, in which case the books are deleted too. It returns the IDs of
+	// the deleted books.
+	Delete(ctx context.Context, id int64, cascade bool) ([]int64, error)
+	// Merge moves the books of the authors sourceIDs to the author id and deletes them, for
+	// authors entered more than once. It returns the IDs of the moved books.
+	Merge(ctx context.Context, id int64, sourceIDs []int64) ([]int64, error)
+	GetByID(ctx context.Context, id int64) (*models.Author, error)
+	Search(ctx context.Context, filter AuthorFilter, limit, offset int, sort, order string) ([]models.Author, int, error)
 }
 
 // sqliteAuthorRepository is the concrete implementation for SQLite.
@@ -26,41 +43,179 @@ func NewSQLiteAuthorRepository(db *sql.DB) AuthorRepository {
 	return &sqliteAuthorRepository{DB: db}
 }
 
-func (r *sqliteAuthorRepository) Create(author models.Author) (int64, error) {
-	stmt, err := r.DB.Prepare("INSERT INTO authors (name, bio) VALUES (?, ?)")
+func (r *sqliteAuthorRepository) Create(ctx context.Context, author models.Author) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO authors (name, bio) VALUES (?, ?)", author.Name, author.Bio)
 	if err != nil {
 		return 0, err
 	}
-	result, err := stmt.Exec(author.Name, author.Bio)
+	id, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+
+	if err := recordAuditSQLite(ctx, tx, models.AuditCreate, models.AuditAuthor, id, nil, authorAuditOf(author)); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
 }
 
-func (r *sqliteAuthorRepository) GetAll() ([]models.Author, error) {
-	query := "SELECT id, name, bio FROM authors"
-	rows, err := r.DB.Query(query)
+// Update changes the author's name and bio.
+func (r *sqliteAuthorRepository) Update(ctx context.Context, id int64, author models.Author) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := authorAuditSQLite(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE authors SET name = ?, bio = ? WHERE id = ?", author.Name, author.Bio, id); err != nil {
+		return err
+	}
+
+	if err := recordAuditSQLite(ctx, tx, models.AuditUpdate, models.AuditAuthor, id, before, authorAuditOf(author)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes the author, along with their books and the books' copies and holds when
+// cascade is set. The cascade is refused with ErrBookHasLoans, before anything is deleted,
+// if the copies of any of the books have loans.
+func (r *sqliteAuthorRepository) Delete(ctx context.Context, id int64, cascade bool) ([]int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var authors []models.Author
-	for rows.Next() {
-		var author models.Author
-		if err := rows.Scan(&author.ID, &author.Name, &author.Bio); err != nil {
+	before, err := authorAuditSQLite(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	books, err := authoredBooksSQLite(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) > 0 && !cascade {
+		return nil, ErrAuthorHasBooks
+	}
+	if len(books) > 0 {
+		var loanCount int
+		err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id JOIN books b ON b.id = c.book_id WHERE b.author_id = ?", id).Scan(&loanCount)
+		if err != nil {
+			return nil, err
+		}
+		if loanCount > 0 {
+			return nil, ErrBookHasLoans
+		}
+	}
+	bookIDs := make([]int64, len(books))
+	for i, book := range books {
+		if err := deleteBookSQLite(ctx, tx, book.ID); err != nil {
+			return nil, err
+		}
+		bookIDs[i] = book.ID
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM authors WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+
+	if err := recordAuditSQLite(ctx, tx, models.AuditDelete, models.AuditAuthor, id, before, nil); err != nil {
+		return nil, err
+	}
+
+	return bookIDs, tx.Commit()
+}
+
+// Merge repoints the books of each source author to the author id and deletes the source
+// author, recording every change. An ID in sourceIDs equal to id is skipped.
+func (r *sqliteAuthorRepository) Merge(ctx context.Context, id int64, sourceIDs []int64) ([]int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := authorAuditSQLite(ctx, tx, id); err != nil {
+		return nil, err
+	}
+
+	var moved []int64
+	for _, sourceID := range sourceIDs {
+		if sourceID == id {
+			continue
+		}
+		before, err := authorAuditSQLite(ctx, tx, sourceID)
+		if err != nil {
+			return nil, err
+		}
+		books, err := authoredBooksSQLite(ctx, tx, sourceID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE books SET author_id = ? WHERE author_id = ?", id, sourceID); err != nil {
+			return nil, err
+		}
+		for _, book := range books {
+			after := book.bookAudit
+			after.AuthorID = id
+			if err := recordAuditSQLite(ctx, tx, models.AuditUpdate, models.AuditBook, book.ID, &book.bookAudit, &after); err != nil {
+				return nil, err
+			}
+			moved = append(moved, book.ID)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM authors WHERE id = ?", sourceID); err != nil {
+			return nil, err
+		}
+		if err := recordAuditSQLite(ctx, tx, models.AuditDelete, models.AuditAuthor, sourceID, before, nil); err != nil {
 			return nil, err
 		}
-		authors = append(authors, author)
 	}
-	return authors, nil
+
+	return moved, tx.Commit()
 }
 
-func (r *sqliteAuthorRepository) GetByID(id int64) (*models.Author, error) {
+// Search returns a page of the authors matching filter, ordered by sort and order, along
+// with the number of matching authors.
+func (r *sqliteAuthorRepository) Search(ctx context.Context, filter AuthorFilter, limit, offset int, sort, order string) ([]models.Author, int, error) {
+	var args []interface{}
+	whereClause := " WHERE 1=1"
+	if filter.Name != nil {
+		whereClause += " AND name LIKE ?"
+		args = append(args, fmt.Sprintf("%%%s%%", *filter.Name))
+	}
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM authors"+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT id, name, bio FROM authors" + whereClause + " ORDER BY " + authorOrderBy(sort, order) + " LIMIT ? OFFSET ?"
+	rows, err := r.DB.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	authors, err := scanAuthors(rows)
+	return authors, total, err
+}
+
+func (r *sqliteAuthorRepository) GetByID(ctx context.Context, id int64) (*models.Author, error) {
 	var author models.Author
 	query := "SELECT id, name, bio FROM authors WHERE id = ?"
-	err := r.DB.QueryRow(query, id).Scan(&author.ID, &author.Name, &author.Bio)
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&author.ID, &author.Name, &author.Bio)
 	if err != nil {
 		// Use errors.Is to check for sql.ErrNoRows and return the shared ErrNotFound.
 		if errors.Is(err, sql.ErrNoRows) {
@@ -70,3 +225,87 @@ func (r *sqliteAuthorRepository) GetByID(id int64) (*models.Author, error) {
 	}
 	return &author, nil
 }
+
+// authorAudit is the state of an author recorded in the audit log.
+type authorAudit struct {
+	Name string `json:"name"`
+	Bio  string `json:"bio"`
+}
+
+// authorAuditOf returns the audited state of author.
+func authorAuditOf(author models.Author) *authorAudit {
+	return &authorAudit{Name: author.Name, Bio: author.Bio}
+}
+
+// authorAuditSQLite reads the audited state of the author within tx, returning ErrNotFound
+// if there is no such author.
+func authorAuditSQLite(ctx context.Context, tx *sql.Tx, id int64) (*authorAudit, error) {
+	var author authorAudit
+	err := tx.QueryRowContext(ctx, "SELECT name, bio FROM authors WHERE id = ?", id).Scan(&author.Name, &author.Bio)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return &author, err
+}
+
+// authoredBook is a book of an author, with its audited state.
+type authoredBook struct {
+	ID int64
+	bookAudit
+}
+
+// authoredBooksSQLite reads the books of the author within tx.
+func authoredBooksSQLite(ctx context.Context, tx *sql.Tx, authorID int64) ([]authoredBook, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT id, title, published_date, isbn, material_type, author_id FROM books WHERE author_id = ? ORDER BY id", authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuthoredBooks(rows)
+}
+
+// scanAuthoredBooks reads the rows of an authored books query.
+func scanAuthoredBooks(rows *sql.Rows) ([]authoredBook, error) {
+	var books []authoredBook
+	for rows.Next() {
+		var book authoredBook
+		if err := rows.Scan(&book.ID, &book.Title, &book.PublishedDate, &book.ISBN, &book.MaterialType, &book.AuthorID); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+// scanAuthors reads the rows of an author query.
+func scanAuthors(rows *sql.Rows) ([]models.Author, error) {
+	var authors []models.Author
+	for rows.Next() {
+		var author models.Author
+		if err := rows.Scan(&author.ID, &author.Name, &author.Bio); err != nil {
+			return nil, err
+		}
+		authors = append(authors, author)
+	}
+	return authors, rows.Err()
+}
+
+// authorSortColumns maps the sort fields accepted by Search to the columns they order by.
+var authorSortColumns = map[string]string{
+	"id":   "id",
+	"name": "name",
+}
+
+// authorOrderBy returns the ORDER BY expressions for the sort field and order accepted by
+// Search. Authors are ordered by ID by default, and ties are broken by ID so pages never
+// overlap.
+func authorOrderBy(sort, order string) string {
+	column, ok := authorSortColumns[sort]
+	if !ok {
+		return "id"
+	}
+	if strings.ToUpper(order) != "DESC" {
+		order = "ASC"
+	}
+	return column + " " + strings.ToUpper(order) + ", id"
+}
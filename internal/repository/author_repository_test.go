@@ -2,6 +2,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"regexp"
@@ -11,93 +12,346 @@ import (
 	"github.com/Lec7ral/fullAPI/internal/models"
 )
 
+// authorBackends lists the AuthorRepository implementations every test runs against,
+// along with the statements their deletes and merges issue.
+var authorBackends = []struct {
+	name          string
+	newRepo       func(*sql.DB) AuthorRepository
+	authorAudit   string
+	authoredBooks string
+	deleteAuthor  string
+	moveBooks     string
+	authorLoans   string
+	bookLoans     string
+	// deleteBook lists the statements deleting a book issues after counting its loans.
+	deleteBook []string
+}{
+	{
+		"sqlite", NewSQLiteAuthorRepository,
+		"SELECT name, bio FROM authors WHERE id = ?",
+		"SELECT id, title, published_date, isbn, material_type, author_id FROM books WHERE author_id = ? ORDER BY id",
+		"DELETE FROM authors WHERE id = ?",
+		"UPDATE books SET author_id = ? WHERE author_id = ?",
+		"SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id JOIN books b ON b.id = c.book_id WHERE b.author_id = ?",
+		"SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id WHERE c.book_id = ?",
+		[]string{"DELETE FROM holds WHERE book_id = ?", "DELETE FROM copies WHERE book_id = ?", "DELETE FROM books WHERE id = ?"},
+	},
+	{
+		"postgres", NewPostgresAuthorRepository,
+		"SELECT name, bio FROM authors WHERE id = $1 FOR UPDATE",
+		"SELECT id, title, published_date, isbn, material_type, author_id FROM books WHERE author_id = $1 ORDER BY id FOR UPDATE",
+		"DELETE FROM authors WHERE id = $1",
+		"UPDATE books SET author_id = $1 WHERE author_id = $2",
+		"SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id JOIN books b ON b.id = c.book_id WHERE b.author_id = $1",
+		"SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id WHERE c.book_id = $1",
+		[]string{"DELETE FROM books WHERE id = $1"},
+	},
+}
+
+// authoredBookColumns are the columns of the authoredBooks query.
+var authoredBookColumns = []string{"id", "title", "published_date", "isbn", "material_type", "author_id"}
+
 // TestCreateAuthor_Success tests the successful creation of an author.
 func TestCreateAuthor_Success(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
-
-	repo := NewSQLiteAuthorRepository(db)
 	authorToCreate := models.Author{
 		Name: "George Orwell",
 		Bio:  "English novelist, essayist, journalist and critic.",
 	}
+	expectations := map[string]func(sqlmock.Sqlmock){
+		"sqlite": func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO authors (name, bio) VALUES (?, ?)")).
+				WithArgs(authorToCreate.Name, authorToCreate.Bio).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		},
+		"postgres": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO authors (name, bio) VALUES ($1, $2) RETURNING id")).
+				WithArgs(authorToCreate.Name, authorToCreate.Bio).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		},
+	}
+	// The entry records every field of a new author as changed from null.
+	changes := `{"bio":{"from":null,"to":"English novelist, essayist, journalist and critic."},"name":{"from":null,"to":"George Orwell"}}`
 
-	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO authors (name, bio) VALUES (?, ?)")).
-		ExpectExec().
-		WithArgs(authorToCreate.Name, authorToCreate.Bio).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	for _, backend := range authorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
 
-	createdID, err := repo.Create(authorToCreate)
+			repo := backend.newRepo(db)
+			mock.ExpectBegin()
+			expectations[backend.name](mock)
+			expectAudit(mock, backend.name, models.AuditCreate, models.AuditAuthor, 1, changes)
+			mock.ExpectCommit()
 
-	if err != nil {
-		t.Errorf("unexpected error: %s", err)
-	}
-	if createdID != 1 {
-		t.Errorf("expected created ID to be 1, but got %d", createdID)
-	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+			createdID, err := repo.Create(context.Background(), authorToCreate)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if createdID != 1 {
+				t.Errorf("expected created ID to be 1, but got %d", createdID)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
 
 // TestGetAuthorByID_Success tests the successful retrieval of an author.
 func TestGetAuthorByID_Success(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
-
-	repo := NewSQLiteAuthorRepository(db)
 	expectedAuthor := &models.Author{
 		ID:   1,
 		Name: "George Orwell",
 		Bio:  "English novelist, essayist, journalist and critic.",
 	}
+	queries := map[string]string{
+		"sqlite":   "SELECT id, name, bio FROM authors WHERE id = ?",
+		"postgres": "SELECT id, name, bio FROM authors WHERE id = $1",
+	}
 
-	rows := sqlmock.NewRows([]string{"id", "name", "bio"}).
-		AddRow(expectedAuthor.ID, expectedAuthor.Name, expectedAuthor.Bio)
+	for _, backend := range authorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
 
-	query := regexp.QuoteMeta("SELECT id, name, bio FROM authors WHERE id = ?")
-	mock.ExpectQuery(query).WithArgs(1).WillReturnRows(rows)
+			repo := backend.newRepo(db)
 
-	author, err := repo.GetByID(1)
+			rows := sqlmock.NewRows([]string{"id", "name", "bio"}).
+				AddRow(expectedAuthor.ID, expectedAuthor.Name, expectedAuthor.Bio)
 
-	if err != nil {
-		t.Errorf("unexpected error: %s", err)
-	}
-	if author.Name != expectedAuthor.Name {
-		t.Errorf("expected author name '%s', but got '%s'", expectedAuthor.Name, author.Name)
-	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+			query := regexp.QuoteMeta(queries[backend.name])
+			mock.ExpectQuery(query).WithArgs(1).WillReturnRows(rows)
+
+			author, err := repo.GetByID(context.Background(), 1)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if author.Name != expectedAuthor.Name {
+				t.Errorf("expected author name '%s', but got '%s'", expectedAuthor.Name, author.Name)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
 
 // TestGetAuthorByID_NotFound tests the case where an author is not found.
 func TestGetAuthorByID_NotFound(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	queries := map[string]string{
+		"sqlite":   "SELECT id, name, bio FROM authors WHERE id = ?",
+		"postgres": "SELECT id, name, bio FROM authors WHERE id = $1",
 	}
-	defer db.Close()
 
-	repo := NewSQLiteAuthorRepository(db)
-	query := regexp.QuoteMeta("SELECT id, name, bio FROM authors WHERE id = ?")
-	mock.ExpectQuery(query).WithArgs(99).WillReturnError(sql.ErrNoRows)
+	for _, backend := range authorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			query := regexp.QuoteMeta(queries[backend.name])
+			mock.ExpectQuery(query).WithArgs(99).WillReturnError(sql.ErrNoRows)
 
-	author, err := repo.GetByID(99)
+			author, err := repo.GetByID(context.Background(), 99)
 
-	if !errors.Is(err, ErrNotFound) {
-		t.Errorf("expected error to be ErrNotFound, but got %v", err)
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected error to be ErrNotFound, but got %v", err)
+			}
+			if author != nil {
+				t.Errorf("expected a nil author, but got one")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	if author != nil {
-		t.Errorf("expected a nil author, but got one")
+}
+
+// TestDeleteAuthor_Books tests that an author with books is only deleted with cascade, and
+// that a cascaded delete removes and audits their books before the author.
+func TestDeleteAuthor_Books(t *testing.T) {
+	for _, backend := range authorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			expectAuthorWithBook := func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta(backend.authorAudit)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"name", "bio"}).AddRow("Frank Herbert", ""))
+				mock.ExpectQuery(regexp.QuoteMeta(backend.authoredBooks)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(authoredBookColumns).AddRow(7, "Dune", "1965-08-01", "9780441172719", "book", 1))
+			}
+
+			expectAuthorWithBook()
+			mock.ExpectRollback()
+
+			if _, err := repo.Delete(context.Background(), 1, false); !errors.Is(err, ErrAuthorHasBooks) {
+				t.Errorf("expected error to be ErrAuthorHasBooks, but got %v", err)
+			}
+
+			expectAuthorWithBook()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authorLoans)).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT title, published_date, isbn, material_type, author_id FROM books WHERE id = ")).WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"title", "published_date", "isbn", "material_type", "author_id"}).AddRow("Dune", "1965-08-01", "9780441172719", "book", 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.bookLoans)).WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			for _, stmt := range backend.deleteBook {
+				mock.ExpectExec(regexp.QuoteMeta(stmt)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			expectAudit(mock, backend.name, models.AuditDelete, models.AuditBook, 7, sqlmock.AnyArg())
+			mock.ExpectExec(regexp.QuoteMeta(backend.deleteAuthor)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(mock, backend.name, models.AuditDelete, models.AuditAuthor, 1, `{"bio":{"from":"","to":null},"name":{"from":"Frank Herbert","to":null}}`)
+			mock.ExpectCommit()
+
+			bookIDs, err := repo.Delete(context.Background(), 1, true)
+			if err != nil || len(bookIDs) != 1 || bookIDs[0] != 7 {
+				t.Errorf("expected book 7 to be deleted, but got %v (error %v)", bookIDs, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+}
+
+// TestMergeAuthors tests that merging repoints the duplicate's books, recording the new
+// author of each, and deletes the duplicate, and that a missing duplicate is reported.
+func TestMergeAuthors(t *testing.T) {
+	for _, backend := range authorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			authorColumns := []string{"name", "bio"}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authorAudit)).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows(authorColumns).AddRow("Frank Herbert", ""))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authorAudit)).WithArgs(2).
+				WillReturnRows(sqlmock.NewRows(authorColumns).AddRow("F. Herbert", ""))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authoredBooks)).WithArgs(2).
+				WillReturnRows(sqlmock.NewRows(authoredBookColumns).
+					AddRow(7, "Dune", "1965-08-01", "9780441172719", "book", 2).
+					AddRow(8, "Dune Messiah", "1969-10-15", "9780441172696", "book", 2))
+			mock.ExpectExec(regexp.QuoteMeta(backend.moveBooks)).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+			expectAudit(mock, backend.name, models.AuditUpdate, models.AuditBook, 7, `{"author_id":{"from":2,"to":1}}`)
+			expectAudit(mock, backend.name, models.AuditUpdate, models.AuditBook, 8, `{"author_id":{"from":2,"to":1}}`)
+			mock.ExpectExec(regexp.QuoteMeta(backend.deleteAuthor)).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(mock, backend.name, models.AuditDelete, models.AuditAuthor, 2, sqlmock.AnyArg())
+			mock.ExpectCommit()
+
+			moved, err := repo.Merge(context.Background(), 1, []int64{2})
+			if err != nil || len(moved) != 2 || moved[0] != 7 || moved[1] != 8 {
+				t.Errorf("expected books 7 and 8 to be moved, but got %v (error %v)", moved, err)
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authorAudit)).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows(authorColumns).AddRow("Frank Herbert", ""))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authorAudit)).WithArgs(3).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
+
+			if _, err := repo.Merge(context.Background(), 1, []int64{3}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected error to be ErrNotFound, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestSearchAuthors tests that the name filter, sort and page are applied, with PostgreSQL
+// numbering its placeholders after the filter's.
+func TestSearchAuthors(t *testing.T) {
+	queries := map[string][2]string{
+		"sqlite": {
+			"SELECT COUNT(*) FROM authors WHERE 1=1 AND name LIKE ?",
+			"SELECT id, name, bio FROM authors WHERE 1=1 AND name LIKE ? ORDER BY name DESC, id LIMIT ? OFFSET ?",
+		},
+		"postgres": {
+			"SELECT COUNT(*) FROM authors WHERE 1=1 AND name ILIKE $1",
+			"SELECT id, name, bio FROM authors WHERE 1=1 AND name ILIKE $1 ORDER BY name DESC, id LIMIT $2 OFFSET $3",
+		},
+	}
+	for _, backend := range authorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			mock.ExpectQuery(regexp.QuoteMeta(queries[backend.name][0])).WithArgs("%herbert%").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+			mock.ExpectQuery(regexp.QuoteMeta(queries[backend.name][1])).WithArgs("%herbert%", 2, 2).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "bio"}).AddRow(4, "Brian Herbert", ""))
+
+			name := "herbert"
+			authors, total, err := repo.Search(context.Background(), AuthorFilter{Name: &name}, 2, 2, "name", "desc")
+			if err != nil || total != 3 || len(authors) != 1 || authors[0].Name != "Brian Herbert" {
+				t.Errorf("expected Brian Herbert of 3 authors, but got %+v of %d (error %v)", authors, total, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestDeleteAuthor_BooksHaveLoans tests that a cascading delete is refused before anything
+// is deleted when a copy of one of the author's books has loans, so no loan is orphaned.
+func TestDeleteAuthor_BooksHaveLoans(t *testing.T) {
+	for _, backend := range authorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authorAudit)).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"name", "bio"}).AddRow("Frank Herbert", ""))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authoredBooks)).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows(authoredBookColumns).
+					AddRow(7, "Dune", "1965-08-01", "9780441172719", "book", 1).
+					AddRow(8, "Dune Messiah", "1969-10-15", "9780441172696", "book", 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authorLoans)).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectRollback()
+
+			if _, err := repo.Delete(context.Background(), 1, true); !errors.Is(err, ErrBookHasLoans) {
+				t.Errorf("expected error to be ErrBookHasLoans, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
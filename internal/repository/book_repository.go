@@ -3,27 +3,36 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Lec7ral/fullAPI/internal/models"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 // BookFilter holds the criteria for searching books.
 type BookFilter struct {
 	Title  *string
 	Author *string
+	// AuthorID limits the results to the books of one author.
+	AuthorID *int64
+	// Query is a free-form full-text query over title, ISBN and author name and bio.
+	// When set, results are ranked by relevance unless an explicit sort is requested.
+	Query *string
 }
 
 // BookRepository defines the interface for book data operations.
 type BookRepository interface {
-	Create(book models.Book) (int64, error)
-	Update(id int64, book models.Book) error
-	Delete(id int64) error
-	GetByID(id int64) (*models.Book, error)
-	Search(filter BookFilter, limit, offset int, sort, order string) ([]models.Book, int, error)
+	Create(ctx context.Context, book models.Book) (int64, error)
+	Update(ctx context.Context, id int64, book models.Book) error
+	Delete(ctx context.Context, id int64) error
+	GetByID(ctx context.Context, id int64) (*models.Book, error)
+	Search(ctx context.Context, filter BookFilter, limit, offset int, sort, order string) ([]models.Book, int, error)
 }
 
 // sqliteBookRepository is the concrete implementation for SQLite.
@@ -36,63 +45,158 @@ func NewSQLiteBookRepository(db *sql.DB) BookRepository {
 	return &sqliteBookRepository{DB: db}
 }
 
-// Create, Update, and Delete methods remain the same as before.
-func (r *sqliteBookRepository) Create(book models.Book) (int64, error) {
-	stmt, err := r.DB.Prepare("INSERT INTO books (title, published_date, isbn, stock, author_id) VALUES (?, ?, ?, ?, ?)")
+// Create inserts the book and adds book.Stock available copies of it in one transaction.
+func (r *sqliteBookRepository) Create(ctx context.Context, book models.Book) (int64, error) {
+	if book.MaterialType == "" {
+		book.MaterialType = "book"
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
-	result, err := stmt.Exec(book.Title, book.PublishedDate, book.ISBN, book.Stock, book.AuthorID)
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO books (title, published_date, isbn, material_type, author_id) VALUES (?, ?, ?, ?, ?)",
+		book.Title, book.PublishedDate, book.ISBN, book.MaterialType, book.AuthorID)
 	if err != nil {
+		return 0, constraintError(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	acquired := time.Now().Format("2006-01-02")
+	for n := 1; n <= book.Stock; n++ {
+		_, err = tx.ExecContext(ctx, "INSERT INTO copies (book_id, barcode, status, acquisition_date) VALUES (?, ?, ?, ?)",
+			id, generatedBarcode(id, n), models.CopyStatusAvailable, acquired)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := recordAuditSQLite(ctx, tx, models.AuditCreate, models.AuditBook, id, nil, bookAuditOf(book)); err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+
+	return id, tx.Commit()
 }
 
-func (r *sqliteBookRepository) Update(id int64, book models.Book) error {
-	stmt, err := r.DB.Prepare("UPDATE books SET title = ?, published_date = ?, isbn = ?, stock = ?, author_id = ? WHERE id = ?")
+// Update changes the book's details. Stock is derived from its copies and is not updated here.
+func (r *sqliteBookRepository) Update(ctx context.Context, id int64, book models.Book) error {
+	if book.MaterialType == "" {
+		book.MaterialType = "book"
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	result, err := stmt.Exec(book.Title, book.PublishedDate, book.ISBN, book.Stock, book.AuthorID, id)
+	defer tx.Rollback()
+
+	before, err := bookAuditSQLite(ctx, tx, id)
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+	_, err = tx.ExecContext(ctx, "UPDATE books SET title = ?, published_date = ?, isbn = ?, material_type = ?, author_id = ? WHERE id = ?",
+		book.Title, book.PublishedDate, book.ISBN, book.MaterialType, book.AuthorID, id)
 	if err != nil {
-		return err
+		return constraintError(err)
 	}
-	if rowsAffected == 0 {
-		return ErrNotFound
+
+	if err := recordAuditSQLite(ctx, tx, models.AuditUpdate, models.AuditBook, id, before, bookAuditOf(book)); err != nil {
+		return err
 	}
-	return nil
+
+	return tx.Commit()
 }
 
-func (r *sqliteBookRepository) Delete(id int64) error {
-	stmt, err := r.DB.Prepare("DELETE FROM books WHERE id = ?")
+// Delete removes the book, its copies and its holds. The SQLite schema does not cascade
+// deletes to holds, so they and the copies are deleted explicitly. A book whose
+// copies have loan history is not deleted, so the history stays intact.
+func (r *sqliteBookRepository) Delete(ctx context.Context, id int64) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	result, err := stmt.Exec(id)
+	defer tx.Rollback()
+
+	if err := deleteBookSQLite(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteBookSQLite deletes the book, its holds and its copies within tx and records the
+// deletion. It returns ErrNotFound if there is no such book, and ErrBookHasLoans if loans,
+// returned or not, reference its copies.
+func deleteBookSQLite(ctx context.Context, tx *sql.Tx, id int64) error {
+	before, err := bookAuditSQLite(ctx, tx, id)
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+
+	var loanCount int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id WHERE c.book_id = ?", id).Scan(&loanCount)
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return ErrNotFound
+	if loanCount > 0 {
+		return ErrBookHasLoans
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM holds WHERE book_id = ?", id); err != nil {
+		return err
 	}
-	return nil
+	if _, err := tx.ExecContext(ctx, "DELETE FROM copies WHERE book_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM books WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return recordAuditSQLite(ctx, tx, models.AuditDelete, models.AuditBook, id, before, nil)
+}
+
+// bookAudit is the state of a book recorded in the audit log. Stock is left out, as it
+// follows from the book's copies rather than being set on the book.
+type bookAudit struct {
+	Title         string `json:"title"`
+	PublishedDate string `json:"published_date"`
+	ISBN          string `json:"isbn"`
+	MaterialType  string `json:"material_type"`
+	AuthorID      int64  `json:"author_id"`
+}
+
+// bookAuditOf returns the audited state of book.
+func bookAuditOf(book models.Book) *bookAudit {
+	return &bookAudit{
+		Title:         book.Title,
+		PublishedDate: book.PublishedDate,
+		ISBN:          book.ISBN,
+		MaterialType:  book.MaterialType,
+		AuthorID:      book.AuthorID,
+	}
+}
+
+// bookAuditSQLite reads the audited state of the book within tx, returning ErrNotFound if
+// there is no such book.
+func bookAuditSQLite(ctx context.Context, tx *sql.Tx, id int64) (*bookAudit, error) {
+	var book bookAudit
+	err := tx.QueryRowContext(ctx, "SELECT title, published_date, isbn, material_type, author_id FROM books WHERE id = ?", id).
+		Scan(&book.Title, &book.PublishedDate, &book.ISBN, &book.MaterialType, &book.AuthorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return &book, err
 }
 
 // GetByID now uses a 2-step query to avoid JOINs on a single-item lookup.
-func (r *sqliteBookRepository) GetByID(id int64) (*models.Book, error) {
+func (r *sqliteBookRepository) GetByID(ctx context.Context, id int64) (*models.Book, error) {
 	// 1. Get the book
 	var book models.Book
-	query := "SELECT id, title, published_date, isbn, stock, author_id FROM books WHERE id = ?"
-	err := r.DB.QueryRow(query, id).Scan(&book.ID, &book.Title, &book.PublishedDate, &book.ISBN, &book.Stock, &book.AuthorID)
+	query := "SELECT b.id, b.title, b.published_date, b.isbn, " + availableCopiesSQL + ", b.material_type, b.author_id FROM books b WHERE b.id = ?"
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&book.ID, &book.Title, &book.PublishedDate, &book.ISBN, &book.Stock, &book.MaterialType, &book.AuthorID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
@@ -104,7 +208,7 @@ func (r *sqliteBookRepository) GetByID(id int64) (*models.Book, error) {
 	if book.AuthorID > 0 {
 		var author models.Author
 		authorQuery := "SELECT id, name, bio FROM authors WHERE id = ?"
-		err = r.DB.QueryRow(authorQuery, book.AuthorID).Scan(&author.ID, &author.Name, &author.Bio)
+		err = r.DB.QueryRowContext(ctx, authorQuery, book.AuthorID).Scan(&author.ID, &author.Name, &author.Bio)
 		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			return nil, err
 		}
@@ -115,14 +219,29 @@ func (r *sqliteBookRepository) GetByID(id int64) (*models.Book, error) {
 }
 
 // Search now uses a 2-query strategy to avoid the N+1 problem.
-func (r *sqliteBookRepository) Search(filter BookFilter, limit, offset int, sort, order string) ([]models.Book, int, error) {
+// Full-text queries go through the books_fts FTS5 index and are ranked by bm25.
+func (r *sqliteBookRepository) Search(ctx context.Context, filter BookFilter, limit, offset int, sort, order string) (books []models.Book, total int, err error) {
+	ctx, span := startSpan(ctx, "books.Search")
+	defer func() { endSpan(span, err) }()
+
 	// --- 1. Build the query for fetching book IDs that match the criteria ---
 	var idArgs []interface{}
-	idQuery := "SELECT b.id FROM books b"
+	selectClause := "SELECT b.id"
+	fromClause := " FROM books b"
 	whereClause := " WHERE 1=1"
 
+	if filter.Query != nil {
+		match := ftsMatchQuery(*filter.Query)
+		if match == "" {
+			return []models.Book{}, 0, nil
+		}
+		selectClause += ", snippet(books_fts, -1, '<mark>', '</mark>', '…', 12)"
+		fromClause += " JOIN books_fts ON books_fts.rowid = b.id"
+		whereClause += " AND books_fts MATCH ?"
+		idArgs = append(idArgs, match)
+	}
 	if filter.Author != nil {
-		idQuery += " JOIN authors a ON b.author_id = a.id"
+		fromClause += " JOIN authors a ON b.author_id = a.id"
 		whereClause += " AND a.name LIKE ?"
 		idArgs = append(idArgs, fmt.Sprintf("%%%s%%", *filter.Author))
 	}
@@ -130,46 +249,63 @@ func (r *sqliteBookRepository) Search(filter BookFilter, limit, offset int, sort
 		whereClause += " AND b.title LIKE ?"
 		idArgs = append(idArgs, fmt.Sprintf("%%%s%%", *filter.Title))
 	}
+	if filter.AuthorID != nil {
+		whereClause += " AND b.author_id = ?"
+		idArgs = append(idArgs, *filter.AuthorID)
+	}
 
-	idQuery += whereClause
+	idQuery := selectClause + fromClause + whereClause
 
 	// --- 2. Get the total count using the same filters ---
-	countQuery := "SELECT COUNT(b.id) FROM books b"
-	if strings.Contains(idQuery, "JOIN") {
-		countQuery += " JOIN authors a ON b.author_id = a.id"
-	}
-	countQuery += whereClause
+	countQuery := "SELECT COUNT(b.id)" + fromClause + whereClause
 
 	var totalRecords int
-	err := r.DB.QueryRow(countQuery, idArgs...).Scan(&totalRecords)
+	err = traceQuery(ctx, "books.Search count", semconv.DBSystemSqlite, countQuery, func(ctx context.Context) error {
+		return r.DB.QueryRowContext(ctx, countQuery, idArgs...).Scan(&totalRecords)
+	})
 	if err != nil {
 		return nil, 0, err
 	}
 
 	// --- 3. Apply sorting and pagination to the ID query ---
-	allowedSortFields := map[string]bool{"title": true, "published_date": true, "stock": true}
-	if allowedSortFields[sort] {
+	if sortColumn, ok := bookSortColumns[sort]; ok {
 		if strings.ToUpper(order) != "ASC" && strings.ToUpper(order) != "DESC" {
 			order = "ASC"
 		}
-		idQuery += fmt.Sprintf(" ORDER BY b.%s %s", sort, order)
+		idQuery += fmt.Sprintf(" ORDER BY %s %s", sortColumn, order)
+	} else if filter.Query != nil {
+		// Weight title and ISBN hits above author name, and author name above bio.
+		idQuery += " ORDER BY bm25(books_fts, 10.0, 10.0, 5.0, 1.0)"
 	}
 	idQuery += " LIMIT ? OFFSET ?"
 	idArgs = append(idArgs, limit, offset)
 
-	rows, err := r.DB.Query(idQuery, idArgs...)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer rows.Close()
-
 	var bookIDs []interface{}
-	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
-			return nil, 0, err
+	snippets := make(map[int64]string)
+	err = traceQuery(ctx, "books.Search ids", semconv.DBSystemSqlite, idQuery, func(ctx context.Context) error {
+		rows, err := r.DB.QueryContext(ctx, idQuery, idArgs...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			if filter.Query != nil {
+				var snippet string
+				if err := rows.Scan(&id, &snippet); err != nil {
+					return err
+				}
+				snippets[id] = snippet
+			} else if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			bookIDs = append(bookIDs, id)
 		}
-		bookIDs = append(bookIDs, id)
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
 	if len(bookIDs) == 0 {
@@ -179,30 +315,37 @@ func (r *sqliteBookRepository) Search(filter BookFilter, limit, offset int, sort
 	// --- 4. Fetch the full book and author data for the retrieved IDs ---
 	mainQuery := getBookWithAuthorSQL + " WHERE b.id IN (?" + strings.Repeat(",?", len(bookIDs)-1) + ")"
 
-	mainRows, err := r.DB.Query(mainQuery, bookIDs...)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer mainRows.Close()
-
 	booksMap := make(map[int64]*models.Book)
-	for mainRows.Next() {
-		var book models.Book
-		var author models.Author
-		if err := mainRows.Scan(
-			&book.ID, &book.Title, &book.PublishedDate, &book.ISBN, &book.Stock, &book.AuthorID,
-			&author.ID, &author.Name, &author.Bio,
-		); err != nil {
-			return nil, 0, err
+	err = traceQuery(ctx, "books.Search books", semconv.DBSystemSqlite, mainQuery, func(ctx context.Context) error {
+		mainRows, err := r.DB.QueryContext(ctx, mainQuery, bookIDs...)
+		if err != nil {
+			return err
 		}
-		book.Author = &author
-		booksMap[book.ID] = &book
+		defer mainRows.Close()
+
+		for mainRows.Next() {
+			var book models.Book
+			var author models.Author
+			if err := mainRows.Scan(
+				&book.ID, &book.Title, &book.PublishedDate, &book.ISBN, &book.Stock, &book.MaterialType, &book.AuthorID,
+				&author.ID, &author.Name, &author.Bio,
+			); err != nil {
+				return err
+			}
+			book.Author = &author
+			booksMap[book.ID] = &book
+		}
+		return mainRows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
 	// Re-order the results to match the order of the bookIDs query.
 	finalBooks := make([]models.Book, 0, len(bookIDs))
 	for _, id := range bookIDs {
 		if book, ok := booksMap[id.(int64)]; ok {
+			book.Snippet = snippets[book.ID]
 			finalBooks = append(finalBooks, *book)
 		}
 	}
@@ -210,11 +353,32 @@ func (r *sqliteBookRepository) Search(filter BookFilter, limit, offset int, sort
 	return finalBooks, totalRecords, nil
 }
 
+// bookSortColumns maps the sort fields accepted by Search to the expressions they order by.
+var bookSortColumns = map[string]string{
+	"title":          "b.title",
+	"published_date": "b.published_date",
+	"stock":          availableCopiesSQL,
+}
+
 const getBookWithAuthorSQL = `
 	SELECT
-		b.id, b.title, b.published_date, b.isbn, b.stock, b.author_id,
+		b.id, b.title, b.published_date, b.isbn, ` + availableCopiesSQL + `, b.material_type, b.author_id,
 		a.id, a.name, a.bio
 	FROM
 		books b
 	LEFT JOIN
 		authors a ON b.author_id = a.id`
+
+// ftsTermPattern matches the words of a free-form search query.
+var ftsTermPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// ftsMatchQuery turns free-form user input such as "dune herbert" into an FTS5 query
+// that matches rows containing every word. Each word is quoted so FTS5 operators and
+// punctuation in the input cannot cause syntax errors. It returns "" if there are no words.
+func ftsMatchQuery(input string) string {
+	terms := ftsTermPattern.FindAllString(input, -1)
+	for i, term := range terms {
+		terms[i] = `"` + term + `"`
+	}
+	return strings.Join(terms, " ")
+}
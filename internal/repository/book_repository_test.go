@@ -2,64 +2,258 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"reflect"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Lec7ral/fullAPI/configs"
+	"github.com/Lec7ral/fullAPI/internal/database"
 	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
+// bookBackends lists the BookRepository implementations every test runs against,
+// along with the single-row lookup queries and the update and delete statements each one
+// issues.
+var bookBackends = []struct {
+	name        string
+	newRepo     func(*sql.DB) BookRepository
+	bookQuery   string
+	authorQuery string
+	auditQuery  string
+	updateQuery string
+	loansQuery  string
+	// deleteStatements lists the statements deleting a book issues after counting its loans.
+	deleteStatements []string
+}{
+	{
+		"sqlite", NewSQLiteBookRepository,
+		"SELECT b.id, b.title, b.published_date, b.isbn, " + availableCopiesSQL + ", b.material_type, b.author_id FROM books b WHERE b.id = ?",
+		"SELECT id, name, bio FROM authors WHERE id = ?",
+		"SELECT title, published_date, isbn, material_type, author_id FROM books WHERE id = ?",
+		"UPDATE books SET title = ?, published_date = ?, isbn = ?, material_type = ?, author_id = ? WHERE id = ?",
+		"SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id WHERE c.book_id = ?",
+		[]string{"DELETE FROM holds WHERE book_id = ?", "DELETE FROM copies WHERE book_id = ?", "DELETE FROM books WHERE id = ?"},
+	},
+	{
+		"postgres", NewPostgresBookRepository,
+		"SELECT b.id, b.title, b.published_date, b.isbn, " + availableCopiesSQL + ", b.material_type, b.author_id FROM books b WHERE b.id = $1",
+		"SELECT id, name, bio FROM authors WHERE id = $1",
+		"SELECT title, published_date, isbn, material_type, author_id FROM books WHERE id = $1 FOR UPDATE",
+		"UPDATE books SET title = $1, published_date = $2, isbn = $3, material_type = $4, author_id = $5 WHERE id = $6",
+		"SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id WHERE c.book_id = $1",
+		[]string{"DELETE FROM books WHERE id = $1"},
+	},
+}
+
 // TestGetByID_Success tests the successful retrieval of a book by its ID.
 func TestGetByID_Success(t *testing.T) {
+	for _, backend := range bookBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			expectedAuthor := &models.Author{ID: 1, Name: "Test Author"}
+			expectedBook := &models.Book{ID: 1, Title: "Test Book", AuthorID: 1, Author: expectedAuthor}
+
+			// Mock for the first query (get book)
+			bookRows := sqlmock.NewRows([]string{"id", "title", "published_date", "isbn", "stock", "material_type", "author_id"}).
+				AddRow(expectedBook.ID, expectedBook.Title, "2023-01-01", "1234567890", 10, "book", expectedBook.AuthorID)
+			mock.ExpectQuery(regexp.QuoteMeta(backend.bookQuery)).
+				WithArgs(1).
+				WillReturnRows(bookRows)
+
+			// Mock for the second query (get author)
+			authorRows := sqlmock.NewRows([]string{"id", "name", "bio"}).
+				AddRow(expectedAuthor.ID, expectedAuthor.Name, "A test bio")
+			mock.ExpectQuery(regexp.QuoteMeta(backend.authorQuery)).
+				WithArgs(expectedBook.AuthorID).
+				WillReturnRows(authorRows)
+
+			book, err := repo.GetByID(context.Background(), 1)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if book == nil {
+				t.Errorf("expected a book but got nil")
+				return
+			}
+			if book.Title != expectedBook.Title {
+				t.Errorf("expected title '%s' but got '%s'", expectedBook.Title, book.Title)
+			}
+			if book.Author == nil || book.Author.Name != expectedAuthor.Name {
+				t.Errorf("expected author name '%s' but got '%s'", expectedAuthor.Name, book.Author.Name)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestGetByID_NotFound tests the case where a book is not found.
+func TestGetByID_NotFound(t *testing.T) {
+	for _, backend := range bookBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectQuery(regexp.QuoteMeta(backend.bookQuery)).
+				WithArgs(2).
+				WillReturnError(sql.ErrNoRows)
+
+			book, err := repo.GetByID(context.Background(), 2)
+
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected error to be ErrNotFound, but got %v", err)
+			}
+			if book != nil {
+				t.Errorf("expected a nil book, but got one")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestGetByID_DeadlineExceeded tests that a query still running when the request's
+// deadline passes is cancelled rather than left to finish.
+func TestGetByID_DeadlineExceeded(t *testing.T) {
+	for _, backend := range bookBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			mock.ExpectQuery(regexp.QuoteMeta(backend.bookQuery)).
+				WithArgs(1).
+				WillDelayFor(time.Second).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "published_date", "isbn", "stock", "material_type", "author_id"}).
+					AddRow(1, "Test Book", "2023-01-01", "1234567890", 10, "book", 1))
+
+			start := time.Now()
+			book, err := repo.GetByID(ctx, 1)
+
+			if err == nil || book != nil {
+				t.Errorf("expected the query to be cancelled, but got %+v", book)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("expected the query to stop at the deadline, but it ran for %s", elapsed)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestSearchBooks_Postgres tests that the PostgreSQL search numbers its placeholders correctly.
+func TestSearchBooks_Postgres(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
 
-	repo := NewSQLiteBookRepository(db)
+	repo := NewPostgresBookRepository(db)
+	title, author := "dune", "herbert"
 
-	expectedAuthor := &models.Author{ID: 1, Name: "Test Author"}
-	expectedBook := &models.Book{ID: 1, Title: "Test Book", AuthorID: 1, Author: expectedAuthor}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(b.id) FROM books b JOIN authors a ON b.author_id = a.id WHERE 1=1 AND a.name ILIKE $1 AND b.title ILIKE $2")).
+		WithArgs("%herbert%", "%dune%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT b.id FROM books b JOIN authors a ON b.author_id = a.id WHERE 1=1 AND a.name ILIKE $1 AND b.title ILIKE $2 ORDER BY b.title desc LIMIT $3 OFFSET $4")).
+		WithArgs("%herbert%", "%dune%", 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE b.id IN ($1)")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "published_date", "isbn", "stock", "material_type", "author_id", "id", "name", "bio"}).
+			AddRow(7, "Dune", "1965-08-01", "9780441013593", 3, "book", 2, 2, "Frank Herbert", ""))
 
-	// Mock for the first query (get book)
-	bookRows := sqlmock.NewRows([]string{"id", "title", "published_date", "isbn", "stock", "author_id"}).
-		AddRow(expectedBook.ID, expectedBook.Title, "2023-01-01", "1234567890", 10, expectedBook.AuthorID)
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, published_date, isbn, stock, author_id FROM books WHERE id = ?")).
-		WithArgs(1).
-		WillReturnRows(bookRows)
+	books, total, err := repo.Search(context.Background(), BookFilter{Title: &title, Author: &author}, 10, 0, "title", "desc")
 
-	// Mock for the second query (get author)
-	authorRows := sqlmock.NewRows([]string{"id", "name", "bio"}).
-		AddRow(expectedAuthor.ID, expectedAuthor.Name, "A test bio")
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, bio FROM authors WHERE id = ?")).
-		WithArgs(expectedBook.AuthorID).
-		WillReturnRows(authorRows)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if total != 1 || len(books) != 1 || books[0].Title != "Dune" {
+		t.Errorf("expected one book titled 'Dune', but got %d books (total %d)", len(books), total)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
 
-	book, err := repo.GetByID(1)
+// TestSearchBooks_FullText tests that a free-form query is matched against the FTS5 index,
+// ranked by bm25 and returned with its snippet.
+func TestSearchBooks_FullText(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	repo := NewSQLiteBookRepository(db)
+	query := "dune: herbert"
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(b.id) FROM books b JOIN books_fts ON books_fts.rowid = b.id WHERE 1=1 AND books_fts MATCH ?")).
+		WithArgs(`"dune" "herbert"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT b.id, snippet(books_fts, -1, '<mark>', '</mark>', '…', 12) FROM books b JOIN books_fts ON books_fts.rowid = b.id WHERE 1=1 AND books_fts MATCH ? ORDER BY bm25(books_fts, 10.0, 10.0, 5.0, 1.0) LIMIT ? OFFSET ?")).
+		WithArgs(`"dune" "herbert"`, 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snippet"}).AddRow(7, "<mark>Dune</mark>"))
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE b.id IN (?)")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "published_date", "isbn", "stock", "material_type", "author_id", "id", "name", "bio"}).
+			AddRow(7, "Dune", "1965-08-01", "9780441013593", 3, "book", 2, 2, "Frank Herbert", ""))
+
+	books, total, err := repo.Search(context.Background(), BookFilter{Query: &query}, 20, 0, "", "")
 
 	if err != nil {
 		t.Errorf("unexpected error: %s", err)
 	}
-	if book == nil {
-		t.Errorf("expected a book but got nil")
-		return
+	if total != 1 || len(books) != 1 {
+		t.Fatalf("expected one result, but got %d books (total %d)", len(books), total)
 	}
-	if book.Title != expectedBook.Title {
-		t.Errorf("expected title '%s' but got '%s'", expectedBook.Title, book.Title)
-	}
-	if book.Author == nil || book.Author.Name != expectedAuthor.Name {
-		t.Errorf("expected author name '%s' but got '%s'", expectedAuthor.Name, book.Author.Name)
+	if books[0].Snippet != "<mark>Dune</mark>" {
+		t.Errorf("expected snippet '<mark>Dune</mark>', but got '%s'", books[0].Snippet)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
 
-// TestGetByID_NotFound tests the case where a book is not found.
-func TestGetByID_NotFound(t *testing.T) {
+// TestSearchBooks_Spans tests that each statement of a search is traced in its own span,
+// nested in the span of the search.
+func TestSearchBooks_Spans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -67,20 +261,251 @@ func TestGetByID_NotFound(t *testing.T) {
 	defer db.Close()
 
 	repo := NewSQLiteBookRepository(db)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(b.id)")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT b.id FROM books b")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE b.id IN (?)")).
+		WillReturnError(errors.New("disk I/O error"))
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, published_date, isbn, stock, author_id FROM books WHERE id = ?")).
-		WithArgs(2).
-		WillReturnError(sql.ErrNoRows)
-
-	book, err := repo.GetByID(2)
+	if _, _, err := repo.Search(context.Background(), BookFilter{}, 10, 0, "", ""); err == nil {
+		t.Fatalf("expected the failed statement to fail the search")
+	}
 
-	if !errors.Is(err, ErrNotFound) {
-		t.Errorf("expected error to be ErrNotFound, but got %v", err)
+	spans := exporter.GetSpans()
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+	}
+	expected := []string{"books.Search count", "books.Search ids", "books.Search books", "books.Search"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected spans %v, but got %v", expected, names)
+	}
+	search := spans[3]
+	for _, span := range spans[:3] {
+		if span.Parent.SpanID() != search.SpanContext.SpanID() {
+			t.Errorf("expected '%s' to be a child of the search span", span.Name)
+		}
 	}
-	if book != nil {
-		t.Errorf("expected a nil book, but got one")
+	for _, span := range []tracetest.SpanStub{spans[2], search} {
+		if span.Status.Code != codes.Error {
+			t.Errorf("expected '%s' to be marked as failed, but got status %v", span.Name, span.Status.Code)
+		}
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+// TestFTSMatchQuery tests that user input is reduced to quoted FTS5 terms.
+func TestFTSMatchQuery(t *testing.T) {
+	tests := map[string]string{
+		"dune herbert":        `"dune" "herbert"`,
+		`title:"dune" OR -x*`: `"title" "dune" "OR" "x"`,
+		"  Cien años  ":       `"Cien" "años"`,
+		"978-0441013593":      `"978" "0441013593"`,
+		"!!!":                 "",
+	}
+	for input, expected := range tests {
+		if got := ftsMatchQuery(input); got != expected {
+			t.Errorf("ftsMatchQuery(%q): expected %q, but got %q", input, expected, got)
+		}
+	}
+}
+
+// TestUpdateBook_Audited tests that updating a book records only the fields that changed,
+// and that a missing book is not updated.
+func TestUpdateBook_Audited(t *testing.T) {
+	for _, backend := range bookBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			book := models.Book{Title: "Dune Messiah", PublishedDate: "1969-10-15", ISBN: "9780441172696", AuthorID: 1}
+			columns := []string{"title", "published_date", "isbn", "material_type", "author_id"}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.auditQuery)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow("Dune", "1969-10-15", "9780441172696", "book", 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.updateQuery)).
+				WithArgs(book.Title, book.PublishedDate, book.ISBN, "book", book.AuthorID, 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(mock, backend.name, models.AuditUpdate, models.AuditBook, 1, `{"title":{"from":"Dune","to":"Dune Messiah"}}`)
+			mock.ExpectCommit()
+
+			if err := repo.Update(context.Background(), 1, book); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.auditQuery)).
+				WithArgs(2).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
+
+			if err := repo.Update(context.Background(), 2, book); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected error to be ErrNotFound, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestUpdateBook_ConstraintViolations tests that a duplicate ISBN and a missing author,
+// as each database reports them, are returned as ConstraintErrors wrapping ErrConflict
+// and ErrForeignKey.
+func TestUpdateBook_ConstraintViolations(t *testing.T) {
+	violations := map[string][]struct {
+		dbErr      error
+		want       error
+		constraint string
+	}{
+		"sqlite": {
+			{errors.New("UNIQUE constraint failed: books.isbn"), ErrConflict, "books.isbn"},
+			{errors.New("FOREIGN KEY constraint failed"), ErrForeignKey, ""},
+		},
+		"postgres": {
+			{&pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "books_isbn_key"}, ErrConflict, "books_isbn_key"},
+			{&pgconn.PgError{Code: pgForeignKeyViolation, ConstraintName: "books_author_id_fkey"}, ErrForeignKey, "books_author_id_fkey"},
+		},
+	}
+	for _, backend := range bookBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			book := models.Book{Title: "Dune", PublishedDate: "1965-08-01", ISBN: "9780441172719", AuthorID: 9}
+			columns := []string{"title", "published_date", "isbn", "material_type", "author_id"}
+			for _, tt := range violations[backend.name] {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta(backend.auditQuery)).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("Dune", "1965-08-01", "9780441172719", "book", 1))
+				mock.ExpectExec(regexp.QuoteMeta(backend.updateQuery)).
+					WithArgs(book.Title, book.PublishedDate, book.ISBN, "book", book.AuthorID, 1).
+					WillReturnError(tt.dbErr)
+				mock.ExpectRollback()
+
+				err := repo.Update(context.Background(), 1, book)
+				var constraintErr *ConstraintError
+				if !errors.Is(err, tt.want) || !errors.As(err, &constraintErr) || constraintErr.Constraint != tt.constraint {
+					t.Errorf("expected a ConstraintError on %q wrapping %v, but got %v", tt.constraint, tt.want, err)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestConstraintError_SQLiteForeignKey tests that SQLite connections opened by the database
+// package enforce foreign keys, and that the violation they report maps to ErrForeignKey.
+func TestConstraintError_SQLiteForeignKey(t *testing.T) {
+	db, err := database.Open(configs.DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening an in-memory database", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{
+		"CREATE TABLE authors (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE books (id INTEGER PRIMARY KEY, author_id INTEGER, FOREIGN KEY (author_id) REFERENCES authors(id))",
+		"INSERT INTO authors (id) VALUES (1)",
+		"INSERT INTO books (id, author_id) VALUES (1, 1)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("unexpected error creating the schema: %s", err)
+		}
+	}
+
+	_, err = db.Exec("INSERT INTO books (id, author_id) VALUES (2, 9)")
+	if !errors.Is(constraintError(err), ErrForeignKey) {
+		t.Errorf("expected a book with a missing author to be ErrForeignKey, but got %v", err)
+	}
+	_, err = db.Exec("DELETE FROM authors WHERE id = 1")
+	if !errors.Is(constraintError(err), ErrForeignKey) {
+		t.Errorf("expected deleting a referenced author to be ErrForeignKey, but got %v", err)
+	}
+}
+
+// TestDeleteBook tests that a book whose copies were never lent out is deleted and audited.
+func TestDeleteBook(t *testing.T) {
+	for _, backend := range bookBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			columns := []string{"title", "published_date", "isbn", "material_type", "author_id"}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.auditQuery)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow("Dune", "1965-08-01", "9780441172719", "book", 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.loansQuery)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			for _, stmt := range backend.deleteStatements {
+				mock.ExpectExec(regexp.QuoteMeta(stmt)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			expectAudit(mock, backend.name, models.AuditDelete, models.AuditBook, 1, sqlmock.AnyArg())
+			mock.ExpectCommit()
+
+			if err := repo.Delete(context.Background(), 1); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestDeleteBook_HasLoans tests that a book whose copies have loans, even returned ones, is
+// not deleted, so no loan is left pointing at a deleted copy.
+func TestDeleteBook_HasLoans(t *testing.T) {
+	for _, backend := range bookBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			columns := []string{"title", "published_date", "isbn", "material_type", "author_id"}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.auditQuery)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow("Dune", "1965-08-01", "9780441172719", "book", 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.loansQuery)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			mock.ExpectRollback()
+
+			if err := repo.Delete(context.Background(), 1); !errors.Is(err, ErrBookHasLoans) {
+				t.Errorf("expected error to be ErrBookHasLoans, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
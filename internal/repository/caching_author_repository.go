@@ -0,0 +1,132 @@
+// Package repository provides a data abstraction layer.
+// This file contains the caching decorator for author data operations.
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/cache"
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"golang.org/x/sync/singleflight"
+)
+
+// authorListingsGenerationKey holds the generation of cached author listings, which are
+// invalidated all at once like book listings.
+const authorListingsGenerationKey = "authors:generation"
+
+// cachingAuthorRepository caches author listings and single authors in front of another
+// AuthorRepository. Books embed their author, so changing an author also invalidates every
+// book listing and the books deleted or moved; single books whose author was renamed show
+// the new name once their cached entries expire.
+type cachingAuthorRepository struct {
+	next    AuthorRepository
+	cache   cache.Cache
+	ttl     time.Duration
+	counter *cache.Counter
+	flight  *singleflight.Group
+}
+
+// NewCachingAuthorRepository wraps next so GetByID and Search are served from c for ttl,
+// counting hits and misses in counter.
+func NewCachingAuthorRepository(next AuthorRepository, c cache.Cache, ttl time.Duration, counter *cache.Counter) AuthorRepository {
+	return &cachingAuthorRepository{
+		next:    next,
+		cache:   c,
+		ttl:     ttl,
+		counter: counter,
+		flight:  &singleflight.Group{},
+	}
+}
+
+func (r *cachingAuthorRepository) Create(ctx context.Context, author models.Author) (int64, error) {
+	id, err := r.next.Create(ctx, author)
+	if err != nil {
+		return 0, err
+	}
+	restartGeneration(ctx, r.cache, authorListingsGenerationKey)
+	return id, nil
+}
+
+func (r *cachingAuthorRepository) Update(ctx context.Context, id int64, author models.Author) error {
+	if err := r.next.Update(ctx, id, author); err != nil {
+		return err
+	}
+	r.invalidate(ctx, []int64{id}, nil)
+	return nil
+}
+
+func (r *cachingAuthorRepository) Delete(ctx context.Context, id int64, cascade bool) ([]int64, error) {
+	bookIDs, err := r.next.Delete(ctx, id, cascade)
+	if err != nil {
+		return nil, err
+	}
+	r.invalidate(ctx, []int64{id}, bookIDs)
+	return bookIDs, nil
+}
+
+func (r *cachingAuthorRepository) Merge(ctx context.Context, id int64, sourceIDs []int64) ([]int64, error) {
+	bookIDs, err := r.next.Merge(ctx, id, sourceIDs)
+	if err != nil {
+		return nil, err
+	}
+	r.invalidate(ctx, append([]int64{id}, sourceIDs...), bookIDs)
+	return bookIDs, nil
+}
+
+func (r *cachingAuthorRepository) GetByID(ctx context.Context, id int64) (*models.Author, error) {
+	return readThrough(ctx, r.cache, r.counter, r.flight, authorKey(id), r.ttl, func(ctx context.Context) (*models.Author, error) {
+		return r.next.GetByID(ctx, id)
+	})
+}
+
+// authorSearchResult is a page of author search results as it is cached.
+type authorSearchResult struct {
+	Authors []models.Author `json:"authors"`
+	Total   int             `json:"total"`
+}
+
+func (r *cachingAuthorRepository) Search(ctx context.Context, filter AuthorFilter, limit, offset int, sort, order string) ([]models.Author, int, error) {
+	generation, err := currentGeneration(ctx, r.cache, authorListingsGenerationKey)
+	if err != nil {
+		r.counter.Error()
+		return r.next.Search(ctx, filter, limit, offset, sort, order)
+	}
+
+	key := "authors:search:" + generation + ":" + authorSearchKey(filter, limit, offset, sort, order)
+	result, err := readThrough(ctx, r.cache, r.counter, r.flight, key, r.ttl, func(ctx context.Context) (authorSearchResult, error) {
+		authors, total, err := r.next.Search(ctx, filter, limit, offset, sort, order)
+		return authorSearchResult{Authors: authors, Total: total}, err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return result.Authors, result.Total, nil
+}
+
+// invalidate removes changed authors and books from the cache, along with every author and
+// book listing they may appear in.
+func (r *cachingAuthorRepository) invalidate(ctx context.Context, authorIDs, bookIDs []int64) {
+	keys := make([]string, 0, len(authorIDs)+len(bookIDs))
+	for _, id := range authorIDs {
+		keys = append(keys, authorKey(id))
+	}
+	for _, id := range bookIDs {
+		keys = append(keys, bookKey(id))
+	}
+	invalidate(ctx, r.cache, keys...)
+	restartGeneration(ctx, r.cache, authorListingsGenerationKey)
+	invalidateListings(ctx, r.cache)
+}
+
+// authorSearchKey hashes the search parameters into a cache key. The name filter is hashed
+// like the author filter of a book search, so equivalent searches share a key.
+func authorSearchKey(filter AuthorFilter, limit, offset int, sort, order string) string {
+	return searchKey(BookFilter{Author: filter.Name}, limit, offset, sort, order)
+}
+
+// authorKey is the cache key of a single author.
+func authorKey(id int64) string {
+	return fmt.Sprintf("author:%d", id)
+}
@@ -1,68 +1,245 @@
+// Package repository provides a data abstraction layer.
+// This file contains the caching decorator for book data operations.
 package repository
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"github.com/Lec7ral/fullAPI/internal/models"
-	"github.com/go-redis/redis/v8"
+	"log/slog"
+	"strings"
 	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/cache"
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"golang.org/x/sync/singleflight"
 )
 
+// bookListingsGenerationKey holds the generation of cached book listings. Search results
+// are cached under the generation current when they were stored, so replacing it makes
+// every cached listing unreachable at once; the old entries then expire on their own.
+const bookListingsGenerationKey = "books:generation"
+
+// generationTTL is how long a generation is kept. When it expires a new one is started,
+// which only costs a round of cache misses.
+const generationTTL = 24 * time.Hour
+
+// cachingBookRepository caches single books and search results in front of another
+// BookRepository. Writes through it, and the loans, copies and holds that change a book's
+// stock through cachingLoanRepository, cachingCopyRepository and cachingHoldRepository,
+// invalidate the affected book and every listing.
 type cachingBookRepository struct {
-	next  BookRepository
-	cache *redis.Client
-	ctx   context.Context
+	next    BookRepository
+	cache   cache.Cache
+	ttl     time.Duration
+	counter *cache.Counter
+	flight  *singleflight.Group
 }
 
-func NewCachingBookRepository(next BookRepository, cache *redis.Client) BookRepository {
+// NewCachingBookRepository wraps next so GetByID and Search are served from c for ttl,
+// counting hits and misses in counter.
+func NewCachingBookRepository(next BookRepository, c cache.Cache, ttl time.Duration, counter *cache.Counter) BookRepository {
 	return &cachingBookRepository{
-		next:  next,
-		cache: cache,
-		ctx:   context.Background(),
+		next:    next,
+		cache:   c,
+		ttl:     ttl,
+		counter: counter,
+		flight:  &singleflight.Group{},
 	}
 }
 
-func (r *cachingBookRepository) GetByID(id int64) (*models.Book, error) {
-	key := fmt.Sprintf("book:%d", id)
-	val, err := r.cache.Get(r.ctx, key).Result()
-	if err == nil {
-		var book models.Book
-		if json.Unmarshal([]byte(val), &book) == nil {
-			return &book, nil
-		}
-	}
-	book, err := r.next.GetByID(id)
-	if err != nil {
-		return nil, err
-	}
-	jsonData, _ := json.Marshal(book)
-	r.cache.Set(r.ctx, key, jsonData, 5*time.Minute)
-	return book, nil
+func (r *cachingBookRepository) GetByID(ctx context.Context, id int64) (*models.Book, error) {
+	return readThrough(ctx, r.cache, r.counter, r.flight, bookKey(id), r.ttl, func(ctx context.Context) (*models.Book, error) {
+		return r.next.GetByID(ctx, id)
+	})
 }
-func (r *cachingBookRepository) Update(id int64, book models.Book) error {
-	err := r.next.Update(id, book)
+
+func (r *cachingBookRepository) Update(ctx context.Context, id int64, book models.Book) error {
+	err := r.next.Update(ctx, id, book)
 	if err != nil {
 		return err
 	}
-	key := fmt.Sprintf("book:%d", id)
-	r.cache.Del(r.ctx, key)
+	invalidateBook(ctx, r.cache, id)
 	return nil
 }
 
-func (r *cachingBookRepository) Delete(id int64) error {
-	err := r.next.Delete(id)
+func (r *cachingBookRepository) Delete(ctx context.Context, id int64) error {
+	err := r.next.Delete(ctx, id)
 	if err != nil {
 		return err
 	}
-	key := fmt.Sprintf("book:%d", id)
-	r.cache.Del(r.ctx, key)
+	invalidateBook(ctx, r.cache, id)
 	return nil
 }
 
-func (r *cachingBookRepository) Create(book models.Book) (int64, error) {
-	return r.next.Create(book)
+func (r *cachingBookRepository) Create(ctx context.Context, book models.Book) (int64, error) {
+	id, err := r.next.Create(ctx, book)
+	if err != nil {
+		return 0, err
+	}
+	invalidateListings(ctx, r.cache)
+	return id, nil
+}
+
+// searchResult is a page of search results as it is cached.
+type searchResult struct {
+	Books []models.Book `json:"books"`
+	Total int           `json:"total"`
+}
+
+func (r *cachingBookRepository) Search(ctx context.Context, filter BookFilter, limit, offset int, sort, order string) ([]models.Book, int, error) {
+	generation, err := listingsGeneration(ctx, r.cache)
+	if err != nil {
+		r.counter.Error()
+		return r.next.Search(ctx, filter, limit, offset, sort, order)
+	}
+
+	key := "books:search:" + generation + ":" + searchKey(filter, limit, offset, sort, order)
+	result, err := readThrough(ctx, r.cache, r.counter, r.flight, key, r.ttl, func(ctx context.Context) (searchResult, error) {
+		books, total, err := r.next.Search(ctx, filter, limit, offset, sort, order)
+		return searchResult{Books: books, Total: total}, err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return result.Books, result.Total, nil
+}
+
+// searchKey hashes the search parameters into a cache key. Equivalent searches, differing
+// only in case or surrounding and repeated whitespace, share a key.
+func searchKey(filter BookFilter, limit, offset int, sort, order string) string {
+	normalize := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return strings.ToLower(strings.Join(strings.Fields(*s), " "))
+	}
+	// A nil filter and an empty one search differently, so presence is part of the key.
+	present := func(s *string) bool { return s != nil }
+
+	params, _ := json.Marshal([]interface{}{
+		present(filter.Query), normalize(filter.Query),
+		present(filter.Title), normalize(filter.Title),
+		present(filter.Author), normalize(filter.Author),
+		filter.AuthorID,
+		limit, offset, strings.ToLower(sort), strings.ToLower(order),
+	})
+	sum := sha256.Sum256(params)
+	return hex.EncodeToString(sum[:16])
+}
+
+// bookKey is the cache key of a single book.
+func bookKey(id int64) string {
+	return fmt.Sprintf("book:%d", id)
 }
-func (r *cachingBookRepository) Search(filter BookFilter, limit, offset int, sort, order string) ([]models.Book, int, error) {
-	return r.next.Search(filter, limit, offset, sort, order)
+
+// listingsGeneration returns the current generation of book listings, starting one if
+// there is none.
+func listingsGeneration(ctx context.Context, c cache.Cache) (string, error) {
+	return currentGeneration(ctx, c, bookListingsGenerationKey)
+}
+
+// currentGeneration returns the generation of the listings held under key, starting one if
+// there is none.
+func currentGeneration(ctx context.Context, c cache.Cache, key string) (string, error) {
+	data, found, err := c.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return string(data), nil
+	}
+	generation := newGeneration()
+	if err := c.Set(ctx, key, []byte(generation), generationTTL); err != nil {
+		return "", err
+	}
+	return generation, nil
+}
+
+// newGeneration returns a random generation, so instances sharing a cache never reuse one.
+func newGeneration() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// invalidateBook removes a changed book from the cache, along with every listing it may appear in.
+func invalidateBook(ctx context.Context, c cache.Cache, id int64) {
+	invalidate(ctx, c, bookKey(id))
+	invalidateListings(ctx, c)
+}
+
+// invalidateListings starts a new generation of book listings.
+func invalidateListings(ctx context.Context, c cache.Cache) {
+	restartGeneration(ctx, c, bookListingsGenerationKey)
+}
+
+// restartGeneration starts a new generation of the listings held under key. Like
+// invalidate, it is called after a committed write, so it carries on if ctx is cancelled.
+func restartGeneration(ctx context.Context, c cache.Cache, key string) {
+	ctx = context.WithoutCancel(ctx)
+	if err := c.Set(ctx, key, []byte(newGeneration()), generationTTL); err != nil {
+		slog.ErrorContext(ctx, "Failed to invalidate cached listings", "key", key, "error", err)
+	}
+}
+
+// sharedLoadTimeout bounds a load shared by concurrent misses, which no longer ends with
+// the request that started it.
+const sharedLoadTimeout = 30 * time.Second
+
+// readThrough returns the value cached under key, or loads it and caches it for ttl.
+// Concurrent misses on the same key share a single load. It runs with the values of the
+// context of the caller that started it but not its cancellation, bounded by
+// sharedLoadTimeout instead, so a caller that gives up does not fail the others; each
+// caller stops waiting when its own ctx is done. The cache is an optimisation:
+// when it fails, the value is loaded from the database and the failure is only counted.
+// Errors from load are returned and never cached.
+func readThrough[T any](ctx context.Context, c cache.Cache, counter *cache.Counter, flight *singleflight.Group, key string, ttl time.Duration, load func(context.Context) (T, error)) (T, error) {
+	data, found, err := c.Get(ctx, key)
+	switch {
+	case err != nil:
+		counter.Error()
+	case found:
+		var value T
+		if json.Unmarshal(data, &value) == nil {
+			counter.Hit()
+			return value, nil
+		}
+		counter.Miss()
+	default:
+		counter.Miss()
+	}
+
+	shared := flight.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedLoadTimeout)
+		defer cancel()
+		value, err := load(ctx)
+		if err != nil {
+			return value, err
+		}
+		if data, err := json.Marshal(value); err == nil {
+			c.Set(ctx, key, data, ttl)
+		}
+		return value, nil
+	})
+	select {
+	case result := <-shared:
+		return result.Val.(T), result.Err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// invalidate removes keys whose values changed. A failure is logged, since the stale
+// values will be served until they expire. The write that made them stale has already
+// been committed, so the invalidation carries on if ctx is cancelled.
+func invalidate(ctx context.Context, c cache.Cache, keys ...string) {
+	ctx = context.WithoutCancel(ctx)
+	if err := c.Delete(ctx, keys...); err != nil {
+		slog.ErrorContext(ctx, "Failed to invalidate cache keys", "keys", keys, "error", err)
+	}
 }
@@ -0,0 +1,62 @@
+// Package repository provides a data abstraction layer.
+// This file contains the decorator that keeps cached books in step with their copies.
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/cache"
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// cachingCopyRepository invalidates the cached book and book listings whenever a copy is
+// added, changed or removed, since any of those can change the book's stock. Every other
+// method passes straight through.
+type cachingCopyRepository struct {
+	CopyRepository
+	cache cache.Cache
+}
+
+// NewCachingCopyRepository wraps next so changes to copies invalidate the books cached in c.
+func NewCachingCopyRepository(next CopyRepository, c cache.Cache) CopyRepository {
+	return &cachingCopyRepository{CopyRepository: next, cache: c}
+}
+
+func (r *cachingCopyRepository) Create(ctx context.Context, bookCopy models.Copy, holdExpiresAt time.Time) (int64, error) {
+	id, err := r.CopyRepository.Create(ctx, bookCopy, holdExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	invalidateBook(ctx, r.cache, bookCopy.BookID)
+	return id, nil
+}
+
+func (r *cachingCopyRepository) Update(ctx context.Context, id int64, bookCopy models.Copy, holdExpiresAt time.Time) error {
+	if err := r.CopyRepository.Update(ctx, id, bookCopy, holdExpiresAt); err != nil {
+		return err
+	}
+	// The update is committed, so the cache is updated even if the request is going away.
+	ctx = context.WithoutCancel(ctx)
+	// The stored copy names its book; bookCopy's book_id is not trusted.
+	if stored, err := r.CopyRepository.GetByID(ctx, id); err == nil {
+		invalidateBook(ctx, r.cache, stored.BookID)
+	} else {
+		invalidateListings(ctx, r.cache)
+	}
+	return nil
+}
+
+func (r *cachingCopyRepository) Delete(ctx context.Context, id int64) error {
+	// The copy is gone once deleted, so its book is looked up first.
+	stored, lookupErr := r.CopyRepository.GetByID(ctx, id)
+	if err := r.CopyRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	if lookupErr == nil {
+		invalidateBook(ctx, r.cache, stored.BookID)
+	} else {
+		invalidateListings(ctx, r.cache)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Package repository provides a data abstraction layer.
+// This file contains the decorator that keeps cached books in step with holds.
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/cache"
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// cachingHoldRepository invalidates the cached book and book listings whenever a hold
+// gives up the copy set aside for it, which may put the copy back on the shelf. Every
+// other method passes straight through.
+type cachingHoldRepository struct {
+	HoldRepository
+	cache cache.Cache
+}
+
+// NewCachingHoldRepository wraps next so cancelled and expired holds invalidate the books cached in c.
+func NewCachingHoldRepository(next HoldRepository, c cache.Cache) HoldRepository {
+	return &cachingHoldRepository{HoldRepository: next, cache: c}
+}
+
+func (r *cachingHoldRepository) Cancel(ctx context.Context, id int64, pickupExpiresAt time.Time) error {
+	if err := r.HoldRepository.Cancel(ctx, id, pickupExpiresAt); err != nil {
+		return err
+	}
+	// The cancellation is committed, so the cache is updated even if the request is going away.
+	ctx = context.WithoutCancel(ctx)
+	// The cancelled hold names the book whose stock may have changed.
+	if hold, err := r.HoldRepository.GetByID(ctx, id); err == nil {
+		invalidateBook(ctx, r.cache, hold.BookID)
+	} else {
+		invalidateListings(ctx, r.cache)
+	}
+	return nil
+}
+
+func (r *cachingHoldRepository) ExpireReadyHolds(ctx context.Context, pickupExpiresAt time.Time) ([]models.Hold, error) {
+	expired, err := r.HoldRepository.ExpireReadyHolds(ctx, pickupExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	// Each expired hold names a book whose copy may be back on the shelf.
+	for _, hold := range expired {
+		invalidate(ctx, r.cache, bookKey(hold.BookID))
+	}
+	if len(expired) > 0 {
+		invalidateListings(ctx, r.cache)
+	}
+	return expired, nil
+}
@@ -0,0 +1,63 @@
+// Package repository provides a data abstraction layer.
+// This file contains the decorator that keeps cached books in step with loans.
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/cache"
+)
+
+// cachingLoanRepository invalidates the cached book and book listings whenever a loan
+// changes a book's stock. Every other method passes straight through.
+type cachingLoanRepository struct {
+	LoanRepository
+	cache cache.Cache
+}
+
+// NewCachingLoanRepository wraps next so checkouts and returns invalidate the books cached in c.
+func NewCachingLoanRepository(next LoanRepository, c cache.Cache) LoanRepository {
+	return &cachingLoanRepository{LoanRepository: next, cache: c}
+}
+
+func (r *cachingLoanRepository) CreateLoan(ctx context.Context, bookID, userID int64, dueDate time.Time, maxBalanceCents int64) (int64, error) {
+	loanID, err := r.LoanRepository.CreateLoan(ctx, bookID, userID, dueDate, maxBalanceCents)
+	if err != nil {
+		return 0, err
+	}
+	invalidateBook(ctx, r.cache, bookID)
+	return loanID, nil
+}
+
+func (r *cachingLoanRepository) CheckoutCopy(ctx context.Context, barcode string, userID int64, dueDate time.Time, maxBalanceCents int64) (int64, error) {
+	loanID, err := r.LoanRepository.CheckoutCopy(ctx, barcode, userID, dueDate, maxBalanceCents)
+	if err != nil {
+		return 0, err
+	}
+	// The checkout is committed, so the cache is updated even if the request is going away.
+	ctx = context.WithoutCancel(ctx)
+	// The new loan names the book whose stock changed.
+	if loan, err := r.LoanRepository.GetLoanByID(ctx, loanID); err == nil {
+		invalidateBook(ctx, r.cache, loan.BookID)
+	} else {
+		invalidateListings(ctx, r.cache)
+	}
+	return loanID, nil
+}
+
+func (r *cachingLoanRepository) ReturnLoan(ctx context.Context, loanID int64, holdExpiresAt time.Time) (int64, error) {
+	fine, err := r.LoanRepository.ReturnLoan(ctx, loanID, holdExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	// The return is committed, so the cache is updated even if the request is going away.
+	ctx = context.WithoutCancel(ctx)
+	// The returned loan names the book whose stock changed.
+	if loan, err := r.LoanRepository.GetLoanByID(ctx, loanID); err == nil {
+		invalidateBook(ctx, r.cache, loan.BookID)
+	} else {
+		invalidateListings(ctx, r.cache)
+	}
+	return fine, nil
+}
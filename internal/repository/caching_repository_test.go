@@ -0,0 +1,372 @@
+// Package repository contains tests for the repository layer.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Lec7ral/fullAPI/internal/cache"
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// failingCache is a cache whose every call fails, like Redis after it goes away.
+type failingCache struct{}
+
+func (failingCache) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func (failingCache) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func (failingCache) Delete(context.Context, ...string) error {
+	return errors.New("connection refused")
+}
+
+// TestCachingAuthorRepository_Search tests that a page of authors is read from the
+// database once, served from the cache afterwards, and reloaded after an author is created.
+func TestCachingAuthorRepository_Search(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	stats := cache.NewStats("memory")
+	repo := NewCachingAuthorRepository(NewSQLiteAuthorRepository(db), cache.NewLRUCache(10), time.Minute, stats.Counter("authors"))
+	countQuery := "SELECT COUNT(*) FROM authors WHERE 1=1"
+	pageQuery := "SELECT id, name, bio FROM authors WHERE 1=1 ORDER BY id LIMIT ? OFFSET ?"
+
+	mock.ExpectQuery(regexp.QuoteMeta(countQuery)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(pageQuery)).WithArgs(20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "bio"}).AddRow(1, "Frank Herbert", ""))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO authors (name, bio) VALUES (?, ?)")).
+		WithArgs("Ursula K. Le Guin", "").WillReturnResult(sqlmock.NewResult(2, 1))
+	expectAudit(mock, "sqlite", models.AuditCreate, models.AuditAuthor, 2, sqlmock.AnyArg())
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(countQuery)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta(pageQuery)).WithArgs(20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "bio"}).AddRow(1, "Frank Herbert", "").AddRow(2, "Ursula K. Le Guin", ""))
+
+	for i := 0; i < 2; i++ {
+		authors, total, err := repo.Search(context.Background(), AuthorFilter{}, 20, 0, "", "")
+		if err != nil || len(authors) != 1 || total != 1 {
+			t.Fatalf("expected 1 author, but got %d of %d (error %v)", len(authors), total, err)
+		}
+	}
+	if _, err := repo.Create(context.Background(), models.Author{Name: "Ursula K. Le Guin"}); err != nil {
+		t.Fatalf("unexpected error creating author: %s", err)
+	}
+	authors, total, err := repo.Search(context.Background(), AuthorFilter{}, 20, 0, "", "")
+	if err != nil || len(authors) != 2 || total != 2 {
+		t.Errorf("expected the new author to be listed, but got %d of %d authors (error %v)", len(authors), total, err)
+	}
+
+	if got := stats.Snapshot()[0]; got.Hits != 1 || got.Misses != 2 {
+		t.Errorf("expected 1 hit and 2 misses, but got %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestCachingAuthorRepository_CacheDown tests that a failing cache falls back to the database.
+func TestCachingAuthorRepository_CacheDown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	stats := cache.NewStats("redis")
+	repo := NewCachingAuthorRepository(NewSQLiteAuthorRepository(db), failingCache{}, time.Minute, stats.Counter("authors"))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, bio FROM authors WHERE id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "bio"}).AddRow(1, "Frank Herbert", ""))
+
+	author, err := repo.GetByID(context.Background(), 1)
+
+	if err != nil || author == nil || author.Name != "Frank Herbert" {
+		t.Errorf("expected Frank Herbert from the database, but got %+v (error %v)", author, err)
+	}
+	if got := stats.Snapshot()[0]; got.Errors != 1 {
+		t.Errorf("expected the cache failure to be counted, but got %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// countingBookRepository is a BookRepository that counts searches and can hold them
+// until release is closed, to let concurrent callers pile up. A held search gives up when
+// its context is done.
+type countingBookRepository struct {
+	BookRepository
+	searches atomic.Int32
+	release  chan struct{}
+}
+
+func (r *countingBookRepository) Search(ctx context.Context, filter BookFilter, limit, offset int, sort, order string) ([]models.Book, int, error) {
+	r.searches.Add(1)
+	if r.release != nil {
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+	return []models.Book{{ID: 1, Title: "Dune"}}, 1, nil
+}
+
+func (r *countingBookRepository) Create(ctx context.Context, book models.Book) (int64, error) {
+	return 2, nil
+}
+
+// TestCachingBookRepository_Search tests that equivalent searches share a cached result,
+// and that creating a book makes every cached listing stale.
+func TestCachingBookRepository_Search(t *testing.T) {
+	next := &countingBookRepository{}
+	repo := NewCachingBookRepository(next, cache.NewLRUCache(10), time.Minute, cache.NewStats("memory").Counter("books"))
+	title, sameTitle := "Dune", "  dune "
+
+	repo.Search(context.Background(), BookFilter{Title: &title}, 10, 0, "title", "asc")
+	repo.Search(context.Background(), BookFilter{Title: &sameTitle}, 10, 0, "title", "ASC")
+	if got := next.searches.Load(); got != 1 {
+		t.Errorf("expected equivalent searches to share a result, but the database was searched %d times", got)
+	}
+
+	repo.Search(context.Background(), BookFilter{Title: &title}, 10, 10, "title", "asc")
+	if got := next.searches.Load(); got != 2 {
+		t.Errorf("expected another page to be searched separately, but the database was searched %d times", got)
+	}
+
+	if _, err := repo.Create(context.Background(), models.Book{Title: "Dune Messiah"}); err != nil {
+		t.Fatalf("unexpected error creating book: %s", err)
+	}
+	repo.Search(context.Background(), BookFilter{Title: &title}, 10, 0, "title", "asc")
+	if got := next.searches.Load(); got != 3 {
+		t.Errorf("expected the listing to be searched again after a create, but the database was searched %d times", got)
+	}
+}
+
+// TestCachingBookRepository_SearchSingleFlight tests that concurrent misses on the same
+// search send a single query to the database.
+func TestCachingBookRepository_SearchSingleFlight(t *testing.T) {
+	next := &countingBookRepository{release: make(chan struct{})}
+	repo := NewCachingBookRepository(next, cache.NewLRUCache(10), time.Minute, cache.NewStats("memory").Counter("books"))
+	title := "Dune"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			books, total, err := repo.Search(context.Background(), BookFilter{Title: &title}, 10, 0, "", "")
+			if err != nil || total != 1 || len(books) != 1 {
+				t.Errorf("expected one book, but got %d (total %d, error %v)", len(books), total, err)
+			}
+		}()
+	}
+	// Give every caller time to miss and join the search in flight.
+	time.Sleep(50 * time.Millisecond)
+	close(next.release)
+	wg.Wait()
+
+	if got := next.searches.Load(); got != 1 {
+		t.Errorf("expected a single database search, but got %d", got)
+	}
+}
+
+// TestCachingBookRepository_SearchSingleFlightCancelled tests that when the caller whose
+// miss started a shared search gives up, it alone gets its context's error, and the
+// callers waiting on the same search still get the result.
+func TestCachingBookRepository_SearchSingleFlightCancelled(t *testing.T) {
+	next := &countingBookRepository{release: make(chan struct{})}
+	repo := NewCachingBookRepository(next, cache.NewLRUCache(10), time.Minute, cache.NewStats("memory").Counter("books"))
+	title := "Dune"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, _, err := repo.Search(ctx, BookFilter{Title: &title}, 10, 0, "", "")
+		firstErr <- err
+	}()
+	// Let the first caller start the search before the others join it.
+	time.Sleep(20 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			books, total, err := repo.Search(context.Background(), BookFilter{Title: &title}, 10, 0, "", "")
+			if err != nil || total != 1 || len(books) != 1 {
+				t.Errorf("expected one book, but got %d (total %d, error %v)", len(books), total, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled caller to get context.Canceled, but got %v", err)
+	}
+	close(next.release)
+	wg.Wait()
+
+	if got := next.searches.Load(); got != 1 {
+		t.Errorf("expected a single database search, but got %d", got)
+	}
+}
+
+// TestCachingLoanRepository_CreateLoan tests that a failed checkout leaves the cache alone,
+// and that a successful one invalidates the cached book and book listings.
+func TestCachingLoanRepository_CreateLoan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	c := cache.NewLRUCache(10)
+	c.Set(ctx, "book:1", []byte(`{"id":1,"stock":1}`), time.Minute)
+	generation, err := listingsGeneration(ctx, c)
+	if err != nil {
+		t.Fatalf("unexpected error starting a generation: %s", err)
+	}
+	repo := NewCachingLoanRepository(NewSQLiteLoanRepository(db), c)
+	backend := loanBackends[0]
+	dueDate := time.Now().AddDate(0, 0, 14)
+
+	mock.ExpectBegin().WillReturnError(errors.New("database is locked"))
+	if _, err := repo.CreateLoan(context.Background(), 1, 1, dueDate, 1000); err == nil {
+		t.Fatalf("expected the checkout to fail")
+	}
+	if _, found, _ := c.Get(ctx, "book:1"); !found {
+		t.Errorf("expected the book to stay cached after a failed checkout")
+	}
+
+	mock.ExpectBegin()
+	expectEligible(mock, backend.name, 1, 1)
+	mock.ExpectQuery(regexp.QuoteMeta(backend.selectHold)).
+		WithArgs(1, 1, models.HoldStatusReady).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(backend.selectCopy)).
+		WithArgs(1, models.CopyStatusAvailable).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta(backend.checkoutCopy)).
+		WithArgs(models.CopyStatusOnLoan, 3, models.CopyStatusAvailable).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertLoan(mock, backend.name, backend.insertLoan, 3, 1, dueDate, 1)
+	expectAudit(mock, backend.name, models.AuditCheckout, models.AuditLoan, 1, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	if _, err := repo.CreateLoan(context.Background(), 1, 1, dueDate, 1000); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, found, _ := c.Get(ctx, "book:1"); found {
+		t.Errorf("expected the book to be invalidated after a checkout")
+	}
+	if current, _ := listingsGeneration(ctx, c); current == generation {
+		t.Errorf("expected a new generation of listings after a checkout")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// stubCopyRepository is a CopyRepository holding a single copy, whose writes always succeed.
+type stubCopyRepository struct {
+	CopyRepository
+	bookCopy models.Copy
+}
+
+func (r *stubCopyRepository) Update(ctx context.Context, id int64, bookCopy models.Copy, holdExpiresAt time.Time) error {
+	return nil
+}
+
+func (r *stubCopyRepository) GetByID(ctx context.Context, id int64) (*models.Copy, error) {
+	if id != r.bookCopy.ID {
+		return nil, ErrNotFound
+	}
+	bookCopy := r.bookCopy
+	return &bookCopy, nil
+}
+
+// TestCachingCopyRepository_Update tests that changing a copy invalidates its book and
+// the book listings, even when the request does not say which book the copy belongs to.
+func TestCachingCopyRepository_Update(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewLRUCache(10)
+	c.Set(ctx, "book:1", []byte(`{"id":1,"stock":0}`), time.Minute)
+	generation, err := listingsGeneration(ctx, c)
+	if err != nil {
+		t.Fatalf("unexpected error starting a generation: %s", err)
+	}
+	repo := NewCachingCopyRepository(&stubCopyRepository{bookCopy: models.Copy{ID: 3, BookID: 1}}, c)
+
+	if err := repo.Update(ctx, 3, models.Copy{Status: models.CopyStatusAvailable}, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, found, _ := c.Get(ctx, "book:1"); found {
+		t.Errorf("expected the book to be invalidated after its copy changed")
+	}
+	if current, _ := listingsGeneration(ctx, c); current == generation {
+		t.Errorf("expected a new generation of listings after a copy changed")
+	}
+}
+
+// stubHoldRepository is a HoldRepository whose ready holds all expire at once.
+type stubHoldRepository struct {
+	HoldRepository
+	expired []models.Hold
+}
+
+func (r *stubHoldRepository) ExpireReadyHolds(ctx context.Context, pickupExpiresAt time.Time) ([]models.Hold, error) {
+	return r.expired, nil
+}
+
+// TestCachingHoldRepository_ExpireReadyHolds tests that expiring holds invalidates the
+// books whose copies they gave up, and leaves other cached books alone.
+func TestCachingHoldRepository_ExpireReadyHolds(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewLRUCache(10)
+	for _, key := range []string{"book:1", "book:2", "book:3"} {
+		c.Set(ctx, key, []byte(`{"stock":0}`), time.Minute)
+	}
+	generation, err := listingsGeneration(ctx, c)
+	if err != nil {
+		t.Fatalf("unexpected error starting a generation: %s", err)
+	}
+	repo := NewCachingHoldRepository(&stubHoldRepository{expired: []models.Hold{{ID: 7, BookID: 1}, {ID: 8, BookID: 2}}}, c)
+
+	expired, err := repo.ExpireReadyHolds(ctx, time.Now().AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(expired) != 2 {
+		t.Errorf("expected 2 expired holds, but got %d", len(expired))
+	}
+	for _, key := range []string{"book:1", "book:2"} {
+		if _, found, _ := c.Get(ctx, key); found {
+			t.Errorf("expected %s to be invalidated after its hold expired", key)
+		}
+	}
+	if _, found, _ := c.Get(ctx, "book:3"); !found {
+		t.Errorf("expected book:3 to stay cached")
+	}
+	if current, _ := listingsGeneration(ctx, c); current == generation {
+		t.Errorf("expected a new generation of listings after holds expired")
+	}
+}
@@ -0,0 +1,196 @@
+// Package repository provides a data abstraction layer.
+// This file contains the implementation for copy data operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// CopyRepository defines the interface for copy data operations.
+type CopyRepository interface {
+	Create(ctx context.Context, bookCopy models.Copy, holdExpiresAt time.Time) (int64, error)
+	Update(ctx context.Context, id int64, bookCopy models.Copy, holdExpiresAt time.Time) error
+	Delete(ctx context.Context, id int64) error
+	GetByID(ctx context.Context, id int64) (*models.Copy, error)
+	ListByBook(ctx context.Context, bookID int64) ([]models.Copy, error)
+}
+
+// availableCopiesSQL counts the available copies of the book aliased as b.
+// It is the derived replacement for the old books.stock column.
+const availableCopiesSQL = "(SELECT COUNT(*) FROM copies c WHERE c.book_id = b.id AND c.status = 'available')"
+
+// generatedBarcode returns the barcode given to the n-th copy created along with a book.
+// It follows the same LIB-<book>-<n> scheme the migration used to convert stock into copies.
+func generatedBarcode(bookID int64, n int) string {
+	return fmt.Sprintf("LIB-%06d-%03d", bookID, n)
+}
+
+// checkCopyStatusChange rejects status changes into or out of "on_loan", which only
+// checking a copy out and returning it may make, and "on_hold", which only holds may make.
+func checkCopyStatusChange(current, next string) error {
+	if current == next {
+		return nil
+	}
+	if current == models.CopyStatusOnLoan || next == models.CopyStatusOnLoan {
+		return ErrCopyOnLoan
+	}
+	if current == models.CopyStatusOnHold || next == models.CopyStatusOnHold {
+		return ErrCopyOnHold
+	}
+	return nil
+}
+
+// sqliteCopyRepository is the concrete implementation for SQLite.
+type sqliteCopyRepository struct {
+	DB *sql.DB
+}
+
+// NewSQLiteCopyRepository creates a new repository instance.
+func NewSQLiteCopyRepository(db *sql.DB) CopyRepository {
+	return &sqliteCopyRepository{DB: db}
+}
+
+// Create inserts a new copy of a book. An available copy goes to the oldest waiting hold
+// on the book first, ready for pickup until holdExpiresAt.
+func (r *sqliteCopyRepository) Create(ctx context.Context, bookCopy models.Copy, holdExpiresAt time.Time) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO copies (book_id, barcode, condition, status, acquisition_date) VALUES (?, ?, ?, ?, ?)",
+		bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.Status, bookCopy.AcquisitionDate)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrBarcodeExists
+		}
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if bookCopy.Status == models.CopyStatusAvailable {
+		if err := releaseCopySQLite(ctx, tx, id, bookCopy.BookID, holdExpiresAt); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// Update changes a copy's barcode, condition, status and acquisition date.
+// Copies on loan keep that status until they are returned. A copy put back into
+// circulation goes to the oldest waiting hold on its book first, ready for pickup until
+// holdExpiresAt.
+func (r *sqliteCopyRepository) Update(ctx context.Context, id int64, bookCopy models.Copy, holdExpiresAt time.Time) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var bookID int64
+	err = tx.QueryRowContext(ctx, "SELECT status, book_id FROM copies WHERE id = ?", id).Scan(&status, &bookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if err := checkCopyStatusChange(status, bookCopy.Status); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE copies SET barcode = ?, condition = ?, status = ?, acquisition_date = ? WHERE id = ?",
+		bookCopy.Barcode, bookCopy.Condition, bookCopy.Status, bookCopy.AcquisitionDate, id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrBarcodeExists
+		}
+		return err
+	}
+
+	if status != models.CopyStatusAvailable && bookCopy.Status == models.CopyStatusAvailable {
+		if err := releaseCopySQLite(ctx, tx, id, bookID, holdExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a copy that has never been lent out.
+// Copies with loan history should be withdrawn instead so the history stays intact.
+func (r *sqliteCopyRepository) Delete(ctx context.Context, id int64) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var loanCount int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(l.id) FROM copies c LEFT JOIN loans l ON l.copy_id = c.id WHERE c.id = ? GROUP BY c.id", id).Scan(&loanCount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if loanCount > 0 {
+		return ErrCopyHasLoans
+	}
+
+	// Holds keep their history without the copy, as ON DELETE SET NULL does in PostgreSQL.
+	if _, err := tx.ExecContext(ctx, "UPDATE holds SET copy_id = NULL WHERE copy_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM copies WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetByID finds a copy by its ID.
+func (r *sqliteCopyRepository) GetByID(ctx context.Context, id int64) (*models.Copy, error) {
+	var bookCopy models.Copy
+	query := "SELECT id, book_id, barcode, condition, status, acquisition_date FROM copies WHERE id = ?"
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&bookCopy.ID, &bookCopy.BookID, &bookCopy.Barcode, &bookCopy.Condition, &bookCopy.Status, &bookCopy.AcquisitionDate)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &bookCopy, nil
+}
+
+// ListByBook returns every copy of a book, ordered by ID.
+func (r *sqliteCopyRepository) ListByBook(ctx context.Context, bookID int64) ([]models.Copy, error) {
+	query := "SELECT id, book_id, barcode, condition, status, acquisition_date FROM copies WHERE book_id = ? ORDER BY id"
+	rows, err := r.DB.QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	copies := []models.Copy{}
+	for rows.Next() {
+		var bookCopy models.Copy
+		if err := rows.Scan(&bookCopy.ID, &bookCopy.BookID, &bookCopy.Barcode, &bookCopy.Condition, &bookCopy.Status, &bookCopy.AcquisitionDate); err != nil {
+			return nil, err
+		}
+		copies = append(copies, bookCopy)
+	}
+	return copies, rows.Err()
+}
@@ -0,0 +1,282 @@
+// Package repository contains tests for the repository layer.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// copyBackends lists the CopyRepository implementations every test runs against,
+// along with the statements each one issues.
+var copyBackends = []struct {
+	name         string
+	newRepo      func(*sql.DB) CopyRepository
+	selectStatus string
+	updateCopy   string
+	countLoans   string
+	nextHold     string
+	readyHold    string
+	setStatus    string
+	duplicateErr error
+}{
+	{
+		name:         "sqlite",
+		newRepo:      NewSQLiteCopyRepository,
+		selectStatus: "SELECT status, book_id FROM copies WHERE id = ?",
+		updateCopy:   "UPDATE copies SET barcode = ?, condition = ?, status = ?, acquisition_date = ? WHERE id = ?",
+		countLoans:   "SELECT COUNT(l.id) FROM copies c LEFT JOIN loans l ON l.copy_id = c.id WHERE c.id = ? GROUP BY c.id",
+		nextHold:     "SELECT id FROM holds WHERE book_id = ? AND status = ? ORDER BY id LIMIT 1",
+		readyHold:    "UPDATE holds SET status = ?, copy_id = ?, ready_at = ?, expires_at = ? WHERE id = ?",
+		setStatus:    "UPDATE copies SET status = ? WHERE id = ?",
+		duplicateErr: errors.New("UNIQUE constraint failed: copies.barcode"),
+	},
+	{
+		name:         "postgres",
+		newRepo:      NewPostgresCopyRepository,
+		selectStatus: "SELECT status, book_id FROM copies WHERE id = $1 FOR UPDATE",
+		updateCopy:   "UPDATE copies SET barcode = $1, condition = $2, status = $3, acquisition_date = $4 WHERE id = $5",
+		countLoans:   "SELECT (SELECT COUNT(*) FROM loans l WHERE l.copy_id = c.id) FROM copies c WHERE c.id = $1 FOR UPDATE",
+		nextHold:     "SELECT id FROM holds WHERE book_id = $1 AND status = $2 ORDER BY id LIMIT 1 FOR UPDATE",
+		readyHold:    "UPDATE holds SET status = $1, copy_id = $2, ready_at = $3, expires_at = $4 WHERE id = $5",
+		setStatus:    "UPDATE copies SET status = $1 WHERE id = $2",
+		duplicateErr: &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"},
+	},
+}
+
+// TestUpdateCopy_Success tests that a copy's details are updated when its status may change.
+func TestUpdateCopy_Success(t *testing.T) {
+	for _, backend := range copyBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			bookCopy := models.Copy{Barcode: "LIB-000001-001", Condition: "fair", Status: models.CopyStatusInRepair, AcquisitionDate: "2024-03-01"}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectStatus)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"status", "book_id"}).AddRow(models.CopyStatusAvailable, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.updateCopy)).
+				WithArgs(bookCopy.Barcode, bookCopy.Condition, bookCopy.Status, bookCopy.AcquisitionDate, 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			err = repo.Update(context.Background(), 1, bookCopy, time.Now())
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestCreateCopy_ReleasedToHold tests that a new available copy of a book with waiting
+// holds is set aside for the oldest one rather than left for anyone to borrow.
+func TestCreateCopy_ReleasedToHold(t *testing.T) {
+	expectations := map[string]func(sqlmock.Sqlmock, models.Copy){
+		"sqlite": func(mock sqlmock.Sqlmock, bookCopy models.Copy) {
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO copies (book_id, barcode, condition, status, acquisition_date) VALUES (?, ?, ?, ?, ?)")).
+				WithArgs(bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.Status, bookCopy.AcquisitionDate).
+				WillReturnResult(sqlmock.NewResult(3, 1))
+		},
+		"postgres": func(mock sqlmock.Sqlmock, bookCopy models.Copy) {
+			mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO copies (book_id, barcode, condition, status, acquisition_date) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+				WithArgs(bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.Status, bookCopy.AcquisitionDate).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		},
+	}
+
+	for _, backend := range copyBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			bookCopy := models.Copy{BookID: 1, Barcode: "LIB-000001-004", Condition: "good", Status: models.CopyStatusAvailable, AcquisitionDate: "2024-03-01"}
+			pickupBy := time.Now().AddDate(0, 0, 3)
+
+			mock.ExpectBegin()
+			expectations[backend.name](mock, bookCopy)
+			mock.ExpectQuery(regexp.QuoteMeta(backend.nextHold)).
+				WithArgs(1, models.HoldStatusWaiting).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+			mock.ExpectExec(regexp.QuoteMeta(backend.readyHold)).
+				WithArgs(models.HoldStatusReady, 3, sqlmock.AnyArg(), pickupBy, 9).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.setStatus)).
+				WithArgs(models.CopyStatusOnHold, 3).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			id, err := repo.Create(context.Background(), bookCopy, pickupBy)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if id != 3 {
+				t.Errorf("expected copy ID to be 3, but got %d", id)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestUpdateCopy_ReleasedToHold tests that a withdrawn copy put back into circulation is
+// set aside for the oldest waiting hold on its book.
+func TestUpdateCopy_ReleasedToHold(t *testing.T) {
+	for _, backend := range copyBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			bookCopy := models.Copy{Barcode: "LIB-000001-001", Condition: "good", Status: models.CopyStatusAvailable, AcquisitionDate: "2024-03-01"}
+			pickupBy := time.Now().AddDate(0, 0, 3)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectStatus)).
+				WithArgs(2).
+				WillReturnRows(sqlmock.NewRows([]string{"status", "book_id"}).AddRow(models.CopyStatusWithdrawn, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.updateCopy)).
+				WithArgs(bookCopy.Barcode, bookCopy.Condition, bookCopy.Status, bookCopy.AcquisitionDate, 2).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.nextHold)).
+				WithArgs(1, models.HoldStatusWaiting).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+			mock.ExpectExec(regexp.QuoteMeta(backend.readyHold)).
+				WithArgs(models.HoldStatusReady, 2, sqlmock.AnyArg(), pickupBy, 9).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.setStatus)).
+				WithArgs(models.CopyStatusOnHold, 2).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			err = repo.Update(context.Background(), 2, bookCopy, pickupBy)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestUpdateCopy_OnLoan tests that the status of a copy on loan cannot be changed directly.
+func TestUpdateCopy_OnLoan(t *testing.T) {
+	for _, backend := range copyBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			bookCopy := models.Copy{Barcode: "LIB-000001-001", Condition: "good", Status: models.CopyStatusAvailable, AcquisitionDate: "2024-03-01"}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectStatus)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"status", "book_id"}).AddRow(models.CopyStatusOnLoan, 1))
+			mock.ExpectRollback()
+
+			err = repo.Update(context.Background(), 1, bookCopy, time.Now())
+
+			if !errors.Is(err, ErrCopyOnLoan) {
+				t.Errorf("expected error to be ErrCopyOnLoan, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestUpdateCopy_DuplicateBarcode tests the case where the new barcode belongs to another copy.
+func TestUpdateCopy_DuplicateBarcode(t *testing.T) {
+	for _, backend := range copyBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			bookCopy := models.Copy{Barcode: "LIB-000002-001", Condition: "good", Status: models.CopyStatusAvailable, AcquisitionDate: "2024-03-01"}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectStatus)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"status", "book_id"}).AddRow(models.CopyStatusAvailable, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.updateCopy)).
+				WithArgs(bookCopy.Barcode, bookCopy.Condition, bookCopy.Status, bookCopy.AcquisitionDate, 1).
+				WillReturnError(backend.duplicateErr)
+			mock.ExpectRollback()
+
+			err = repo.Update(context.Background(), 1, bookCopy, time.Now())
+
+			if !errors.Is(err, ErrBarcodeExists) {
+				t.Errorf("expected error to be ErrBarcodeExists, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestDeleteCopy_HasLoans tests that a copy with loan history is not deleted.
+func TestDeleteCopy_HasLoans(t *testing.T) {
+	for _, backend := range copyBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.countLoans)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			mock.ExpectRollback()
+
+			err = repo.Delete(context.Background(), 1)
+
+			if !errors.Is(err, ErrCopyHasLoans) {
+				t.Errorf("expected error to be ErrCopyHasLoans, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package repository provides a data abstraction layer.
+// This file contains the implementation for fine policy operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// FinePolicyRepository defines the interface for fine policy operations.
+type FinePolicyRepository interface {
+	GetAll(ctx context.Context) ([]models.FinePolicy, error)
+	Upsert(ctx context.Context, policy models.FinePolicy) error
+}
+
+// sqliteFinePolicyRepository is the concrete implementation for SQLite.
+type sqliteFinePolicyRepository struct {
+	DB *sql.DB
+}
+
+// NewSQLiteFinePolicyRepository creates a new repository instance.
+func NewSQLiteFinePolicyRepository(db *sql.DB) FinePolicyRepository {
+	return &sqliteFinePolicyRepository{DB: db}
+}
+
+// GetAll returns the fine policy of every material type.
+func (r *sqliteFinePolicyRepository) GetAll(ctx context.Context) ([]models.FinePolicy, error) {
+	rows, err := r.DB.QueryContext(ctx, "SELECT material_type, daily_rate_cents, max_fine_cents, grace_days FROM fine_policies ORDER BY material_type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanFinePolicies(rows)
+}
+
+// Upsert creates or replaces the fine policy for a material type.
+func (r *sqliteFinePolicyRepository) Upsert(ctx context.Context, policy models.FinePolicy) error {
+	_, err := r.DB.ExecContext(ctx, `
+		INSERT INTO fine_policies (material_type, daily_rate_cents, max_fine_cents, grace_days) VALUES (?, ?, ?, ?)
+		ON CONFLICT (material_type) DO UPDATE SET
+			daily_rate_cents = excluded.daily_rate_cents,
+			max_fine_cents = excluded.max_fine_cents,
+			grace_days = excluded.grace_days`,
+		policy.MaterialType, policy.DailyRateCents, policy.MaxFineCents, policy.GraceDays)
+	return err
+}
+
+// scanFinePolicies reads fine_policies rows in column order.
+func scanFinePolicies(rows *sql.Rows) ([]models.FinePolicy, error) {
+	policies := []models.FinePolicy{}
+	for rows.Next() {
+		var policy models.FinePolicy
+		if err := rows.Scan(&policy.MaterialType, &policy.DailyRateCents, &policy.MaxFineCents, &policy.GraceDays); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
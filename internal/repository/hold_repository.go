@@ -0,0 +1,273 @@
+// Package repository provides a data abstraction layer.
+// This file contains the implementation for hold (reservation queue) operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// HoldRepository defines the interface for hold data operations.
+// Holds on a book are served in the order they were placed.
+type HoldRepository interface {
+	Create(ctx context.Context, bookID, userID int64) (int64, error)
+	GetByID(ctx context.Context, id int64) (*models.Hold, error)
+	GetActiveHoldsByUserID(ctx context.Context, userID int64) ([]models.Hold, error)
+	Cancel(ctx context.Context, id int64, pickupExpiresAt time.Time) error
+	ExpireReadyHolds(ctx context.Context, pickupExpiresAt time.Time) ([]models.Hold, error)
+}
+
+// sqliteHoldRepository is the concrete implementation for SQLite.
+type sqliteHoldRepository struct {
+	DB *sql.DB
+}
+
+// NewSQLiteHoldRepository creates a new repository instance.
+func NewSQLiteHoldRepository(db *sql.DB) HoldRepository {
+	return &sqliteHoldRepository{DB: db}
+}
+
+// releaseCopySQLite puts a copy that has come back into circulation aside for the oldest
+// waiting hold on its book, ready for pickup until pickupExpiresAt. When nobody is
+// waiting, the copy becomes available again.
+func releaseCopySQLite(ctx context.Context, tx *sql.Tx, copyID, bookID int64, pickupExpiresAt time.Time) error {
+	var holdID int64
+	err := tx.QueryRowContext(ctx, "SELECT id FROM holds WHERE book_id = ? AND status = ? ORDER BY id LIMIT 1",
+		bookID, models.HoldStatusWaiting).Scan(&holdID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = tx.ExecContext(ctx, "UPDATE copies SET status = ? WHERE id = ?", models.CopyStatusAvailable, copyID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE holds SET status = ?, copy_id = ?, ready_at = ?, expires_at = ? WHERE id = ?",
+		models.HoldStatusReady, copyID, time.Now(), pickupExpiresAt, holdID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE copies SET status = ? WHERE id = ?", models.CopyStatusOnHold, copyID)
+	return err
+}
+
+// Create places a hold on a book for the user, at the back of the book's queue.
+// Books with an available copy cannot be held; they can be borrowed straight away.
+func (r *sqliteHoldRepository) Create(ctx context.Context, bookID, userID int64) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var bookExists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM books WHERE id = ?)", bookID).Scan(&bookExists); err != nil {
+		return 0, err
+	}
+	if !bookExists {
+		return 0, ErrNotFound
+	}
+
+	var available bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM copies WHERE book_id = ? AND status = ?)", bookID, models.CopyStatusAvailable).Scan(&available)
+	if err != nil {
+		return 0, err
+	}
+	if available {
+		return 0, ErrCopyAvailable
+	}
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO holds (book_id, user_id, status, created_at) VALUES (?, ?, ?, ?)",
+		bookID, userID, models.HoldStatusWaiting, time.Now())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrHoldExists
+		}
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// GetByID finds a hold by its ID.
+func (r *sqliteHoldRepository) GetByID(ctx context.Context, id int64) (*models.Hold, error) {
+	query := "SELECT id, book_id, user_id, status, copy_id, created_at, ready_at, expires_at FROM holds WHERE id = ?"
+	hold, err := scanHold(r.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return hold, nil
+}
+
+// GetActiveHoldsByUserID returns the user's waiting and ready holds, oldest first,
+// with each waiting hold's position in its book's queue.
+func (r *sqliteHoldRepository) GetActiveHoldsByUserID(ctx context.Context, userID int64) ([]models.Hold, error) {
+	query := `
+		SELECT h.id, h.book_id, h.user_id, h.status, h.copy_id, h.created_at, h.ready_at, h.expires_at,
+			(SELECT COUNT(*) FROM holds q WHERE q.book_id = h.book_id AND q.status = 'waiting' AND q.id <= h.id),
+			b.title, b.isbn
+		FROM holds h
+		JOIN books b ON h.book_id = b.id
+		WHERE h.user_id = ? AND h.status IN ('waiting', 'ready')
+		ORDER BY h.id
+	`
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanActiveHolds(rows)
+}
+
+// Cancel withdraws a waiting or ready hold. A copy set aside for a ready hold passes
+// to the next hold in the queue, or back on the shelf.
+func (r *sqliteHoldRepository) Cancel(ctx context.Context, id int64, pickupExpiresAt time.Time) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var bookID int64
+	var status string
+	var copyID sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT book_id, status, copy_id FROM holds WHERE id = ?", id).Scan(&bookID, &status, &copyID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if status != models.HoldStatusWaiting && status != models.HoldStatusReady {
+		return ErrHoldNotActive
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE holds SET status = ? WHERE id = ?", models.HoldStatusCancelled, id); err != nil {
+		return err
+	}
+	if status == models.HoldStatusReady && copyID.Valid {
+		if err := releaseCopySQLite(ctx, tx, copyID.Int64, bookID, pickupExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ExpireReadyHolds expires ready holds whose pickup deadline has passed, passing each
+// copy on to the next hold in its queue. It returns the holds that expired, with their
+// book and copy.
+func (r *sqliteHoldRepository) ExpireReadyHolds(ctx context.Context, pickupExpiresAt time.Time) ([]models.Hold, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, book_id, copy_id FROM holds WHERE status = ? AND julianday(expires_at) < julianday('now') ORDER BY id",
+		models.HoldStatusReady)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := scanExpiredHolds(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hold := range expired {
+		if _, err := tx.ExecContext(ctx, "UPDATE holds SET status = ? WHERE id = ?", models.HoldStatusExpired, hold.ID); err != nil {
+			return nil, err
+		}
+		if hold.CopyID != nil {
+			if err := releaseCopySQLite(ctx, tx, *hold.CopyID, hold.BookID, pickupExpiresAt); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// scanHold scans a single holds row.
+func scanHold(row *sql.Row) (*models.Hold, error) {
+	var hold models.Hold
+	var copyID sql.NullInt64
+	var readyAt, expiresAt sql.NullTime
+	if err := row.Scan(&hold.ID, &hold.BookID, &hold.UserID, &hold.Status, &copyID, &hold.CreatedAt, &readyAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	setHoldNullables(&hold, copyID, readyAt, expiresAt)
+	return &hold, nil
+}
+
+// scanActiveHolds scans holds rows followed by the queue position and the book's title and ISBN.
+func scanActiveHolds(rows *sql.Rows) ([]models.Hold, error) {
+	holds := []models.Hold{}
+	for rows.Next() {
+		var hold models.Hold
+		var book models.Book
+		var copyID sql.NullInt64
+		var readyAt, expiresAt sql.NullTime
+		var position int
+		if err := rows.Scan(&hold.ID, &hold.BookID, &hold.UserID, &hold.Status, &copyID, &hold.CreatedAt, &readyAt, &expiresAt,
+			&position, &book.Title, &book.ISBN); err != nil {
+			return nil, err
+		}
+		setHoldNullables(&hold, copyID, readyAt, expiresAt)
+		if hold.Status == models.HoldStatusWaiting {
+			hold.Position = position
+		}
+		book.ID = hold.BookID
+		hold.Book = &book
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+// scanExpiredHolds reads the id, book_id and copy_id of each row and closes rows, so the
+// transaction they were queried in is free for the updates that follow.
+func scanExpiredHolds(rows *sql.Rows) ([]models.Hold, error) {
+	defer rows.Close()
+
+	var holds []models.Hold
+	for rows.Next() {
+		var hold models.Hold
+		var copyID sql.NullInt64
+		if err := rows.Scan(&hold.ID, &hold.BookID, &copyID); err != nil {
+			return nil, err
+		}
+		if copyID.Valid {
+			hold.CopyID = &copyID.Int64
+		}
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+// setHoldNullables copies the nullable columns of a holds row onto hold.
+func setHoldNullables(hold *models.Hold, copyID sql.NullInt64, readyAt, expiresAt sql.NullTime) {
+	if copyID.Valid {
+		hold.CopyID = &copyID.Int64
+	}
+	if readyAt.Valid {
+		hold.ReadyAt = &readyAt.Time
+	}
+	if expiresAt.Valid {
+		hold.ExpiresAt = &expiresAt.Time
+	}
+}
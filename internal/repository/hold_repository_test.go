@@ -0,0 +1,257 @@
+// Package repository contains tests for the repository layer.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// holdBackends lists the HoldRepository implementations every test runs against,
+// along with the statements each one issues.
+var holdBackends = []struct {
+	name        string
+	newRepo     func(*sql.DB) HoldRepository
+	bookExists  string
+	available   string
+	selectHold  string
+	cancelHold  string
+	nextHold    string
+	readyHold   string
+	releaseCopy string
+}{
+	{
+		name:        "sqlite",
+		newRepo:     NewSQLiteHoldRepository,
+		bookExists:  "SELECT EXISTS (SELECT 1 FROM books WHERE id = ?)",
+		available:   "SELECT EXISTS (SELECT 1 FROM copies WHERE book_id = ? AND status = ?)",
+		selectHold:  "SELECT book_id, status, copy_id FROM holds WHERE id = ?",
+		cancelHold:  "UPDATE holds SET status = ? WHERE id = ?",
+		nextHold:    "SELECT id FROM holds WHERE book_id = ? AND status = ? ORDER BY id LIMIT 1",
+		readyHold:   "UPDATE holds SET status = ?, copy_id = ?, ready_at = ?, expires_at = ? WHERE id = ?",
+		releaseCopy: "UPDATE copies SET status = ? WHERE id = ?",
+	},
+	{
+		name:        "postgres",
+		newRepo:     NewPostgresHoldRepository,
+		bookExists:  "SELECT EXISTS (SELECT 1 FROM books WHERE id = $1)",
+		available:   "SELECT EXISTS (SELECT 1 FROM copies WHERE book_id = $1 AND status = $2)",
+		selectHold:  "SELECT book_id, status, copy_id FROM holds WHERE id = $1 FOR UPDATE",
+		cancelHold:  "UPDATE holds SET status = $1 WHERE id = $2",
+		nextHold:    "SELECT id FROM holds WHERE book_id = $1 AND status = $2 ORDER BY id LIMIT 1 FOR UPDATE",
+		readyHold:   "UPDATE holds SET status = $1, copy_id = $2, ready_at = $3, expires_at = $4 WHERE id = $5",
+		releaseCopy: "UPDATE copies SET status = $1 WHERE id = $2",
+	},
+}
+
+// TestCreateHold_Success tests placing a hold on a book with no available copies.
+func TestCreateHold_Success(t *testing.T) {
+	expectations := map[string]func(sqlmock.Sqlmock){
+		"sqlite": func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO holds (book_id, user_id, status, created_at) VALUES (?, ?, ?, ?)")).
+				WithArgs(1, 2, models.HoldStatusWaiting, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(7, 1))
+		},
+		"postgres": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO holds (book_id, user_id, status, created_at) VALUES ($1, $2, $3, $4) RETURNING id")).
+				WithArgs(1, 2, models.HoldStatusWaiting, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		},
+	}
+
+	for _, backend := range holdBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.bookExists)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.available)).
+				WithArgs(1, models.CopyStatusAvailable).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			expectations[backend.name](mock)
+			mock.ExpectCommit()
+
+			id, err := repo.Create(context.Background(), 1, 2)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if id != 7 {
+				t.Errorf("expected hold ID to be 7, but got %d", id)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestCreateHold_AlreadyHeld tests that a patron's second active hold on the same book,
+// refused by either database's unique index, is reported as ErrHoldExists.
+func TestCreateHold_AlreadyHeld(t *testing.T) {
+	expectations := map[string]func(sqlmock.Sqlmock){
+		"sqlite": func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO holds (book_id, user_id, status, created_at) VALUES (?, ?, ?, ?)")).
+				WithArgs(1, 2, models.HoldStatusWaiting, sqlmock.AnyArg()).
+				WillReturnError(errors.New("UNIQUE constraint failed: holds.book_id, holds.user_id"))
+		},
+		"postgres": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO holds (book_id, user_id, status, created_at) VALUES ($1, $2, $3, $4) RETURNING id")).
+				WithArgs(1, 2, models.HoldStatusWaiting, sqlmock.AnyArg()).
+				WillReturnError(&pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "idx_holds_active_per_user"})
+		},
+	}
+
+	for _, backend := range holdBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.bookExists)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.available)).
+				WithArgs(1, models.CopyStatusAvailable).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			expectations[backend.name](mock)
+			mock.ExpectRollback()
+
+			_, err = repo.Create(context.Background(), 1, 2)
+
+			if !errors.Is(err, ErrHoldExists) {
+				t.Errorf("expected error to be ErrHoldExists, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestCreateHold_CopyAvailable tests that a book with an available copy cannot be held.
+func TestCreateHold_CopyAvailable(t *testing.T) {
+	for _, backend := range holdBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.bookExists)).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.available)).
+				WithArgs(1, models.CopyStatusAvailable).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectRollback()
+
+			_, err = repo.Create(context.Background(), 1, 2)
+
+			if !errors.Is(err, ErrCopyAvailable) {
+				t.Errorf("expected error to be ErrCopyAvailable, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestCancelHold_Ready tests that cancelling a ready hold passes its copy to the next hold in the queue.
+func TestCancelHold_Ready(t *testing.T) {
+	for _, backend := range holdBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			holdID, nextHoldID, bookID, copyID := int64(7), int64(9), int64(1), int64(3)
+			pickupBy := time.Now().AddDate(0, 0, 3)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectHold)).
+				WithArgs(holdID).
+				WillReturnRows(sqlmock.NewRows([]string{"book_id", "status", "copy_id"}).AddRow(bookID, models.HoldStatusReady, copyID))
+			mock.ExpectExec(regexp.QuoteMeta(backend.cancelHold)).
+				WithArgs(models.HoldStatusCancelled, holdID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.nextHold)).
+				WithArgs(bookID, models.HoldStatusWaiting).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(nextHoldID))
+			mock.ExpectExec(regexp.QuoteMeta(backend.readyHold)).
+				WithArgs(models.HoldStatusReady, copyID, sqlmock.AnyArg(), pickupBy, nextHoldID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.releaseCopy)).
+				WithArgs(models.CopyStatusOnHold, copyID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			err = repo.Cancel(context.Background(), holdID, pickupBy)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestCancelHold_NotActive tests that a hold that was already fulfilled cannot be cancelled.
+func TestCancelHold_NotActive(t *testing.T) {
+	for _, backend := range holdBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectHold)).
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"book_id", "status", "copy_id"}).AddRow(1, models.HoldStatusFulfilled, 3))
+			mock.ExpectRollback()
+
+			err = repo.Cancel(context.Background(), 7, time.Now())
+
+			if !errors.Is(err, ErrHoldNotActive) {
+				t.Errorf("expected error to be ErrHoldNotActive, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
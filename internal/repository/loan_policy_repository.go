@@ -0,0 +1,93 @@
+// Package repository provides a data abstraction layer.
+// This file contains the implementation for loan policy operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// LoanPolicyRepository defines the interface for loan policy operations.
+type LoanPolicyRepository interface {
+	GetAll(ctx context.Context) ([]models.LoanPolicy, error)
+	Upsert(ctx context.Context, policy models.LoanPolicy) error
+	Delete(ctx context.Context, role, materialType string) error
+}
+
+// sqliteLoanPolicyRepository is the concrete implementation for SQLite.
+type sqliteLoanPolicyRepository struct {
+	DB *sql.DB
+}
+
+// NewSQLiteLoanPolicyRepository creates a new repository instance.
+func NewSQLiteLoanPolicyRepository(db *sql.DB) LoanPolicyRepository {
+	return &sqliteLoanPolicyRepository{DB: db}
+}
+
+// GetAll returns the loan policy of every role and material type.
+func (r *sqliteLoanPolicyRepository) GetAll(ctx context.Context) ([]models.LoanPolicy, error) {
+	rows, err := r.DB.QueryContext(ctx, "SELECT role, material_type, max_loans FROM loan_policies ORDER BY role, material_type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLoanPolicies(rows)
+}
+
+// Upsert creates or replaces the loan policy for a role and material type. The role must exist.
+func (r *sqliteLoanPolicyRepository) Upsert(ctx context.Context, policy models.LoanPolicy) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE name = ?)", policy.Role).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRoleNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loan_policies (role, material_type, max_loans) VALUES (?, ?, ?)
+		ON CONFLICT (role, material_type) DO UPDATE SET max_loans = excluded.max_loans`,
+		policy.Role, policy.MaterialType, policy.MaxLoans)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes the loan policy for a role and material type, lifting its cap.
+func (r *sqliteLoanPolicyRepository) Delete(ctx context.Context, role, materialType string) error {
+	result, err := r.DB.ExecContext(ctx, "DELETE FROM loan_policies WHERE role = ? AND material_type = ?", role, materialType)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scanLoanPolicies reads loan_policies rows in column order.
+func scanLoanPolicies(rows *sql.Rows) ([]models.LoanPolicy, error) {
+	policies := []models.LoanPolicy{}
+	for rows.Next() {
+		var policy models.LoanPolicy
+		if err := rows.Scan(&policy.Role, &policy.MaterialType, &policy.MaxLoans); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
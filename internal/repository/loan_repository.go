@@ -6,22 +6,31 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/Lec7ral/fullAPI/internal/models"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // LoanFilter holds the criteria for searching loans.
 type LoanFilter struct {
-	Status *string // "active" or "returned"
+	Status  *string // "active", "returned" or "overdue"
+	UserID  *int64
+	BookID  *int64
+	Barcode *string // the barcode of the copy on loan
 }
 
 // LoanRepository defines the interface for loan data operations.
 type LoanRepository interface {
-	CreateLoan(bookID, userID int64) error
-	ReturnLoan(loanID int64) error
-	GetActiveLoansByUserID(userID int64) ([]models.Loan, error)
-	SearchLoans(filter LoanFilter) ([]models.Loan, error)
+	CreateLoan(ctx context.Context, bookID, userID int64, dueDate time.Time, maxBalanceCents int64) (int64, error)
+	CheckoutCopy(ctx context.Context, barcode string, userID int64, dueDate time.Time, maxBalanceCents int64) (int64, error)
+	CheckEligibility(ctx context.Context, userID int64, maxBalanceCents int64) ([]models.PolicyViolation, error)
+	ReturnLoan(ctx context.Context, loanID int64, holdExpiresAt time.Time) (int64, error)
+	RenewLoan(ctx context.Context, loanID int64, dueDate time.Time, maxRenewals int) error
+	GetLoanByID(ctx context.Context, loanID int64) (*models.Loan, error)
+	GetActiveLoansByUserID(ctx context.Context, userID int64) ([]models.Loan, error)
+	SearchLoans(ctx context.Context, filter LoanFilter) ([]models.Loan, error)
 }
 
 // sqliteLoanRepository is the concrete implementation for SQLite.
@@ -34,50 +43,324 @@ func NewSQLiteLoanRepository(db *sql.DB) LoanRepository {
 	return &sqliteLoanRepository{DB: db}
 }
 
-func (r *sqliteLoanRepository) CreateLoan(bookID, userID int64) error {
-	tx, err := r.DB.BeginTx(context.Background(), nil)
+// CreateLoan checks out the first available copy of the book to the user until dueDate,
+// or the copy set aside for the user's ready hold, which the loan fulfils.
+// Loans that break a circulation rule, such as a balance above maxBalanceCents, are
+// refused with a *PolicyError listing every rule broken.
+// The conditional UPDATE guards against another transaction claiming the same copy.
+func (r *sqliteLoanRepository) CreateLoan(ctx context.Context, bookID, userID int64, dueDate time.Time, maxBalanceCents int64) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "loans.CreateLoan", attribute.Int64("book.id", bookID), attribute.Int64("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	violations, err := loanViolationsSQLite(ctx, tx, userID, bookID, maxBalanceCents)
+	if err != nil {
+		return 0, err
+	}
+	if len(violations) > 0 {
+		return 0, &PolicyError{Violations: violations}
+	}
+
+	// A copy set aside for the patron's ready hold is theirs to pick up; anyone else
+	// gets the first available copy.
+	var holdID, copyID int64
+	copyStatus := models.CopyStatusOnHold
+	err = tx.QueryRowContext(ctx, "SELECT id, copy_id FROM holds WHERE book_id = ? AND user_id = ? AND status = ? AND copy_id IS NOT NULL AND julianday(expires_at) > julianday('now')",
+		bookID, userID, models.HoldStatusReady).Scan(&holdID, &copyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		copyStatus = models.CopyStatusAvailable
+		err = tx.QueryRowContext(ctx, "SELECT id FROM copies WHERE book_id = ? AND status = ? ORDER BY id LIMIT 1",
+			bookID, models.CopyStatusAvailable).Scan(&copyID)
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		return 0, ErrStockExhausted
+	}
+
+	loanID, err := lendCopySQLite(ctx, tx, copyID, copyStatus, holdID, bookID, userID, dueDate)
+	if err != nil {
+		return 0, err
+	}
+	return loanID, tx.Commit()
+}
+
+// CheckoutCopy checks out the copy with the given barcode to the user until dueDate, as
+// when it is scanned at the circulation desk. A copy set aside for a hold can only be
+// checked out to the patron who placed it, fulfilling the hold.
+// Loans that break a circulation rule, such as a balance above maxBalanceCents, are
+// refused with a *PolicyError listing every rule broken.
+func (r *sqliteLoanRepository) CheckoutCopy(ctx context.Context, barcode string, userID int64, dueDate time.Time, maxBalanceCents int64) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "loans.CheckoutCopy", attribute.String("copy.barcode", barcode), attribute.Int64("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
 	}
 	defer tx.Rollback()
 
-	var currentStock int
-	err = tx.QueryRow("SELECT stock FROM books WHERE id = ?", bookID).Scan(&currentStock)
+	var copyID, bookID, holdID int64
+	var copyStatus string
+	err = tx.QueryRowContext(ctx, "SELECT id, book_id, status FROM copies WHERE barcode = ?", barcode).Scan(&copyID, &bookID, &copyStatus)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return ErrNotFound
+			return 0, ErrNotFound
 		}
-		return err
+		return 0, err
+	}
+	switch copyStatus {
+	case models.CopyStatusAvailable:
+	case models.CopyStatusOnHold:
+		err = tx.QueryRowContext(ctx, "SELECT id FROM holds WHERE copy_id = ? AND user_id = ? AND status = ?",
+			copyID, userID, models.HoldStatusReady).Scan(&holdID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrCopyOnHold
+		}
+		if err != nil {
+			return 0, err
+		}
+	case models.CopyStatusOnLoan:
+		return 0, ErrCopyOnLoan
+	default:
+		return 0, ErrCopyNotLendable
 	}
 
-	if currentStock <= 0 {
-		return errors.New("no stock available")
+	violations, err := loanViolationsSQLite(ctx, tx, userID, bookID, maxBalanceCents)
+	if err != nil {
+		return 0, err
+	}
+	if len(violations) > 0 {
+		return 0, &PolicyError{Violations: violations}
 	}
 
-	_, err = tx.Exec("UPDATE books SET stock = stock - 1 WHERE id = ?", bookID)
+	loanID, err := lendCopySQLite(ctx, tx, copyID, copyStatus, holdID, bookID, userID, dueDate)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return loanID, tx.Commit()
+}
 
-	_, err = tx.Exec("INSERT INTO loans (book_id, user_id, loan_date) VALUES (?, ?, ?)",
-		bookID, userID, time.Now())
+// CheckEligibility returns the circulation rules that keep the user from borrowing any
+// book at all, such as an expired membership or a balance above maxBalanceCents.
+func (r *sqliteLoanRepository) CheckEligibility(ctx context.Context, userID int64, maxBalanceCents int64) ([]models.PolicyViolation, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	return tx.Commit()
+	return loanViolationsSQLite(ctx, tx, userID, 0, maxBalanceCents)
 }
 
-func (r *sqliteLoanRepository) ReturnLoan(loanID int64) error {
-	tx, err := r.DB.BeginTx(context.Background(), nil)
+// loanViolationsSQLite returns the circulation rules the user would break by borrowing a
+// copy of the book within tx, or only those that apply to every book if bookID is 0.
+func loanViolationsSQLite(ctx context.Context, tx *sql.Tx, userID, bookID int64, maxBalanceCents int64) ([]models.PolicyViolation, error) {
+	var borrower models.Borrower
+	var membershipExpiresAt sql.NullTime
+	err := tx.QueryRowContext(ctx, "SELECT role, membership_expires_at FROM users WHERE id = ?", userID).
+		Scan(&borrower.Role, &membershipExpiresAt)
 	if err != nil {
-		return err
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if membershipExpiresAt.Valid {
+		borrower.MembershipExpiresAt = &membershipExpiresAt.Time
+	}
+	if err := tx.QueryRowContext(ctx, accountBalanceSQL+"?", userID).Scan(&borrower.BalanceCents); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT c.book_id, b.material_type
+		FROM loans l
+		JOIN copies c ON l.copy_id = c.id
+		JOIN books b ON c.book_id = b.id
+		WHERE l.user_id = ? AND l.return_date IS NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var item models.BorrowedItem
+		if err := rows.Scan(&item.BookID, &item.MaterialType); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		borrower.ActiveLoans = append(borrower.ActiveLoans, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var materialType string
+	if bookID != 0 {
+		err := tx.QueryRowContext(ctx, "SELECT material_type FROM books WHERE id = ?", bookID).Scan(&materialType)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrNotFound
+			}
+			return nil, err
+		}
+	}
+
+	rows, err = tx.QueryContext(ctx, "SELECT role, material_type, max_loans FROM loan_policies WHERE role = ?", borrower.Role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	policies, err := scanLoanPolicies(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return borrower.CheckLoan(policies, maxBalanceCents, bookID, materialType, time.Now()), nil
+}
+
+// lendCopySQLite checks out the copy, whose status is copyStatus, to the user until dueDate
+// within tx, fulfilling the hold with holdID unless it is 0, and returns the new loan's ID.
+// The conditional UPDATE guards against another transaction claiming the same copy.
+func lendCopySQLite(ctx context.Context, tx *sql.Tx, copyID int64, copyStatus string, holdID, bookID, userID int64, dueDate time.Time) (int64, error) {
+	result, err := tx.ExecContext(ctx, "UPDATE copies SET status = ? WHERE id = ? AND status = ?",
+		models.CopyStatusOnLoan, copyID, copyStatus)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected == 0 {
+		return 0, ErrStockExhausted
+	}
+
+	result, err = tx.ExecContext(ctx, "INSERT INTO loans (copy_id, user_id, loan_date, due_date) VALUES (?, ?, ?, ?)",
+		copyID, userID, time.Now(), dueDate)
+	if err != nil {
+		return 0, err
+	}
+	loanID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if holdID != 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE holds SET status = ? WHERE id = ?", models.HoldStatusFulfilled, holdID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	after := map[string]interface{}{"copy_id": copyID, "book_id": bookID, "user_id": userID, "due_date": dueDate}
+	if err := recordAuditSQLite(ctx, tx, models.AuditCheckout, models.AuditLoan, loanID, nil, after); err != nil {
+		return 0, err
+	}
+	return loanID, nil
+}
+
+// ReturnLoan marks the loan as returned and, if it comes back late, charges the fine set by
+// its material type's policy to the patron. The copy is set aside for the first hold on its
+// book, ready for pickup until holdExpiresAt, or made available when nobody is waiting.
+// It returns the fine in cents.
+func (r *sqliteLoanRepository) ReturnLoan(ctx context.Context, loanID int64, holdExpiresAt time.Time) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "loans.ReturnLoan", attribute.Int64("loan.id", loanID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
 	}
 	defer tx.Rollback()
 
 	var loan models.Loan
 	var returnDate sql.NullTime
-	err = tx.QueryRow("SELECT id, book_id, return_date FROM loans WHERE id = ?", loanID).Scan(&loan.ID, &loan.BookID, &returnDate)
+	var bookID sql.NullInt64
+	var materialType sql.NullString
+	query := `
+		SELECT l.id, l.copy_id, l.user_id, l.due_date, l.return_date, c.book_id, b.material_type
+		FROM loans l
+		LEFT JOIN copies c ON l.copy_id = c.id
+		LEFT JOIN books b ON c.book_id = b.id
+		WHERE l.id = ?
+	`
+	err = tx.QueryRowContext(ctx, query, loanID).Scan(&loan.ID, &loan.CopyID, &loan.UserID, &loan.DueDate, &returnDate, &bookID, &materialType)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	if returnDate.Valid {
+		return 0, ErrAlreadyReturned
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, "UPDATE loans SET return_date = ? WHERE id = ?", now, loanID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := releaseCopySQLite(ctx, tx, loan.CopyID, bookID.Int64, holdExpiresAt); err != nil {
+		return 0, err
+	}
+
+	var fine int64
+	daysOverdue := models.DaysOverdue(loan.DueDate, now)
+	if daysOverdue > 0 && materialType.Valid {
+		var policy models.FinePolicy
+		err = tx.QueryRowContext(ctx, "SELECT daily_rate_cents, max_fine_cents, grace_days FROM fine_policies WHERE material_type = ?", materialType.String).
+			Scan(&policy.DailyRateCents, &policy.MaxFineCents, &policy.GraceDays)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		// Material types without a policy are not fined.
+		fine = policy.Assess(daysOverdue)
+	}
+	if fine > 0 {
+		_, err = tx.ExecContext(ctx, "INSERT INTO account_entries (user_id, loan_id, kind, amount_cents, note, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			loan.UserID, loan.ID, models.EntryCharge, fine, fmt.Sprintf("Overdue fine: %d days late", daysOverdue), now)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	before := map[string]interface{}{"return_date": nil}
+	after := map[string]interface{}{"return_date": now, "fine_cents": fine}
+	if err := recordAuditSQLite(ctx, tx, models.AuditReturn, models.AuditLoan, loanID, before, after); err != nil {
+		return 0, err
+	}
+
+	return fine, tx.Commit()
+}
+
+// RenewLoan moves an active loan's due date to dueDate, at most maxRenewals times per loan.
+// Overdue loans are not renewed, so the fine for returning them late still applies, and
+// neither are loans of books that patrons are waiting for, so the hold queue moves on.
+func (r *sqliteLoanRepository) RenewLoan(ctx context.Context, loanID int64, dueDate time.Time, maxRenewals int) (err error) {
+	ctx, span := startSpan(ctx, "loans.RenewLoan", attribute.Int64("loan.id", loanID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldDueDate time.Time
+	var returnDate sql.NullTime
+	var renewalCount int
+	var bookID int64
+	err = tx.QueryRowContext(ctx, "SELECT l.due_date, l.return_date, l.renewal_count, c.book_id FROM loans l JOIN copies c ON c.id = l.copy_id WHERE l.id = ?", loanID).
+		Scan(&oldDueDate, &returnDate, &renewalCount, &bookID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return ErrNotFound
@@ -86,30 +369,73 @@ func (r *sqliteLoanRepository) ReturnLoan(loanID int64) error {
 	}
 
 	if returnDate.Valid {
-		return errors.New("book already returned")
+		return ErrAlreadyReturned
+	}
+	if renewalCount >= maxRenewals {
+		return ErrRenewalLimit
+	}
+	if time.Now().After(oldDueDate) {
+		return ErrLoanOverdue
 	}
 
-	_, err = tx.Exec("UPDATE loans SET return_date = ? WHERE id = ?", time.Now(), loanID)
+	var waiting int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM holds WHERE book_id = ? AND status = ?", bookID, models.HoldStatusWaiting).Scan(&waiting)
 	if err != nil {
 		return err
 	}
+	if waiting > 0 {
+		return ErrHoldsWaiting
+	}
 
-	_, err = tx.Exec("UPDATE books SET stock = stock + 1 WHERE id = ?", loan.BookID)
+	_, err = tx.ExecContext(ctx, "UPDATE loans SET due_date = ?, renewal_count = renewal_count + 1 WHERE id = ?", dueDate, loanID)
 	if err != nil {
 		return err
 	}
 
+	before := map[string]interface{}{"due_date": oldDueDate, "renewal_count": renewalCount}
+	after := map[string]interface{}{"due_date": dueDate, "renewal_count": renewalCount + 1}
+	if err := recordAuditSQLite(ctx, tx, models.AuditRenew, models.AuditLoan, loanID, before, after); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
-func (r *sqliteLoanRepository) GetActiveLoansByUserID(userID int64) ([]models.Loan, error) {
+// GetLoanByID finds a loan by its ID, including the book its copy belongs to.
+func (r *sqliteLoanRepository) GetLoanByID(ctx context.Context, loanID int64) (*models.Loan, error) {
+	query := `
+		SELECT l.id, l.copy_id, c.book_id, l.user_id, l.loan_date, l.due_date, l.return_date, l.renewal_count
+		FROM loans l
+		JOIN copies c ON l.copy_id = c.id
+		WHERE l.id = ?
+	`
+	var loan models.Loan
+	var returnDate sql.NullTime
+	err := r.DB.QueryRowContext(ctx, query, loanID).Scan(
+		&loan.ID, &loan.CopyID, &loan.BookID, &loan.UserID,
+		&loan.LoanDate, &loan.DueDate, &returnDate, &loan.RenewalCount,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if returnDate.Valid {
+		loan.ReturnDate = &returnDate.Time
+	}
+	return &loan, nil
+}
+
+func (r *sqliteLoanRepository) GetActiveLoansByUserID(ctx context.Context, userID int64) ([]models.Loan, error) {
 	q := `
-		SELECT l.id, l.loan_date, l.book_id, b.title, b.isbn 
+		SELECT l.id, l.loan_date, l.due_date, l.renewal_count, l.copy_id, c.book_id, b.title, b.isbn
 		FROM loans l
-		JOIN books b ON l.book_id = b.id
+		JOIN copies c ON l.copy_id = c.id
+		JOIN books b ON c.book_id = b.id
 		WHERE l.user_id = ? AND l.return_date IS NULL
 	`
-	rows, err := r.DB.Query(q, userID)
+	rows, err := r.DB.QueryContext(ctx, q, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -119,9 +445,10 @@ func (r *sqliteLoanRepository) GetActiveLoansByUserID(userID int64) ([]models.Lo
 	for rows.Next() {
 		var loan models.Loan
 		var book models.Book
-		if err := rows.Scan(&loan.ID, &loan.LoanDate, &loan.BookID, &book.Title, &book.ISBN); err != nil {
+		if err := rows.Scan(&loan.ID, &loan.LoanDate, &loan.DueDate, &loan.RenewalCount, &loan.CopyID, &loan.BookID, &book.Title, &book.ISBN); err != nil {
 			return nil, err
 		}
+		loan.UserID, book.ID = userID, loan.BookID
 		loan.Book = &book
 		loans = append(loans, loan)
 	}
@@ -129,14 +456,15 @@ func (r *sqliteLoanRepository) GetActiveLoansByUserID(userID int64) ([]models.Lo
 }
 
 // SearchLoans searches for loans with optional filters.
-func (r *sqliteLoanRepository) SearchLoans(filter LoanFilter) ([]models.Loan, error) {
+func (r *sqliteLoanRepository) SearchLoans(ctx context.Context, filter LoanFilter) ([]models.Loan, error) {
 	query := `
 		SELECT
-			l.id, l.loan_date, l.return_date,
+			l.id, l.copy_id, l.loan_date, l.due_date, l.return_date, l.renewal_count,
 			b.id, b.title,
 			u.id, u.username
 		FROM loans l
-		JOIN books b ON l.book_id = b.id
+		JOIN copies c ON l.copy_id = c.id
+		JOIN books b ON c.book_id = b.id
 		JOIN users u ON l.user_id = u.id
 	`
 	var args []interface{}
@@ -147,10 +475,24 @@ func (r *sqliteLoanRepository) SearchLoans(filter LoanFilter) ([]models.Loan, er
 			whereClause += " AND l.return_date IS NULL"
 		} else if *filter.Status == "returned" {
 			whereClause += " AND l.return_date IS NOT NULL"
+		} else if *filter.Status == "overdue" {
+			whereClause += " AND l.return_date IS NULL AND julianday(l.due_date) < julianday('now')"
 		}
 	}
+	if filter.UserID != nil {
+		whereClause += " AND l.user_id = ?"
+		args = append(args, *filter.UserID)
+	}
+	if filter.BookID != nil {
+		whereClause += " AND c.book_id = ?"
+		args = append(args, *filter.BookID)
+	}
+	if filter.Barcode != nil {
+		whereClause += " AND c.barcode = ?"
+		args = append(args, *filter.Barcode)
+	}
 
-	rows, err := r.DB.Query(query+whereClause, args...)
+	rows, err := r.DB.QueryContext(ctx, query+whereClause, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -164,7 +506,7 @@ func (r *sqliteLoanRepository) SearchLoans(filter LoanFilter) ([]models.Loan, er
 		var returnDate sql.NullTime
 
 		if err := rows.Scan(
-			&loan.ID, &loan.LoanDate, &returnDate,
+			&loan.ID, &loan.CopyID, &loan.LoanDate, &loan.DueDate, &returnDate, &loan.RenewalCount,
 			&book.ID, &book.Title,
 			&user.ID, &user.Username,
 		); err != nil {
@@ -175,6 +517,7 @@ func (r *sqliteLoanRepository) SearchLoans(filter LoanFilter) ([]models.Loan, er
 		if returnDate.Valid {
 			loan.ReturnDate = &returnDate.Time
 		}
+		loan.BookID, loan.UserID = book.ID, user.ID
 		loan.Book = &book
 		loan.User = &user
 		loans = append(loans, loan)
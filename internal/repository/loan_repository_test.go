@@ -2,135 +2,785 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Lec7ral/fullAPI/internal/models"
 )
 
+// borrowerQueries maps each backend to the statements it loads a patron's standing with
+// before checking the circulation rules.
+var borrowerQueries = map[string]struct{ user, balance, loans, material, policies string }{
+	"sqlite": {
+		user:     "SELECT role, membership_expires_at FROM users WHERE id = ?",
+		balance:  accountBalanceSQL + "?",
+		loans:    "SELECT c.book_id, b.material_type",
+		material: "SELECT material_type FROM books WHERE id = ?",
+		policies: "SELECT role, material_type, max_loans FROM loan_policies WHERE role = ?",
+	},
+	"postgres": {
+		user:     "SELECT role, membership_expires_at FROM users WHERE id = $1 FOR UPDATE",
+		balance:  accountBalanceSQL + "$1",
+		loans:    "SELECT c.book_id, b.material_type",
+		material: "SELECT material_type FROM books WHERE id = $1",
+		policies: "SELECT role, material_type, max_loans FROM loan_policies WHERE role = $1",
+	},
+}
+
+// expectEligible expects backend to check the circulation rules for lending the book to a
+// member in good standing with nothing on loan.
+func expectEligible(mock sqlmock.Sqlmock, backend string, userID, bookID int64) {
+	q := borrowerQueries[backend]
+	mock.ExpectQuery(regexp.QuoteMeta(q.user)).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "membership_expires_at"}).AddRow("member", nil))
+	mock.ExpectQuery(regexp.QuoteMeta(q.balance)).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(q.loans)).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "material_type"}))
+	mock.ExpectQuery(regexp.QuoteMeta(q.material)).
+		WithArgs(bookID).
+		WillReturnRows(sqlmock.NewRows([]string{"material_type"}).AddRow("book"))
+	mock.ExpectQuery(regexp.QuoteMeta(q.policies)).
+		WithArgs("member").
+		WillReturnRows(sqlmock.NewRows([]string{"role", "material_type", "max_loans"}).AddRow("member", models.AnyMaterialType, 5))
+}
+
+// loanBackends lists the LoanRepository implementations every test runs against,
+// along with the transaction statements each one issues.
+var loanBackends = []struct {
+	name          string
+	newRepo       func(*sql.DB) LoanRepository
+	selectHold    string
+	selectCopy    string
+	copyByBarcode string
+	holdForCopy   string
+	checkoutCopy  string
+	insertLoan    string
+	fulfilHold    string
+	selectLoan    string
+	markReturned  string
+	nextHold      string
+	readyHold     string
+	releaseCopy   string
+	selectPolicy  string
+	insertFine    string
+	selectRenewal string
+	waitingHolds  string
+	renewLoan     string
+}{
+	{
+		name:          "sqlite",
+		newRepo:       NewSQLiteLoanRepository,
+		selectHold:    "SELECT id, copy_id FROM holds WHERE book_id = ? AND user_id = ? AND status = ? AND copy_id IS NOT NULL AND julianday(expires_at) > julianday('now')",
+		selectCopy:    "SELECT id FROM copies WHERE book_id = ? AND status = ? ORDER BY id LIMIT 1",
+		copyByBarcode: "SELECT id, book_id, status FROM copies WHERE barcode = ?",
+		holdForCopy:   "SELECT id FROM holds WHERE copy_id = ? AND user_id = ? AND status = ?",
+		checkoutCopy:  "UPDATE copies SET status = ? WHERE id = ? AND status = ?",
+		insertLoan:    "INSERT INTO loans (copy_id, user_id, loan_date, due_date) VALUES (?, ?, ?, ?)",
+		fulfilHold:    "UPDATE holds SET status = ? WHERE id = ?",
+		selectLoan:    "SELECT l.id, l.copy_id, l.user_id, l.due_date, l.return_date, c.book_id, b.material_type",
+		markReturned:  "UPDATE loans SET return_date = ? WHERE id = ?",
+		nextHold:      "SELECT id FROM holds WHERE book_id = ? AND status = ? ORDER BY id LIMIT 1",
+		readyHold:     "UPDATE holds SET status = ?, copy_id = ?, ready_at = ?, expires_at = ? WHERE id = ?",
+		releaseCopy:   "UPDATE copies SET status = ? WHERE id = ?",
+		selectPolicy:  "SELECT daily_rate_cents, max_fine_cents, grace_days FROM fine_policies WHERE material_type = ?",
+		insertFine:    "INSERT INTO account_entries (user_id, loan_id, kind, amount_cents, note, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		selectRenewal: "SELECT l.due_date, l.return_date, l.renewal_count, c.book_id FROM loans l JOIN copies c ON c.id = l.copy_id WHERE l.id = ?",
+		waitingHolds:  "SELECT COUNT(*) FROM holds WHERE book_id = ? AND status = ?",
+		renewLoan:     "UPDATE loans SET due_date = ?, renewal_count = renewal_count + 1 WHERE id = ?",
+	},
+	{
+		name:          "postgres",
+		newRepo:       NewPostgresLoanRepository,
+		selectHold:    "SELECT id, copy_id FROM holds WHERE book_id = $1 AND user_id = $2 AND status = $3 AND copy_id IS NOT NULL AND expires_at > now() FOR UPDATE",
+		selectCopy:    "SELECT id FROM copies WHERE book_id = $1 AND status = $2 ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED",
+		copyByBarcode: "SELECT id, book_id, status FROM copies WHERE barcode = $1 FOR UPDATE",
+		holdForCopy:   "SELECT id FROM holds WHERE copy_id = $1 AND user_id = $2 AND status = $3 FOR UPDATE",
+		checkoutCopy:  "UPDATE copies SET status = $1 WHERE id = $2 AND status = $3",
+		insertLoan:    "INSERT INTO loans (copy_id, user_id, loan_date, due_date) VALUES ($1, $2, $3, $4) RETURNING id",
+		fulfilHold:    "UPDATE holds SET status = $1 WHERE id = $2",
+		selectLoan:    "SELECT l.id, l.copy_id, l.user_id, l.due_date, l.return_date, c.book_id, b.material_type",
+		markReturned:  "UPDATE loans SET return_date = $1 WHERE id = $2",
+		nextHold:      "SELECT id FROM holds WHERE book_id = $1 AND status = $2 ORDER BY id LIMIT 1 FOR UPDATE",
+		readyHold:     "UPDATE holds SET status = $1, copy_id = $2, ready_at = $3, expires_at = $4 WHERE id = $5",
+		releaseCopy:   "UPDATE copies SET status = $1 WHERE id = $2",
+		selectPolicy:  "SELECT daily_rate_cents, max_fine_cents, grace_days FROM fine_policies WHERE material_type = $1",
+		insertFine:    "INSERT INTO account_entries (user_id, loan_id, kind, amount_cents, note, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		selectRenewal: "SELECT l.due_date, l.return_date, l.renewal_count, c.book_id FROM loans l JOIN copies c ON c.id = l.copy_id WHERE l.id = $1 FOR UPDATE OF l",
+		waitingHolds:  "SELECT COUNT(*) FROM holds WHERE book_id = $1 AND status = $2",
+		renewLoan:     "UPDATE loans SET due_date = $1, renewal_count = renewal_count + 1 WHERE id = $2",
+	},
+}
+
 // TestCreateLoan_Success tests the successful transaction of creating a loan.
 func TestCreateLoan_Success(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
 
-	repo := NewSQLiteLoanRepository(db)
-	bookID, userID := int64(1), int64(1)
+			repo := backend.newRepo(db)
+			bookID, userID, copyID := int64(1), int64(1), int64(3)
+			dueDate := time.Now().AddDate(0, 0, 14)
 
-	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT stock FROM books WHERE id = ?")).
-		WithArgs(bookID).
-		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(5))
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE books SET stock = stock - 1 WHERE id = ?")).
-		WithArgs(bookID).
-		WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO loans (book_id, user_id, loan_date) VALUES (?, ?, ?)")).
-		WithArgs(bookID, userID, sqlmock.AnyArg()).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectCommit()
+			mock.ExpectBegin()
+			expectEligible(mock, backend.name, userID, bookID)
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectHold)).
+				WithArgs(bookID, userID, models.HoldStatusReady).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectCopy)).
+				WithArgs(bookID, models.CopyStatusAvailable).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(copyID))
+			mock.ExpectExec(regexp.QuoteMeta(backend.checkoutCopy)).
+				WithArgs(models.CopyStatusOnLoan, copyID, models.CopyStatusAvailable).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectInsertLoan(mock, backend.name, backend.insertLoan, copyID, userID, dueDate, 1)
+			expectAudit(mock, backend.name, models.AuditCheckout, models.AuditLoan, 1, sqlmock.AnyArg())
+			mock.ExpectCommit()
 
-	err = repo.CreateLoan(bookID, userID)
+			loanID, err := repo.CreateLoan(context.Background(), bookID, userID, dueDate, 1000)
 
-	if err != nil {
-		t.Errorf("unexpected error: %s", err)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if loanID != 1 {
+				t.Errorf("expected the new loan's ID to be 1, but got %d", loanID)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+}
+
+// TestCreateLoan_Cancelled tests that no transaction is started for a request that has
+// already been cancelled, such as one whose client disconnected.
+func TestCreateLoan_Cancelled(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err = repo.CreateLoan(ctx, 1, 1, time.Now().AddDate(0, 0, 14), 1000)
+
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected error to be context.Canceled, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
 
-// TestCreateLoan_NoStock tests that the transaction is rolled back if there is no stock.
+// TestCreateLoan_NoStock tests that the transaction is rolled back if no copy is available.
 func TestCreateLoan_NoStock(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
 
-	repo := NewSQLiteLoanRepository(db)
-	bookID, userID := int64(1), int64(1)
+			repo := backend.newRepo(db)
+			bookID, userID := int64(1), int64(1)
 
-	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT stock FROM books WHERE id = ?")).
-		WithArgs(bookID).
-		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(0))
-	mock.ExpectRollback()
+			mock.ExpectBegin()
+			expectEligible(mock, backend.name, userID, bookID)
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectHold)).
+				WithArgs(bookID, userID, models.HoldStatusReady).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectCopy)).
+				WithArgs(bookID, models.CopyStatusAvailable).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
 
-	err = repo.CreateLoan(bookID, userID)
+			_, err = repo.CreateLoan(context.Background(), bookID, userID, time.Now(), 1000)
 
-	if err == nil {
-		t.Errorf("expected an error, but got nil")
+			if err == nil {
+				t.Fatalf("expected an error, but got nil")
+			}
+			if err.Error() != "no stock available" {
+				t.Errorf("expected error 'no stock available', but got '%v'", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	if err.Error() != "no stock available" {
-		t.Errorf("expected error 'no stock available', but got '%v'", err)
+}
+
+// TestCreateLoan_BookNotFound tests that a loan of a book that does not exist returns ErrNotFound.
+func TestCreateLoan_BookNotFound(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			bookID, userID := int64(99), int64(1)
+
+			mock.ExpectBegin()
+			q := borrowerQueries[backend.name]
+			mock.ExpectQuery(regexp.QuoteMeta(q.user)).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"role", "membership_expires_at"}).AddRow("member", nil))
+			mock.ExpectQuery(regexp.QuoteMeta(q.balance)).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0))
+			mock.ExpectQuery(regexp.QuoteMeta(q.loans)).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"book_id", "material_type"}))
+			mock.ExpectQuery(regexp.QuoteMeta(q.material)).
+				WithArgs(bookID).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
+
+			_, err = repo.CreateLoan(context.Background(), bookID, userID, time.Now(), 1000)
+
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected error to be ErrNotFound, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+}
+
+// TestCreateLoan_PolicyViolations tests that a loan breaking circulation rules is refused
+// with every rule it breaks: an expired membership, a balance above the limit, a copy of
+// the same book already on loan, and the cap of the patron's role.
+func TestCreateLoan_PolicyViolations(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			bookID, userID := int64(1), int64(1)
+			q := borrowerQueries[backend.name]
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(q.user)).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"role", "membership_expires_at"}).AddRow("member", time.Now().AddDate(0, 0, -1)))
+			mock.ExpectQuery(regexp.QuoteMeta(q.balance)).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(1500))
+			mock.ExpectQuery(regexp.QuoteMeta(q.loans)).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"book_id", "material_type"}).AddRow(bookID, "dvd").AddRow(2, "book"))
+			mock.ExpectQuery(regexp.QuoteMeta(q.material)).
+				WithArgs(bookID).
+				WillReturnRows(sqlmock.NewRows([]string{"material_type"}).AddRow("dvd"))
+			mock.ExpectQuery(regexp.QuoteMeta(q.policies)).
+				WithArgs("member").
+				WillReturnRows(sqlmock.NewRows([]string{"role", "material_type", "max_loans"}).
+					AddRow("member", models.AnyMaterialType, 5).AddRow("member", "dvd", 1))
+			mock.ExpectRollback()
+
+			_, err = repo.CreateLoan(context.Background(), bookID, userID, time.Now(), 1000)
+
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("expected a *PolicyError, but got %v", err)
+			}
+			var rules []string
+			for _, v := range policyErr.Violations {
+				rules = append(rules, v.Rule)
+			}
+			want := []string{models.RuleMembershipExpired, models.RuleFineBalance, models.RuleDuplicateLoan, models.RuleMaxLoans}
+			if strings.Join(rules, ",") != strings.Join(want, ",") {
+				t.Errorf("expected violations %v, but got %v", want, rules)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
 
 // TestReturnLoan_Success tests the successful transaction of returning a loan.
 func TestReturnLoan_Success(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
-
-	repo := NewSQLiteLoanRepository(db)
-	loanID, bookID := int64(1), int64(5)
-
-	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, book_id, return_date FROM loans WHERE id = ?")).
-		WithArgs(loanID).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "return_date"}).AddRow(loanID, bookID, nil))
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE loans SET return_date = ? WHERE id = ?")).
-		WithArgs(sqlmock.AnyArg(), loanID).
-		WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE books SET stock = stock + 1 WHERE id = ?")).
-		WithArgs(bookID).
-		WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectCommit()
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			loanID, copyID, bookID := int64(1), int64(5), int64(2)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectLoan)).
+				WithArgs(loanID).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "copy_id", "user_id", "due_date", "return_date", "book_id", "material_type"}).
+					AddRow(loanID, copyID, 1, time.Now().AddDate(0, 0, 7), nil, bookID, "book"))
+			mock.ExpectExec(regexp.QuoteMeta(backend.markReturned)).
+				WithArgs(sqlmock.AnyArg(), loanID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.nextHold)).
+				WithArgs(bookID, models.HoldStatusWaiting).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectExec(regexp.QuoteMeta(backend.releaseCopy)).
+				WithArgs(models.CopyStatusAvailable, copyID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(mock, backend.name, models.AuditReturn, models.AuditLoan, loanID, sqlmock.AnyArg())
+			mock.ExpectCommit()
+
+			fine, err := repo.ReturnLoan(context.Background(), loanID, time.Now().AddDate(0, 0, 3))
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if fine != 0 {
+				t.Errorf("expected no fine for a loan returned on time, but got %d", fine)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
 
-	err = repo.ReturnLoan(loanID)
+// TestReturnLoan_Overdue tests that a late return charges the capped fine in the same transaction.
+func TestReturnLoan_Overdue(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
 
-	if err != nil {
-		t.Errorf("unexpected error: %s", err)
+			repo := backend.newRepo(db)
+			loanID, copyID, userID, bookID := int64(1), int64(5), int64(3), int64(2)
+
+			// Returned 60 days late at 25 cents a day, capped at 1000 cents.
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectLoan)).
+				WithArgs(loanID).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "copy_id", "user_id", "due_date", "return_date", "book_id", "material_type"}).
+					AddRow(loanID, copyID, userID, time.Now().AddDate(0, 0, -60), nil, bookID, "book"))
+			mock.ExpectExec(regexp.QuoteMeta(backend.markReturned)).
+				WithArgs(sqlmock.AnyArg(), loanID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.nextHold)).
+				WithArgs(bookID, models.HoldStatusWaiting).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectExec(regexp.QuoteMeta(backend.releaseCopy)).
+				WithArgs(models.CopyStatusAvailable, copyID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectPolicy)).
+				WithArgs("book").
+				WillReturnRows(sqlmock.NewRows([]string{"daily_rate_cents", "max_fine_cents", "grace_days"}).AddRow(25, 1000, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.insertFine)).
+				WithArgs(userID, loanID, models.EntryCharge, int64(1000), sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			expectAudit(mock, backend.name, models.AuditReturn, models.AuditLoan, loanID, sqlmock.AnyArg())
+			mock.ExpectCommit()
+
+			fine, err := repo.ReturnLoan(context.Background(), loanID, time.Now().AddDate(0, 0, 3))
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if fine != 1000 {
+				t.Errorf("expected the fine to be capped at 1000, but got %d", fine)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+}
+
+// TestCreateLoan_ReadyHold tests that a patron picking up a ready hold is lent the copy
+// set aside for them and the hold is fulfilled.
+func TestCreateLoan_ReadyHold(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			bookID, userID, copyID, holdID := int64(1), int64(1), int64(3), int64(8)
+			dueDate := time.Now().AddDate(0, 0, 14)
+
+			mock.ExpectBegin()
+			expectEligible(mock, backend.name, userID, bookID)
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectHold)).
+				WithArgs(bookID, userID, models.HoldStatusReady).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "copy_id"}).AddRow(holdID, copyID))
+			mock.ExpectExec(regexp.QuoteMeta(backend.checkoutCopy)).
+				WithArgs(models.CopyStatusOnLoan, copyID, models.CopyStatusOnHold).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectInsertLoan(mock, backend.name, backend.insertLoan, copyID, userID, dueDate, 1)
+			mock.ExpectExec(regexp.QuoteMeta(backend.fulfilHold)).
+				WithArgs(models.HoldStatusFulfilled, holdID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(mock, backend.name, models.AuditCheckout, models.AuditLoan, 1, sqlmock.AnyArg())
+			mock.ExpectCommit()
+
+			_, err = repo.CreateLoan(context.Background(), bookID, userID, dueDate, 1000)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestCheckoutCopy_ReadyHold tests that a copy scanned at the desk for the patron whose
+// hold it was set aside for is lent to them, fulfilling the hold.
+func TestCheckoutCopy_ReadyHold(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			barcode, bookID, userID, copyID, holdID := "B-0003", int64(1), int64(2), int64(3), int64(8)
+			dueDate := time.Now().AddDate(0, 0, 14)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.copyByBarcode)).
+				WithArgs(barcode).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "status"}).AddRow(copyID, bookID, models.CopyStatusOnHold))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.holdForCopy)).
+				WithArgs(copyID, userID, models.HoldStatusReady).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(holdID))
+			expectEligible(mock, backend.name, userID, bookID)
+			mock.ExpectExec(regexp.QuoteMeta(backend.checkoutCopy)).
+				WithArgs(models.CopyStatusOnLoan, copyID, models.CopyStatusOnHold).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectInsertLoan(mock, backend.name, backend.insertLoan, copyID, userID, dueDate, 4)
+			mock.ExpectExec(regexp.QuoteMeta(backend.fulfilHold)).
+				WithArgs(models.HoldStatusFulfilled, holdID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(mock, backend.name, models.AuditCheckout, models.AuditLoan, 4, sqlmock.AnyArg())
+			mock.ExpectCommit()
+
+			loanID, err := repo.CheckoutCopy(context.Background(), barcode, userID, dueDate, 1000)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if loanID != 4 {
+				t.Errorf("expected the new loan's ID to be 4, but got %d", loanID)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestCheckoutCopy_HeldForAnother tests that a copy set aside for someone else's hold
+// cannot be checked out.
+func TestCheckoutCopy_HeldForAnother(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			barcode, userID, copyID := "B-0003", int64(2), int64(3)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.copyByBarcode)).
+				WithArgs(barcode).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "status"}).AddRow(copyID, 1, models.CopyStatusOnHold))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.holdForCopy)).
+				WithArgs(copyID, userID, models.HoldStatusReady).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
+
+			_, err = repo.CheckoutCopy(context.Background(), barcode, userID, time.Now(), 1000)
+
+			if !errors.Is(err, ErrCopyOnHold) {
+				t.Errorf("expected ErrCopyOnHold, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestReturnLoan_HoldWaiting tests that a returned copy is set aside for the first hold
+// on its book instead of becoming available.
+func TestReturnLoan_HoldWaiting(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			loanID, copyID, bookID, holdID := int64(1), int64(5), int64(2), int64(8)
+			pickupBy := time.Now().AddDate(0, 0, 3)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectLoan)).
+				WithArgs(loanID).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "copy_id", "user_id", "due_date", "return_date", "book_id", "material_type"}).
+					AddRow(loanID, copyID, 1, time.Now().AddDate(0, 0, 7), nil, bookID, "book"))
+			mock.ExpectExec(regexp.QuoteMeta(backend.markReturned)).
+				WithArgs(sqlmock.AnyArg(), loanID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.nextHold)).
+				WithArgs(bookID, models.HoldStatusWaiting).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(holdID))
+			mock.ExpectExec(regexp.QuoteMeta(backend.readyHold)).
+				WithArgs(models.HoldStatusReady, copyID, sqlmock.AnyArg(), pickupBy, holdID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.releaseCopy)).
+				WithArgs(models.CopyStatusOnHold, copyID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(mock, backend.name, models.AuditReturn, models.AuditLoan, loanID, sqlmock.AnyArg())
+			mock.ExpectCommit()
+
+			_, err = repo.ReturnLoan(context.Background(), loanID, pickupBy)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
 
 // TestReturnLoan_AlreadyReturned tests the case where a loan has already been returned.
 func TestReturnLoan_AlreadyReturned(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			loanID, copyID := int64(1), int64(5)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectLoan)).
+				WithArgs(loanID).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "copy_id", "user_id", "due_date", "return_date", "book_id", "material_type"}).
+					AddRow(loanID, copyID, 1, time.Now().AddDate(0, 0, -7), time.Now(), 2, "book"))
+			mock.ExpectRollback()
+
+			_, err = repo.ReturnLoan(context.Background(), loanID, time.Now().AddDate(0, 0, 3))
+
+			if err == nil {
+				t.Fatalf("expected an error, but got nil")
+			}
+			if err.Error() != "book already returned" {
+				t.Errorf("expected error 'book already returned', but got '%v'", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	defer db.Close()
+}
+
+// TestRenewLoan_Success tests that renewing moves the due date and counts the renewal.
+func TestRenewLoan_Success(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
 
-	repo := NewSQLiteLoanRepository(db)
-	loanID, bookID := int64(1), int64(5)
+			repo := backend.newRepo(db)
+			loanID := int64(1)
+			dueDate := time.Now().AddDate(0, 0, 14)
 
-	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, book_id, return_date FROM loans WHERE id = ?")).
-		WithArgs(loanID).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "return_date"}).AddRow(loanID, bookID, time.Now()))
-	mock.ExpectRollback()
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectRenewal)).
+				WithArgs(loanID).
+				WillReturnRows(sqlmock.NewRows(renewalColumns).AddRow(time.Now().Add(time.Hour), nil, 1, 5))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.waitingHolds)).
+				WithArgs(5, models.HoldStatusWaiting).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			mock.ExpectExec(regexp.QuoteMeta(backend.renewLoan)).
+				WithArgs(dueDate, loanID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(mock, backend.name, models.AuditRenew, models.AuditLoan, loanID, sqlmock.AnyArg())
+			mock.ExpectCommit()
 
-	err = repo.ReturnLoan(loanID)
+			err = repo.RenewLoan(context.Background(), loanID, dueDate, 2)
 
-	if err == nil {
-		t.Errorf("expected an error, but got nil")
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	if err.Error() != "book already returned" {
-		t.Errorf("expected error 'book already returned', but got '%v'", err)
+}
+
+// TestRenewLoan_LimitReached tests that a loan cannot be renewed more than the maximum number of times.
+func TestRenewLoan_LimitReached(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			loanID := int64(1)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectRenewal)).
+				WithArgs(loanID).
+				WillReturnRows(sqlmock.NewRows(renewalColumns).AddRow(time.Now().Add(time.Hour), nil, 2, 5))
+			mock.ExpectRollback()
+
+			err = repo.RenewLoan(context.Background(), loanID, time.Now().AddDate(0, 0, 14), 2)
+
+			if !errors.Is(err, ErrRenewalLimit) {
+				t.Errorf("expected error to be ErrRenewalLimit, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+}
+
+// TestRenewLoan_Overdue tests that an overdue loan is not renewed, so returning it late
+// is still fined.
+func TestRenewLoan_Overdue(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			loanID := int64(1)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectRenewal)).
+				WithArgs(loanID).
+				WillReturnRows(sqlmock.NewRows(renewalColumns).AddRow(time.Now().AddDate(0, 0, -3), nil, 0, 5))
+			mock.ExpectRollback()
+
+			err = repo.RenewLoan(context.Background(), loanID, time.Now().AddDate(0, 0, 14), 2)
+
+			if !errors.Is(err, ErrLoanOverdue) {
+				t.Errorf("expected error to be ErrLoanOverdue, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestRenewLoan_HoldsWaiting tests that a loan of a book other patrons are waiting for is
+// not renewed, so the borrower cannot keep it past the hold queue.
+func TestRenewLoan_HoldsWaiting(t *testing.T) {
+	for _, backend := range loanBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			loanID := int64(1)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectRenewal)).
+				WithArgs(loanID).
+				WillReturnRows(sqlmock.NewRows(renewalColumns).AddRow(time.Now().Add(time.Hour), nil, 0, 5))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.waitingHolds)).
+				WithArgs(5, models.HoldStatusWaiting).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			mock.ExpectRollback()
+
+			err = repo.RenewLoan(context.Background(), loanID, time.Now().AddDate(0, 0, 14), 2)
+
+			if !errors.Is(err, ErrHoldsWaiting) {
+				t.Errorf("expected error to be ErrHoldsWaiting, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// renewalColumns are the columns of a loan read for renewal.
+var renewalColumns = []string{"due_date", "return_date", "renewal_count", "book_id"}
+
+// expectInsertLoan expects backend to insert the loan, which gets loanID. SQLite reports
+// the ID through LastInsertId and PostgreSQL returns it.
+func expectInsertLoan(mock sqlmock.Sqlmock, backend, insertLoan string, copyID, userID int64, dueDate time.Time, loanID int64) {
+	if backend == "postgres" {
+		mock.ExpectQuery(regexp.QuoteMeta(insertLoan)).
+			WithArgs(copyID, userID, sqlmock.AnyArg(), dueDate).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(loanID))
+		return
 	}
+	mock.ExpectExec(regexp.QuoteMeta(insertLoan)).
+		WithArgs(copyID, userID, sqlmock.AnyArg(), dueDate).
+		WillReturnResult(sqlmock.NewResult(loanID, 1))
 }
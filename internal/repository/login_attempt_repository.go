@@ -0,0 +1,87 @@
+// Package repository provides a data abstraction layer.
+// This file contains the implementation for failed login attempt operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// LoginAttemptFilter holds the criteria for searching failed login attempts.
+type LoginAttemptFilter struct {
+	Username *string
+	IP       *string
+	Since    *time.Time
+}
+
+// LoginAttemptRepository defines the interface for failed login attempt operations.
+type LoginAttemptRepository interface {
+	Record(ctx context.Context, attempt models.LoginAttempt) error
+	Search(ctx context.Context, filter LoginAttemptFilter, limit, offset int) ([]models.LoginAttempt, int, error)
+}
+
+// sqliteLoginAttemptRepository is the concrete implementation for SQLite.
+type sqliteLoginAttemptRepository struct {
+	DB *sql.DB
+}
+
+// NewSQLiteLoginAttemptRepository creates a new repository instance.
+func NewSQLiteLoginAttemptRepository(db *sql.DB) LoginAttemptRepository {
+	return &sqliteLoginAttemptRepository{DB: db}
+}
+
+// Record stores a failed login attempt, timestamped now.
+func (r *sqliteLoginAttemptRepository) Record(ctx context.Context, attempt models.LoginAttempt) error {
+	_, err := r.DB.ExecContext(ctx, "INSERT INTO login_attempts (username, ip, reason, created_at) VALUES (?, ?, ?, ?)",
+		attempt.Username, attempt.IP, attempt.Reason, time.Now())
+	return err
+}
+
+// Search returns a page of the failed login attempts matching filter, newest first,
+// along with the total number of matches.
+func (r *sqliteLoginAttemptRepository) Search(ctx context.Context, filter LoginAttemptFilter, limit, offset int) ([]models.LoginAttempt, int, error) {
+	whereClause := " WHERE 1=1"
+	var args []interface{}
+	if filter.Username != nil {
+		whereClause += " AND username = ?"
+		args = append(args, *filter.Username)
+	}
+	if filter.IP != nil {
+		whereClause += " AND ip = ?"
+		args = append(args, *filter.IP)
+	}
+	if filter.Since != nil {
+		whereClause += " AND julianday(created_at) >= julianday(?)"
+		args = append(args, *filter.Since)
+	}
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM login_attempts"+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, username, ip, reason, created_at FROM login_attempts"+whereClause+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	attempts, err := scanLoginAttempts(rows)
+	return attempts, total, err
+}
+
+// scanLoginAttempts reads every row of a login_attempts query.
+func scanLoginAttempts(rows *sql.Rows) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IP, &attempt.Reason, &attempt.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
@@ -0,0 +1,72 @@
+// Package repository contains tests for the repository layer.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// loginAttemptBackends lists the LoginAttemptRepository implementations every test runs
+// against, along with the statements each one issues for a search by username and IP.
+var loginAttemptBackends = []struct {
+	name        string
+	newRepo     func(*sql.DB) LoginAttemptRepository
+	countQuery  string
+	selectQuery string
+}{
+	{
+		name:        "sqlite",
+		newRepo:     NewSQLiteLoginAttemptRepository,
+		countQuery:  "SELECT COUNT(*) FROM login_attempts WHERE 1=1 AND username = ? AND ip = ?",
+		selectQuery: "SELECT id, username, ip, reason, created_at FROM login_attempts WHERE 1=1 AND username = ? AND ip = ? ORDER BY id DESC LIMIT ? OFFSET ?",
+	},
+	{
+		name:        "postgres",
+		newRepo:     NewPostgresLoginAttemptRepository,
+		countQuery:  "SELECT COUNT(*) FROM login_attempts WHERE 1=1 AND username = $1 AND ip = $2",
+		selectQuery: "SELECT id, username, ip, reason, created_at FROM login_attempts WHERE 1=1 AND username = $1 AND ip = $2 ORDER BY id DESC LIMIT $3 OFFSET $4",
+	},
+}
+
+// TestSearchLoginAttempts tests that failed logins are filtered and paged, newest first.
+func TestSearchLoginAttempts(t *testing.T) {
+	for _, backend := range loginAttemptBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			username, ip := "alice", "203.0.113.7"
+			now := time.Now()
+
+			mock.ExpectQuery(regexp.QuoteMeta(backend.countQuery)).
+				WithArgs(username, ip).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectQuery)).
+				WithArgs(username, ip, 10, 10).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username", "ip", "reason", "created_at"}).
+					AddRow(2, username, ip, "wrong_password", now).
+					AddRow(1, username, ip, "wrong_password", now))
+
+			attempts, total, err := repo.Search(context.Background(), LoginAttemptFilter{Username: &username, IP: &ip}, 10, 10)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if total != 12 || len(attempts) != 2 || attempts[0].ID != 2 {
+				t.Errorf("expected 2 of 12 attempts, newest first, but got %d of %d", len(attempts), total)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
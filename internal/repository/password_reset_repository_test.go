@@ -0,0 +1,107 @@
+// Package repository contains tests for the repository layer.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// passwordResetBackends lists the PasswordResetRepository implementations every test runs
+// against, along with the statements each one issues while consuming a token.
+var passwordResetBackends = []struct {
+	name           string
+	newRepo        func(*sql.DB) PasswordResetRepository
+	markUsed       string
+	setPassword    string
+	revokeSessions string
+}{
+	{
+		name:           "sqlite",
+		newRepo:        NewSQLitePasswordResetRepository,
+		markUsed:       "UPDATE password_reset_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL",
+		setPassword:    "UPDATE users SET password_hash = ? WHERE id = ?",
+		revokeSessions: "UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+	},
+	{
+		name:           "postgres",
+		newRepo:        NewPostgresPasswordResetRepository,
+		markUsed:       "UPDATE password_reset_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL",
+		setPassword:    "UPDATE users SET password_hash = $1 WHERE id = $2",
+		revokeSessions: "UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL",
+	},
+}
+
+// TestConsumePasswordReset_Success tests that consuming a token sets the new password and
+// revokes every session of the user in the same transaction, auditing the change without
+// recording either password hash.
+func TestConsumePasswordReset_Success(t *testing.T) {
+	for _, backend := range passwordResetBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			token := &models.PasswordResetToken{ID: 4, UserID: 3}
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(backend.markUsed)).
+				WithArgs(sqlmock.AnyArg(), 4).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.setPassword)).
+				WithArgs("new-hash", 3).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.revokeSessions)).
+				WithArgs(sqlmock.AnyArg(), 3).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			expectAudit(mock, backend.name, models.AuditPasswordChange, models.AuditUser, 3, "{}")
+			mock.ExpectCommit()
+
+			if err := repo.Consume(context.Background(), token, "new-hash"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestConsumePasswordReset_AlreadyUsed tests that a token used by a concurrent request
+// does not change the password again.
+func TestConsumePasswordReset_AlreadyUsed(t *testing.T) {
+	for _, backend := range passwordResetBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(backend.markUsed)).
+				WithArgs(sqlmock.AnyArg(), 4).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			err = repo.Consume(context.Background(), &models.PasswordResetToken{ID: 4, UserID: 3}, "new-hash")
+
+			if !errors.Is(err, ErrTokenUsed) {
+				t.Errorf("expected error to be ErrTokenUsed, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+// Package repository provides a data abstraction layer.
+// This file contains helpers shared by the PostgreSQL implementations.
+package repository
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PostgreSQL error codes (SQLSTATE) handled by the repositories.
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+// pgPlaceholders returns a comma-separated list of n positional parameters starting at $start.
+func pgPlaceholders(start, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = "$" + strconv.Itoa(start+i)
+	}
+	return strings.Join(params, ",")
+}
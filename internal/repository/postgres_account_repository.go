@@ -0,0 +1,87 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for patron account (fines ledger) operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresAccountRepository is the concrete implementation for PostgreSQL.
+type postgresAccountRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresAccountRepository creates a new repository instance.
+func NewPostgresAccountRepository(db *sql.DB) AccountRepository {
+	return &postgresAccountRepository{DB: db}
+}
+
+// AddEntry locks the patron's row so concurrent payments cannot both pass the balance check.
+func (r *postgresAccountRepository) AddEntry(ctx context.Context, entry models.AccountEntry) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", entry.UserID).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	var balance int64
+	if err := tx.QueryRowContext(ctx, accountBalanceSQL+"$1", entry.UserID).Scan(&balance); err != nil {
+		return 0, err
+	}
+	if entry.Kind != models.EntryCharge && entry.AmountCents > balance {
+		return 0, ErrExceedsBalance
+	}
+
+	if entry.LoanID != nil {
+		var owned bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM loans WHERE id = $1 AND user_id = $2)", *entry.LoanID, entry.UserID).Scan(&owned)
+		if err != nil {
+			return 0, err
+		}
+		if !owned {
+			return 0, ErrLoanNotOwned
+		}
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO account_entries (user_id, loan_id, kind, amount_cents, note, recorded_by, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		entry.UserID, entry.LoanID, entry.Kind, entry.AmountCents, entry.Note, entry.RecordedBy, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// GetAccount returns a patron's ledger, newest entry first, and its balance.
+func (r *postgresAccountRepository) GetAccount(ctx context.Context, userID int64) (*models.Account, error) {
+	var exists bool
+	if err := r.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, user_id, loan_id, kind, amount_cents, note, recorded_by, created_at FROM account_entries WHERE user_id = $1 ORDER BY created_at DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAccount(userID, rows)
+}
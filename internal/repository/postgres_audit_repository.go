@@ -0,0 +1,110 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for audit log operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/Lec7ral/fullAPI/internal/audit"
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresAuditRepository is the concrete implementation for PostgreSQL.
+type postgresAuditRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresAuditRepository creates a new repository instance.
+func NewPostgresAuditRepository(db *sql.DB) AuditRepository {
+	return &postgresAuditRepository{DB: db}
+}
+
+// Search returns a page of the audit entries matching filter, newest first, along with
+// the total number of matches.
+func (r *postgresAuditRepository) Search(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEntry, int, error) {
+	whereClause := " WHERE 1=1"
+	var args []interface{}
+	if filter.ActorID != nil {
+		args = append(args, *filter.ActorID)
+		whereClause += " AND actor_id = $" + strconv.Itoa(len(args))
+	}
+	if filter.Action != nil {
+		args = append(args, *filter.Action)
+		whereClause += " AND action = $" + strconv.Itoa(len(args))
+	}
+	if filter.EntityType != nil {
+		args = append(args, *filter.EntityType)
+		whereClause += " AND entity_type = $" + strconv.Itoa(len(args))
+	}
+	if filter.EntityID != nil {
+		args = append(args, *filter.EntityID)
+		whereClause += " AND entity_id = $" + strconv.Itoa(len(args))
+	}
+	if filter.RequestID != nil {
+		args = append(args, *filter.RequestID)
+		whereClause += " AND request_id = $" + strconv.Itoa(len(args))
+	}
+	if filter.Since != nil {
+		args = append(args, *filter.Since)
+		whereClause += " AND created_at >= $" + strconv.Itoa(len(args))
+	}
+	if filter.Until != nil {
+		args = append(args, *filter.Until)
+		whereClause += " AND created_at < $" + strconv.Itoa(len(args))
+	}
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log"+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	page := " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)+1) + " OFFSET $" + strconv.Itoa(len(args)+2)
+	rows, err := r.DB.QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_log"+whereClause+page,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var entries []models.AuditEntry
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// Verify checks the hash chain of the whole log, oldest entry first.
+func (r *postgresAuditRepository) Verify(ctx context.Context) (*models.AuditVerification, error) {
+	return verifyAuditLog(ctx, r.DB)
+}
+
+// recordAuditPostgres appends the entry for a change made within tx, attributed to the
+// actor of ctx. While the hash chain is on, appends take a transaction-scoped advisory
+// lock, so concurrent transactions chain their entries one after another.
+func recordAuditPostgres(ctx context.Context, tx *sql.Tx, action, entityType string, entityID int64, before, after interface{}) error {
+	entry, err := audit.NewEntry(ctx, action, entityType, entityID, before, after)
+	if err != nil {
+		return err
+	}
+	var prevHash, hash sql.NullString
+	if audit.HashChain() {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('audit_log'))"); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(ctx, "SELECT hash FROM audit_log WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		hash = sql.NullString{String: audit.Hash(prevHash.String, entry), Valid: true}
+		prevHash.Valid = true
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO audit_log (created_at, actor_id, actor, action, entity_type, entity_id, changes, request_id, ip, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
+		entry.CreatedAt, entry.ActorID, entry.Actor, entry.Action, entry.EntityType, entry.EntityID, string(entry.Changes), entry.RequestID, entry.IP, prevHash, hash)
+	return err
+}
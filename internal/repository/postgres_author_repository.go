@@ -0,0 +1,226 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for author data operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresAuthorRepository is the concrete implementation for PostgreSQL.
+type postgresAuthorRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresAuthorRepository creates a new repository instance.
+func NewPostgresAuthorRepository(db *sql.DB) AuthorRepository {
+	return &postgresAuthorRepository{DB: db}
+}
+
+func (r *postgresAuthorRepository) Create(ctx context.Context, author models.Author) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO authors (name, bio) VALUES ($1, $2) RETURNING id", author.Name, author.Bio).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := recordAuditPostgres(ctx, tx, models.AuditCreate, models.AuditAuthor, id, nil, authorAuditOf(author)); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// Update changes the author's name and bio.
+func (r *postgresAuthorRepository) Update(ctx context.Context, id int64, author models.Author) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := authorAuditPostgres(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE authors SET name = $1, bio = $2 WHERE id = $3", author.Name, author.Bio, id); err != nil {
+		return err
+	}
+
+	if err := recordAuditPostgres(ctx, tx, models.AuditUpdate, models.AuditAuthor, id, before, authorAuditOf(author)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes the author, along with their books when cascade is set. The books'
+// copies and holds go with them by ON DELETE CASCADE. The cascade is refused with
+// ErrBookHasLoans, before anything is deleted, if the copies of any of the books have loans.
+func (r *postgresAuthorRepository) Delete(ctx context.Context, id int64, cascade bool) ([]int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := authorAuditPostgres(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	books, err := authoredBooksPostgres(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) > 0 && !cascade {
+		return nil, ErrAuthorHasBooks
+	}
+	if len(books) > 0 {
+		var loanCount int
+		err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id JOIN books b ON b.id = c.book_id WHERE b.author_id = $1", id).Scan(&loanCount)
+		if err != nil {
+			return nil, err
+		}
+		if loanCount > 0 {
+			return nil, ErrBookHasLoans
+		}
+	}
+	bookIDs := make([]int64, len(books))
+	for i, book := range books {
+		if err := deleteBookPostgres(ctx, tx, book.ID); err != nil {
+			return nil, err
+		}
+		bookIDs[i] = book.ID
+	}
+	// A book added for the author since they were read makes this fail the foreign key.
+	if _, err := tx.ExecContext(ctx, "DELETE FROM authors WHERE id = $1", id); err != nil {
+		return nil, constraintError(err)
+	}
+
+	if err := recordAuditPostgres(ctx, tx, models.AuditDelete, models.AuditAuthor, id, before, nil); err != nil {
+		return nil, err
+	}
+
+	return bookIDs, tx.Commit()
+}
+
+// Merge repoints the books of each source author to the author id and deletes the source
+// author, recording every change. An ID in sourceIDs equal to id is skipped.
+func (r *postgresAuthorRepository) Merge(ctx context.Context, id int64, sourceIDs []int64) ([]int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := authorAuditPostgres(ctx, tx, id); err != nil {
+		return nil, err
+	}
+
+	var moved []int64
+	for _, sourceID := range sourceIDs {
+		if sourceID == id {
+			continue
+		}
+		before, err := authorAuditPostgres(ctx, tx, sourceID)
+		if err != nil {
+			return nil, err
+		}
+		books, err := authoredBooksPostgres(ctx, tx, sourceID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE books SET author_id = $1 WHERE author_id = $2", id, sourceID); err != nil {
+			return nil, err
+		}
+		for _, book := range books {
+			after := book.bookAudit
+			after.AuthorID = id
+			if err := recordAuditPostgres(ctx, tx, models.AuditUpdate, models.AuditBook, book.ID, &book.bookAudit, &after); err != nil {
+				return nil, err
+			}
+			moved = append(moved, book.ID)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM authors WHERE id = $1", sourceID); err != nil {
+			return nil, constraintError(err)
+		}
+		if err := recordAuditPostgres(ctx, tx, models.AuditDelete, models.AuditAuthor, sourceID, before, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return moved, tx.Commit()
+}
+
+// Search returns a page of the authors matching filter, ordered by sort and order, along
+// with the number of matching authors. ILIKE keeps the name filter case-insensitive,
+// matching SQLite's LIKE behaviour.
+func (r *postgresAuthorRepository) Search(ctx context.Context, filter AuthorFilter, limit, offset int, sort, order string) ([]models.Author, int, error) {
+	var args []interface{}
+	whereClause := " WHERE 1=1"
+	if filter.Name != nil {
+		args = append(args, fmt.Sprintf("%%%s%%", *filter.Name))
+		whereClause += " AND name ILIKE $" + strconv.Itoa(len(args))
+	}
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM authors"+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT id, name, bio FROM authors" + whereClause + " ORDER BY " + authorOrderBy(sort, order) +
+		" LIMIT $" + strconv.Itoa(len(args)+1) + " OFFSET $" + strconv.Itoa(len(args)+2)
+	rows, err := r.DB.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	authors, err := scanAuthors(rows)
+	return authors, total, err
+}
+
+func (r *postgresAuthorRepository) GetByID(ctx context.Context, id int64) (*models.Author, error) {
+	var author models.Author
+	query := "SELECT id, name, bio FROM authors WHERE id = $1"
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&author.ID, &author.Name, &author.Bio)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &author, nil
+}
+
+// authorAuditPostgres reads and locks the audited state of the author within tx, returning
+// ErrNotFound if there is no such author.
+func authorAuditPostgres(ctx context.Context, tx *sql.Tx, id int64) (*authorAudit, error) {
+	var author authorAudit
+	err := tx.QueryRowContext(ctx, "SELECT name, bio FROM authors WHERE id = $1 FOR UPDATE", id).Scan(&author.Name, &author.Bio)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return &author, err
+}
+
+// authoredBooksPostgres reads and locks the books of the author within tx.
+func authoredBooksPostgres(ctx context.Context, tx *sql.Tx, authorID int64) ([]authoredBook, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT id, title, published_date, isbn, material_type, author_id FROM books WHERE author_id = $1 ORDER BY id FOR UPDATE", authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuthoredBooks(rows)
+}
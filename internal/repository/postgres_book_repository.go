@@ -0,0 +1,304 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for book data operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// postgresBookRepository is the concrete implementation for PostgreSQL.
+type postgresBookRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresBookRepository creates a new repository instance.
+func NewPostgresBookRepository(db *sql.DB) BookRepository {
+	return &postgresBookRepository{DB: db}
+}
+
+// Create inserts the book and adds book.Stock available copies of it in one transaction.
+func (r *postgresBookRepository) Create(ctx context.Context, book models.Book) (int64, error) {
+	if book.MaterialType == "" {
+		book.MaterialType = "book"
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO books (title, published_date, isbn, material_type, author_id) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		book.Title, book.PublishedDate, book.ISBN, book.MaterialType, book.AuthorID,
+	).Scan(&id)
+	if err != nil {
+		return 0, constraintError(err)
+	}
+
+	acquired := time.Now().Format("2006-01-02")
+	for n := 1; n <= book.Stock; n++ {
+		_, err = tx.ExecContext(ctx, "INSERT INTO copies (book_id, barcode, status, acquisition_date) VALUES ($1, $2, $3, $4)",
+			id, generatedBarcode(id, n), models.CopyStatusAvailable, acquired)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := recordAuditPostgres(ctx, tx, models.AuditCreate, models.AuditBook, id, nil, bookAuditOf(book)); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// Update changes the book's details. Stock is derived from its copies and is not updated here.
+func (r *postgresBookRepository) Update(ctx context.Context, id int64, book models.Book) error {
+	if book.MaterialType == "" {
+		book.MaterialType = "book"
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := bookAuditPostgres(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE books SET title = $1, published_date = $2, isbn = $3, material_type = $4, author_id = $5 WHERE id = $6",
+		book.Title, book.PublishedDate, book.ISBN, book.MaterialType, book.AuthorID, id,
+	)
+	if err != nil {
+		return constraintError(err)
+	}
+
+	if err := recordAuditPostgres(ctx, tx, models.AuditUpdate, models.AuditBook, id, before, bookAuditOf(book)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes the book; its copies go with it through ON DELETE CASCADE. A book whose
+// copies have loan history is not deleted, so the history stays intact.
+func (r *postgresBookRepository) Delete(ctx context.Context, id int64) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteBookPostgres(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteBookPostgres deletes the book within tx, its copies and holds going with it by
+// ON DELETE CASCADE, and records the deletion. It returns ErrNotFound if there is no such
+// book, and ErrBookHasLoans if loans, returned or not, reference its copies.
+func deleteBookPostgres(ctx context.Context, tx *sql.Tx, id int64) error {
+	before, err := bookAuditPostgres(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	var loanCount int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM loans l JOIN copies c ON c.id = l.copy_id WHERE c.book_id = $1", id).Scan(&loanCount)
+	if err != nil {
+		return err
+	}
+	if loanCount > 0 {
+		return ErrBookHasLoans
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM books WHERE id = $1", id); err != nil {
+		return constraintError(err)
+	}
+
+	return recordAuditPostgres(ctx, tx, models.AuditDelete, models.AuditBook, id, before, nil)
+}
+
+// bookAuditPostgres reads and locks the audited state of the book within tx, returning
+// ErrNotFound if there is no such book.
+func bookAuditPostgres(ctx context.Context, tx *sql.Tx, id int64) (*bookAudit, error) {
+	var book bookAudit
+	err := tx.QueryRowContext(ctx, "SELECT title, published_date, isbn, material_type, author_id FROM books WHERE id = $1 FOR UPDATE", id).
+		Scan(&book.Title, &book.PublishedDate, &book.ISBN, &book.MaterialType, &book.AuthorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return &book, err
+}
+
+// GetByID uses a 2-step query to avoid JOINs on a single-item lookup.
+func (r *postgresBookRepository) GetByID(ctx context.Context, id int64) (*models.Book, error) {
+	var book models.Book
+	query := "SELECT b.id, b.title, b.published_date, b.isbn, " + availableCopiesSQL + ", b.material_type, b.author_id FROM books b WHERE b.id = $1"
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&book.ID, &book.Title, &book.PublishedDate, &book.ISBN, &book.Stock, &book.MaterialType, &book.AuthorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if book.AuthorID > 0 {
+		var author models.Author
+		authorQuery := "SELECT id, name, bio FROM authors WHERE id = $1"
+		err = r.DB.QueryRowContext(ctx, authorQuery, book.AuthorID).Scan(&author.ID, &author.Name, &author.Bio)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		book.Author = &author
+	}
+
+	return &book, nil
+}
+
+// Search uses the same 2-query strategy as the SQLite implementation to avoid the N+1 problem.
+// ILIKE keeps the filters case-insensitive, matching SQLite's LIKE behaviour. Full-text
+// queries match the books.search_vector column and are ranked with ts_rank_cd.
+func (r *postgresBookRepository) Search(ctx context.Context, filter BookFilter, limit, offset int, sort, order string) (books []models.Book, total int, err error) {
+	ctx, span := startSpan(ctx, "books.Search")
+	defer func() { endSpan(span, err) }()
+
+	// --- 1. Build the query for fetching book IDs that match the criteria ---
+	var idArgs []interface{}
+	selectClause := "SELECT b.id"
+	fromClause := " FROM books b"
+	whereClause := " WHERE 1=1"
+	var tsQuery string
+
+	if filter.Author != nil {
+		fromClause += " JOIN authors a ON b.author_id = a.id"
+		idArgs = append(idArgs, fmt.Sprintf("%%%s%%", *filter.Author))
+		whereClause += fmt.Sprintf(" AND a.name ILIKE $%d", len(idArgs))
+	} else if filter.Query != nil {
+		// The author's name and bio feed the highlighted snippet.
+		fromClause += " LEFT JOIN authors a ON b.author_id = a.id"
+	}
+	if filter.Title != nil {
+		idArgs = append(idArgs, fmt.Sprintf("%%%s%%", *filter.Title))
+		whereClause += fmt.Sprintf(" AND b.title ILIKE $%d", len(idArgs))
+	}
+	if filter.AuthorID != nil {
+		idArgs = append(idArgs, *filter.AuthorID)
+		whereClause += fmt.Sprintf(" AND b.author_id = $%d", len(idArgs))
+	}
+	if filter.Query != nil {
+		idArgs = append(idArgs, *filter.Query)
+		tsQuery = fmt.Sprintf("websearch_to_tsquery('english', $%d)", len(idArgs))
+		selectClause += ", ts_headline('english', concat_ws(' — ', b.title, a.name, a.bio), " + tsQuery +
+			", 'StartSel=<mark>, StopSel=</mark>, MaxWords=24, MinWords=8')"
+		whereClause += " AND b.search_vector @@ " + tsQuery
+	}
+
+	idQuery := selectClause + fromClause + whereClause
+
+	// --- 2. Get the total count using the same filters ---
+	countQuery := "SELECT COUNT(b.id)" + fromClause + whereClause
+
+	var totalRecords int
+	err = traceQuery(ctx, "books.Search count", semconv.DBSystemPostgreSQL, countQuery, func(ctx context.Context) error {
+		return r.DB.QueryRowContext(ctx, countQuery, idArgs...).Scan(&totalRecords)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// --- 3. Apply sorting and pagination to the ID query ---
+	if sortColumn, ok := bookSortColumns[sort]; ok {
+		if strings.ToUpper(order) != "ASC" && strings.ToUpper(order) != "DESC" {
+			order = "ASC"
+		}
+		idQuery += fmt.Sprintf(" ORDER BY %s %s", sortColumn, order)
+	} else if filter.Query != nil {
+		idQuery += " ORDER BY ts_rank_cd(b.search_vector, " + tsQuery + ") DESC"
+	}
+	idQuery += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(idArgs)+1, len(idArgs)+2)
+	idArgs = append(idArgs, limit, offset)
+
+	var bookIDs []interface{}
+	snippets := make(map[int64]string)
+	err = traceQuery(ctx, "books.Search ids", semconv.DBSystemPostgreSQL, idQuery, func(ctx context.Context) error {
+		rows, err := r.DB.QueryContext(ctx, idQuery, idArgs...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			if filter.Query != nil {
+				var snippet string
+				if err := rows.Scan(&id, &snippet); err != nil {
+					return err
+				}
+				snippets[id] = snippet
+			} else if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			bookIDs = append(bookIDs, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(bookIDs) == 0 {
+		return []models.Book{}, totalRecords, nil
+	}
+
+	// --- 4. Fetch the full book and author data for the retrieved IDs ---
+	mainQuery := getBookWithAuthorSQL + " WHERE b.id IN (" + pgPlaceholders(1, len(bookIDs)) + ")"
+
+	booksMap := make(map[int64]*models.Book)
+	err = traceQuery(ctx, "books.Search books", semconv.DBSystemPostgreSQL, mainQuery, func(ctx context.Context) error {
+		mainRows, err := r.DB.QueryContext(ctx, mainQuery, bookIDs...)
+		if err != nil {
+			return err
+		}
+		defer mainRows.Close()
+
+		for mainRows.Next() {
+			var book models.Book
+			var author models.Author
+			if err := mainRows.Scan(
+				&book.ID, &book.Title, &book.PublishedDate, &book.ISBN, &book.Stock, &book.MaterialType, &book.AuthorID,
+				&author.ID, &author.Name, &author.Bio,
+			); err != nil {
+				return err
+			}
+			book.Author = &author
+			booksMap[book.ID] = &book
+		}
+		return mainRows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Re-order the results to match the order of the bookIDs query.
+	finalBooks := make([]models.Book, 0, len(bookIDs))
+	for _, id := range bookIDs {
+		if book, ok := booksMap[id.(int64)]; ok {
+			book.Snippet = snippets[book.ID]
+			finalBooks = append(finalBooks, *book)
+		}
+	}
+
+	return finalBooks, totalRecords, nil
+}
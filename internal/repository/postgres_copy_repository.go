@@ -0,0 +1,154 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for copy data operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresCopyRepository is the concrete implementation for PostgreSQL.
+type postgresCopyRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresCopyRepository creates a new repository instance.
+func NewPostgresCopyRepository(db *sql.DB) CopyRepository {
+	return &postgresCopyRepository{DB: db}
+}
+
+// Create inserts a new copy of a book. An available copy goes to the oldest waiting hold
+// on the book first, ready for pickup until holdExpiresAt.
+func (r *postgresCopyRepository) Create(ctx context.Context, bookCopy models.Copy, holdExpiresAt time.Time) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO copies (book_id, barcode, condition, status, acquisition_date) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.Status, bookCopy.AcquisitionDate,
+	).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrBarcodeExists
+		}
+		return 0, err
+	}
+
+	if bookCopy.Status == models.CopyStatusAvailable {
+		if err := releaseCopyPostgres(ctx, tx, id, bookCopy.BookID, holdExpiresAt); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// Update locks the copy row so a concurrent checkout cannot slip in between
+// the status check and the update. A copy put back into circulation goes to the oldest
+// waiting hold on its book first, ready for pickup until holdExpiresAt.
+func (r *postgresCopyRepository) Update(ctx context.Context, id int64, bookCopy models.Copy, holdExpiresAt time.Time) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var bookID int64
+	err = tx.QueryRowContext(ctx, "SELECT status, book_id FROM copies WHERE id = $1 FOR UPDATE", id).Scan(&status, &bookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if err := checkCopyStatusChange(status, bookCopy.Status); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE copies SET barcode = $1, condition = $2, status = $3, acquisition_date = $4 WHERE id = $5",
+		bookCopy.Barcode, bookCopy.Condition, bookCopy.Status, bookCopy.AcquisitionDate, id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrBarcodeExists
+		}
+		return err
+	}
+
+	if status != models.CopyStatusAvailable && bookCopy.Status == models.CopyStatusAvailable {
+		if err := releaseCopyPostgres(ctx, tx, id, bookID, holdExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a copy that has never been lent out.
+func (r *postgresCopyRepository) Delete(ctx context.Context, id int64) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var loanCount int
+	err = tx.QueryRowContext(ctx, "SELECT (SELECT COUNT(*) FROM loans l WHERE l.copy_id = c.id) FROM copies c WHERE c.id = $1 FOR UPDATE", id).Scan(&loanCount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if loanCount > 0 {
+		return ErrCopyHasLoans
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM copies WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetByID finds a copy by its ID.
+func (r *postgresCopyRepository) GetByID(ctx context.Context, id int64) (*models.Copy, error) {
+	var bookCopy models.Copy
+	query := "SELECT id, book_id, barcode, condition, status, acquisition_date FROM copies WHERE id = $1"
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&bookCopy.ID, &bookCopy.BookID, &bookCopy.Barcode, &bookCopy.Condition, &bookCopy.Status, &bookCopy.AcquisitionDate)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &bookCopy, nil
+}
+
+// ListByBook returns every copy of a book, ordered by ID.
+func (r *postgresCopyRepository) ListByBook(ctx context.Context, bookID int64) ([]models.Copy, error) {
+	query := "SELECT id, book_id, barcode, condition, status, acquisition_date FROM copies WHERE book_id = $1 ORDER BY id"
+	rows, err := r.DB.QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	copies := []models.Copy{}
+	for rows.Next() {
+		var bookCopy models.Copy
+		if err := rows.Scan(&bookCopy.ID, &bookCopy.BookID, &bookCopy.Barcode, &bookCopy.Condition, &bookCopy.Status, &bookCopy.AcquisitionDate); err != nil {
+			return nil, err
+		}
+		copies = append(copies, bookCopy)
+	}
+	return copies, rows.Err()
+}
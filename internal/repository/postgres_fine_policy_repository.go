@@ -0,0 +1,43 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for fine policy operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresFinePolicyRepository is the concrete implementation for PostgreSQL.
+type postgresFinePolicyRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresFinePolicyRepository creates a new repository instance.
+func NewPostgresFinePolicyRepository(db *sql.DB) FinePolicyRepository {
+	return &postgresFinePolicyRepository{DB: db}
+}
+
+// GetAll returns the fine policy of every material type.
+func (r *postgresFinePolicyRepository) GetAll(ctx context.Context) ([]models.FinePolicy, error) {
+	rows, err := r.DB.QueryContext(ctx, "SELECT material_type, daily_rate_cents, max_fine_cents, grace_days FROM fine_policies ORDER BY material_type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanFinePolicies(rows)
+}
+
+// Upsert creates or replaces the fine policy for a material type.
+func (r *postgresFinePolicyRepository) Upsert(ctx context.Context, policy models.FinePolicy) error {
+	_, err := r.DB.ExecContext(ctx, `
+		INSERT INTO fine_policies (material_type, daily_rate_cents, max_fine_cents, grace_days) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (material_type) DO UPDATE SET
+			daily_rate_cents = excluded.daily_rate_cents,
+			max_fine_cents = excluded.max_fine_cents,
+			grace_days = excluded.grace_days`,
+		policy.MaterialType, policy.DailyRateCents, policy.MaxFineCents, policy.GraceDays)
+	return err
+}
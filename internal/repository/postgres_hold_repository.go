@@ -0,0 +1,191 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for hold (reservation queue) operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresHoldRepository is the concrete implementation for PostgreSQL.
+type postgresHoldRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresHoldRepository creates a new repository instance.
+func NewPostgresHoldRepository(db *sql.DB) HoldRepository {
+	return &postgresHoldRepository{DB: db}
+}
+
+// releaseCopyPostgres puts a copy that has come back into circulation aside for the oldest
+// waiting hold on its book, ready for pickup until pickupExpiresAt. When nobody is
+// waiting, the copy becomes available again.
+func releaseCopyPostgres(ctx context.Context, tx *sql.Tx, copyID, bookID int64, pickupExpiresAt time.Time) error {
+	var holdID int64
+	err := tx.QueryRowContext(ctx, "SELECT id FROM holds WHERE book_id = $1 AND status = $2 ORDER BY id LIMIT 1 FOR UPDATE",
+		bookID, models.HoldStatusWaiting).Scan(&holdID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = tx.ExecContext(ctx, "UPDATE copies SET status = $1 WHERE id = $2", models.CopyStatusAvailable, copyID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE holds SET status = $1, copy_id = $2, ready_at = $3, expires_at = $4 WHERE id = $5",
+		models.HoldStatusReady, copyID, time.Now(), pickupExpiresAt, holdID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE copies SET status = $1 WHERE id = $2", models.CopyStatusOnHold, copyID)
+	return err
+}
+
+// Create places a hold on a book for the user, at the back of the book's queue.
+// Books with an available copy cannot be held; they can be borrowed straight away.
+func (r *postgresHoldRepository) Create(ctx context.Context, bookID, userID int64) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var bookExists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM books WHERE id = $1)", bookID).Scan(&bookExists); err != nil {
+		return 0, err
+	}
+	if !bookExists {
+		return 0, ErrNotFound
+	}
+
+	var available bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM copies WHERE book_id = $1 AND status = $2)", bookID, models.CopyStatusAvailable).Scan(&available)
+	if err != nil {
+		return 0, err
+	}
+	if available {
+		return 0, ErrCopyAvailable
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO holds (book_id, user_id, status, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		bookID, userID, models.HoldStatusWaiting, time.Now()).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrHoldExists
+		}
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// GetByID finds a hold by its ID.
+func (r *postgresHoldRepository) GetByID(ctx context.Context, id int64) (*models.Hold, error) {
+	query := "SELECT id, book_id, user_id, status, copy_id, created_at, ready_at, expires_at FROM holds WHERE id = $1"
+	hold, err := scanHold(r.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return hold, nil
+}
+
+// GetActiveHoldsByUserID returns the user's waiting and ready holds, oldest first,
+// with each waiting hold's position in its book's queue.
+func (r *postgresHoldRepository) GetActiveHoldsByUserID(ctx context.Context, userID int64) ([]models.Hold, error) {
+	query := `
+		SELECT h.id, h.book_id, h.user_id, h.status, h.copy_id, h.created_at, h.ready_at, h.expires_at,
+			(SELECT COUNT(*) FROM holds q WHERE q.book_id = h.book_id AND q.status = 'waiting' AND q.id <= h.id),
+			b.title, b.isbn
+		FROM holds h
+		JOIN books b ON h.book_id = b.id
+		WHERE h.user_id = $1 AND h.status IN ('waiting', 'ready')
+		ORDER BY h.id
+	`
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanActiveHolds(rows)
+}
+
+// Cancel withdraws a waiting or ready hold. A copy set aside for a ready hold passes
+// to the next hold in the queue, or back on the shelf.
+func (r *postgresHoldRepository) Cancel(ctx context.Context, id int64, pickupExpiresAt time.Time) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var bookID int64
+	var status string
+	var copyID sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT book_id, status, copy_id FROM holds WHERE id = $1 FOR UPDATE", id).Scan(&bookID, &status, &copyID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if status != models.HoldStatusWaiting && status != models.HoldStatusReady {
+		return ErrHoldNotActive
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE holds SET status = $1 WHERE id = $2", models.HoldStatusCancelled, id); err != nil {
+		return err
+	}
+	if status == models.HoldStatusReady && copyID.Valid {
+		if err := releaseCopyPostgres(ctx, tx, copyID.Int64, bookID, pickupExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ExpireReadyHolds expires ready holds whose pickup deadline has passed, passing each
+// copy on to the next hold in its queue. It returns the holds that expired, with their
+// book and copy.
+func (r *postgresHoldRepository) ExpireReadyHolds(ctx context.Context, pickupExpiresAt time.Time) ([]models.Hold, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, book_id, copy_id FROM holds WHERE status = $1 AND expires_at < now() ORDER BY id FOR UPDATE",
+		models.HoldStatusReady)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := scanExpiredHolds(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hold := range expired {
+		if _, err := tx.ExecContext(ctx, "UPDATE holds SET status = $1 WHERE id = $2", models.HoldStatusExpired, hold.ID); err != nil {
+			return nil, err
+		}
+		if hold.CopyID != nil {
+			if err := releaseCopyPostgres(ctx, tx, *hold.CopyID, hold.BookID, pickupExpiresAt); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
@@ -0,0 +1,75 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for loan policy operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresLoanPolicyRepository is the concrete implementation for PostgreSQL.
+type postgresLoanPolicyRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresLoanPolicyRepository creates a new repository instance.
+func NewPostgresLoanPolicyRepository(db *sql.DB) LoanPolicyRepository {
+	return &postgresLoanPolicyRepository{DB: db}
+}
+
+// GetAll returns the loan policy of every role and material type.
+func (r *postgresLoanPolicyRepository) GetAll(ctx context.Context) ([]models.LoanPolicy, error) {
+	rows, err := r.DB.QueryContext(ctx, "SELECT role, material_type, max_loans FROM loan_policies ORDER BY role, material_type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLoanPolicies(rows)
+}
+
+// Upsert creates or replaces the loan policy for a role and material type. The role must exist.
+func (r *postgresLoanPolicyRepository) Upsert(ctx context.Context, policy models.LoanPolicy) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The share lock keeps the role from being deleted until the policy commits.
+	var name string
+	if err := tx.QueryRowContext(ctx, "SELECT name FROM roles WHERE name = $1 FOR SHARE", policy.Role).Scan(&name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loan_policies (role, material_type, max_loans) VALUES ($1, $2, $3)
+		ON CONFLICT (role, material_type) DO UPDATE SET max_loans = excluded.max_loans`,
+		policy.Role, policy.MaterialType, policy.MaxLoans)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes the loan policy for a role and material type, lifting its cap.
+func (r *postgresLoanPolicyRepository) Delete(ctx context.Context, role, materialType string) error {
+	result, err := r.DB.ExecContext(ctx, "DELETE FROM loan_policies WHERE role = $1 AND material_type = $2", role, materialType)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,504 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for loan data operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// postgresLoanRepository is the concrete implementation for PostgreSQL.
+type postgresLoanRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresLoanRepository creates a new repository instance.
+func NewPostgresLoanRepository(db *sql.DB) LoanRepository {
+	return &postgresLoanRepository{DB: db}
+}
+
+// CreateLoan checks out the copy set aside for the user's ready hold, fulfilling the hold, or
+// else the first available copy of the book until dueDate. SKIP LOCKED lets
+// concurrent loans of the same book each claim a different copy instead of queueing.
+// Loans that break a circulation rule, such as a balance above maxBalanceCents, are
+// refused with a *PolicyError listing every rule broken.
+func (r *postgresLoanRepository) CreateLoan(ctx context.Context, bookID, userID int64, dueDate time.Time, maxBalanceCents int64) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "loans.CreateLoan", attribute.Int64("book.id", bookID), attribute.Int64("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	violations, err := loanViolationsPostgres(ctx, tx, userID, bookID, maxBalanceCents)
+	if err != nil {
+		return 0, err
+	}
+	if len(violations) > 0 {
+		return 0, &PolicyError{Violations: violations}
+	}
+
+	// A copy set aside for the patron's ready hold is theirs to pick up; anyone else
+	// gets the first available copy.
+	var holdID, copyID int64
+	copyStatus := models.CopyStatusOnHold
+	err = tx.QueryRowContext(ctx, "SELECT id, copy_id FROM holds WHERE book_id = $1 AND user_id = $2 AND status = $3 AND copy_id IS NOT NULL AND expires_at > now() FOR UPDATE",
+		bookID, userID, models.HoldStatusReady).Scan(&holdID, &copyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		copyStatus = models.CopyStatusAvailable
+		err = tx.QueryRowContext(ctx, "SELECT id FROM copies WHERE book_id = $1 AND status = $2 ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED",
+			bookID, models.CopyStatusAvailable).Scan(&copyID)
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		return 0, ErrStockExhausted
+	}
+
+	loanID, err := lendCopyPostgres(ctx, tx, copyID, copyStatus, holdID, bookID, userID, dueDate)
+	if err != nil {
+		return 0, err
+	}
+	return loanID, tx.Commit()
+}
+
+// CheckoutCopy checks out the copy with the given barcode to the user until dueDate, as
+// when it is scanned at the circulation desk. A copy set aside for a hold can only be
+// checked out to the patron who placed it, fulfilling the hold.
+// Loans that break a circulation rule, such as a balance above maxBalanceCents, are
+// refused with a *PolicyError listing every rule broken.
+func (r *postgresLoanRepository) CheckoutCopy(ctx context.Context, barcode string, userID int64, dueDate time.Time, maxBalanceCents int64) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "loans.CheckoutCopy", attribute.String("copy.barcode", barcode), attribute.Int64("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var copyID, bookID, holdID int64
+	var copyStatus string
+	err = tx.QueryRowContext(ctx, "SELECT id, book_id, status FROM copies WHERE barcode = $1 FOR UPDATE", barcode).Scan(&copyID, &bookID, &copyStatus)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	switch copyStatus {
+	case models.CopyStatusAvailable:
+	case models.CopyStatusOnHold:
+		err = tx.QueryRowContext(ctx, "SELECT id FROM holds WHERE copy_id = $1 AND user_id = $2 AND status = $3 FOR UPDATE",
+			copyID, userID, models.HoldStatusReady).Scan(&holdID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrCopyOnHold
+		}
+		if err != nil {
+			return 0, err
+		}
+	case models.CopyStatusOnLoan:
+		return 0, ErrCopyOnLoan
+	default:
+		return 0, ErrCopyNotLendable
+	}
+
+	violations, err := loanViolationsPostgres(ctx, tx, userID, bookID, maxBalanceCents)
+	if err != nil {
+		return 0, err
+	}
+	if len(violations) > 0 {
+		return 0, &PolicyError{Violations: violations}
+	}
+
+	loanID, err := lendCopyPostgres(ctx, tx, copyID, copyStatus, holdID, bookID, userID, dueDate)
+	if err != nil {
+		return 0, err
+	}
+	return loanID, tx.Commit()
+}
+
+// CheckEligibility returns the circulation rules that keep the user from borrowing any
+// book at all, such as an expired membership or a balance above maxBalanceCents.
+func (r *postgresLoanRepository) CheckEligibility(ctx context.Context, userID int64, maxBalanceCents int64) ([]models.PolicyViolation, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return loanViolationsPostgres(ctx, tx, userID, 0, maxBalanceCents)
+}
+
+// loanViolationsPostgres returns the circulation rules the user would break by borrowing a
+// copy of the book within tx, or only those that apply to every book if bookID is 0.
+// Locking the patron makes their concurrent checkouts count each other's loans.
+func loanViolationsPostgres(ctx context.Context, tx *sql.Tx, userID, bookID int64, maxBalanceCents int64) ([]models.PolicyViolation, error) {
+	var borrower models.Borrower
+	var membershipExpiresAt sql.NullTime
+	err := tx.QueryRowContext(ctx, "SELECT role, membership_expires_at FROM users WHERE id = $1 FOR UPDATE", userID).
+		Scan(&borrower.Role, &membershipExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if membershipExpiresAt.Valid {
+		borrower.MembershipExpiresAt = &membershipExpiresAt.Time
+	}
+	if err := tx.QueryRowContext(ctx, accountBalanceSQL+"$1", userID).Scan(&borrower.BalanceCents); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT c.book_id, b.material_type
+		FROM loans l
+		JOIN copies c ON l.copy_id = c.id
+		JOIN books b ON c.book_id = b.id
+		WHERE l.user_id = $1 AND l.return_date IS NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var item models.BorrowedItem
+		if err := rows.Scan(&item.BookID, &item.MaterialType); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		borrower.ActiveLoans = append(borrower.ActiveLoans, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var materialType string
+	if bookID != 0 {
+		err := tx.QueryRowContext(ctx, "SELECT material_type FROM books WHERE id = $1", bookID).Scan(&materialType)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrNotFound
+			}
+			return nil, err
+		}
+	}
+
+	rows, err = tx.QueryContext(ctx, "SELECT role, material_type, max_loans FROM loan_policies WHERE role = $1", borrower.Role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	policies, err := scanLoanPolicies(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return borrower.CheckLoan(policies, maxBalanceCents, bookID, materialType, time.Now()), nil
+}
+
+// lendCopyPostgres checks out the copy, whose status is copyStatus, to the user until dueDate
+// within tx, fulfilling the hold with holdID unless it is 0, and returns the new loan's ID.
+// The conditional UPDATE guards against another transaction claiming the same copy.
+func lendCopyPostgres(ctx context.Context, tx *sql.Tx, copyID int64, copyStatus string, holdID, bookID, userID int64, dueDate time.Time) (int64, error) {
+	result, err := tx.ExecContext(ctx, "UPDATE copies SET status = $1 WHERE id = $2 AND status = $3",
+		models.CopyStatusOnLoan, copyID, copyStatus)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected == 0 {
+		return 0, ErrStockExhausted
+	}
+
+	var loanID int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO loans (copy_id, user_id, loan_date, due_date) VALUES ($1, $2, $3, $4) RETURNING id",
+		copyID, userID, time.Now(), dueDate).Scan(&loanID)
+	if err != nil {
+		return 0, err
+	}
+
+	if holdID != 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE holds SET status = $1 WHERE id = $2", models.HoldStatusFulfilled, holdID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	after := map[string]interface{}{"copy_id": copyID, "book_id": bookID, "user_id": userID, "due_date": dueDate}
+	if err := recordAuditPostgres(ctx, tx, models.AuditCheckout, models.AuditLoan, loanID, nil, after); err != nil {
+		return 0, err
+	}
+	return loanID, nil
+}
+
+// ReturnLoan marks the loan as returned and, if it comes back late, charges the fine set by
+// its material type's policy to the patron. The copy is set aside for the first hold on its
+// book, ready for pickup until holdExpiresAt, or made available when nobody is waiting.
+// It returns the fine in cents.
+func (r *postgresLoanRepository) ReturnLoan(ctx context.Context, loanID int64, holdExpiresAt time.Time) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "loans.ReturnLoan", attribute.Int64("loan.id", loanID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var loan models.Loan
+	var returnDate sql.NullTime
+	var bookID sql.NullInt64
+	var materialType sql.NullString
+	query := `
+		SELECT l.id, l.copy_id, l.user_id, l.due_date, l.return_date, c.book_id, b.material_type
+		FROM loans l
+		LEFT JOIN copies c ON l.copy_id = c.id
+		LEFT JOIN books b ON c.book_id = b.id
+		WHERE l.id = $1 FOR UPDATE OF l
+	`
+	err = tx.QueryRowContext(ctx, query, loanID).Scan(&loan.ID, &loan.CopyID, &loan.UserID, &loan.DueDate, &returnDate, &bookID, &materialType)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	if returnDate.Valid {
+		return 0, ErrAlreadyReturned
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, "UPDATE loans SET return_date = $1 WHERE id = $2", now, loanID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := releaseCopyPostgres(ctx, tx, loan.CopyID, bookID.Int64, holdExpiresAt); err != nil {
+		return 0, err
+	}
+
+	var fine int64
+	daysOverdue := models.DaysOverdue(loan.DueDate, now)
+	if daysOverdue > 0 && materialType.Valid {
+		var policy models.FinePolicy
+		err = tx.QueryRowContext(ctx, "SELECT daily_rate_cents, max_fine_cents, grace_days FROM fine_policies WHERE material_type = $1", materialType.String).
+			Scan(&policy.DailyRateCents, &policy.MaxFineCents, &policy.GraceDays)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		// Material types without a policy are not fined.
+		fine = policy.Assess(daysOverdue)
+	}
+	if fine > 0 {
+		_, err = tx.ExecContext(ctx, "INSERT INTO account_entries (user_id, loan_id, kind, amount_cents, note, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+			loan.UserID, loan.ID, models.EntryCharge, fine, fmt.Sprintf("Overdue fine: %d days late", daysOverdue), now)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	before := map[string]interface{}{"return_date": nil}
+	after := map[string]interface{}{"return_date": now, "fine_cents": fine}
+	if err := recordAuditPostgres(ctx, tx, models.AuditReturn, models.AuditLoan, loanID, before, after); err != nil {
+		return 0, err
+	}
+
+	return fine, tx.Commit()
+}
+
+// RenewLoan moves an active loan's due date to dueDate, at most maxRenewals times per loan.
+// Overdue loans are not renewed, so the fine for returning them late still applies, and
+// neither are loans of books that patrons are waiting for, so the hold queue moves on.
+func (r *postgresLoanRepository) RenewLoan(ctx context.Context, loanID int64, dueDate time.Time, maxRenewals int) (err error) {
+	ctx, span := startSpan(ctx, "loans.RenewLoan", attribute.Int64("loan.id", loanID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldDueDate time.Time
+	var returnDate sql.NullTime
+	var renewalCount int
+	var bookID int64
+	err = tx.QueryRowContext(ctx, "SELECT l.due_date, l.return_date, l.renewal_count, c.book_id FROM loans l JOIN copies c ON c.id = l.copy_id WHERE l.id = $1 FOR UPDATE OF l", loanID).
+		Scan(&oldDueDate, &returnDate, &renewalCount, &bookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if returnDate.Valid {
+		return ErrAlreadyReturned
+	}
+	if renewalCount >= maxRenewals {
+		return ErrRenewalLimit
+	}
+	if time.Now().After(oldDueDate) {
+		return ErrLoanOverdue
+	}
+
+	var waiting int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM holds WHERE book_id = $1 AND status = $2", bookID, models.HoldStatusWaiting).Scan(&waiting)
+	if err != nil {
+		return err
+	}
+	if waiting > 0 {
+		return ErrHoldsWaiting
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE loans SET due_date = $1, renewal_count = renewal_count + 1 WHERE id = $2", dueDate, loanID)
+	if err != nil {
+		return err
+	}
+
+	before := map[string]interface{}{"due_date": oldDueDate, "renewal_count": renewalCount}
+	after := map[string]interface{}{"due_date": dueDate, "renewal_count": renewalCount + 1}
+	if err := recordAuditPostgres(ctx, tx, models.AuditRenew, models.AuditLoan, loanID, before, after); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetLoanByID finds a loan by its ID, including the book its copy belongs to.
+func (r *postgresLoanRepository) GetLoanByID(ctx context.Context, loanID int64) (*models.Loan, error) {
+	query := `
+		SELECT l.id, l.copy_id, c.book_id, l.user_id, l.loan_date, l.due_date, l.return_date, l.renewal_count
+		FROM loans l
+		JOIN copies c ON l.copy_id = c.id
+		WHERE l.id = $1
+	`
+	var loan models.Loan
+	var returnDate sql.NullTime
+	err := r.DB.QueryRowContext(ctx, query, loanID).Scan(
+		&loan.ID, &loan.CopyID, &loan.BookID, &loan.UserID,
+		&loan.LoanDate, &loan.DueDate, &returnDate, &loan.RenewalCount,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if returnDate.Valid {
+		loan.ReturnDate = &returnDate.Time
+	}
+	return &loan, nil
+}
+
+func (r *postgresLoanRepository) GetActiveLoansByUserID(ctx context.Context, userID int64) ([]models.Loan, error) {
+	q := `
+		SELECT l.id, l.loan_date, l.due_date, l.renewal_count, l.copy_id, c.book_id, b.title, b.isbn
+		FROM loans l
+		JOIN copies c ON l.copy_id = c.id
+		JOIN books b ON c.book_id = b.id
+		WHERE l.user_id = $1 AND l.return_date IS NULL
+	`
+	rows, err := r.DB.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loans []models.Loan
+	for rows.Next() {
+		var loan models.Loan
+		var book models.Book
+		if err := rows.Scan(&loan.ID, &loan.LoanDate, &loan.DueDate, &loan.RenewalCount, &loan.CopyID, &loan.BookID, &book.Title, &book.ISBN); err != nil {
+			return nil, err
+		}
+		loan.UserID, book.ID = userID, loan.BookID
+		loan.Book = &book
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
+
+// SearchLoans searches for loans with optional filters.
+func (r *postgresLoanRepository) SearchLoans(ctx context.Context, filter LoanFilter) ([]models.Loan, error) {
+	query := `
+		SELECT
+			l.id, l.copy_id, l.loan_date, l.due_date, l.return_date, l.renewal_count,
+			b.id, b.title,
+			u.id, u.username
+		FROM loans l
+		JOIN copies c ON l.copy_id = c.id
+		JOIN books b ON c.book_id = b.id
+		JOIN users u ON l.user_id = u.id
+	`
+	var args []interface{}
+	whereClause := " WHERE 1=1"
+
+	if filter.Status != nil {
+		if *filter.Status == "active" {
+			whereClause += " AND l.return_date IS NULL"
+		} else if *filter.Status == "returned" {
+			whereClause += " AND l.return_date IS NOT NULL"
+		} else if *filter.Status == "overdue" {
+			whereClause += " AND l.return_date IS NULL AND l.due_date < now()"
+		}
+	}
+	if filter.UserID != nil {
+		args = append(args, *filter.UserID)
+		whereClause += " AND l.user_id = $" + strconv.Itoa(len(args))
+	}
+	if filter.BookID != nil {
+		args = append(args, *filter.BookID)
+		whereClause += " AND c.book_id = $" + strconv.Itoa(len(args))
+	}
+	if filter.Barcode != nil {
+		args = append(args, *filter.Barcode)
+		whereClause += " AND c.barcode = $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := r.DB.QueryContext(ctx, query+whereClause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loans []models.Loan
+	for rows.Next() {
+		var loan models.Loan
+		var book models.Book
+		var user models.User
+		var returnDate sql.NullTime
+
+		if err := rows.Scan(
+			&loan.ID, &loan.CopyID, &loan.LoanDate, &loan.DueDate, &returnDate, &loan.RenewalCount,
+			&book.ID, &book.Title,
+			&user.ID, &user.Username,
+		); err != nil {
+			return nil, err
+		}
+
+		if returnDate.Valid {
+			loan.ReturnDate = &returnDate.Time
+		}
+		loan.BookID, loan.UserID = book.ID, user.ID
+		loan.Book = &book
+		loan.User = &user
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
@@ -0,0 +1,63 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for failed login attempt operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresLoginAttemptRepository is the concrete implementation for PostgreSQL.
+type postgresLoginAttemptRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresLoginAttemptRepository creates a new repository instance.
+func NewPostgresLoginAttemptRepository(db *sql.DB) LoginAttemptRepository {
+	return &postgresLoginAttemptRepository{DB: db}
+}
+
+// Record stores a failed login attempt, timestamped now.
+func (r *postgresLoginAttemptRepository) Record(ctx context.Context, attempt models.LoginAttempt) error {
+	_, err := r.DB.ExecContext(ctx, "INSERT INTO login_attempts (username, ip, reason, created_at) VALUES ($1, $2, $3, $4)",
+		attempt.Username, attempt.IP, attempt.Reason, time.Now())
+	return err
+}
+
+// Search returns a page of the failed login attempts matching filter, newest first,
+// along with the total number of matches.
+func (r *postgresLoginAttemptRepository) Search(ctx context.Context, filter LoginAttemptFilter, limit, offset int) ([]models.LoginAttempt, int, error) {
+	whereClause := " WHERE 1=1"
+	var args []interface{}
+	if filter.Username != nil {
+		args = append(args, *filter.Username)
+		whereClause += " AND username = $" + strconv.Itoa(len(args))
+	}
+	if filter.IP != nil {
+		args = append(args, *filter.IP)
+		whereClause += " AND ip = $" + strconv.Itoa(len(args))
+	}
+	if filter.Since != nil {
+		args = append(args, *filter.Since)
+		whereClause += " AND created_at >= $" + strconv.Itoa(len(args))
+	}
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM login_attempts"+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	page := " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)+1) + " OFFSET $" + strconv.Itoa(len(args)+2)
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, username, ip, reason, created_at FROM login_attempts"+whereClause+page,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	attempts, err := scanLoginAttempts(rows)
+	return attempts, total, err
+}
@@ -0,0 +1,94 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for password reset token operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresPasswordResetRepository is the concrete implementation for PostgreSQL.
+type postgresPasswordResetRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresPasswordResetRepository creates a new repository instance.
+func NewPostgresPasswordResetRepository(db *sql.DB) PasswordResetRepository {
+	return &postgresPasswordResetRepository{DB: db}
+}
+
+// Create stores a newly issued reset token. Unused tokens issued to the user before it
+// expire, so only the latest one works.
+func (r *postgresPasswordResetRepository) Create(ctx context.Context, token models.PasswordResetToken) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, "UPDATE password_reset_tokens SET expires_at = $1 WHERE user_id = $2 AND used_at IS NULL AND expires_at > $3", now, token.UserID, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO password_reset_tokens (user_id, token_hash, created_by, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		token.UserID, token.TokenHash, token.CreatedBy, now, token.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get finds the reset token with hash tokenHash. It returns ErrTokenUsed or
+// ErrTokenExpired for tokens that can no longer be used.
+func (r *postgresPasswordResetRepository) Get(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	var usedAt sql.NullTime
+	err := r.DB.QueryRowContext(ctx, "SELECT id, user_id, created_by, created_at, expires_at, used_at FROM password_reset_tokens WHERE token_hash = $1", tokenHash).
+		Scan(&token.ID, &token.UserID, &token.CreatedBy, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	token.TokenHash = tokenHash
+	if usedAt.Valid {
+		return nil, ErrTokenUsed
+	}
+	if !time.Now().Before(token.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	return &token, nil
+}
+
+// Consume marks the reset token as used and sets its user's password hash in one
+// transaction, revoking the user's refresh tokens. It returns ErrTokenUsed if the token
+// was used in the meantime.
+func (r *postgresPasswordResetRepository) Consume(ctx context.Context, token *models.PasswordResetToken, passwordHash string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE password_reset_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL", time.Now(), token.ID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTokenUsed
+	}
+	if err := setPasswordPostgres(ctx, tx, token.UserID, passwordHash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,90 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for refresh token operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresRefreshTokenRepository is the concrete implementation for PostgreSQL.
+type postgresRefreshTokenRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresRefreshTokenRepository creates a new repository instance.
+func NewPostgresRefreshTokenRepository(db *sql.DB) RefreshTokenRepository {
+	return &postgresRefreshTokenRepository{DB: db}
+}
+
+// Create stores a newly issued refresh token.
+func (r *postgresRefreshTokenRepository) Create(ctx context.Context, token models.RefreshToken) error {
+	_, err := r.DB.ExecContext(ctx, "INSERT INTO refresh_tokens (user_id, session_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		token.UserID, token.SessionID, token.TokenHash, time.Now(), token.ExpiresAt)
+	return err
+}
+
+// Rotate exchanges the refresh token with hash tokenHash for a new one in the same session,
+// expiring at expiresAt, and returns the new token. The old token cannot be used again:
+// presenting a revoked token revokes its whole session, since it means the token was stolen
+// or replayed.
+func (r *postgresRefreshTokenRepository) Rotate(ctx context.Context, tokenHash, nextHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current models.RefreshToken
+	var revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT id, user_id, session_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE", tokenHash).
+		Scan(&current.ID, &current.UserID, &current.SessionID, &current.ExpiresAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if revokedAt.Valid {
+		if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE session_id = $2 AND revoked_at IS NULL", now, current.SessionID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrTokenRevoked
+	}
+	if !now.Before(current.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE id = $2", now, current.ID); err != nil {
+		return nil, err
+	}
+	next := models.RefreshToken{UserID: current.UserID, SessionID: current.SessionID, TokenHash: nextHash, CreatedAt: now, ExpiresAt: expiresAt}
+	err = tx.QueryRowContext(ctx, "INSERT INTO refresh_tokens (user_id, session_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		next.UserID, next.SessionID, next.TokenHash, next.CreatedAt, next.ExpiresAt).Scan(&next.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &next, tx.Commit()
+}
+
+// RevokeSession revokes every refresh token of a session.
+func (r *postgresRefreshTokenRepository) RevokeSession(ctx context.Context, sessionID string) error {
+	_, err := r.DB.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE session_id = $2 AND revoked_at IS NULL", time.Now(), sessionID)
+	return err
+}
+
+// RevokeAllForUser revokes every refresh token of every session of a user.
+func (r *postgresRefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID int64) error {
+	_, err := r.DB.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", time.Now(), userID)
+	return err
+}
@@ -0,0 +1,153 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for role and permission operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresRoleRepository is the concrete implementation for PostgreSQL.
+type postgresRoleRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresRoleRepository creates a new repository instance.
+func NewPostgresRoleRepository(db *sql.DB) RoleRepository {
+	return &postgresRoleRepository{DB: db}
+}
+
+// GetAll returns every role with its permissions, ordered by name.
+func (r *postgresRoleRepository) GetAll(ctx context.Context) ([]models.Role, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT r.name, r.description, rp.permission
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		ORDER BY r.name, rp.permission`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRoles(rows)
+}
+
+// GetByName returns a single role with its permissions.
+func (r *postgresRoleRepository) GetByName(ctx context.Context, name string) (*models.Role, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT r.name, r.description, rp.permission
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		WHERE r.name = $1
+		ORDER BY rp.permission`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, ErrNotFound
+	}
+	return &roles[0], nil
+}
+
+// Create inserts a new role and its permissions in a single transaction.
+func (r *postgresRoleRepository) Create(ctx context.Context, role models.Role) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO roles (name, description) VALUES ($1, $2)", role.Name, role.Description); err != nil {
+		if isUniqueViolation(err) {
+			return ErrRoleExists
+		}
+		return err
+	}
+	for _, permission := range role.Permissions {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO role_permissions (role, permission) VALUES ($1, $2) ON CONFLICT DO NOTHING", role.Name, permission); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Update replaces the description and permissions of an existing role.
+func (r *postgresRoleRepository) Update(ctx context.Context, role models.Role) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE roles SET description = $1 WHERE name = $2", role.Description, role.Name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role = $1", role.Name); err != nil {
+		return err
+	}
+	for _, permission := range role.Permissions {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO role_permissions (role, permission) VALUES ($1, $2) ON CONFLICT DO NOTHING", role.Name, permission); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a role that no user is assigned. Its permissions cascade with it.
+func (r *postgresRoleRepository) Delete(ctx context.Context, name string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the role keeps a concurrent role assignment from slipping in before the delete.
+	var locked string
+	if err := tx.QueryRowContext(ctx, "SELECT name FROM roles WHERE name = $1 FOR UPDATE", name).Scan(&locked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	var users int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE role = $1", name).Scan(&users); err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrRoleInUse
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM roles WHERE name = $1", name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// HasPermission reports whether role grants permission.
+func (r *postgresRoleRepository) HasPermission(ctx context.Context, role, permission string) (bool, error) {
+	var granted bool
+	err := r.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM role_permissions WHERE role = $1 AND permission = $2)", role, permission).Scan(&granted)
+	return granted, err
+}
@@ -0,0 +1,157 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for two-factor authentication operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresTwoFactorRepository is the concrete implementation for PostgreSQL.
+type postgresTwoFactorRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresTwoFactorRepository creates a new repository instance.
+func NewPostgresTwoFactorRepository(db *sql.DB) TwoFactorRepository {
+	return &postgresTwoFactorRepository{DB: db}
+}
+
+// Get returns the user's authenticator with the number of recovery codes they have left.
+// It returns ErrNotFound if the user never enrolled one.
+func (r *postgresTwoFactorRepository) Get(ctx context.Context, userID int64) (*models.TwoFactor, error) {
+	query := `SELECT t.user_id, t.secret, t.created_at, t.enabled_at, t.last_step,
+		(SELECT COUNT(*) FROM recovery_codes c WHERE c.user_id = t.user_id AND c.used_at IS NULL)
+		FROM user_totp t WHERE t.user_id = $1`
+	var tf models.TwoFactor
+	var enabledAt sql.NullTime
+	err := r.DB.QueryRowContext(ctx, query, userID).
+		Scan(&tf.UserID, &tf.Secret, &tf.CreatedAt, &enabledAt, &tf.LastStep, &tf.RecoveryCodesLeft)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if enabledAt.Valid {
+		tf.EnabledAt = &enabledAt.Time
+	}
+	return &tf, nil
+}
+
+// Enroll stores a pending authenticator with the given secret, replacing one the user
+// enrolled before but never confirmed. It returns ErrTwoFactorEnabled if the user's
+// authenticator is already enabled.
+func (r *postgresTwoFactorRepository) Enroll(ctx context.Context, userID int64, secret string) error {
+	result, err := r.DB.ExecContext(ctx, `INSERT INTO user_totp (user_id, secret, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at, last_step = 0
+		WHERE user_totp.enabled_at IS NULL`, userID, secret, time.Now())
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// Enable confirms the user's pending authenticator with the code of step, and replaces
+// their recovery codes with codeHashes, in one transaction. It returns ErrNotFound if the
+// user has no pending authenticator.
+func (r *postgresTwoFactorRepository) Enable(ctx context.Context, userID, step int64, codeHashes []string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE user_totp SET enabled_at = $1, last_step = $2 WHERE user_id = $3 AND enabled_at IS NULL", time.Now(), step, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseStep records that the user's code of step was accepted. It returns ErrTokenUsed if
+// a code of that step or a later one was accepted already, so two requests racing with
+// the same code cannot both succeed.
+func (r *postgresTwoFactorRepository) UseStep(ctx context.Context, userID, step int64) error {
+	result, err := r.DB.ExecContext(ctx, "UPDATE user_totp SET last_step = $1 WHERE user_id = $2 AND last_step < $3", step, userID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the user's recovery code with hash codeHash as used. It returns
+// ErrNotFound if the user has no unused code with that hash.
+func (r *postgresTwoFactorRepository) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	result, err := r.DB.ExecContext(ctx, "UPDATE recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL", time.Now(), userID, codeHash)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Disable removes the user's authenticator and recovery codes. It returns ErrNotFound if
+// the user has no authenticator.
+func (r *postgresTwoFactorRepository) Disable(ctx context.Context, userID int64) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
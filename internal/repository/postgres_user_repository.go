@@ -0,0 +1,193 @@
+// Package repository provides a data abstraction layer.
+// This file contains the PostgreSQL implementation for user data operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// postgresUserRepository is the concrete implementation for PostgreSQL.
+type postgresUserRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresUserRepository creates a new repository instance.
+func NewPostgresUserRepository(db *sql.DB) UserRepository {
+	return &postgresUserRepository{DB: db}
+}
+
+// Create inserts a new user into the database.
+func (r *postgresUserRepository) Create(ctx context.Context, user models.User, passwordHash string) error {
+	if user.Role == "" {
+		user.Role = models.DefaultRole
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3) RETURNING id",
+		user.Username, passwordHash, user.Role).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrUsernameExists
+		}
+		return err
+	}
+
+	if err := recordAuditPostgres(ctx, tx, models.AuditCreate, models.AuditUser, id, nil, userAuditOf(user)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetByUsername finds a user by their username and includes their role and
+// whether they have two-factor authentication enabled.
+func (r *postgresUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	var membershipExpiresAt sql.NullTime
+	query := "SELECT u.id, u.username, u.password_hash, u.role, u.membership_expires_at, t.enabled_at IS NOT NULL FROM users u LEFT JOIN user_totp t ON t.user_id = u.id WHERE u.username = $1"
+	err := r.DB.QueryRowContext(ctx, query, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &membershipExpiresAt, &user.TwoFactorEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if membershipExpiresAt.Valid {
+		user.MembershipExpiresAt = &membershipExpiresAt.Time
+	}
+	return &user, nil
+}
+
+// GetByID finds a user by their ID and includes their role and whether
+// they have two-factor authentication enabled.
+func (r *postgresUserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	var user models.User
+	var membershipExpiresAt sql.NullTime
+	query := "SELECT u.id, u.username, u.password_hash, u.role, u.membership_expires_at, t.enabled_at IS NOT NULL FROM users u LEFT JOIN user_totp t ON t.user_id = u.id WHERE u.id = $1"
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &membershipExpiresAt, &user.TwoFactorEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if membershipExpiresAt.Valid {
+		user.MembershipExpiresAt = &membershipExpiresAt.Time
+	}
+	return &user, nil
+}
+
+// UpdateUserRole updates the role of a specific user. The role must exist.
+func (r *postgresUserRepository) UpdateUserRole(ctx context.Context, username, role string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The share lock keeps the role from being deleted until the assignment commits.
+	var name string
+	if err := tx.QueryRowContext(ctx, "SELECT name FROM roles WHERE name = $1 FOR SHARE", role).Scan(&name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+
+	var userID int64
+	var oldRole string
+	err = tx.QueryRowContext(ctx, "SELECT id, role FROM users WHERE username = $1 FOR UPDATE", username).Scan(&userID, &oldRole)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, userID); err != nil {
+		return err
+	}
+
+	before := &userAudit{Username: username, Role: oldRole}
+	after := &userAudit{Username: username, Role: role}
+	if err := recordAuditPostgres(ctx, tx, models.AuditRoleChange, models.AuditUser, userID, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateMembership sets when the user's membership expires, or makes it never expire if
+// expiresAt is nil.
+func (r *postgresUserRepository) UpdateMembership(ctx context.Context, userID int64, expiresAt *time.Time) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldExpiresAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT membership_expires_at FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&oldExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET membership_expires_at = $1 WHERE id = $2", expiresAt, userID); err != nil {
+		return err
+	}
+
+	before := map[string]interface{}{"membership_expires_at": nil}
+	if oldExpiresAt.Valid {
+		before["membership_expires_at"] = oldExpiresAt.Time
+	}
+	after := map[string]interface{}{"membership_expires_at": expiresAt}
+	if err := recordAuditPostgres(ctx, tx, models.AuditUpdate, models.AuditUser, userID, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdatePassword sets the user's password hash and revokes every refresh token of the
+// user in the same transaction, so no session outlives the old password.
+func (r *postgresUserRepository) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setPasswordPostgres(ctx, tx, userID, passwordHash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setPasswordPostgres sets a user's password hash and revokes their refresh tokens within tx.
+// The audit entry records that the password changed, but neither hash.
+func setPasswordPostgres(ctx context.Context, tx *sql.Tx, userID int64, passwordHash string) error {
+	result, err := tx.ExecContext(ctx, "UPDATE users SET password_hash = $1 WHERE id = $2", passwordHash, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	return recordAuditPostgres(ctx, tx, models.AuditPasswordChange, models.AuditUser, userID, nil, nil)
+}
@@ -0,0 +1,155 @@
+// Package repository contains tests for the repository layer.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// refreshTokenBackends lists the RefreshTokenRepository implementations every test runs against,
+// along with the statements each one issues while rotating a token.
+var refreshTokenBackends = []struct {
+	name          string
+	newRepo       func(*sql.DB) RefreshTokenRepository
+	selectToken   string
+	revokeSession string
+	revokeToken   string
+}{
+	{
+		name:          "sqlite",
+		newRepo:       NewSQLiteRefreshTokenRepository,
+		selectToken:   "SELECT id, user_id, session_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = ?",
+		revokeSession: "UPDATE refresh_tokens SET revoked_at = ? WHERE session_id = ? AND revoked_at IS NULL",
+		revokeToken:   "UPDATE refresh_tokens SET revoked_at = ? WHERE id = ?",
+	},
+	{
+		name:          "postgres",
+		newRepo:       NewPostgresRefreshTokenRepository,
+		selectToken:   "SELECT id, user_id, session_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE",
+		revokeSession: "UPDATE refresh_tokens SET revoked_at = $1 WHERE session_id = $2 AND revoked_at IS NULL",
+		revokeToken:   "UPDATE refresh_tokens SET revoked_at = $1 WHERE id = $2",
+	},
+}
+
+// TestRotateRefreshToken_Success tests that rotating a token revokes it and issues a new one in the same session.
+func TestRotateRefreshToken_Success(t *testing.T) {
+	expectations := map[string]func(sqlmock.Sqlmock, time.Time){
+		"sqlite": func(mock sqlmock.Sqlmock, expiresAt time.Time) {
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens (user_id, session_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)")).
+				WithArgs(3, "session", "next-hash", sqlmock.AnyArg(), expiresAt).
+				WillReturnResult(sqlmock.NewResult(2, 1))
+		},
+		"postgres": func(mock sqlmock.Sqlmock, expiresAt time.Time) {
+			mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO refresh_tokens (user_id, session_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+				WithArgs(3, "session", "next-hash", sqlmock.AnyArg(), expiresAt).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		},
+	}
+
+	for _, backend := range refreshTokenBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			expiresAt := time.Now().AddDate(0, 0, 30)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectToken)).
+				WithArgs("hash").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "session_id", "expires_at", "revoked_at"}).
+					AddRow(1, 3, "session", time.Now().Add(time.Hour), nil))
+			mock.ExpectExec(regexp.QuoteMeta(backend.revokeToken)).
+				WithArgs(sqlmock.AnyArg(), 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectations[backend.name](mock, expiresAt)
+			mock.ExpectCommit()
+
+			next, err := repo.Rotate(context.Background(), "hash", "next-hash", expiresAt)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if next.ID != 2 || next.UserID != 3 || next.SessionID != "session" {
+				t.Errorf("expected token 2 of user 3 in the same session, but got %+v", next)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestRotateRefreshToken_Reused tests that presenting an already rotated token revokes its whole session.
+func TestRotateRefreshToken_Reused(t *testing.T) {
+	for _, backend := range refreshTokenBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectToken)).
+				WithArgs("hash").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "session_id", "expires_at", "revoked_at"}).
+					AddRow(1, 3, "session", time.Now().Add(time.Hour), time.Now().Add(-time.Minute)))
+			mock.ExpectExec(regexp.QuoteMeta(backend.revokeSession)).
+				WithArgs(sqlmock.AnyArg(), "session").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			_, err = repo.Rotate(context.Background(), "hash", "next-hash", time.Now().AddDate(0, 0, 30))
+
+			if !errors.Is(err, ErrTokenRevoked) {
+				t.Errorf("expected error to be ErrTokenRevoked, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestRotateRefreshToken_Expired tests that an expired token cannot be rotated.
+func TestRotateRefreshToken_Expired(t *testing.T) {
+	for _, backend := range refreshTokenBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectToken)).
+				WithArgs("hash").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "session_id", "expires_at", "revoked_at"}).
+					AddRow(1, 3, "session", time.Now().Add(-time.Hour), nil))
+			mock.ExpectRollback()
+
+			_, err = repo.Rotate(context.Background(), "hash", "next-hash", time.Now().AddDate(0, 0, 30))
+
+			if !errors.Is(err, ErrTokenExpired) {
+				t.Errorf("expected error to be ErrTokenExpired, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -2,10 +2,105 @@
 // This file contains shared error variables and types for the repository layer.
 package repository
 
-import "errors"
+import (
+	"errors"
+	"strings"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+)
 
 // Shared error variables for the repository layer.
 var (
-	ErrNotFound       = errors.New("resource not found")
-	ErrUsernameExists = errors.New("username already exists")
+	ErrNotFound         = errors.New("resource not found")
+	ErrUsernameExists   = errors.New("username already exists")
+	ErrBarcodeExists    = errors.New("barcode already exists")
+	ErrCopyOnLoan       = errors.New("copy is on loan")
+	ErrCopyOnHold       = errors.New("copy is set aside for a hold")
+	ErrCopyHasLoans     = errors.New("copy has loan history")
+	ErrBookHasLoans     = errors.New("book has loan history")
+	ErrCopyNotLendable  = errors.New("copy is not available for loan")
+	ErrRenewalLimit     = errors.New("renewal limit reached")
+	ErrLoanOverdue      = errors.New("loan is overdue")
+	ErrHoldsWaiting     = errors.New("patrons are waiting for this book")
+	ErrExceedsBalance   = errors.New("amount exceeds the account balance")
+	ErrLoanNotOwned     = errors.New("loan belongs to another patron")
+	ErrHoldExists       = errors.New("patron already holds this book")
+	ErrCopyAvailable    = errors.New("book has an available copy")
+	ErrHoldNotActive    = errors.New("hold is no longer active")
+	ErrTokenRevoked     = errors.New("token has been revoked")
+	ErrTokenExpired     = errors.New("token has expired")
+	ErrTokenUsed        = errors.New("token has already been used")
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	ErrRoleExists       = errors.New("role already exists")
+	ErrRoleNotFound     = errors.New("role does not exist")
+	ErrRoleInUse        = errors.New("role is assigned to users")
+	ErrAuthorHasBooks   = errors.New("author has books")
+	ErrStockExhausted   = errors.New("no stock available")
+	ErrAlreadyReturned  = errors.New("book already returned")
+	ErrConflict         = errors.New("conflicts with an existing resource")
+	ErrForeignKey       = errors.New("references a missing resource or is still referenced")
 )
+
+// ConstraintError reports a write the database refused because it broke a constraint. It
+// wraps ErrConflict for a unique violation and ErrForeignKey for a foreign key violation.
+type ConstraintError struct {
+	// Constraint names the broken constraint, or the columns it covers, when known.
+	Constraint string
+	Err        error
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Constraint == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + " (" + e.Constraint + ")"
+}
+
+func (e *ConstraintError) Unwrap() error { return e.Err }
+
+// constraintError translates a unique or foreign key violation reported by either database
+// into a *ConstraintError, returning any other error unchanged.
+func constraintError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return &ConstraintError{Constraint: pgErr.ConstraintName, Err: ErrConflict}
+		case pgForeignKeyViolation:
+			return &ConstraintError{Constraint: pgErr.ConstraintName, Err: ErrForeignKey}
+		}
+		return err
+	}
+	// SQLite reports "UNIQUE constraint failed: books.isbn" and "FOREIGN KEY constraint failed".
+	if _, columns, ok := strings.Cut(err.Error(), "UNIQUE constraint failed"); ok {
+		return &ConstraintError{Constraint: strings.TrimPrefix(columns, ": "), Err: ErrConflict}
+	}
+	if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+		return &ConstraintError{Err: ErrForeignKey}
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a unique violation reported by either
+// database, as translated by constraintError.
+func isUniqueViolation(err error) bool {
+	var constraintErr *ConstraintError
+	return errors.As(constraintError(err), &constraintErr) && errors.Is(constraintErr, ErrConflict)
+}
+
+// PolicyError reports the circulation rules a loan would break.
+type PolicyError struct {
+	Violations []models.PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "loan refused: " + strings.Join(messages, "; ")
+}
@@ -0,0 +1,190 @@
+// Package repository provides a data abstraction layer.
+// This file contains the implementation for role and permission operations.
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Lec7ral/fullAPI/internal/models"
+)
+
+// RoleRepository defines the interface for role operations.
+type RoleRepository interface {
+	GetAll(ctx context.Context) ([]models.Role, error)
+	GetByName(ctx context.Context, name string) (*models.Role, error)
+	Create(ctx context.Context, role models.Role) error
+	Update(ctx context.Context, role models.Role) error
+	Delete(ctx context.Context, name string) error
+	HasPermission(ctx context.Context, role, permission string) (bool, error)
+}
+
+// sqliteRoleRepository is the concrete implementation for SQLite.
+type sqliteRoleRepository struct {
+	DB *sql.DB
+}
+
+// NewSQLiteRoleRepository creates a new repository instance.
+func NewSQLiteRoleRepository(db *sql.DB) RoleRepository {
+	return &sqliteRoleRepository{DB: db}
+}
+
+// GetAll returns every role with its permissions, ordered by name.
+func (r *sqliteRoleRepository) GetAll(ctx context.Context) ([]models.Role, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT r.name, r.description, rp.permission
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		ORDER BY r.name, rp.permission`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRoles(rows)
+}
+
+// GetByName returns a single role with its permissions.
+func (r *sqliteRoleRepository) GetByName(ctx context.Context, name string) (*models.Role, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT r.name, r.description, rp.permission
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		WHERE r.name = ?
+		ORDER BY rp.permission`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, ErrNotFound
+	}
+	return &roles[0], nil
+}
+
+// Create inserts a new role and its permissions in a single transaction.
+func (r *sqliteRoleRepository) Create(ctx context.Context, role models.Role) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO roles (name, description) VALUES (?, ?)", role.Name, role.Description); err != nil {
+		if isUniqueViolation(err) {
+			return ErrRoleExists
+		}
+		return err
+	}
+	for _, permission := range role.Permissions {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO role_permissions (role, permission) VALUES (?, ?)", role.Name, permission); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Update replaces the description and permissions of an existing role.
+func (r *sqliteRoleRepository) Update(ctx context.Context, role models.Role) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE roles SET description = ? WHERE name = ?", role.Description, role.Name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role = ?", role.Name); err != nil {
+		return err
+	}
+	for _, permission := range role.Permissions {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO role_permissions (role, permission) VALUES (?, ?)", role.Name, permission); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a role that no user is assigned. Its permissions are removed with it.
+func (r *sqliteRoleRepository) Delete(ctx context.Context, name string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var users int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE role = ?", name).Scan(&users); err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrRoleInUse
+	}
+
+	// Foreign keys are not enforced in SQLite, so the permissions and loan policies are
+	// removed explicitly.
+	if _, err := tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role = ?", name); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM loan_policies WHERE role = ?", name); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM roles WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+// HasPermission reports whether role grants permission.
+func (r *sqliteRoleRepository) HasPermission(ctx context.Context, role, permission string) (bool, error) {
+	var granted bool
+	err := r.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM role_permissions WHERE role = ? AND permission = ?)", role, permission).Scan(&granted)
+	return granted, err
+}
+
+// scanRoles folds rows of (name, description, permission) ordered by name into roles.
+// Roles without permissions come back with a NULL permission from the LEFT JOIN.
+func scanRoles(rows *sql.Rows) ([]models.Role, error) {
+	roles := []models.Role{}
+	for rows.Next() {
+		var name, description string
+		var permission sql.NullString
+		if err := rows.Scan(&name, &description, &permission); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, models.Role{Name: name, Description: description, Permissions: []string{}})
+		}
+		if permission.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+	return roles, rows.Err()
+}
@@ -0,0 +1,212 @@
+// Package repository contains tests for the repository layer.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// roleBackends lists the RoleRepository implementations every test runs against,
+// along with the statements and duplicate-key error each one deals with.
+var roleBackends = []struct {
+	name            string
+	newRepo         func(*sql.DB) RoleRepository
+	insertRole      string
+	insertPerm      string
+	countUsers      string
+	hasPermission   string
+	duplicateErr    error
+	expectRoleCheck func(sqlmock.Sqlmock)
+}{
+	{
+		name:            "sqlite",
+		newRepo:         NewSQLiteRoleRepository,
+		insertRole:      "INSERT INTO roles (name, description) VALUES (?, ?)",
+		insertPerm:      "INSERT OR IGNORE INTO role_permissions (role, permission) VALUES (?, ?)",
+		countUsers:      "SELECT COUNT(*) FROM users WHERE role = ?",
+		hasPermission:   "SELECT EXISTS (SELECT 1 FROM role_permissions WHERE role = ? AND permission = ?)",
+		duplicateErr:    errors.New("UNIQUE constraint failed: roles.name"),
+		expectRoleCheck: func(sqlmock.Sqlmock) {},
+	},
+	{
+		name:          "postgres",
+		newRepo:       NewPostgresRoleRepository,
+		insertRole:    "INSERT INTO roles (name, description) VALUES ($1, $2)",
+		insertPerm:    "INSERT INTO role_permissions (role, permission) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		countUsers:    "SELECT COUNT(*) FROM users WHERE role = $1",
+		hasPermission: "SELECT EXISTS (SELECT 1 FROM role_permissions WHERE role = $1 AND permission = $2)",
+		duplicateErr:  &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"},
+		expectRoleCheck: func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT name FROM roles WHERE name = $1 FOR UPDATE")).
+				WithArgs("clerk").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("clerk"))
+		},
+	},
+}
+
+// TestCreateRole_Success tests that a role is created with each of its permissions.
+func TestCreateRole_Success(t *testing.T) {
+	for _, backend := range roleBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			role := models.Role{Name: "clerk", Description: "Front desk", Permissions: []string{models.PermLoansReadAll, models.PermHoldsManage}}
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(backend.insertRole)).
+				WithArgs("clerk", "Front desk").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			for _, permission := range role.Permissions {
+				mock.ExpectExec(regexp.QuoteMeta(backend.insertPerm)).
+					WithArgs("clerk", permission).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+
+			if err := repo.Create(context.Background(), role); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestCreateRole_Exists tests that creating a role with a taken name returns ErrRoleExists.
+func TestCreateRole_Exists(t *testing.T) {
+	for _, backend := range roleBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(backend.insertRole)).
+				WithArgs("librarian", "").
+				WillReturnError(backend.duplicateErr)
+			mock.ExpectRollback()
+
+			err = repo.Create(context.Background(), models.Role{Name: "librarian"})
+
+			if !errors.Is(err, ErrRoleExists) {
+				t.Errorf("expected error to be ErrRoleExists, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestDeleteRole_InUse tests that a role assigned to users cannot be deleted.
+func TestDeleteRole_InUse(t *testing.T) {
+	for _, backend := range roleBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			backend.expectRoleCheck(mock)
+			mock.ExpectQuery(regexp.QuoteMeta(backend.countUsers)).
+				WithArgs("clerk").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			mock.ExpectRollback()
+
+			err = repo.Delete(context.Background(), "clerk")
+
+			if !errors.Is(err, ErrRoleInUse) {
+				t.Errorf("expected error to be ErrRoleInUse, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestHasPermission tests looking up whether a role grants a permission.
+func TestHasPermission(t *testing.T) {
+	for _, backend := range roleBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectQuery(regexp.QuoteMeta(backend.hasPermission)).
+				WithArgs("librarian", models.PermBooksWrite).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+			granted, err := repo.HasPermission(context.Background(), "librarian", models.PermBooksWrite)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !granted {
+				t.Errorf("expected librarian to be granted %s", models.PermBooksWrite)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestGetAllRoles tests that permission rows are folded into their roles, and that roles
+// without permissions get an empty list.
+func TestGetAllRoles(t *testing.T) {
+	for _, backend := range roleBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT r.name, r.description, rp.permission")).
+				WillReturnRows(sqlmock.NewRows([]string{"name", "description", "permission"}).
+					AddRow("librarian", "Runs the library", models.PermBooksWrite).
+					AddRow("librarian", "Runs the library", models.PermLoansReadAll).
+					AddRow("member", "Borrows books", nil))
+
+			roles, err := repo.GetAll(context.Background())
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(roles) != 2 || len(roles[0].Permissions) != 2 || roles[1].Name != "member" || roles[1].Permissions == nil || len(roles[1].Permissions) != 0 {
+				t.Errorf("expected librarian with 2 permissions and member with none, but got %+v", roles)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// Package repository provides a data abstraction layer.
+// This file contains the helpers that trace repository operations and SQL statements.
+package repository
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the repository layer. It follows the global tracer
+// provider, so spans are dropped until tracing is set up.
+var tracer = otel.Tracer("github.com/Lec7ral/fullAPI/internal/repository")
+
+// startSpan starts a span for a repository operation, such as a whole transaction.
+// Pass the span to endSpan when the operation returns.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan marks span as failed if err is set, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceQuery runs fn, which issues query to db and reads its results, in a child span
+// named name. The span records the statement text, so slow statements can be told apart.
+func traceQuery(ctx context.Context, name string, db attribute.KeyValue, query string, fn func(context.Context) error) error {
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(db, semconv.DBQueryText(query)))
+	err := fn(ctx)
+	endSpan(span, err)
+	return err
+}
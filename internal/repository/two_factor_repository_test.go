@@ -0,0 +1,168 @@
+// Package repository contains tests for the repository layer.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// twoFactorBackends lists the TwoFactorRepository implementations every test runs against,
+// along with the statements each one issues.
+var twoFactorBackends = []struct {
+	name        string
+	newRepo     func(*sql.DB) TwoFactorRepository
+	enroll      string
+	enable      string
+	deleteCodes string
+	insertCode  string
+	useStep     string
+}{
+	{
+		name:        "sqlite",
+		newRepo:     NewSQLiteTwoFactorRepository,
+		enroll:      "INSERT INTO user_totp (user_id, secret, created_at) VALUES (?, ?, ?)",
+		enable:      "UPDATE user_totp SET enabled_at = ?, last_step = ? WHERE user_id = ? AND enabled_at IS NULL",
+		deleteCodes: "DELETE FROM recovery_codes WHERE user_id = ?",
+		insertCode:  "INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)",
+		useStep:     "UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?",
+	},
+	{
+		name:        "postgres",
+		newRepo:     NewPostgresTwoFactorRepository,
+		enroll:      "INSERT INTO user_totp (user_id, secret, created_at) VALUES ($1, $2, $3)",
+		enable:      "UPDATE user_totp SET enabled_at = $1, last_step = $2 WHERE user_id = $3 AND enabled_at IS NULL",
+		deleteCodes: "DELETE FROM recovery_codes WHERE user_id = $1",
+		insertCode:  "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+		useStep:     "UPDATE user_totp SET last_step = $1 WHERE user_id = $2 AND last_step < $3",
+	},
+}
+
+// TestEnrollTwoFactor_AlreadyEnabled tests that enrolling again does not replace the
+// secret of an enabled authenticator.
+func TestEnrollTwoFactor_AlreadyEnabled(t *testing.T) {
+	for _, backend := range twoFactorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectExec(regexp.QuoteMeta(backend.enroll)).
+				WithArgs(3, "SECRET", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			err = repo.Enroll(context.Background(), 3, "SECRET")
+
+			if !errors.Is(err, ErrTwoFactorEnabled) {
+				t.Errorf("expected error to be ErrTwoFactorEnabled, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestEnableTwoFactor_Success tests that confirming an authenticator replaces the user's
+// recovery codes in the same transaction.
+func TestEnableTwoFactor_Success(t *testing.T) {
+	for _, backend := range twoFactorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(backend.enable)).
+				WithArgs(sqlmock.AnyArg(), 1000, 3).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(backend.deleteCodes)).
+				WithArgs(3).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			for _, hash := range []string{"hash-a", "hash-b"} {
+				mock.ExpectExec(regexp.QuoteMeta(backend.insertCode)).
+					WithArgs(3, hash).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+			mock.ExpectCommit()
+
+			if err := repo.Enable(context.Background(), 3, 1000, []string{"hash-a", "hash-b"}); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestEnableTwoFactor_NotPending tests that an authenticator that was never enrolled, or
+// was confirmed already, cannot be confirmed.
+func TestEnableTwoFactor_NotPending(t *testing.T) {
+	for _, backend := range twoFactorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(backend.enable)).
+				WithArgs(sqlmock.AnyArg(), 1000, 3).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			err = repo.Enable(context.Background(), 3, 1000, []string{"hash-a"})
+
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected error to be ErrNotFound, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestUseStep_Replayed tests that a code whose step was accepted already is refused.
+func TestUseStep_Replayed(t *testing.T) {
+	for _, backend := range twoFactorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectExec(regexp.QuoteMeta(backend.useStep)).
+				WithArgs(1000, 3, 1000).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			err = repo.UseStep(context.Background(), 3, 1000)
+
+			if !errors.Is(err, ErrTokenUsed) {
+				t.Errorf("expected error to be ErrTokenUsed, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
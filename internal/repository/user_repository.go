@@ -3,18 +3,22 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"strings"
+	"time"
 
 	"github.com/Lec7ral/fullAPI/internal/models"
 )
 
 // UserRepository defines the interface for user data operations.
 type UserRepository interface {
-	Create(user models.User, passwordHash string) error
-	GetByUsername(username string) (*models.User, error)
-	UpdateUserRole(username, role string) error // New method
+	Create(ctx context.Context, user models.User, passwordHash string) error
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	GetByID(ctx context.Context, id int64) (*models.User, error)
+	UpdateUserRole(ctx context.Context, username, role string) error
+	UpdatePassword(ctx context.Context, userID int64, passwordHash string) error
+	UpdateMembership(ctx context.Context, userID int64, expiresAt *time.Time) error
 }
 
 // sqliteUserRepository is the concrete implementation for SQLite.
@@ -28,55 +32,186 @@ func NewSQLiteUserRepository(db *sql.DB) UserRepository {
 }
 
 // Create inserts a new user into the database.
-func (r *sqliteUserRepository) Create(user models.User, passwordHash string) error {
+func (r *sqliteUserRepository) Create(ctx context.Context, user models.User, passwordHash string) error {
 	if user.Role == "" {
-		user.Role = "member"
+		user.Role = models.DefaultRole
 	}
-	stmt, err := r.DB.Prepare("INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)")
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(user.Username, passwordHash, user.Role)
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)",
+		user.Username, passwordHash, user.Role)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		if isUniqueViolation(err) {
 			return ErrUsernameExists
 		}
 		return err
 	}
-	return nil
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if err := recordAuditSQLite(ctx, tx, models.AuditCreate, models.AuditUser, id, nil, userAuditOf(user)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetByUsername finds a user by their username and includes their role and
+// whether they have two-factor authentication enabled.
+func (r *sqliteUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	var membershipExpiresAt sql.NullTime
+	query := "SELECT u.id, u.username, u.password_hash, u.role, u.membership_expires_at, t.enabled_at IS NOT NULL FROM users u LEFT JOIN user_totp t ON t.user_id = u.id WHERE u.username = ?"
+	err := r.DB.QueryRowContext(ctx, query, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &membershipExpiresAt, &user.TwoFactorEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if membershipExpiresAt.Valid {
+		user.MembershipExpiresAt = &membershipExpiresAt.Time
+	}
+	return &user, nil
 }
 
-// GetByUsername finds a user by their username and includes their role.
-func (r *sqliteUserRepository) GetByUsername(username string) (*models.User, error) {
+// GetByID finds a user by their ID and includes their role and whether
+// they have two-factor authentication enabled.
+func (r *sqliteUserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
 	var user models.User
-	query := "SELECT id, username, password_hash, role FROM users WHERE username = ?"
-	err := r.DB.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role)
+	var membershipExpiresAt sql.NullTime
+	query := "SELECT u.id, u.username, u.password_hash, u.role, u.membership_expires_at, t.enabled_at IS NOT NULL FROM users u LEFT JOIN user_totp t ON t.user_id = u.id WHERE u.id = ?"
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &membershipExpiresAt, &user.TwoFactorEnabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
+	if membershipExpiresAt.Valid {
+		user.MembershipExpiresAt = &membershipExpiresAt.Time
+	}
 	return &user, nil
 }
 
-// UpdateUserRole updates the role of a specific user.
-func (r *sqliteUserRepository) UpdateUserRole(username, role string) error {
-	stmt, err := r.DB.Prepare("UPDATE users SET role = ? WHERE username = ?")
+// UpdateUserRole updates the role of a specific user. The role must exist.
+func (r *sqliteUserRepository) UpdateUserRole(ctx context.Context, username, role string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE name = ?)", role).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRoleNotFound
+	}
+
+	var userID int64
+	var oldRole string
+	err = tx.QueryRowContext(ctx, "SELECT id, role FROM users WHERE username = ?", username).Scan(&userID, &oldRole)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound // Reuse our "not found" error.
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, userID); err != nil {
+		return err
+	}
+
+	before := &userAudit{Username: username, Role: oldRole}
+	after := &userAudit{Username: username, Role: role}
+	if err := recordAuditSQLite(ctx, tx, models.AuditRoleChange, models.AuditUser, userID, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateMembership sets when the user's membership expires, or makes it never expire if
+// expiresAt is nil.
+func (r *sqliteUserRepository) UpdateMembership(ctx context.Context, userID int64, expiresAt *time.Time) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldExpiresAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT membership_expires_at FROM users WHERE id = ?", userID).Scan(&oldExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET membership_expires_at = ? WHERE id = ?", expiresAt, userID); err != nil {
+		return err
+	}
+
+	before := map[string]interface{}{"membership_expires_at": nil}
+	if oldExpiresAt.Valid {
+		before["membership_expires_at"] = oldExpiresAt.Time
+	}
+	after := map[string]interface{}{"membership_expires_at": expiresAt}
+	if err := recordAuditSQLite(ctx, tx, models.AuditUpdate, models.AuditUser, userID, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdatePassword sets the user's password hash and revokes every refresh token of the
+// user in the same transaction, so no session outlives the old password.
+func (r *sqliteUserRepository) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	result, err := stmt.Exec(role, username)
+	defer tx.Rollback()
+
+	if err := setPasswordSQLite(ctx, tx, userID, passwordHash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setPasswordSQLite sets a user's password hash and revokes their refresh tokens within tx.
+// The audit entry records that the password changed, but neither hash.
+func setPasswordSQLite(ctx context.Context, tx *sql.Tx, userID int64, passwordHash string) error {
+	result, err := tx.ExecContext(ctx, "UPDATE users SET password_hash = ? WHERE id = ?", passwordHash, userID)
 	if err != nil {
 		return err
 	}
-	// Check if any row was affected. If not, the user was not found.
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 	if rowsAffected == 0 {
-		return ErrNotFound // Reuse our "not found" error.
+		return ErrNotFound
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now(), userID)
+	if err != nil {
+		return err
 	}
-	return nil
+	return recordAuditSQLite(ctx, tx, models.AuditPasswordChange, models.AuditUser, userID, nil, nil)
+}
+
+// userAudit is the state of a user recorded in the audit log. The password hash is never
+// recorded.
+type userAudit struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// userAuditOf returns the audited state of user.
+func userAuditOf(user models.User) *userAudit {
+	return &userAudit{Username: user.Username, Role: user.Role}
 }
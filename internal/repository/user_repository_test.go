@@ -2,121 +2,315 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/Lec7ral/fullAPI/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-// TestCreateUser_Success tests the successful creation of a user.
-func TestCreateUser_Success(t *testing.T) {
-	db, mock, err := sqlmock.New()
+// userBackends lists the UserRepository implementations every test runs against,
+// along with the SQL and duplicate-key error each one deals with.
+var userBackends = []struct {
+	name         string
+	newRepo      func(*sql.DB) UserRepository
+	insertQuery  string
+	selectQuery  string
+	selectByID   string
+	duplicateErr error
+}{
+	{
+		"sqlite", NewSQLiteUserRepository,
+		"INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)",
+		"SELECT u.id, u.username, u.password_hash, u.role, u.membership_expires_at, t.enabled_at IS NOT NULL FROM users u LEFT JOIN user_totp t ON t.user_id = u.id WHERE u.username = ?",
+		"SELECT u.id, u.username, u.password_hash, u.role, u.membership_expires_at, t.enabled_at IS NOT NULL FROM users u LEFT JOIN user_totp t ON t.user_id = u.id WHERE u.id = ?",
+		errors.New("UNIQUE constraint failed: users.username"),
+	},
+	{
+		"postgres", NewPostgresUserRepository,
+		"INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3) RETURNING id",
+		"SELECT u.id, u.username, u.password_hash, u.role, u.membership_expires_at, t.enabled_at IS NOT NULL FROM users u LEFT JOIN user_totp t ON t.user_id = u.id WHERE u.username = $1",
+		"SELECT u.id, u.username, u.password_hash, u.role, u.membership_expires_at, t.enabled_at IS NOT NULL FROM users u LEFT JOIN user_totp t ON t.user_id = u.id WHERE u.id = $1",
+		&pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"},
+	},
+}
+
+// expectUserInsert registers the INSERT expectation the way each backend issues it. The
+// new user gets the ID 1, unless the insert fails with err.
+func expectUserInsert(mock sqlmock.Sqlmock, backend, query string, user models.User, passwordHash string, err error) {
+	if backend == "sqlite" {
+		exec := mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(user.Username, passwordHash, user.Role)
+		if err != nil {
+			exec.WillReturnError(err)
+		} else {
+			exec.WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+		return
+	}
+	insert := mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(user.Username, passwordHash, user.Role)
 	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		insert.WillReturnError(err)
+	} else {
+		insert.WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	}
-	defer db.Close()
+}
 
-	repo := NewSQLiteUserRepository(db)
-	user := models.User{Username: "testuser", Role: "member"}
-	passwordHash := "hashed_password"
+// TestCreateUser_Success tests the successful creation of a user.
+func TestCreateUser_Success(t *testing.T) {
+	for _, backend := range userBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
 
-	// Expect the INSERT statement that now includes the 'role' column.
-	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)")).
-		ExpectExec().
-		WithArgs(user.Username, passwordHash, user.Role).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+			repo := backend.newRepo(db)
+			user := models.User{Username: "testuser", Role: "member"}
+			passwordHash := "hashed_password"
 
-	err = repo.Create(user, passwordHash)
+			// Expect the INSERT statement that now includes the 'role' column.
+			mock.ExpectBegin()
+			expectUserInsert(mock, backend.name, backend.insertQuery, user, passwordHash, nil)
+			expectAudit(mock, backend.name, models.AuditCreate, models.AuditUser, 1,
+				`{"role":{"from":null,"to":"member"},"username":{"from":null,"to":"testuser"}}`)
+			mock.ExpectCommit()
 
-	if err != nil {
-		t.Errorf("unexpected error: %s", err)
-	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+			err = repo.Create(context.Background(), user, passwordHash)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
 
 // TestCreateUser_Duplicate tests the case where the username already exists.
 func TestCreateUser_Duplicate(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
+	for _, backend := range userBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
 
-	repo := NewSQLiteUserRepository(db)
-	user := models.User{Username: "testuser", Role: "member"}
+			repo := backend.newRepo(db)
+			user := models.User{Username: "testuser", Role: "member"}
 
-	// Expect the INSERT statement that now includes the 'role' column.
-	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)")).
-		ExpectExec().
-		WithArgs(user.Username, "any_hash", user.Role).
-		WillReturnError(errors.New("UNIQUE constraint failed: users.username"))
+			// Expect the INSERT statement that now includes the 'role' column.
+			mock.ExpectBegin()
+			expectUserInsert(mock, backend.name, backend.insertQuery, user, "any_hash", backend.duplicateErr)
+			mock.ExpectRollback()
 
-	err = repo.Create(user, "any_hash")
+			err = repo.Create(context.Background(), user, "any_hash")
 
-	if !errors.Is(err, ErrUsernameExists) {
-		t.Errorf("expected error to be ErrUsernameExists, but got %v", err)
-	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+			if !errors.Is(err, ErrUsernameExists) {
+				t.Errorf("expected error to be ErrUsernameExists, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
 
 // TestGetUserByUsername_Success tests the successful retrieval of a user.
 func TestGetUserByUsername_Success(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
+	for _, backend := range userBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
 
-	repo := NewSQLiteUserRepository(db)
-	expectedUser := &models.User{ID: 1, Username: "testuser", PasswordHash: "hashed_password", Role: "member"}
+			repo := backend.newRepo(db)
+			expectedUser := &models.User{ID: 1, Username: "testuser", PasswordHash: "hashed_password", Role: "member"}
 
-	rows := sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).
-		AddRow(expectedUser.ID, expectedUser.Username, expectedUser.PasswordHash, expectedUser.Role)
+			rows := sqlmock.NewRows([]string{"id", "username", "password_hash", "role", "membership_expires_at", "two_factor_enabled"}).
+				AddRow(expectedUser.ID, expectedUser.Username, expectedUser.PasswordHash, expectedUser.Role, nil, expectedUser.TwoFactorEnabled)
 
-	query := regexp.QuoteMeta("SELECT id, username, password_hash, role FROM users WHERE username = ?")
-	mock.ExpectQuery(query).WithArgs("testuser").WillReturnRows(rows)
+			query := regexp.QuoteMeta(backend.selectQuery)
+			mock.ExpectQuery(query).WithArgs("testuser").WillReturnRows(rows)
 
-	user, err := repo.GetByUsername("testuser")
+			user, err := repo.GetByUsername(context.Background(), "testuser")
 
-	if err != nil {
-		t.Errorf("unexpected error: %s", err)
-	}
-	if user.Username != expectedUser.Username {
-		t.Errorf("expected username '%s', but got '%s'", expectedUser.Username, user.Username)
-	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if user.Username != expectedUser.Username {
+				t.Errorf("expected username '%s', but got '%s'", expectedUser.Username, user.Username)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
 
 // TestGetUserByUsername_NotFound tests the case where a user is not found.
 func TestGetUserByUsername_NotFound(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	for _, backend := range userBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			query := regexp.QuoteMeta(backend.selectQuery)
+			mock.ExpectQuery(query).WithArgs("nonexistent").WillReturnError(sql.ErrNoRows)
+
+			user, err := repo.GetByUsername(context.Background(), "nonexistent")
+
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected error to be ErrNotFound, but got %v", err)
+			}
+			if user != nil {
+				t.Errorf("expected a nil user, but got one")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestGetUserByID_Success tests the successful retrieval of a user by their ID.
+func TestGetUserByID_Success(t *testing.T) {
+	for _, backend := range userBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+			expiresAt := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			rows := sqlmock.NewRows([]string{"id", "username", "password_hash", "role", "membership_expires_at", "two_factor_enabled"}).
+				AddRow(7, "testuser", "hashed_password", "librarian", expiresAt, true)
+			mock.ExpectQuery(regexp.QuoteMeta(backend.selectByID)).WithArgs(7).WillReturnRows(rows)
+
+			user, err := repo.GetByID(context.Background(), 7)
+
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if user == nil || user.Username != "testuser" || user.Role != "librarian" || !user.TwoFactorEnabled {
+				t.Errorf("expected librarian 'testuser' with two-factor authentication, but got %+v", user)
+			}
+			if user != nil && (user.MembershipExpiresAt == nil || !user.MembershipExpiresAt.Equal(expiresAt)) {
+				t.Errorf("expected the membership to expire at %v, but got %v", expiresAt, user.MembershipExpiresAt)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// TestUpdateUserRole_RoleNotFound tests that a user cannot be assigned a role that does not exist.
+func TestUpdateUserRole_RoleNotFound(t *testing.T) {
+	roleChecks := map[string]func(sqlmock.Sqlmock){
+		"sqlite": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM roles WHERE name = ?)")).
+				WithArgs("wizard").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		},
+		"postgres": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT name FROM roles WHERE name = $1 FOR SHARE")).
+				WithArgs("wizard").
+				WillReturnError(sql.ErrNoRows)
+		},
 	}
-	defer db.Close()
 
-	repo := NewSQLiteUserRepository(db)
-	query := regexp.QuoteMeta("SELECT id, username, password_hash, role FROM users WHERE username = ?")
-	mock.ExpectQuery(query).WithArgs("nonexistent").WillReturnError(sql.ErrNoRows)
+	for _, backend := range userBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
 
-	user, err := repo.GetByUsername("nonexistent")
+			mock.ExpectBegin()
+			roleChecks[backend.name](mock)
+			mock.ExpectRollback()
 
-	if !errors.Is(err, ErrNotFound) {
-		t.Errorf("expected error to be ErrNotFound, but got %v", err)
+			err = repo.UpdateUserRole(context.Background(), "testuser", "wizard")
+
+			if !errors.Is(err, ErrRoleNotFound) {
+				t.Errorf("expected error to be ErrRoleNotFound, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-	if user != nil {
-		t.Errorf("expected a nil user, but got one")
+}
+
+// TestUpdateUserRole_Success tests that a role change is recorded in the audit log with the
+// user's old and new role.
+func TestUpdateUserRole_Success(t *testing.T) {
+	roleChanges := map[string]func(sqlmock.Sqlmock){
+		"sqlite": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM roles WHERE name = ?)")).
+				WithArgs("librarian").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, role FROM users WHERE username = ?")).
+				WithArgs("testuser").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "role"}).AddRow(4, "member"))
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET role = ? WHERE id = ?")).
+				WithArgs("librarian", 4).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		},
+		"postgres": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT name FROM roles WHERE name = $1 FOR SHARE")).
+				WithArgs("librarian").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("librarian"))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, role FROM users WHERE username = $1 FOR UPDATE")).
+				WithArgs("testuser").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "role"}).AddRow(4, "member"))
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET role = $1 WHERE id = $2")).
+				WithArgs("librarian", 4).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		},
 	}
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+
+	for _, backend := range userBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			repo := backend.newRepo(db)
+
+			mock.ExpectBegin()
+			roleChanges[backend.name](mock)
+			expectAudit(mock, backend.name, models.AuditRoleChange, models.AuditUser, 4, `{"role":{"from":"member","to":"librarian"}}`)
+			mock.ExpectCommit()
+
+			if err := repo.UpdateUserRole(context.Background(), "testuser", "librarian"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
 }
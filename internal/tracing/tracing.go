@@ -0,0 +1,68 @@
+// Package tracing sets up OpenTelemetry tracing: the exporter spans are sent to, and the
+// W3C trace context propagation that joins them to the traces of calling services.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Supported exporters.
+const (
+	ExporterNone   = "none"   // Spans are not recorded
+	ExporterStdout = "stdout" // Spans are printed to stdout, for local debugging
+	ExporterOTLP   = "otlp"   // Spans are sent to an OpenTelemetry collector over OTLP/HTTP
+)
+
+// Options configures tracing.
+type Options struct {
+	Exporter     string
+	OTLPEndpoint string // Collector URL for ExporterOTLP, e.g. http://localhost:4318
+	ServiceName  string
+	SampleRatio  float64 // Share of new traces recorded; traces joined from callers follow their decision
+}
+
+// Setup installs the global tracer provider and the W3C traceparent/baggage propagator.
+// The returned function flushes buffered spans and must be called on shutdown.
+// With ExporterNone, spans are still propagated but never recorded.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(opts.OTLPEndpoint))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, want %q, %q or %q", opts.Exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s exporter: %w", opts.Exporter, err)
+	}
+
+	provider := newProvider(exporter, opts.ServiceName, opts.SampleRatio)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newProvider creates a tracer provider that batches spans to exporter.
+func newProvider(exporter sdktrace.SpanExporter, serviceName string, sampleRatio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+}
@@ -0,0 +1,39 @@
+// Package tracing contains tests for the tracing setup.
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// TestSetup_Propagation tests that a traceparent header is carried even when spans are
+// not exported, so the service does not break the traces of its callers.
+func TestSetup_Propagation(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterNone})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer shutdown(context.Background())
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	in := propagation.HeaderCarrier{}
+	in.Set("traceparent", traceparent)
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), in)
+
+	out := propagation.HeaderCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, out)
+	if out.Get("traceparent") != traceparent {
+		t.Errorf("expected traceparent %q to be propagated, but got %q", traceparent, out.Get("traceparent"))
+	}
+}
+
+// TestSetup_UnknownExporter tests that a misspelt exporter is rejected rather than
+// silently disabling tracing.
+func TestSetup_UnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), Options{Exporter: "jaeger"}); err == nil {
+		t.Errorf("expected an unknown exporter to be rejected")
+	}
+}
@@ -0,0 +1,17 @@
+// Package web provides shared web-related utility functions.
+package web
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the IP address of the client that made the request, without the port.
+// Behind a reverse proxy, middleware.RealIP must have set RemoteAddr from its headers.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
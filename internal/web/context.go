@@ -6,3 +6,6 @@ type ContextKey string
 
 // Defines the key for storing user information in the context.
 const UserContextKey = ContextKey("user")
+
+// ClaimsContextKey stores the claims of the access token the request was authenticated with.
+const ClaimsContextKey = ContextKey("claims")
@@ -0,0 +1,116 @@
+// Package web provides shared web-related utility functions.
+package web
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// problemTypePrefix prefixes the code of a problem to form its type URI.
+const problemTypePrefix = "urn:librarium:problem:"
+
+// Stable codes of the problems every endpoint may respond with. Codes of domain errors are
+// listed with the errors they report, in the handlers.
+const (
+	CodeValidationFailed  = "validation_failed"
+	CodeInvalidBody       = "invalid_body"
+	CodeInternalError     = "internal_error"
+	CodeInvalidToken      = "invalid_token"
+	CodeTokenRevoked      = "token_revoked"
+	CodeTwoFactorRequired = "two_factor_required"
+)
+
+// Problem is an RFC 7807 problem details object. Code is a stable, machine-readable
+// identifier of the kind of problem, which Type repeats as a URI; Detail is meant for
+// people and may change.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+	// Errors holds a message for each invalid field of a validation_failed problem.
+	Errors    map[string]string `json:"errors,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	// Extensions holds additional members specific to the problem type.
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// NewProblem returns the problem with the given status, code and detail.
+func NewProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// MarshalJSON flattens the extension members into the problem object.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	body, err := json.Marshal(problem(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return body, err
+	}
+	members := make(map[string]interface{}, len(p.Extensions))
+	for name, value := range p.Extensions {
+		members[name] = value
+	}
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+// RespondWithProblem sends the problem as application/problem+json, stamped with the ID
+// the request ID middleware gave the request.
+func RespondWithProblem(w http.ResponseWriter, p *Problem) {
+	p.RequestID = w.Header().Get(RequestIDHeader)
+	body, err := json.Marshal(p)
+	if err != nil {
+		slog.Error("Error marshaling problem response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal Server Error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
+
+// RespondWithCode sends a problem with the given status, code and detail.
+func RespondWithCode(w http.ResponseWriter, status int, code, detail string) {
+	RespondWithProblem(w, NewProblem(status, code, detail))
+}
+
+// RespondWithValidationErrors sends a validation_failed problem with a message for each
+// invalid field.
+func RespondWithValidationErrors(w http.ResponseWriter, errors map[string]string) {
+	p := NewProblem(http.StatusBadRequest, CodeValidationFailed, "The request has invalid fields.")
+	p.Errors = errors
+	RespondWithProblem(w, p)
+}
+
+// StatusCode returns the generic problem code of an HTTP status, such as "not_found" for
+// 404, used when nothing more specific is known about the problem.
+func StatusCode(status int) string {
+	if status == http.StatusInternalServerError {
+		return CodeInternalError
+	}
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(strings.ReplaceAll(text, "-", " ")), " ", "_")
+}
@@ -3,7 +3,7 @@ package web
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
@@ -12,7 +12,7 @@ import (
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshaling JSON response: %v", err)
+		slog.Error("Error marshaling JSON response", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Internal Server Error"))
 		return
@@ -23,8 +23,9 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
-// RespondWithError is a helper function to send consistent JSON error responses.
+// RespondWithError is a helper function to send consistent error responses: a problem
+// with the generic code of the status and the message as its detail.
 // It is exported so it can be used by other packages.
 func RespondWithError(w http.ResponseWriter, code int, message string) {
-	RespondWithJSON(w, code, map[string]string{"error": message})
+	RespondWithCode(w, code, StatusCode(code), message)
 }
@@ -7,21 +7,21 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"math/rand"
 	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/Lec7ral/fullAPI/configs"
+	"github.com/Lec7ral/fullAPI/internal/database"
 )
 
 func main() {
 	log.Println("Starting database super-seeder...")
 
 	// --- 1. Connect to the Database ---
-	db, err := sql.Open("sqlite3", "./library.db")
+	db, err := database.Open(configs.DriverSQLite, "./library.db")
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
@@ -41,46 +41,24 @@ func main() {
 
 	log.Println("Database is empty or near-empty. Seeding with large volume of data...")
 
-	// --- 3. Create Tables (Idempotent) ---
-	createTablesSQL := `
-	DROP TABLE IF EXISTS loans;
-	DROP TABLE IF EXISTS books;
-	DROP TABLE IF EXISTS authors;
-	DROP TABLE IF EXISTS users;
-
-	CREATE TABLE authors (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL,
-		bio TEXT
-	);
-	CREATE TABLE users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE NOT NULL,
-		password_hash TEXT NOT NULL,
-		role TEXT NOT NULL DEFAULT 'member'
-	);
-	CREATE TABLE books (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		title TEXT NOT NULL,
-		published_date TEXT NOT NULL,
-		isbn TEXT UNIQUE NOT NULL,
-		stock INTEGER NOT NULL DEFAULT 0,
-		author_id INTEGER,
-		FOREIGN KEY(author_id) REFERENCES authors(id)
-	);
-	CREATE TABLE loans (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		book_id INTEGER NOT NULL,
-		user_id INTEGER NOT NULL,
-		loan_date TEXT NOT NULL,
-		return_date TEXT,
-		FOREIGN KEY(book_id) REFERENCES books(id),
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = db.Exec(createTablesSQL)
+	// --- 3. Reset the Schema ---
+	// Bring the schema up to date first so tables created before versioned migrations are
+	// tracked, then roll everything back and re-apply it so seeding starts from a clean schema.
+	if enabled, err := database.FTS5Enabled(db); err != nil || !enabled {
+		log.Fatalf("%v", database.ErrFTS5Unavailable)
+	}
+	migrator, err := database.NewMigrator(db, configs.DriverSQLite)
 	if err != nil {
-		log.Fatalf("Failed to create tables: %v", err)
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	if err := migrator.Up(); err != nil {
+		log.Fatalf("Failed to apply migrations: %v", err)
+	}
+	if err := migrator.To(0); err != nil {
+		log.Fatalf("Failed to roll back migrations: %v", err)
+	}
+	if err := migrator.Up(); err != nil {
+		log.Fatalf("Failed to apply migrations: %v", err)
 	}
 
 	// Use a transaction for performance when inserting many rows.
@@ -116,12 +94,20 @@ func main() {
 
 	// --- 5. Seed Books ---
 	log.Println("Seeding books...")
-	bookStmt, err := tx.Prepare("INSERT INTO books (title, published_date, isbn, stock, author_id) VALUES (?, ?, ?, ?, ?)")
+	bookStmt, err := tx.Prepare("INSERT INTO books (title, published_date, isbn, author_id) VALUES (?, ?, ?, ?)")
 	if err != nil {
 		log.Fatalf("Failed to prepare book insert: %v", err)
 	}
 	defer bookStmt.Close()
 
+	copyStmt, err := tx.Prepare("INSERT INTO copies (book_id, barcode, condition, status, acquisition_date) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		log.Fatalf("Failed to prepare copy insert: %v", err)
+	}
+	defer copyStmt.Close()
+	conditions := []string{"new", "good", "good", "fair", "poor"}
+	copyCount := 0
+
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for i := 1; i <= 500; i++ {
 		title := fmt.Sprintf("Book Title %d", i)
@@ -131,15 +117,28 @@ func main() {
 		day := 1 + r.Intn(28)
 		publishedDate := fmt.Sprintf("%d-%02d-%02d", year, month, day)
 		isbn := fmt.Sprintf("978-3-16-148410-%d", i)  // Fake but unique ISBN
-		stock := r.Intn(20)                           // Random stock between 0 and 19
+		stock := r.Intn(20)                           // Random number of copies between 0 and 19
 		authorID := authorIDs[r.Intn(len(authorIDs))] // Assign a random author
 
-		_, err = bookStmt.Exec(title, publishedDate, isbn, stock, authorID)
+		result, err := bookStmt.Exec(title, publishedDate, isbn, authorID)
 		if err != nil {
 			log.Fatalf("Failed to execute book insert: %v", err)
 		}
+		bookID, err := result.LastInsertId()
+		if err != nil {
+			log.Fatalf("Failed to get book last insert ID: %v", err)
+		}
+
+		for n := 1; n <= stock; n++ {
+			barcode := fmt.Sprintf("LIB-%06d-%03d", bookID, n)
+			_, err = copyStmt.Exec(bookID, barcode, conditions[r.Intn(len(conditions))], "available", publishedDate)
+			if err != nil {
+				log.Fatalf("Failed to execute copy insert: %v", err)
+			}
+			copyCount++
+		}
 	}
-	log.Println("500 books seeded successfully.")
+	log.Printf("500 books with %d copies seeded successfully.", copyCount)
 
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {